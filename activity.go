@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Timeline entry kinds
+const (
+	ActivityAdded     = "added"
+	ActivityStarted   = "started"
+	ActivityPaused    = "paused"
+	ActivityErrored   = "errored"
+	ActivityTracker   = "tracker"
+	ActivityRecovered = "recovered"
+	ActivityCompleted = "completed"
+	ActivityMoved     = "moved"
+	ActivityRemoved   = "removed"
+)
+
+const (
+	defaultActivityRetention = 90 * 24 * time.Hour
+	activityPruneInterval    = time.Hour
+	maxActivityEntries       = 500
+)
+
+// ActivityEntry is one notable thing that happened to a torrent
+type ActivityEntry struct {
+	ID      int       `json:"id"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Message string    `json:"message,omitempty"`
+}
+
+// TorrentActivity keeps each torrent's timeline: adds, removals and
+// completions from the event bus, and starts, stops, errors, tracker
+// messages and moves noticed between polls. It's keyed by info hash, so a
+// timeline outlives the torrent until the retention drops it.
+type TorrentActivity struct {
+	db        *sql.DB
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewTorrentActivity creates the torrent_activity table
+func NewTorrentActivity(db *sql.DB, retention time.Duration) (*TorrentActivity, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS torrent_activity (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		hash TEXT NOT NULL,
+		time DATETIME NOT NULL,
+		kind TEXT NOT NULL,
+		message TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create torrent_activity table: %w", err)
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_torrent_activity_hash ON torrent_activity (hash, time)"); err != nil {
+		return nil, fmt.Errorf("failed to index torrent_activity: %w", err)
+	}
+	if retention <= 0 {
+		retention = defaultActivityRetention
+	}
+	return &TorrentActivity{db: db, retention: retention}, nil
+}
+
+func (a *TorrentActivity) record(hash, kind, message string, at time.Time) {
+	if hash == "" {
+		return
+	}
+	_, err := a.db.Exec("INSERT INTO torrent_activity (hash, time, kind, message) VALUES (?, ?, ?, ?)", hash, at, kind, message)
+	if err != nil {
+		log.Printf("Failed to record torrent activity: %v", err)
+	}
+}
+
+// OnEvent records adds, completions and removals
+func (a *TorrentActivity) OnEvent(e Event) {
+	switch e.Type {
+	case EventTorrentAdded:
+		a.record(e.Hash, ActivityAdded, "Added by "+e.Source, e.Time)
+	case EventTorrentCompleted:
+		a.record(e.Hash, ActivityCompleted, "", e.Time)
+	case EventTorrentRemoved:
+		a.record(e.Hash, ActivityRemoved, "Removed by "+e.Source, e.Time)
+	}
+}
+
+// OnSnapshot records what changed for each torrent since the last poll
+func (a *TorrentActivity) OnSnapshot(prev, cur *Snapshot) {
+	a.prune(cur.Time)
+	if prev == nil {
+		return
+	}
+	before := make(map[string]*Torrent, len(prev.Torrents))
+	for i := range prev.Torrents {
+		before[prev.Torrents[i].HashString] = &prev.Torrents[i]
+	}
+	for _, t := range cur.Torrents {
+		p, ok := before[t.HashString]
+		if !ok {
+			continue
+		}
+		switch {
+		case p.Status == 0 && t.Status != 0:
+			a.record(t.HashString, ActivityStarted, "", cur.Time)
+		case p.Status != 0 && t.Status == 0:
+			a.record(t.HashString, ActivityPaused, "", cur.Time)
+		}
+		if t.Error != p.Error || t.ErrorString != p.ErrorString {
+			switch t.Error {
+			case 0:
+				a.record(t.HashString, ActivityRecovered, "Error cleared", cur.Time)
+			case 1, 2: // tracker warning, tracker error
+				a.record(t.HashString, ActivityTracker, t.ErrorString, cur.Time)
+			default:
+				a.record(t.HashString, ActivityErrored, t.ErrorString, cur.Time)
+			}
+		}
+		if p.DownloadDir != "" && t.DownloadDir != p.DownloadDir {
+			a.record(t.HashString, ActivityMoved, p.DownloadDir+" → "+t.DownloadDir, cur.Time)
+		}
+	}
+}
+
+// prune drops entries past the retention, at most once an hour
+func (a *TorrentActivity) prune(now time.Time) {
+	a.mu.Lock()
+	if now.Sub(a.lastPrune) < activityPruneInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.lastPrune = now
+	a.mu.Unlock()
+	if _, err := a.db.Exec("DELETE FROM torrent_activity WHERE time < ?", now.Add(-a.retention)); err != nil {
+		log.Printf("Failed to prune torrent activity: %v", err)
+	}
+}
+
+// For returns a torrent's timeline, newest first
+func (a *TorrentActivity) For(hash string, limit int) ([]ActivityEntry, error) {
+	if limit <= 0 || limit > maxActivityEntries {
+		limit = maxActivityEntries
+	}
+	rows, err := a.db.Query("SELECT id, hash, time, kind, message FROM torrent_activity WHERE hash = ? ORDER BY time DESC, id DESC LIMIT ?", hash, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := []ActivityEntry{}
+	for rows.Next() {
+		var e ActivityEntry
+		if err := rows.Scan(&e.ID, &e.Hash, &e.Time, &e.Kind, &e.Message); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// handleTorrentActivity serves GET /api/torrent/{id}/activity[?limit=]:
+// the torrent's timeline, newest first. Only the default daemon is polled,
+// so other instances' torrents have none.
+func (s *Server) handleTorrentActivity(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	t, err := client.GetTorrent(id)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if client != s.client {
+		writeJSON(w, map[string]interface{}{"activity": []ActivityEntry{}})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := s.activity.For(t.HashString, limit)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"activity": entries})
+}
+
+// apiTorrentActivity serves GET /api/v1/torrents/{id}/activity
+func (s *Server) apiTorrentActivity(w http.ResponseWriter, r *http.Request) error {
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	if c != s.client {
+		writeJSON(w, ActivityList{Activity: []ActivityEntry{}})
+		return nil
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries, err := s.activity.For(t.HashString, limit)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, ActivityList{Activity: entries})
+	return nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// addDefaultSources orders the sources defaults can be set for
+var addDefaultSources = []string{SourceUI, SourceAPI, SourceRSS, SourceWatch, SourceIRC, SourceSearch}
+
+// AddDefaults is how torrents from one source are added when the add
+// itself doesn't say
+type AddDefaults struct {
+	Source string   `json:"source"`
+	Paused bool     `json:"paused"`
+	Dir    string   `json:"dir,omitempty"`    // empty uses the daemon default
+	Labels []string `json:"labels,omitempty"` // added to the add's own labels
+	// bandwidthPriority from -1 to 1; nil leaves the daemon's default
+	Priority *int `json:"priority,omitempty"`
+}
+
+// Validate checks the source and priority, and normalizes the directory
+// and labels
+func (d *AddDefaults) Validate() error {
+	if !slices.Contains(addDefaultSources, d.Source) {
+		return fmt.Errorf("unknown source %q", d.Source)
+	}
+	if d.Dir != "" {
+		dir, err := cleanLocation(d.Dir)
+		if err != nil {
+			return err
+		}
+		d.Dir = dir
+	}
+	labels, err := normalizeLabels(d.Labels)
+	if err != nil {
+		return err
+	}
+	d.Labels = labels
+	if p := d.Priority; p != nil && (*p < BandwidthPriorityLow || *p > BandwidthPriorityHigh) {
+		return fmt.Errorf("priority must be -1, 0 or 1")
+	}
+	return nil
+}
+
+// PriorityOption is Priority as a form value, "" when unset
+func (d AddDefaults) PriorityOption() string {
+	if d.Priority == nil {
+		return ""
+	}
+	return strconv.Itoa(*d.Priority)
+}
+
+func (d *AddDefaults) empty() bool {
+	return !d.Paused && d.Dir == "" && len(d.Labels) == 0 && d.Priority == nil
+}
+
+// AddDefaultsStore keeps each source's add defaults in SQLite. The adder
+// applies them to every add, so a watch folder can add paused into its own
+// directory while the UI starts torrents straight away.
+type AddDefaultsStore struct {
+	db *sql.DB
+
+	mu       sync.Mutex
+	defaults map[string]AddDefaults // by source
+}
+
+// NewAddDefaultsStore creates the add_defaults table
+func NewAddDefaultsStore(db *sql.DB) (*AddDefaultsStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS add_defaults (
+		source TEXT PRIMARY KEY,
+		paused INTEGER NOT NULL DEFAULT 0,
+		dir TEXT NOT NULL DEFAULT '',
+		labels TEXT NOT NULL DEFAULT '[]',
+		priority INTEGER
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create add_defaults table: %w", err)
+	}
+	st := &AddDefaultsStore{db: db, defaults: map[string]AddDefaults{}}
+
+	rows, err := db.Query("SELECT source, paused, dir, labels, priority FROM add_defaults")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d AddDefaults
+		var labels string
+		var priority sql.NullInt64
+		if err := rows.Scan(&d.Source, &d.Paused, &d.Dir, &labels, &priority); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(labels), &d.Labels); err != nil {
+			return nil, err
+		}
+		if priority.Valid {
+			p := int(priority.Int64)
+			d.Priority = &p
+		}
+		st.defaults[d.Source] = d
+	}
+	return st, rows.Err()
+}
+
+// Get returns a source's defaults, which are empty until set
+func (st *AddDefaultsStore) Get(source string) AddDefaults {
+	if st == nil {
+		return AddDefaults{Source: source}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if d, ok := st.defaults[source]; ok {
+		return d
+	}
+	return AddDefaults{Source: source}
+}
+
+// All returns every source's defaults in addDefaultSources order
+func (st *AddDefaultsStore) All() []AddDefaults {
+	all := make([]AddDefaults, len(addDefaultSources))
+	for i, source := range addDefaultSources {
+		all[i] = st.Get(source)
+	}
+	return all
+}
+
+// Set validates and stores a source's defaults; empty ones are deleted
+func (st *AddDefaultsStore) Set(d *AddDefaults) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	var err error
+	if d.empty() {
+		_, err = st.db.Exec("DELETE FROM add_defaults WHERE source = ?", d.Source)
+	} else {
+		labels, _ := json.Marshal(d.Labels)
+		_, err = st.db.Exec("INSERT OR REPLACE INTO add_defaults (source, paused, dir, labels, priority) VALUES (?, ?, ?, ?, ?)",
+			d.Source, d.Paused, d.Dir, string(labels), d.Priority)
+	}
+	if err != nil {
+		return err
+	}
+	st.mu.Lock()
+	if d.empty() {
+		delete(st.defaults, d.Source)
+	} else {
+		st.defaults[d.Source] = *d
+	}
+	st.mu.Unlock()
+	return nil
+}
+
+// Apply fills in what req leaves unset from its source's defaults. Their
+// labels are added to the request's rather than replacing them.
+func (st *AddDefaultsStore) Apply(req *AddRequest) error {
+	d := st.Get(req.Source)
+	if req.Dir == "" {
+		req.Dir = d.Dir
+	}
+	if req.Paused == nil && d.Paused {
+		req.Paused = &d.Paused
+	}
+	if req.Priority == nil {
+		req.Priority = d.Priority
+	}
+	if len(d.Labels) > 0 {
+		labels, err := normalizeLabels(append(slices.Clone(d.Labels), req.Labels...))
+		if err != nil {
+			return err
+		}
+		req.Labels = labels
+	}
+	return nil
+}
+
+// handleGetAddDefaults serves GET /api/adddefaults: every source's defaults
+func (s *Server) handleGetAddDefaults(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{"defaults": s.addDefaults.All()})
+}
+
+// handleSetAddDefaults serves POST /api/adddefaults/update with one
+// source's defaults, replacing what it had
+func (s *Server) handleSetAddDefaults(w http.ResponseWriter, r *http.Request) {
+	var d AddDefaults
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.addDefaults.Set(&d); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, d)
+}
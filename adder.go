@@ -0,0 +1,329 @@
+package main
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AddRequest describes a torrent to add to Transmission
+type AddRequest struct {
+	URL    string   // magnet link or .torrent URL
+	Data   []byte   // raw .torrent file
+	Source string   // one of the Source constants
+	Dir    string   // download directory; empty uses the daemon default
+	Labels []string // labels to add the torrent with
+	Paused *bool    // add without starting; nil leaves the source's default
+	// bandwidthPriority from -1 to 1; nil leaves the daemon's default
+	Priority *int
+}
+
+// maxAddBodySize caps an add request's body: a .torrent upload, or a
+// base64 one in JSON
+const maxAddBodySize = 32 << 20
+
+// DuplicateAddError is returned when the same release was already added
+// within the dedupe window
+type DuplicateAddError struct {
+	Key        string
+	WonBy      string
+	AddedAt    time.Time
+	RejectedBy string
+}
+
+func (e *DuplicateAddError) Error() string {
+	return fmt.Sprintf("duplicate add: already added by %s %v ago",
+		e.WonBy, time.Since(e.AddedAt).Round(time.Second))
+}
+
+// ExcludedURLError is returned for URLs matching an exclusion pattern
+type ExcludedURLError struct {
+	URL     string
+	Pattern string
+}
+
+func (e *ExcludedURLError) Error() string {
+	return fmt.Sprintf("excluded: URL matches exclusion pattern %q", e.Pattern)
+}
+
+type recentAdd struct {
+	source string
+	at     time.Time
+}
+
+// Adder is the single path through which every torrent is added. Adds of the
+// same info-hash or URL are serialized, and repeats within the dedupe window
+// are rejected, so RSS and manual adds racing on the same release only add
+// it once.
+type Adder struct {
+	client   *TransmissionClient
+	registry *TorrentRegistry
+	trackers *TrackerAugmenter
+	cookies  *CookieStore
+	disks    *DiskMonitor
+	defaults *AddDefaultsStore
+	window   time.Duration
+
+	locks *keyedMutex
+
+	mu       sync.Mutex
+	recent   map[string]recentAdd
+	excludes []*regexp.Regexp
+}
+
+// NewAdder creates an adder rejecting duplicates added within window
+func NewAdder(client *TransmissionClient, registry *TorrentRegistry, window time.Duration) *Adder {
+	return &Adder{
+		client:   client,
+		registry: registry,
+		window:   window,
+		locks:    newKeyedMutex(),
+		recent:   make(map[string]recentAdd),
+	}
+}
+
+// Add adds the torrent described by req
+func (a *Adder) Add(req AddRequest) (*AddedTorrent, error) {
+	key := addKey(req)
+	if key == "" {
+		return nil, fmt.Errorf("no torrent data provided")
+	}
+
+	if err := a.excluded(req.URL); err != nil {
+		log.Printf("Rejected add from %s: %s matches exclusion %s", req.Source, req.URL, err.Pattern)
+		return nil, err
+	}
+
+	unlock := a.locks.Lock(key)
+	defer unlock()
+
+	if err := a.checkRecent(key, req.Source); err != nil {
+		return nil, err
+	}
+
+	if err := a.defaults.Apply(&req); err != nil {
+		return nil, err
+	}
+	a.trackers.Prepare(&req)
+
+	if err := a.disks.CheckAdd(req.Dir); err != nil {
+		log.Printf("Rejected add from %s: %v", req.Source, err)
+		return nil, err
+	}
+
+	opts := AddOptions{DownloadDir: req.Dir, Labels: req.Labels, Paused: req.Paused != nil && *req.Paused, Priority: req.Priority}
+	if len(req.Data) == 0 && !isMagnetLink(req.URL) {
+		opts.Cookies = a.cookies.Header(req.URL)
+	}
+	added, err := a.client.AddTorrent(req.URL, req.Data, opts)
+	if err != nil {
+		return nil, err
+	}
+	a.trackers.AfterAdd(&req, added)
+
+	a.remember(key, req.Source)
+	// A URL add only learns its hash now; remember that too so a later
+	// magnet for the same release is caught
+	if added.HashString != "" {
+		a.remember("hash:"+strings.ToLower(added.HashString), req.Source)
+	}
+
+	a.registry.RecordAdd(added, req.Source)
+	return added, nil
+}
+
+// excluded returns the error for a URL matching an exclusion pattern, or
+// nil if it may be added
+func (a *Adder) excluded(url string) *ExcludedURLError {
+	if url == "" {
+		return nil
+	}
+	a.mu.Lock()
+	excludes := a.excludes
+	a.mu.Unlock()
+	for _, re := range excludes {
+		if re.MatchString(url) {
+			return &ExcludedURLError{URL: url, Pattern: re.String()}
+		}
+	}
+	return nil
+}
+
+// SetExclusions compiles the URL patterns that must never be added
+func (a *Adder) SetExclusions(patterns []string) error {
+	excludes := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("invalid exclusion pattern %q: %w", p, err)
+		}
+		excludes = append(excludes, re)
+	}
+	a.mu.Lock()
+	a.excludes = excludes
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Adder) checkRecent(key, source string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Expire old entries while we're here
+	for k, r := range a.recent {
+		if time.Since(r.at) > a.window {
+			delete(a.recent, k)
+		}
+	}
+
+	if r, ok := a.recent[key]; ok {
+		err := &DuplicateAddError{Key: key, WonBy: r.source, AddedAt: r.at, RejectedBy: source}
+		log.Printf("Rejected duplicate add from %s (%s): %v", source, key, err)
+		return err
+	}
+	return nil
+}
+
+func (a *Adder) remember(key, source string) {
+	a.mu.Lock()
+	a.recent[key] = recentAdd{source: source, at: time.Now()}
+	a.mu.Unlock()
+}
+
+// addKey identifies a release for deduplication: its info-hash when it can
+// be derived locally, otherwise the URL
+func addKey(req AddRequest) string {
+	if len(req.Data) > 0 {
+		if meta, err := parseTorrentFile(req.Data); err == nil {
+			return "hash:" + meta.InfoHash
+		}
+		return fmt.Sprintf("data:%x", req.Data[:min(len(req.Data), 64)])
+	}
+	if hash := magnetInfoHash(req.URL); hash != "" {
+		return "hash:" + hash
+	}
+	if req.URL != "" {
+		return "url:" + req.URL
+	}
+	return ""
+}
+
+// magnetInfoHash extracts the v1 info-hash from a magnet link as lowercase
+// hex, or "" if uri isn't a magnet with a btih
+func magnetInfoHash(uri string) string {
+	if !isMagnetLink(uri) {
+		return ""
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	for _, xt := range u.Query()["xt"] {
+		if !strings.HasPrefix(xt, "urn:btih:") {
+			continue
+		}
+		hash := strings.TrimPrefix(xt, "urn:btih:")
+		switch len(hash) {
+		case 40:
+			if _, err := hex.DecodeString(hash); err == nil {
+				return strings.ToLower(hash)
+			}
+		case 32:
+			if b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+	return ""
+}
+
+// magnetFromHash builds a magnet URI from a bare info-hash: 40 hex or 32
+// base32 characters for v1, 64 hex for v2
+func magnetFromHash(hash, name string, trackers []string) (string, error) {
+	hash = strings.TrimSpace(hash)
+	var xt string
+	switch len(hash) {
+	case 40:
+		if _, err := hex.DecodeString(hash); err != nil {
+			return "", fmt.Errorf("invalid info-hash: not hex")
+		}
+		xt = "urn:btih:" + strings.ToLower(hash)
+	case 32:
+		b, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash))
+		if err != nil {
+			return "", fmt.Errorf("invalid info-hash: not base32")
+		}
+		xt = "urn:btih:" + hex.EncodeToString(b)
+	case 64:
+		if _, err := hex.DecodeString(hash); err != nil {
+			return "", fmt.Errorf("invalid info-hash: not hex")
+		}
+		// 0x12 0x20 is the multihash prefix for a 32-byte SHA-256
+		xt = "urn:btmh:1220" + strings.ToLower(hash)
+	default:
+		return "", fmt.Errorf("info-hash must be 40 or 64 hex characters, or 32 base32 characters")
+	}
+
+	var b strings.Builder
+	b.WriteString("magnet:?xt=")
+	b.WriteString(xt)
+	if name != "" {
+		b.WriteString("&dn=")
+		b.WriteString(url.QueryEscape(name))
+	}
+	for _, tr := range trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tr))
+	}
+	return b.String(), nil
+}
+
+// isInfoHash reports whether s looks like a bare info-hash rather than a link
+func isInfoHash(s string) bool {
+	_, err := magnetFromHash(s, "", nil)
+	return err == nil
+}
+
+// keyedMutex hands out one mutex per key, freeing it when unused
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{locks: make(map[string]*keyedLock)}
+}
+
+// Lock locks key and returns the matching unlock function
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		k.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,519 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AltSpeed is the daemon's alternative ("turtle") speed limits and its own
+// schedule for them. Limits are in KB/s, schedule times in minutes after
+// midnight, and days a bitmask with Sunday = 1.
+type AltSpeed struct {
+	Enabled     bool `json:"alt-speed-enabled"`
+	Down        int  `json:"alt-speed-down"`
+	Up          int  `json:"alt-speed-up"`
+	TimeEnabled bool `json:"alt-speed-time-enabled"`
+	TimeBegin   int  `json:"alt-speed-time-begin"`
+	TimeEnd     int  `json:"alt-speed-time-end"`
+	TimeDay     int  `json:"alt-speed-time-day"`
+}
+
+// GetAltSpeed reads the alternative limits and the daemon's schedule
+func (c *TransmissionClient) GetAltSpeed() (*AltSpeed, error) {
+	req := &RPCRequest{
+		Method: "session-get",
+		Arguments: map[string]interface{}{"fields": []string{
+			"alt-speed-enabled", "alt-speed-down", "alt-speed-up",
+			"alt-speed-time-enabled", "alt-speed-time-begin", "alt-speed-time-end", "alt-speed-time-day",
+		}},
+	}
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var alt AltSpeed
+	if err := json.Unmarshal(resp.Arguments, &alt); err != nil {
+		return nil, err
+	}
+	return &alt, nil
+}
+
+// SetAltSpeedLimits sets the alternative limits in KB/s
+func (c *TransmissionClient) SetAltSpeedLimits(down, up int) error {
+	_, err := c.doRequest(&RPCRequest{
+		Method:    "session-set",
+		Arguments: map[string]interface{}{"alt-speed-down": down, "alt-speed-up": up},
+	})
+	return err
+}
+
+// SetAltSpeedSchedule sets the daemon's own single-window schedule
+func (c *TransmissionClient) SetAltSpeedSchedule(enabled bool, begin, end, days int) error {
+	_, err := c.doRequest(&RPCRequest{
+		Method: "session-set",
+		Arguments: map[string]interface{}{
+			"alt-speed-time-enabled": enabled,
+			"alt-speed-time-begin":   begin,
+			"alt-speed-time-end":     end,
+			"alt-speed-time-day":     days,
+		},
+	})
+	return err
+}
+
+// weekdayNames are the day names schedule rules use, Sunday first to
+// match Transmission's bitmask
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// AltSpeedRule is one window in which turtle mode is on, e.g. weekday
+// evenings. A window whose end is before its start runs past midnight into
+// the next day. Down and Up, when set, replace the alternative limits while
+// the rule is active; they aren't put back when it ends.
+type AltSpeedRule struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Enabled bool     `json:"enabled"`
+	Days    []string `json:"days"`  // weekdayNames; empty means every day
+	Start   string   `json:"start"` // "HH:MM"
+	End     string   `json:"end"`
+	Down    *int     `json:"down,omitempty"` // KB/s
+	Up      *int     `json:"up,omitempty"`
+}
+
+// parseClock reads "HH:MM" as minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// dayMask converts day names to a bitmask; no days means every day
+func dayMask(days []string) (int, error) {
+	if len(days) == 0 {
+		return 127, nil
+	}
+	mask := 0
+	for _, d := range days {
+		i := slices.Index(weekdayNames, strings.ToLower(strings.TrimSpace(d)))
+		if i < 0 {
+			return 0, fmt.Errorf("invalid day %q, expected one of %s", d, strings.Join(weekdayNames, ", "))
+		}
+		mask |= 1 << i
+	}
+	return mask, nil
+}
+
+func maskDays(mask int) []string {
+	days := []string{}
+	for i, name := range weekdayNames {
+		if mask&(1<<i) != 0 {
+			days = append(days, name)
+		}
+	}
+	return days
+}
+
+// Validate checks the rule and normalizes its days and times
+func (r *AltSpeedRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("start and end must differ")
+	}
+	mask, err := dayMask(r.Days)
+	if err != nil {
+		return err
+	}
+	for name, v := range map[string]*int{"down": r.Down, "up": r.Up} {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	r.Start, r.End, r.Days = formatClock(start), formatClock(end), maskDays(mask)
+	return nil
+}
+
+// activeAt reports whether the rule's window covers t, in appLocation
+func (r *AltSpeedRule) activeAt(t time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	start, err1 := parseClock(r.Start)
+	end, err2 := parseClock(r.End)
+	mask, err3 := dayMask(r.Days)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	return windowActive(mask, start, end, t)
+}
+
+// windowActive reports whether the window from start to end, in minutes
+// after midnight on the days in mask, covers t in appLocation
+func windowActive(mask, start, end int, t time.Time) bool {
+	t = t.In(appLocation)
+	now := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	if start < end {
+		return mask&(1<<today) != 0 && now >= start && now < end
+	}
+	// Past midnight: the evening part belongs to today, the morning part
+	// to the window that started yesterday
+	yesterday := (today + 6) % 7
+	return (mask&(1<<today) != 0 && now >= start) || (mask&(1<<yesterday) != 0 && now < end)
+}
+
+// AltSpeedScheduler toggles turtle mode from a table of rules, for
+// schedules Transmission's single begin/end window can't express. It only
+// acts when the wanted state changes, so toggling turtle mode by hand
+// sticks until the next rule boundary.
+type AltSpeedScheduler struct {
+	db     *sql.DB
+	client *TransmissionClient
+	usage  *UsageTracker // a data cap holding turtle mode on overrides the rules
+
+	mu      sync.Mutex
+	applied *bool // the state last set, nil before the first check
+	active  *AltSpeedRule
+	lastErr error
+}
+
+// NewAltSpeedScheduler creates the alt_speed_rules table
+func NewAltSpeedScheduler(db *sql.DB, client *TransmissionClient, usage *UsageTracker) (*AltSpeedScheduler, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS alt_speed_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		days INTEGER NOT NULL,
+		start_minute INTEGER NOT NULL,
+		end_minute INTEGER NOT NULL,
+		down INTEGER,
+		up INTEGER
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &AltSpeedScheduler{db: db, client: client, usage: usage}, nil
+}
+
+// Rules returns every rule in ID order
+func (a *AltSpeedScheduler) Rules() ([]AltSpeedRule, error) {
+	rows, err := a.db.Query("SELECT id, name, enabled, days, start_minute, end_minute, down, up FROM alt_speed_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AltSpeedRule{}
+	for rows.Next() {
+		var r AltSpeedRule
+		var days, start, end int
+		var down, up sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Name, &r.Enabled, &days, &start, &end, &down, &up); err != nil {
+			return nil, err
+		}
+		r.Days, r.Start, r.End = maskDays(days), formatClock(start), formatClock(end)
+		if down.Valid {
+			v := int(down.Int64)
+			r.Down = &v
+		}
+		if up.Valid {
+			v := int(up.Int64)
+			r.Up = &v
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// ruleColumns converts a validated rule to its stored values
+func ruleColumns(r *AltSpeedRule) (days, start, end int, down, up interface{}) {
+	days, _ = dayMask(r.Days)
+	start, _ = parseClock(r.Start)
+	end, _ = parseClock(r.End)
+	if r.Down != nil {
+		down = *r.Down
+	}
+	if r.Up != nil {
+		up = *r.Up
+	}
+	return days, start, end, down, up
+}
+
+// AddRule validates and stores a rule, setting its ID
+func (a *AltSpeedScheduler) AddRule(r *AltSpeedRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	days, start, end, down, up := ruleColumns(r)
+	res, err := a.db.Exec("INSERT INTO alt_speed_rules (name, enabled, days, start_minute, end_minute, down, up) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		r.Name, r.Enabled, days, start, end, down, up)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ID = int(id)
+	a.Check(time.Now())
+	return nil
+}
+
+// UpdateRule replaces a stored rule
+func (a *AltSpeedScheduler) UpdateRule(r *AltSpeedRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	days, start, end, down, up := ruleColumns(r)
+	res, err := a.db.Exec("UPDATE alt_speed_rules SET name = ?, enabled = ?, days = ?, start_minute = ?, end_minute = ?, down = ?, up = ? WHERE id = ?",
+		r.Name, r.Enabled, days, start, end, down, up, r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("rule %d not found", r.ID)
+	}
+	a.Check(time.Now())
+	return nil
+}
+
+// DeleteRule removes a rule
+func (a *AltSpeedScheduler) DeleteRule(id int) error {
+	if _, err := a.db.Exec("DELETE FROM alt_speed_rules WHERE id = ?", id); err != nil {
+		return err
+	}
+	a.Check(time.Now())
+	return nil
+}
+
+// Start checks the rules every minute
+func (a *AltSpeedScheduler) Start() {
+	go func() {
+		if rules, err := a.Rules(); err == nil && len(rules) > 0 {
+			if alt, err := a.client.GetAltSpeed(); err == nil && alt.TimeEnabled {
+				log.Printf("⚠️ Transmission's own alt-speed schedule is on and will fight the schedule rules; turn it off in the daemon settings")
+			}
+		}
+		a.Check(time.Now())
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			a.Check(now)
+		}
+	}()
+}
+
+// Check applies the rules at t. The first active rule, in ID order, wins.
+// With no rules at all turtle mode is left alone.
+func (a *AltSpeedScheduler) Check(t time.Time) {
+	rules, err := a.Rules()
+	if err != nil {
+		log.Printf("Failed to load alt-speed rules: %v", err)
+		return
+	}
+	var active *AltSpeedRule
+	for i := range rules {
+		if rules[i].activeAt(t) {
+			active = &rules[i]
+			break
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	want := active != nil
+	changed := a.applied == nil || *a.applied != want || (want && a.active.ID != active.ID)
+	if len(rules) == 0 || !changed || (!want && a.usage.HoldsAltSpeed()) {
+		return
+	}
+
+	if active != nil && (active.Down != nil || active.Up != nil) {
+		alt, err := a.client.GetAltSpeed()
+		if err == nil {
+			down, up := alt.Down, alt.Up
+			if active.Down != nil {
+				down = *active.Down
+			}
+			if active.Up != nil {
+				up = *active.Up
+			}
+			err = a.client.SetAltSpeedLimits(down, up)
+		}
+		if err != nil {
+			a.lastErr = err
+			log.Printf("Failed to set alt-speed limits for rule %q: %v", active.Name, err)
+			return
+		}
+	}
+	if err := a.client.SetAltSpeedEnabled(want); err != nil {
+		a.lastErr = err
+		log.Printf("Failed to toggle alt-speed limits: %v", err)
+		return
+	}
+	a.applied, a.active, a.lastErr = &want, active, nil
+	if active != nil {
+		log.Printf("🐢 Alt-speed limits on (rule %q)", active.Name)
+	} else {
+		log.Printf("🐢 Alt-speed limits off")
+	}
+}
+
+// Active returns the rule last applied, if one is in effect
+func (a *AltSpeedScheduler) Active() *AltSpeedRule {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.active
+}
+
+// AltSpeedStatus is the scheduler's view for the API
+type AltSpeedStatus struct {
+	Daemon     *AltSpeed      `json:"daemon,omitempty"`
+	DaemonErr  string         `json:"daemonError,omitempty"`
+	Rules      []AltSpeedRule `json:"rules"`
+	ActiveRule *AltSpeedRule  `json:"activeRule,omitempty"`
+	LastError  string         `json:"lastError,omitempty"`
+}
+
+// Status reads the daemon's current state alongside the rules
+func (a *AltSpeedScheduler) Status() (AltSpeedStatus, error) {
+	rules, err := a.Rules()
+	if err != nil {
+		return AltSpeedStatus{}, err
+	}
+	status := AltSpeedStatus{Rules: rules}
+	if status.Daemon, err = a.client.GetAltSpeed(); err != nil {
+		status.DaemonErr = err.Error()
+	}
+	status.ActiveRule = a.Active()
+	a.mu.Lock()
+	if a.lastErr != nil {
+		status.LastError = a.lastErr.Error()
+	}
+	a.mu.Unlock()
+	return status, nil
+}
+
+// handleAltSpeed shows the schedule status; POST {"enabled": bool} toggles
+// turtle mode by hand, which holds until the next rule boundary
+func (s *Server) handleAltSpeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var req struct {
+			Enabled *bool `json:"enabled"`
+			Down    *int  `json:"down"`
+			Up      *int  `json:"up"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Enabled == nil && req.Down == nil && req.Up == nil) {
+			writeJSONError(w, "invalid request")
+			return
+		}
+		if req.Down != nil || req.Up != nil {
+			alt, err := s.client.GetAltSpeed()
+			if err != nil {
+				writeJSONError(w, err.Error())
+				return
+			}
+			down, up := alt.Down, alt.Up
+			if req.Down != nil {
+				down = *req.Down
+			}
+			if req.Up != nil {
+				up = *req.Up
+			}
+			if down < 0 || up < 0 {
+				writeJSONError(w, "limits must not be negative")
+				return
+			}
+			if err := s.client.SetAltSpeedLimits(down, up); err != nil {
+				writeJSONError(w, err.Error())
+				return
+			}
+		}
+		if req.Enabled != nil {
+			if err := s.client.SetAltSpeedEnabled(*req.Enabled); err != nil {
+				writeJSONError(w, err.Error())
+				return
+			}
+		}
+	} else if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status, err := s.altSpeed.Status()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, status)
+}
+
+func (s *Server) handleAddAltSpeedRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rule := AltSpeedRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.altSpeed.AddRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleUpdateAltSpeedRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rule AltSpeedRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.altSpeed.UpdateRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleDeleteAltSpeedRule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.altSpeed.DeleteRule(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
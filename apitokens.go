@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// APITokens are bearer tokens for integrations. A token with a label is
+// restricted to it: it only sees, adds and manages torrents carrying the
+// label, so a media automation tool can't touch anything added by hand. A
+// token without one is for scripts and mobile apps and reaches the whole
+// API, except managing tokens. Like sessions, tokens are stored hashed and
+// shown only when created.
+type APITokens struct {
+	db *sql.DB
+}
+
+// APIToken is one token, without its secret
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Label      string     `json:"label"` // "" for full access
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// NewAPITokens creates the api_tokens table
+func NewAPITokens(db *sql.DB) (*APITokens, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS api_tokens (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		label TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_used_at DATETIME
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api_tokens table: %w", err)
+	}
+	return &APITokens{db: db}, nil
+}
+
+// Create stores a new token, for label or with full access when it's "",
+// and returns its secret, which isn't kept anywhere
+func (t *APITokens) Create(name, label string) (string, *APIToken, error) {
+	name, label = strings.TrimSpace(name), strings.TrimSpace(label)
+	if name == "" {
+		return "", nil, errors.New("name is required")
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	secret := "tw_" + hex.EncodeToString(buf)
+	token := &APIToken{Name: name, Label: label, CreatedAt: time.Now()}
+	res, err := t.db.Exec("INSERT INTO api_tokens (name, token_hash, label, created_at) VALUES (?, ?, ?, ?)",
+		token.Name, hashToken(secret), token.Label, token.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+	token.ID = int(id)
+	return secret, token, nil
+}
+
+// List returns every token, oldest first
+func (t *APITokens) List() ([]APIToken, error) {
+	rows, err := t.db.Query("SELECT id, name, label, created_at, last_used_at FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []APIToken{}
+	for rows.Next() {
+		var tok APIToken
+		var lastUsed sql.NullTime
+		if err := rows.Scan(&tok.ID, &tok.Name, &tok.Label, &tok.CreatedAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if lastUsed.Valid {
+			tok.LastUsedAt = &lastUsed.Time
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens, rows.Err()
+}
+
+// Delete revokes a token
+func (t *APITokens) Delete(id int) error {
+	res, err := t.db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token %d not found", id)
+	}
+	return nil
+}
+
+// lookup returns the token for secret, or nil if there's none
+func (t *APITokens) lookup(secret string) *APIToken {
+	tok := &APIToken{}
+	err := t.db.QueryRow("SELECT id, name, label, created_at FROM api_tokens WHERE token_hash = ?",
+		hashToken(secret)).Scan(&tok.ID, &tok.Name, &tok.Label, &tok.CreatedAt)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up API token: %v", err)
+		}
+		return nil
+	}
+	if _, err := t.db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", time.Now(), tok.ID); err != nil {
+		log.Printf("Failed to update API token: %v", err)
+	}
+	return tok
+}
+
+type tokenScopeKey struct{}
+
+// requestToken is the API token a request was authenticated with, or nil
+// for a normal login
+func requestToken(r *http.Request) *APIToken {
+	tok, _ := r.Context().Value(tokenScopeKey{}).(*APIToken)
+	return tok
+}
+
+// scopeLabel is the label a token-authenticated request is restricted to,
+// or "" for a normal login or a full-access token
+func scopeLabel(r *http.Request) string {
+	if tok := requestToken(r); tok != nil {
+		return tok.Label
+	}
+	return ""
+}
+
+// scopeLabels are the labels to add a torrent with for r: the token's
+// label, or none
+func scopeLabels(r *http.Request) []string {
+	if label := scopeLabel(r); label != "" {
+		return []string{label}
+	}
+	return nil
+}
+
+// withLabel keeps the torrents carrying label
+func withLabel(torrents []Torrent, label string) []Torrent {
+	scoped := make([]Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		if slices.Contains(t.Labels, label) {
+			scoped = append(scoped, t)
+		}
+	}
+	return scoped
+}
+
+// tokenMiddleware lets /api/ requests with an "Authorization: Bearer"
+// token skip the login: a full-access token to anything but the token
+// endpoints, a scoped one to the few endpoints it may use. Everything else
+// goes through authed. app is the handler behind the login.
+func (s *Server) tokenMiddleware(authed, app http.Handler) http.Handler {
+	full := http.NewServeMux()
+	full.HandleFunc("/api/tokens", tokenManagementDenied)
+	full.HandleFunc("/api/tokens/", tokenManagementDenied)
+	full.Handle("/", app)
+
+	scoped := http.NewServeMux()
+	scoped.Handle("GET /api/torrents", app)
+	scoped.Handle("POST /api/add", app)
+	scoped.Handle("POST /api/add/hash", app)
+	scoped.Handle("POST /api/action", s.scopedAction(app))
+	for _, route := range []string{
+		"GET /api/torrent/{id}",
+		"GET /api/torrent/{id}/tuning",
+		"POST /api/torrent/{id}/tuning",
+		"GET /api/torrent/{id}/limits",
+		"POST /api/torrent/{id}/limits",
+		"POST /api/torrent/{id}/files",
+		"POST /api/torrent/{id}/move",
+		"GET /api/torrent/{id}/activity",
+	} {
+		scoped.Handle(route, s.scopedTorrent(app))
+	}
+	// /api/v1 checks the token's label itself
+	scoped.Handle("GET /api/v1/torrents", app)
+	scoped.Handle("POST /api/v1/torrents", app)
+	scoped.Handle("/api/v1/torrents/{id}", app)
+	scoped.Handle("/api/v1/torrents/{id}/", app)
+	scoped.HandleFunc("/api/v1/", func(w http.ResponseWriter, _ *http.Request) {
+		writeAPIError(w, apiErrorf(http.StatusForbidden, "not allowed for an API token"))
+	})
+	scoped.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		tokenError(w, http.StatusForbidden, "not allowed for an API token")
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Other bearer tokens, such as METRICS_TOKEN, are checked by their
+		// own endpoints
+		secret, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || s.tokens == nil || !strings.HasPrefix(r.URL.Path, "/api/") {
+			authed.ServeHTTP(w, r)
+			return
+		}
+		tok := s.tokens.lookup(strings.TrimSpace(secret))
+		if tok == nil {
+			tokenError(w, http.StatusUnauthorized, "invalid API token")
+			return
+		}
+		ctx := context.WithValue(r.Context(), tokenScopeKey{}, tok)
+		if tok.Label == "" {
+			full.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		// Scoped tokens only reach the default instance
+		if r.URL.Query().Get("instance") != "" {
+			tokenError(w, http.StatusForbidden, "API tokens only reach the default instance")
+			return
+		}
+		scoped.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenManagementDenied keeps a leaked token from minting more tokens or
+// revoking the others; managing them takes a login
+func tokenManagementDenied(w http.ResponseWriter, _ *http.Request) {
+	tokenError(w, http.StatusForbidden, "API tokens are managed with a login, not a token")
+}
+
+func tokenError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSONError(w, msg)
+}
+
+// inScope checks that torrent id carries label
+func (s *Server) inScope(w http.ResponseWriter, id int, label string) bool {
+	t, err := s.client.GetTorrent(id)
+	if err != nil || !slices.Contains(t.Labels, label) {
+		// Torrents outside the scope look the same as missing ones
+		tokenError(w, http.StatusNotFound, fmt.Sprintf("torrent %d not found", id))
+		return false
+	}
+	return true
+}
+
+func (s *Server) scopedTorrent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if ok && s.inScope(w, id, scopeLabel(r)) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// scopedAction checks /api/action's torrent, and that a label edit keeps
+// the token's label on it
+func (s *Server) scopedAction(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Action       string   `json:"action"`
+			ID           int      `json:"id"`
+			Instance     string   `json:"instance"`
+			Labels       []string `json:"labels"`
+			RemoveLabels []string `json:"removeLabels"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		label := scopeLabel(r)
+		switch {
+		case req.Instance != "":
+			tokenError(w, http.StatusForbidden, "API tokens only reach the default instance")
+			return
+		case req.Action == "reannounce-all":
+			tokenError(w, http.StatusForbidden, "not allowed for an API token")
+			return
+		case req.Action == "labels" && (req.Labels != nil && !slices.Contains(req.Labels, label) || slices.Contains(req.RemoveLabels, label)):
+			tokenError(w, http.StatusForbidden, fmt.Sprintf("the %q label can't be removed with this token", label))
+			return
+		}
+		if !s.inScope(w, req.ID, label) {
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleGetTokens(w http.ResponseWriter, _ *http.Request) {
+	tokens, err := s.tokens.List()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, tokens)
+}
+
+// handleAddToken creates a token, scoped to "label" or with full access
+// without one; the response is the only time its secret is shown
+func (s *Server) handleAddToken(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string `json:"name"`
+		Label string `json:"label"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	secret, token, err := s.tokens.Create(req.Name, req.Label)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"token": secret, "apiToken": token})
+}
+
+func (s *Server) handleDeleteToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.tokens.Delete(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
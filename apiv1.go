@@ -0,0 +1,494 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// /api/v1 is the scripting API: resources and methods instead of
+// /api/action's {"action": ...} bodies, with status codes that mean
+// something (404 for a missing torrent, 422 for a request that parsed but
+// can't be done) and every error in the same envelope:
+//
+//	{"error": "torrent 7 not found", "code": "not_found", "status": 404}
+//
+// The older endpoints stay for the UI and existing scripts.
+
+// APIErrorBody is the envelope every v1 error is answered with
+type APIErrorBody struct {
+	Error  string `json:"error"`
+	Code   string `json:"code"`
+	Status int    `json:"status"`
+}
+
+// AddTorrentRequest is POST /api/v1/torrents' body: a url or a metainfo
+type AddTorrentRequest struct {
+	URL         string   `json:"url,omitempty"`      // magnet, link or info hash
+	Metainfo    string   `json:"metainfo,omitempty"` // base64 .torrent
+	DownloadDir string   `json:"downloadDir,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	Paused      *bool    `json:"paused,omitempty"`
+	// -1 low, 0 normal, 1 high
+	BandwidthPriority *int `json:"bandwidthPriority,omitempty"`
+}
+
+// TorrentList is a page of GET /api/v1/torrents. The paging fields are
+// only set when page or per_page is given.
+type TorrentList struct {
+	Torrents []Torrent         `json:"torrents"`
+	Total    int               `json:"total"`
+	Page     int               `json:"page,omitempty"`
+	PerPage  int               `json:"perPage,omitempty"`
+	Pages    int               `json:"pages,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"` // daemons that couldn't be reached, by instance
+}
+
+// PeerList is a torrent's connected peers
+type PeerList struct {
+	Peers []Peer `json:"peers"`
+}
+
+// TorrentLabels is a torrent's labels, replaced whole by PUT
+type TorrentLabels struct {
+	Labels []string `json:"labels"`
+}
+
+// ActivityList is a torrent's timeline, newest first
+type ActivityList struct {
+	Activity []ActivityEntry `json:"activity"`
+}
+
+// apiError is a v1 failure and the status it's answered with
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string { return e.Message }
+
+func apiErrorf(status int, format string, args ...interface{}) *apiError {
+	return &apiError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// apiErrorCodes name the statuses the envelope's "code" can carry
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "unprocessable",
+	http.StatusServiceUnavailable:  "unavailable",
+	http.StatusInsufficientStorage: "insufficient_storage",
+	http.StatusBadGateway:          "upstream_error",
+}
+
+// writeAPIError writes err in the v1 envelope. Errors that aren't an
+// apiError came from the daemon: a 502, or a 503 while it's busy; a 409
+// is a removal a seed rule refused.
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *apiError
+	if !errors.As(err, &apiErr) {
+		apiErr = &apiError{Status: http.StatusBadGateway, Message: err.Error()}
+		var notFound *TorrentNotFoundError
+		var busy *RPCBusyError
+		var owed *SeedObligationError
+		switch {
+		case errors.As(err, &notFound):
+			apiErr.Status = http.StatusNotFound
+		case errors.As(err, &owed):
+			apiErr.Status = http.StatusConflict
+		case errors.As(err, &busy):
+			apiErr.Status = http.StatusServiceUnavailable
+		}
+	}
+	code, ok := apiErrorCodes[apiErr.Status]
+	if !ok {
+		code = "error"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	writeJSON(w, APIErrorBody{Error: apiErr.Message, Code: code, Status: apiErr.Status})
+}
+
+// apiHandler is a v1 endpoint; a returned error is written in the
+// envelope, so handlers only write successful responses
+type apiHandler func(w http.ResponseWriter, r *http.Request) error
+
+// apiRouter is a ServeMux that answers unknown paths and wrong methods
+// in the v1 envelope rather than with plain text. It keeps each route's
+// apiOp for the OpenAPI document.
+type apiRouter struct {
+	mux     *http.ServeMux
+	paths   *http.ServeMux // each path without its method, to tell a 405 from a 404
+	methods map[string][]string
+	routes  []apiRoute
+}
+
+func newAPIRouter() *apiRouter {
+	return &apiRouter{mux: http.NewServeMux(), paths: http.NewServeMux(), methods: map[string][]string{}}
+}
+
+func (rt *apiRouter) handle(method, path string, op apiOp, h apiHandler) {
+	rt.mux.HandleFunc(method+" "+path, func(w http.ResponseWriter, r *http.Request) {
+		if err := h(w, r); err != nil {
+			writeAPIError(w, err)
+		}
+	})
+	if rt.methods[path] == nil {
+		rt.paths.HandleFunc(path, func(http.ResponseWriter, *http.Request) {})
+	}
+	rt.methods[path] = append(rt.methods[path], method)
+	rt.routes = append(rt.routes, apiRoute{Method: method, Path: path, Op: op})
+}
+
+func (rt *apiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := rt.mux.Handler(r); pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+	if _, path := rt.paths.Handler(r); path != "" {
+		w.Header().Set("Allow", strings.Join(rt.methods[path], ", "))
+		writeAPIError(w, apiErrorf(http.StatusMethodNotAllowed, "%s is not allowed on %s", r.Method, r.URL.Path))
+		return
+	}
+	writeAPIError(w, apiErrorf(http.StatusNotFound, "no such endpoint %s", r.URL.Path))
+}
+
+// apiV1 builds the /api/v1 router
+func (s *Server) apiV1() *apiRouter {
+	rt := newAPIRouter()
+	rt.handle("GET", "/api/v1/torrents", apiOp{
+		ID:       "listTorrents",
+		Summary:  "List torrents",
+		Query:    append([]apiParam{instanceParam(true)}, torrentListParams...),
+		Response: TorrentList{},
+	}, s.apiListTorrents)
+	rt.handle("POST", "/api/v1/torrents", apiOp{
+		ID:       "addTorrent",
+		Summary:  "Add a torrent",
+		Query:    []apiParam{instanceParam(false)},
+		Body:     AddTorrentRequest{},
+		Response: AddedTorrent{},
+		Status:   http.StatusCreated,
+		Also:     []int{http.StatusOK}, // already in the daemon
+	}, s.apiAddTorrent)
+	rt.handle("GET", "/api/v1/torrents/{id}", apiOp{
+		ID:       "getTorrent",
+		Summary:  "Get a torrent with its files, trackers and pieces",
+		Query:    []apiParam{instanceParam(false)},
+		Response: TorrentDetail{},
+	}, s.apiGetTorrent)
+	rt.handle("DELETE", "/api/v1/torrents/{id}", apiOp{
+		ID:      "removeTorrent",
+		Summary: "Remove a torrent",
+		Query: []apiParam{
+			instanceParam(false),
+			{Name: "deleteData", Type: "boolean", Description: "Also delete the downloaded data"},
+			{Name: "force", Type: "boolean", Description: "Remove even if it still owes its tracker seeding; otherwise that's a 409"},
+		},
+	}, s.apiRemoveTorrent)
+	rt.handle("GET", "/api/v1/torrents/{id}/peers", apiOp{
+		ID:       "getPeers",
+		Summary:  "List a torrent's peers",
+		Query:    []apiParam{instanceParam(false)},
+		Response: PeerList{},
+	}, s.apiGetPeers)
+	rt.handle("GET", "/api/v1/torrents/{id}/activity", apiOp{
+		ID:       "getActivity",
+		Summary:  "Get a torrent's timeline",
+		Query:    []apiParam{instanceParam(false), {Name: "limit", Type: "integer", Description: "Most entries to return, up to 500"}},
+		Response: ActivityList{},
+	}, s.apiTorrentActivity)
+	rt.handle("GET", "/api/v1/torrents/{id}/traffic", apiOp{
+		ID:       "getTraffic",
+		Summary:  "Get a torrent's transfer history and the last 24 hours' totals",
+		Query:    []apiParam{instanceParam(false), {Name: "points", Type: "integer", Description: "Thin the history to at most this many points"}},
+		Response: TrafficReport{},
+	}, s.apiTorrentTraffic)
+	rt.handle("PUT", "/api/v1/torrents/{id}/labels", apiOp{
+		ID:       "setLabels",
+		Summary:  "Replace a torrent's labels",
+		Query:    []apiParam{instanceParam(false)},
+		Body:     TorrentLabels{},
+		Response: TorrentLabels{},
+	}, s.apiSetLabels)
+	for _, action := range []string{"start", "stop", "reannounce", "verify"} {
+		rt.handle("POST", "/api/v1/torrents/{id}/"+action, apiOp{
+			ID:      action + "Torrent",
+			Summary: strings.ToUpper(action[:1]) + action[1:] + " a torrent",
+			Query:   []apiParam{instanceParam(false)},
+		}, s.apiTorrentAction(action))
+	}
+	rt.handle("POST", "/api/v1/torrents/{id}/queue/{move}", apiOp{
+		ID:      "moveQueue",
+		Summary: "Move a torrent in the queue: top, up, down or bottom",
+		Query:   []apiParam{instanceParam(false)},
+	}, s.apiMoveQueue)
+	rt.handle("GET", "/api/v1/stats", apiOp{
+		ID:       "getStats",
+		Summary:  "Get the daemon's session stats",
+		Query:    []apiParam{instanceParam(false)},
+		Response: SessionStats{},
+	}, s.apiStats)
+	return rt
+}
+
+// apiClient picks the daemon for ?instance=
+func (s *Server) apiClient(r *http.Request) (*TransmissionClient, error) {
+	c, err := s.clientFor(r.URL.Query().Get("instance"))
+	if err != nil {
+		return nil, apiErrorf(http.StatusNotFound, "%v", err)
+	}
+	return c, nil
+}
+
+// apiTorrent resolves {id} to a torrent on the request's daemon. It's a
+// 404 when there's no such torrent, or, for an API token, when the
+// torrent doesn't carry the token's label.
+func (s *Server) apiTorrent(r *http.Request) (*TransmissionClient, *Torrent, error) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		return nil, nil, apiErrorf(http.StatusNotFound, "torrent %q not found", r.PathValue("id"))
+	}
+	c, err := s.apiClient(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := c.GetTorrent(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if label := scopeLabel(r); label != "" && !slices.Contains(t.Labels, label) {
+		return nil, nil, &TorrentNotFoundError{ID: id}
+	}
+	return c, t, nil
+}
+
+// decodeAPIBody reads a JSON request body into v: a 400 if it isn't JSON,
+// a 413 past maxAddBodySize
+func decodeAPIBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAddBodySize)).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return apiErrorf(http.StatusRequestEntityTooLarge, "request body over %d bytes", tooLarge.Limit)
+		}
+		return apiErrorf(http.StatusBadRequest, "invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// apiListTorrents serves GET /api/v1/torrents, taking /api/torrents'
+// filter, sort and paging parameters
+func (s *Server) apiListTorrents(w http.ResponseWriter, r *http.Request) error {
+	instance := r.URL.Query().Get("instance")
+	if instance != AllInstances {
+		if _, err := s.apiClient(r); err != nil {
+			return err
+		}
+	}
+	s.poller.Touch()
+	torrents, _, errs, err := s.listTorrents(instance)
+	if err != nil {
+		return err
+	}
+	if label := scopeLabel(r); label != "" {
+		torrents = withLabel(torrents, label)
+	}
+	page := newTorrentIndex(filterTorrents(torrents, r.URL.Query())).query(parseTorrentQuery(r.URL.Query(), 0))
+	writeJSON(w, TorrentList{
+		Torrents: page.Torrents,
+		Total:    page.Total,
+		Page:     page.Page,
+		PerPage:  page.PerPage,
+		Pages:    page.Pages,
+		Errors:   errorStrings(errs),
+	})
+	return nil
+}
+
+// apiAddTorrent serves POST /api/v1/torrents with {"url": magnet, link or
+// info-hash} or {"metainfo": base64 .torrent}, plus optional
+// "downloadDir", "labels", "paused" and "bandwidthPriority". A new
+// torrent is a 201 with its Location.
+func (s *Server) apiAddTorrent(w http.ResponseWriter, r *http.Request) error {
+	var req AddTorrentRequest
+	if err := decodeAPIBody(w, r, &req); err != nil {
+		return err
+	}
+	if r.URL.Query().Get("instance") != "" {
+		return apiErrorf(http.StatusUnprocessableEntity, "torrents can only be added to the default instance")
+	}
+	labels, err := normalizeLabels(append(req.Labels, scopeLabels(r)...))
+	if err != nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "%v", err)
+	}
+	if p := req.BandwidthPriority; p != nil && (*p < BandwidthPriorityLow || *p > BandwidthPriorityHigh) {
+		return apiErrorf(http.StatusUnprocessableEntity, "bandwidthPriority must be -1, 0 or 1")
+	}
+	dir, err := cleanAddDir(s.client, req.DownloadDir)
+	if err != nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "downloadDir: %v", err)
+	}
+	add := AddRequest{URL: strings.TrimSpace(req.URL), Source: SourceAPI, Dir: dir, Labels: labels, Paused: req.Paused, Priority: req.BandwidthPriority}
+	switch {
+	case add.URL != "" && req.Metainfo != "":
+		return apiErrorf(http.StatusUnprocessableEntity, "give url or metainfo, not both")
+	case req.Metainfo != "":
+		if add.Data, err = base64.StdEncoding.DecodeString(req.Metainfo); err != nil {
+			return apiErrorf(http.StatusUnprocessableEntity, "metainfo is not valid base64: %v", err)
+		}
+	case isInfoHash(add.URL):
+		add.URL, _ = magnetFromHash(add.URL, "", nil)
+	case add.URL == "":
+		return apiErrorf(http.StatusUnprocessableEntity, "url or metainfo is required")
+	}
+	added, err := s.adder.Add(add)
+	if err != nil {
+		return &apiError{Status: addErrorStatus(err), Message: err.Error()}
+	}
+	status := http.StatusCreated
+	if added.Duplicate {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/torrents/%d", added.ID))
+	w.WriteHeader(status)
+	writeJSON(w, added)
+	return nil
+}
+
+// apiGetTorrent serves GET /api/v1/torrents/{id}: the torrent with its
+// files, trackers and pieces
+func (s *Server) apiGetTorrent(w http.ResponseWriter, r *http.Request) error {
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	d, err := c.GetTorrentDetail(t.ID)
+	if err != nil {
+		return err
+	}
+	d.Torrent.Obligation = s.seedRules.Obligation(d.Torrent)
+	if c == s.client {
+		d.ReannounceAt = s.reannounce.Scheduled(d.Torrent.HashString)
+	}
+	writeJSON(w, d)
+	return nil
+}
+
+// apiRemoveTorrent serves DELETE /api/v1/torrents/{id}[?deleteData=true][&force=true]
+func (s *Server) apiRemoveTorrent(w http.ResponseWriter, r *http.Request) error {
+	deleteData, _ := parseBoolParam(r.URL.Query().Get("deleteData"))
+	force, _ := parseBoolParam(r.URL.Query().Get("force"))
+	if deleteData && !features.Enabled(FeatureRemoveData) {
+		return apiErrorf(http.StatusForbidden, "%v", featureError(FeatureRemoveData))
+	}
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	if err := s.torrentAction(c, "remove", t.ID, deleteData, force); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiGetPeers serves GET /api/v1/torrents/{id}/peers
+func (s *Server) apiGetPeers(w http.ResponseWriter, r *http.Request) error {
+	if !features.Enabled(FeaturePeers) {
+		return apiErrorf(http.StatusForbidden, "%v", featureError(FeaturePeers))
+	}
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	peers, err := c.GetPeers(t.ID)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, PeerList{Peers: peers})
+	return nil
+}
+
+// apiSetLabels serves PUT /api/v1/torrents/{id}/labels with {"labels":
+// [...]}, replacing them; an API token's own label has to stay
+func (s *Server) apiSetLabels(w http.ResponseWriter, r *http.Request) error {
+	var req TorrentLabels
+	if err := decodeAPIBody(w, r, &req); err != nil {
+		return err
+	}
+	if req.Labels == nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "labels is required; send [] to clear them")
+	}
+	labels, err := normalizeLabels(req.Labels)
+	if err != nil {
+		return apiErrorf(http.StatusUnprocessableEntity, "%v", err)
+	}
+	if label := scopeLabel(r); label != "" && !slices.Contains(labels, label) {
+		return apiErrorf(http.StatusForbidden, "the %q label can't be removed with this token", label)
+	}
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	if err := c.SetLabels(t.ID, labels); err != nil {
+		return err
+	}
+	writeJSON(w, TorrentLabels{Labels: labels})
+	return nil
+}
+
+// apiTorrentAction serves POST /api/v1/torrents/{id}/<action>
+func (s *Server) apiTorrentAction(action string) apiHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		c, t, err := s.apiTorrent(r)
+		if err != nil {
+			return err
+		}
+		if err := s.torrentAction(c, action, t.ID, false, false); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// apiMoveQueue serves POST /api/v1/torrents/{id}/queue/{top,up,down,bottom}
+func (s *Server) apiMoveQueue(w http.ResponseWriter, r *http.Request) error {
+	move, ok := queueActions["queue-"+r.PathValue("move")]
+	if !ok {
+		return apiErrorf(http.StatusNotFound, "unknown queue move %q; use top, up, down or bottom", r.PathValue("move"))
+	}
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	if err := move(c, t.ID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// apiStats serves GET /api/v1/stats: the daemon's session stats
+func (s *Server) apiStats(w http.ResponseWriter, r *http.Request) error {
+	c, err := s.apiClient(r)
+	if err != nil {
+		return err
+	}
+	stats, err := c.GetSessionStats()
+	if err != nil {
+		return err
+	}
+	writeJSON(w, stats)
+	return nil
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// arrImportedLabel is added to torrents whose files Sonarr/Radarr imported
+const arrImportedLabel = "imported"
+
+// ArrDownload correlates a torrent with the Sonarr/Radarr item it was
+// grabbed for
+type ArrDownload struct {
+	Hash         string     `json:"hash"`
+	App          string     `json:"app"`
+	Title        string     `json:"title"`
+	ReleaseTitle string     `json:"releaseTitle"`
+	GrabbedAt    time.Time  `json:"grabbedAt"`
+	ImportedAt   *time.Time `json:"importedAt,omitempty"`
+}
+
+// arrWebhook is the subset of the Sonarr/Radarr webhook payload we use
+type arrWebhook struct {
+	EventType    string `json:"eventType"`
+	InstanceName string `json:"instanceName"`
+	DownloadID   string `json:"downloadId"`
+	Series       *struct {
+		Title string `json:"title"`
+	} `json:"series"`
+	Movie *struct {
+		Title string `json:"title"`
+		Year  int    `json:"year"`
+	} `json:"movie"`
+	Release *struct {
+		ReleaseTitle string `json:"releaseTitle"`
+	} `json:"release"`
+}
+
+// ArrTracker records Sonarr/Radarr grab and import notifications, labels
+// the matching torrents and tells policies which ones are safe to clean up
+type ArrTracker struct {
+	db     *sql.DB
+	client *TransmissionClient
+	token  string
+
+	mu        sync.Mutex
+	downloads map[string]*ArrDownload
+	labeled   map[string]bool // hashes whose labels are up to date
+}
+
+// NewArrTracker loads tracked downloads from the arr_downloads table
+func NewArrTracker(db *sql.DB, client *TransmissionClient, token string) (*ArrTracker, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS arr_downloads (
+		hash TEXT PRIMARY KEY,
+		app TEXT NOT NULL,
+		title TEXT NOT NULL,
+		release_title TEXT NOT NULL DEFAULT '',
+		grabbed_at DATETIME NOT NULL,
+		imported_at DATETIME
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &ArrTracker{
+		db:        db,
+		client:    client,
+		token:     token,
+		downloads: make(map[string]*ArrDownload),
+		labeled:   make(map[string]bool),
+	}
+
+	rows, err := db.Query("SELECT hash, app, title, release_title, grabbed_at, imported_at FROM arr_downloads")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d ArrDownload
+		var imported sql.NullTime
+		if err := rows.Scan(&d.Hash, &d.App, &d.Title, &d.ReleaseTitle, &d.GrabbedAt, &imported); err != nil {
+			return nil, err
+		}
+		if imported.Valid {
+			d.ImportedAt = &imported.Time
+		}
+		a.downloads[d.Hash] = &d
+	}
+	return a, rows.Err()
+}
+
+// Download returns the tracked download for hash
+func (a *ArrTracker) Download(hash string) (*ArrDownload, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	d, ok := a.downloads[hash]
+	if !ok {
+		return nil, false
+	}
+	copied := *d
+	return &copied, true
+}
+
+// Imported reports whether the torrent's files have been imported, which
+// makes it safe to remove once seeding obligations are met
+func (a *ArrTracker) Imported(hash string) bool {
+	if a == nil {
+		return false
+	}
+	d, ok := a.Download(hash)
+	return ok && d.ImportedAt != nil
+}
+
+// List returns every tracked download, newest first
+func (a *ArrTracker) List() []ArrDownload {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	list := make([]ArrDownload, 0, len(a.downloads))
+	for _, d := range a.downloads {
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].GrabbedAt.After(list[j].GrabbedAt)
+	})
+	return list
+}
+
+// Record handles one webhook notification. Events other than Grab and
+// Download (import) are ignored.
+func (a *ArrTracker) Record(hook *arrWebhook) error {
+	hash := strings.ToLower(hook.DownloadID)
+	if hash == "" {
+		return nil
+	}
+
+	app, title := "sonarr", ""
+	switch {
+	case hook.Series != nil:
+		title = hook.Series.Title
+	case hook.Movie != nil:
+		app, title = "radarr", hook.Movie.Title
+	}
+	if hook.InstanceName != "" {
+		app = strings.ToLower(hook.InstanceName)
+	}
+	release := ""
+	if hook.Release != nil {
+		release = hook.Release.ReleaseTitle
+	}
+
+	now := time.Now().UTC()
+	switch hook.EventType {
+	case "Grab":
+		_, err := a.db.Exec(
+			`INSERT INTO arr_downloads (hash, app, title, release_title, grabbed_at) VALUES (?, ?, ?, ?, ?)
+			 ON CONFLICT(hash) DO UPDATE SET app = excluded.app, title = excluded.title, release_title = excluded.release_title`,
+			hash, app, title, release, sqliteTime(now),
+		)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		if d, ok := a.downloads[hash]; ok {
+			d.App, d.Title, d.ReleaseTitle = app, title, release
+		} else {
+			a.downloads[hash] = &ArrDownload{Hash: hash, App: app, Title: title, ReleaseTitle: release, GrabbedAt: now}
+		}
+		delete(a.labeled, hash)
+		a.mu.Unlock()
+		log.Printf("%s grabbed %s (%s)", app, title, hash)
+
+	case "Download":
+		// Imports can arrive without a grab we saw, e.g. after a restore
+		_, err := a.db.Exec(
+			`INSERT INTO arr_downloads (hash, app, title, release_title, grabbed_at, imported_at) VALUES (?, ?, ?, ?, ?, ?)
+			 ON CONFLICT(hash) DO UPDATE SET imported_at = excluded.imported_at`,
+			hash, app, title, release, sqliteTime(now), sqliteTime(now),
+		)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		d, ok := a.downloads[hash]
+		if !ok {
+			d = &ArrDownload{Hash: hash, App: app, Title: title, ReleaseTitle: release, GrabbedAt: now}
+			a.downloads[hash] = d
+		}
+		d.ImportedAt = &now
+		delete(a.labeled, hash)
+		a.mu.Unlock()
+		log.Printf("%s imported %s (%s)", app, d.Title, hash)
+	}
+	return nil
+}
+
+// OnSnapshot labels tracked torrents with their series/movie title, plus
+// arrImportedLabel once imported. Grabs usually arrive before the torrent
+// shows up in the daemon, so labeling waits for it here.
+func (a *ArrTracker) OnSnapshot(_, cur *Snapshot) {
+	for i := range cur.Torrents {
+		t := &cur.Torrents[i]
+		a.mu.Lock()
+		d, ok := a.downloads[t.HashString]
+		done := a.labeled[t.HashString]
+		var want []string
+		if ok && !done {
+			want = []string{d.Title}
+			if d.ImportedAt != nil {
+				want = append(want, arrImportedLabel)
+			}
+		}
+		a.mu.Unlock()
+		if !ok || done {
+			continue
+		}
+
+		labels := append([]string(nil), t.Labels...)
+		for _, l := range want {
+			// Transmission rejects labels containing commas
+			l = strings.TrimSpace(strings.ReplaceAll(l, ",", ""))
+			if l != "" && !containsString(labels, l) {
+				labels = append(labels, l)
+			}
+		}
+		if len(labels) != len(t.Labels) {
+			if err := a.client.SetLabels(t.ID, labels); err != nil {
+				log.Printf("Failed to label %s: %v", t.Name, err)
+				continue
+			}
+		}
+
+		a.mu.Lock()
+		a.labeled[t.HashString] = true
+		a.mu.Unlock()
+	}
+}
+
+// arrAuthorized checks the webhook token, given either as ?token= or as
+// the basic auth password Sonarr/Radarr send. Without a token the webhook
+// needs the usual login, like the rest of the API.
+func (s *Server) arrAuthorized(r *http.Request) bool {
+	a := s.arr
+	if a.token == "" {
+		return s.auth == nil || s.auth.authenticated(r)
+	}
+	given := r.URL.Query().Get("token")
+	if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) == 1
+}
+
+func (s *Server) handleArrWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.arrAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var hook arrWebhook
+	if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.arr.Record(&hook); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleArrDownloads(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{"downloads": s.arr.List()})
+}
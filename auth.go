@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookieName = "tw_session"
+	defaultSessionTTL = 7 * 24 * time.Hour
+)
+
+// authEnabled is read by the templates to show the sign-out link
+var authEnabled bool
+
+// WebAuth protects the UI and API with a login for WEB_USER/WEB_PASS.
+// Sessions are random tokens stored hashed in SQLite, so they survive
+// restarts and a leaked database doesn't leak live cookies.
+type WebAuth struct {
+	db     *sql.DB
+	ttl    time.Duration
+	secure *bool // nil sets Secure only on HTTPS requests
+
+	mu   sync.RWMutex
+	user string
+	pass string
+}
+
+// NewWebAuth creates the sessions table. A nil WebAuth (no WEB_USER) leaves
+// everything open.
+func NewWebAuth(db *sql.DB, user, pass string, ttl time.Duration, secure *bool) (*WebAuth, error) {
+	if user == "" {
+		return nil, nil
+	}
+	if pass == "" {
+		return nil, errors.New("WEB_PASS is required when WEB_USER is set")
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS web_sessions (
+		token_hash TEXT PRIMARY KEY,
+		username TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create web_sessions table: %w", err)
+	}
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	return &WebAuth{db: db, user: user, pass: pass, ttl: ttl, secure: secure}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetCredentials changes the login, for config reloads. A change signs out
+// every session, so a revoked password doesn't stay usable through an old
+// cookie; turning the login off needs a restart.
+func (a *WebAuth) SetCredentials(user, pass string) error {
+	if user == "" {
+		return errors.New("WEB_USER can't be removed without a restart")
+	}
+	if pass == "" {
+		return errors.New("WEB_PASS is required when WEB_USER is set")
+	}
+	a.mu.Lock()
+	changed := user != a.user || pass != a.pass
+	a.user, a.pass = user, pass
+	a.mu.Unlock()
+	if changed {
+		if _, err := a.db.Exec("DELETE FROM web_sessions"); err != nil {
+			return fmt.Errorf("failed to sign out sessions: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkPassword compares credentials in constant time
+func (a *WebAuth) checkPassword(user, pass string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.user)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(a.pass)) == 1
+	return userOK && passOK
+}
+
+// createSession stores a new session and returns its token
+func (a *WebAuth) createSession(user string) (string, time.Time, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+	expires := now.Add(a.ttl)
+
+	// Expired sessions are only swept on login; lookups ignore them anyway
+	if _, err := a.db.Exec("DELETE FROM web_sessions WHERE expires_at < ?", now); err != nil {
+		log.Printf("Failed to delete expired sessions: %v", err)
+	}
+	_, err := a.db.Exec("INSERT INTO web_sessions (token_hash, username, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		hashToken(token), user, now, expires)
+	return token, expires, err
+}
+
+// sessionUser returns who a session cookie belongs to, or "" if it's
+// missing, unknown or expired
+func (a *WebAuth) sessionUser(r *http.Request) string {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	var user string
+	err = a.db.QueryRow("SELECT username FROM web_sessions WHERE token_hash = ? AND expires_at > ?",
+		hashToken(cookie.Value), time.Now()).Scan(&user)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Failed to look up session: %v", err)
+		}
+		return ""
+	}
+	return user
+}
+
+// requestUser is who a request is signed in as, for per-user settings;
+// "" when there's no login
+func (a *WebAuth) requestUser(r *http.Request) string {
+	if a == nil {
+		return ""
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	return a.sessionUser(r)
+}
+
+// authenticated accepts a session cookie, or basic auth with the same
+// credentials for scripts
+func (a *WebAuth) authenticated(r *http.Request) bool {
+	if user, pass, ok := r.BasicAuth(); ok {
+		return a.checkPassword(user, pass)
+	}
+	return a.sessionUser(r) != ""
+}
+
+// secureCookie decides the cookie's Secure flag: WEB_COOKIE_SECURE if set,
+// otherwise whether the request came in over HTTPS (directly or through a
+// proxy setting X-Forwarded-Proto)
+func (a *WebAuth) secureCookie(r *http.Request) bool {
+	if a.secure != nil {
+		return *a.secure
+	}
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (a *WebAuth) setCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.secureCookie(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// publicPath lists what's reachable without logging in: the login page,
+// the health check, and endpoints with their own key (the *arr and IP
+// webhooks, the Prowlarr sync API and /metrics, which check the login
+// themselves when they have no token)
+func publicPath(path string) bool {
+	switch path {
+	case "/login", "/healthz", "/api/webhooks/arr", "/api/webhooks/ip", "/metrics":
+		return true
+	}
+	return strings.HasPrefix(path, "/api/v3/")
+}
+
+// Middleware rejects unauthenticated requests: API calls get a 401 and
+// pages redirect to the login form
+func (a *WebAuth) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPath(r.URL.Path) || a.authenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/ws" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			writeJSONError(w, "authentication required")
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// safeNext only allows redirects back into this site after login
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	next := safeNext(r.FormValue("next"))
+	data := map[string]interface{}{"Next": next, "Version": Version}
+
+	if r.Method != "POST" {
+		s.render(w, "login.html", data)
+		return
+	}
+	user := r.PostFormValue("username")
+	if !s.auth.checkPassword(user, r.PostFormValue("password")) {
+		log.Printf("Failed login for %q from %s", user, r.RemoteAddr)
+		data["Error"] = "Invalid username or password"
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusUnauthorized)
+		s.render(w, "login.html", data)
+		return
+	}
+	token, expires, err := s.auth.createSession(user)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.auth.setCookie(w, r, token, expires)
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth != nil {
+		if cookie, err := r.Cookie(sessionCookieName); err == nil {
+			if _, err := s.auth.db.Exec("DELETE FROM web_sessions WHERE token_hash = ?", hashToken(cookie.Value)); err != nil {
+				log.Printf("Failed to delete session: %v", err)
+			}
+		}
+		s.auth.setCookie(w, r, "", time.Unix(0, 0))
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBandwidthInterval  = 10 * time.Second
+	defaultBandwidthRetention = 30 * 24 * time.Hour
+	bandwidthPruneInterval    = 10 * time.Minute
+)
+
+// bandwidthTier is one resolution samples are kept at. Resolution 0 is
+// every sample; the others are averages over buckets of that length.
+type bandwidthTier struct {
+	resolution time.Duration
+	retention  time.Duration // 0 uses BANDWIDTH_RETENTION
+}
+
+var bandwidthTiers = []bandwidthTier{
+	{0, 2 * time.Hour},
+	{5 * time.Minute, 2 * 24 * time.Hour},
+	{time.Hour, 0},
+}
+
+// bandwidthRanges are the spans /api/stats/history charts, and the tier
+// each is drawn from
+var bandwidthRanges = map[string]struct {
+	window     time.Duration
+	resolution time.Duration
+}{
+	"hour": {time.Hour, 0},
+	"day":  {24 * time.Hour, 5 * time.Minute},
+	"week": {7 * 24 * time.Hour, time.Hour},
+}
+
+// BandwidthPoint is the session's transfer rates at a sample, or their
+// average and peak over a bucket
+type BandwidthPoint struct {
+	Time         time.Time `json:"time"`
+	Download     int64     `json:"download"` // bytes/s
+	Upload       int64     `json:"upload"`
+	DownloadPeak int64     `json:"downloadPeak"`
+	UploadPeak   int64     `json:"uploadPeak"`
+}
+
+// BandwidthHistory samples the default daemon's session-stats on its own
+// interval, independent of how busy the poller is, and keeps the rates in
+// SQLite at each tier's resolution: raw samples for the last hours,
+// five-minute averages for the last days and hourly ones for
+// BANDWIDTH_RETENTION.
+type BandwidthHistory struct {
+	db        *sql.DB
+	client    *TransmissionClient
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewBandwidthHistory creates the bandwidth_history table
+func NewBandwidthHistory(db *sql.DB, client *TransmissionClient, retention time.Duration) (*BandwidthHistory, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS bandwidth_history (
+		resolution INTEGER NOT NULL,
+		bucket DATETIME NOT NULL,
+		samples INTEGER NOT NULL,
+		download_sum INTEGER NOT NULL,
+		upload_sum INTEGER NOT NULL,
+		download_peak INTEGER NOT NULL,
+		upload_peak INTEGER NOT NULL,
+		PRIMARY KEY (resolution, bucket)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bandwidth_history table: %w", err)
+	}
+	if retention <= 0 {
+		retention = defaultBandwidthRetention
+	}
+	return &BandwidthHistory{db: db, client: client, retention: retention}, nil
+}
+
+// Start samples on an interval
+func (h *BandwidthHistory) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultBandwidthInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			h.sample()
+			<-ticker.C
+		}
+	}()
+}
+
+func (h *BandwidthHistory) sample() {
+	stats, err := h.client.GetSessionStats()
+	if err != nil {
+		return
+	}
+	h.Record(time.Now(), stats.DownloadSpeed, stats.UploadSpeed)
+}
+
+// Record adds a sample to every tier
+func (h *BandwidthHistory) Record(at time.Time, down, up int64) {
+	at = at.UTC()
+	for _, tier := range bandwidthTiers {
+		bucket := at.Truncate(time.Second)
+		if tier.resolution > 0 {
+			bucket = at.Truncate(tier.resolution)
+		}
+		_, err := h.db.Exec(`INSERT INTO bandwidth_history (resolution, bucket, samples, download_sum, upload_sum, download_peak, upload_peak)
+			VALUES (?, ?, 1, ?, ?, ?, ?)
+			ON CONFLICT(resolution, bucket) DO UPDATE SET
+			  samples = samples + 1,
+			  download_sum = download_sum + excluded.download_sum,
+			  upload_sum = upload_sum + excluded.upload_sum,
+			  download_peak = MAX(download_peak, excluded.download_peak),
+			  upload_peak = MAX(upload_peak, excluded.upload_peak)`,
+			int(tier.resolution.Seconds()), bucket, down, up, down, up)
+		if err != nil {
+			log.Printf("Failed to record bandwidth: %v", err)
+			return
+		}
+	}
+	h.prune(at)
+}
+
+// prune drops each tier's buckets past its retention, every ten minutes
+func (h *BandwidthHistory) prune(now time.Time) {
+	h.mu.Lock()
+	if now.Sub(h.lastPrune) < bandwidthPruneInterval {
+		h.mu.Unlock()
+		return
+	}
+	h.lastPrune = now
+	h.mu.Unlock()
+	for _, tier := range bandwidthTiers {
+		retention := tier.retention
+		if retention == 0 {
+			retention = h.retention
+		}
+		_, err := h.db.Exec("DELETE FROM bandwidth_history WHERE resolution = ? AND bucket < ?",
+			int(tier.resolution.Seconds()), now.Add(-retention))
+		if err != nil {
+			log.Printf("Failed to prune bandwidth history: %v", err)
+		}
+	}
+}
+
+// History returns the points for span ("hour", "day" or "week") up to
+// now, oldest first, and the resolution they're at
+func (h *BandwidthHistory) History(span string, now time.Time) ([]BandwidthPoint, time.Duration, error) {
+	r, ok := bandwidthRanges[span]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown range %q; use hour, day or week", span)
+	}
+	rows, err := h.db.Query(`SELECT bucket, download_sum / samples, upload_sum / samples, download_peak, upload_peak
+		FROM bandwidth_history WHERE resolution = ? AND bucket >= ? ORDER BY bucket`,
+		int(r.resolution.Seconds()), now.UTC().Add(-r.window))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	points := []BandwidthPoint{}
+	for rows.Next() {
+		var p BandwidthPoint
+		if err := rows.Scan(&p.Time, &p.Download, &p.Upload, &p.DownloadPeak, &p.UploadPeak); err != nil {
+			return nil, 0, err
+		}
+		points = append(points, p)
+	}
+	return points, r.resolution, rows.Err()
+}
+
+// handleStatsHistory serves GET /api/stats/history?range=hour|day|week:
+// the default daemon's transfer rates over the span
+func (s *Server) handleStatsHistory(w http.ResponseWriter, r *http.Request) {
+	span := r.URL.Query().Get("range")
+	if span == "" {
+		span = "hour"
+	}
+	points, resolution, err := s.bandwidth.History(span, time.Now())
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"range":      span,
+		"resolution": int(resolution.Seconds()), // 0 for raw samples
+		"points":     points,
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The basic view is a server-rendered torrent list whose actions are plain
+// form posts that redirect back with a status message, so it works without
+// JavaScript: in text browsers, with scripts blocked and with screen readers.
+
+// basicActions are the single-torrent actions the basic view's forms post
+var basicActions = map[string]string{
+	"start":      "Started",
+	"stop":       "Stopped",
+	"reannounce": "Reannounced",
+	"verify":     "Verifying",
+	"remove":     "Removed",
+	// Queue moves
+	"queue-top":    "Moved to the top of the queue",
+	"queue-up":     "Moved up the queue",
+	"queue-down":   "Moved down the queue",
+	"queue-bottom": "Moved to the bottom of the queue",
+}
+
+// basicRedirect sends the browser back to next with a status message
+func basicRedirect(w http.ResponseWriter, r *http.Request, next, msg string, failed bool) {
+	u, err := url.Parse(safeNext(next))
+	if err != nil {
+		u = &url.URL{Path: "/basic"}
+	}
+	q := u.Query()
+	q.Del("msg")
+	q.Del("error")
+	if failed {
+		q.Set("error", msg)
+	} else {
+		q.Set("msg", msg)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// basicNext is where a basic form returns to: its "next" field, or the list
+func basicNext(r *http.Request) string {
+	if next := r.FormValue("next"); strings.HasPrefix(next, "/basic") {
+		return next
+	}
+	return "/basic"
+}
+
+// handleBasicPage renders the list with a form per action
+func (s *Server) handleBasicPage(w http.ResponseWriter, r *http.Request) {
+	instance := r.URL.Query().Get("instance")
+	torrents, stats, listErrs, err := s.listTorrents(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if instance == "" {
+		instance = DefaultInstance
+	}
+
+	query := parseTorrentQuery(r.URL.Query(), defaultPageSize)
+	list := newTorrentIndex(filterTorrents(torrents, r.URL.Query())).query(query)
+	for i := range list.Torrents {
+		list.Torrents[i].Remedy = remedyFor(&list.Torrents[i])
+	}
+	pageURL := func(n int) string {
+		v := query.values(n)
+		if instance != DefaultInstance {
+			v.Set("instance", instance)
+		}
+		return "/basic?" + v.Encode()
+	}
+	var prevPage, nextPage string
+	if list.Page > 1 {
+		prevPage = pageURL(min(list.Page-1, list.Pages))
+	}
+	if list.Page < list.Pages {
+		nextPage = pageURL(list.Page + 1)
+	}
+
+	s.render(w, "basic.html", map[string]interface{}{
+		"List":          list,
+		"Query":         query,
+		"Here":          pageURL(list.Page),
+		"PrevPage":      prevPage,
+		"NextPage":      nextPage,
+		"StatusFilters": torrentStatusNames,
+		"SortKeys":      listSortKeys,
+		"Stats":         stats,
+		"Instance":      instance,
+		"Instances":     s.instanceNames(),
+		"Down":          errorStrings(listErrs),
+		"Message":       r.URL.Query().Get("msg"),
+		"Error":         r.URL.Query().Get("error"),
+		"Version":       Version,
+	})
+}
+
+// handleBasicRemove asks before removing, since a form can't confirm()
+func (s *Server) handleBasicRemove(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	instance := r.URL.Query().Get("instance")
+	client, err := s.clientFor(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	t, err := client.GetTorrent(id)
+	if err != nil {
+		basicRedirect(w, r, basicNext(r), err.Error(), true)
+		return
+	}
+	t.Obligation = s.seedRules.Obligation(t)
+	s.render(w, "basic-remove.html", map[string]interface{}{
+		"Torrent":  t,
+		"Blocked":  s.seedRules.Blocks(t),
+		"Instance": instance,
+		"Next":     basicNext(r),
+		"Version":  Version,
+	})
+}
+
+// handleBasicAction runs a form-posted action and redirects back
+func (s *Server) handleBasicAction(w http.ResponseWriter, r *http.Request) {
+	next := basicNext(r)
+	action := r.FormValue("action")
+	done, ok := basicActions[action]
+	if !ok {
+		basicRedirect(w, r, next, "Unknown action", true)
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		basicRedirect(w, r, next, "Invalid torrent", true)
+		return
+	}
+	client, err := s.clientFor(r.FormValue("instance"))
+	if err != nil {
+		basicRedirect(w, r, next, err.Error(), true)
+		return
+	}
+	name := r.FormValue("name")
+	if name == "" {
+		name = fmt.Sprintf("torrent %d", id)
+	}
+	deleteData := r.FormValue("deleteData") == "true"
+	if err := s.torrentAction(client, action, id, deleteData, r.FormValue("force") == "true"); err != nil {
+		basicRedirect(w, r, next, fmt.Sprintf("Couldn't %s %s: %v", action, name, err), true)
+		return
+	}
+	if deleteData {
+		done += " with its data"
+	}
+	basicRedirect(w, r, next, done+": "+name, false)
+}
+
+// handleBasicAdd adds a magnet link, info-hash or uploaded .torrent
+func (s *Server) handleBasicAdd(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAddBodySize)
+	next := basicNext(r)
+	req := AddRequest{Source: SourceUI}
+	if file, _, err := r.FormFile("torrent-file"); err == nil {
+		defer file.Close()
+		if req.Data, err = io.ReadAll(file); err != nil {
+			basicRedirect(w, r, next, "Failed to read file", true)
+			return
+		}
+	} else if magnet := strings.TrimSpace(r.FormValue("magnet")); magnet != "" {
+		if isInfoHash(magnet) {
+			magnet, _ = magnetFromHash(magnet, "", nil)
+		}
+		req.URL = magnet
+	} else {
+		basicRedirect(w, r, next, "Paste a magnet link or choose a .torrent file", true)
+		return
+	}
+
+	added, err := s.adder.Add(req)
+	if err != nil {
+		log.Printf("Basic view add failed: %v", err)
+		basicRedirect(w, r, next, "Couldn't add the torrent: "+err.Error(), true)
+		return
+	}
+	switch {
+	case added == nil || added.Name == "":
+		basicRedirect(w, r, next, "Added", false)
+	case added.Duplicate:
+		basicRedirect(w, r, next, "Already in Transmission: "+added.Name, false)
+	default:
+		basicRedirect(w, r, next, "Added: "+added.Name, false)
+	}
+}
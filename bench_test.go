@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// benchTorrentCount approximates a large seedbox
+const benchTorrentCount = 15000
+
+func benchTorrents(n int) []Torrent {
+	torrents := make([]Torrent, n)
+	for i := range torrents {
+		torrents[i] = Torrent{
+			ID:             i + 1,
+			Name:           fmt.Sprintf("Some.Show.S%02dE%02d.1080p.WEB.h264-GROUP", i%20, i%24),
+			Status:         6,
+			PercentDone:    1,
+			RateUpload:     int64(i * 37),
+			UploadRatio:    float64(i%300) / 100,
+			SizeWhenDone:   int64(i) * 1 << 20,
+			DownloadedEver: int64(i) * 1 << 20,
+			UploadedEver:   int64(i) * 3 << 19,
+			AddedDate:      1700000000 + int64(i),
+			HashString:     fmt.Sprintf("%040x", i),
+			Labels:         []string{"tv"},
+			Trackers:       []TrackerInfo{{Announce: "https://tracker.example.org/announce"}},
+		}
+	}
+	return torrents
+}
+
+// benchDaemon serves a canned torrent-get response
+func benchDaemon(b *testing.B, n int) *TransmissionClient {
+	b.Helper()
+	body, err := json.Marshal(map[string]interface{}{
+		"arguments": map[string]interface{}{"torrents": benchTorrents(n)},
+		"result":    "success",
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+	b.Cleanup(srv.Close)
+	b.SetBytes(int64(len(body)))
+	return NewTransmissionClient(srv.URL, "", "")
+}
+
+func BenchmarkGetTorrents(b *testing.B) {
+	client := benchDaemon(b, benchTorrentCount)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := client.GetTorrents(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkGetTorrentsUnmarshal is the whole-body decode GetTorrents used to
+// do, for comparison
+func BenchmarkGetTorrentsUnmarshal(b *testing.B) {
+	client := benchDaemon(b, benchTorrentCount)
+	b.ReportAllocs()
+	for b.Loop() {
+		resp, err := client.doRequest(&RPCRequest{Method: "torrent-get"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		var list struct {
+			Torrents []Torrent `json:"torrents"`
+		}
+		if err := json.Unmarshal(resp.Arguments, &list); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeTorrentList(b *testing.B) {
+	torrents := benchTorrents(benchTorrentCount)
+	stats := &SessionStats{TorrentCount: benchTorrentCount}
+	b.ReportAllocs()
+	for b.Loop() {
+		addDisplay(torrents, stats)
+		if err := json.NewEncoder(io.Discard).Encode(map[string]interface{}{
+			"torrents": torrents,
+			"stats":    stats,
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWindowTorrents(b *testing.B) {
+	torrents := benchTorrents(benchTorrentCount)
+	q := url.Values{"sort": {"name"}, "offset": {"5000"}, "limit": {"100"}}
+	b.ReportAllocs()
+	for b.Loop() {
+		page := append([]Torrent(nil), torrents...)
+		if _, err := windowTorrents(page, q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNDJSONFiles(b *testing.B) {
+	file := TorrentFile{TorrentID: 1, Name: "Some.Show.S01E01.mkv", Length: 1 << 30, Wanted: true}
+	b.ReportAllocs()
+	for b.Loop() {
+		rec := httptest.NewRecorder()
+		nw := newNDJSONWriter(rec)
+		for i := 0; i < 10000; i++ {
+			file.Index = i
+			if err := nw.Write(file); err != nil {
+				b.Fatal(err)
+			}
+		}
+		nw.Flush()
+	}
+}
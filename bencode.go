@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1" //nolint:gosec // BitTorrent v1 info-hashes are SHA-1 by definition
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Minimal bencode decoder, just enough to inspect .torrent files before
+// handing them to Transmission
+
+var errBencode = errors.New("invalid bencode")
+
+// maxBencodeDepth bounds list and dict nesting, so a crafted file can't
+// recurse until the stack overflows; real torrents nest a few levels deep
+const maxBencodeDepth = 64
+
+type bdecoder struct {
+	data []byte
+	pos  int
+}
+
+// decode reads the next value: int64, string, []interface{} or
+// map[string]interface{}. depth is how many lists and dicts enclose it.
+func (d *bdecoder) decode(depth int) (interface{}, error) {
+	if d.pos >= len(d.data) || depth > maxBencodeDepth {
+		return nil, errBencode
+	}
+
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(d.data[d.pos:], 'e')
+		if end < 0 {
+			return nil, errBencode
+		}
+		n, err := strconv.ParseInt(string(d.data[d.pos+1:d.pos+end]), 10, 64)
+		if err != nil {
+			return nil, errBencode
+		}
+		d.pos += end + 1
+		return n, nil
+
+	case c == 'l':
+		d.pos++
+		var list []interface{}
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode // no closing 'e'
+		}
+		d.pos++
+		return list, nil
+
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]interface{})
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.decodeString()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = v
+		}
+		if d.pos >= len(d.data) {
+			return nil, errBencode // no closing 'e'
+		}
+		d.pos++
+		return dict, nil
+
+	case c >= '0' && c <= '9':
+		return d.decodeString()
+	}
+
+	return nil, errBencode
+}
+
+func (d *bdecoder) decodeString() (string, error) {
+	colon := bytes.IndexByte(d.data[d.pos:], ':')
+	if colon < 0 {
+		return "", errBencode
+	}
+	n, err := strconv.Atoi(string(d.data[d.pos : d.pos+colon]))
+	if err != nil || n < 0 {
+		return "", errBencode
+	}
+	start := d.pos + colon + 1
+	if start+n > len(d.data) {
+		return "", errBencode
+	}
+	d.pos = start + n
+	return string(d.data[start:d.pos]), nil
+}
+
+// TorrentMeta is the subset of a .torrent file the app cares about
+type TorrentMeta struct {
+	InfoHash string
+	Name     string
+	Private  bool
+	Trackers []string
+}
+
+// parseTorrentFile decodes a .torrent file and computes its v1 info-hash
+func parseTorrentFile(data []byte) (*TorrentMeta, error) {
+	d := &bdecoder{data: data}
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, errBencode
+	}
+	d.pos++
+
+	meta := &TorrentMeta{}
+	var info map[string]interface{}
+	for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+		key, err := d.decodeString()
+		if err != nil {
+			return nil, err
+		}
+		start := d.pos
+		v, err := d.decode(1)
+		if err != nil {
+			return nil, err
+		}
+
+		switch key {
+		case "info":
+			sum := sha1.Sum(data[start:d.pos]) //nolint:gosec
+			meta.InfoHash = hex.EncodeToString(sum[:])
+			info, _ = v.(map[string]interface{})
+		case "announce":
+			if s, ok := v.(string); ok {
+				meta.Trackers = append(meta.Trackers, s)
+			}
+		case "announce-list":
+			tiers, _ := v.([]interface{})
+			for _, tier := range tiers {
+				urls, _ := tier.([]interface{})
+				for _, u := range urls {
+					if s, ok := u.(string); ok && !containsString(meta.Trackers, s) {
+						meta.Trackers = append(meta.Trackers, s)
+					}
+				}
+			}
+		}
+	}
+	if d.pos >= len(d.data) {
+		return nil, errBencode // no closing 'e'
+	}
+
+	if info == nil {
+		return nil, fmt.Errorf("%w: missing info dictionary", errBencode)
+	}
+	meta.Name, _ = info["name"].(string)
+	if private, ok := info["private"].(int64); ok && private == 1 {
+		meta.Private = true
+	}
+	return meta, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
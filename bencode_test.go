@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseTorrentFileTruncated(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not a dict", "l4:infoe"},
+		{"unterminated top level", "d4:infod4:name1:xe"},
+		{"unterminated info dict", "d4:infod"},
+		{"unterminated info dict with a key", "d4:infod4:name1:x"},
+		{"unterminated list", "d8:announce3:url13:announce-listll3:url"},
+		{"unterminated int", "d4:infod7:privatei1"},
+		{"string past the end", "d4:infod4:name10:x"},
+		{"missing value", "d4:info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Spare capacity, as io.ReadAll leaves, mustn't be read: here
+			// it would close every open list and dict
+			data := []byte(tt.data + "eeeeeeee")[:len(tt.data)]
+			if _, err := parseTorrentFile(data); !errors.Is(err, errBencode) {
+				t.Errorf("parseTorrentFile(%q) error = %v, want errBencode", tt.data, err)
+			}
+		})
+	}
+}
+
+func TestParseTorrentFileNested(t *testing.T) {
+	// Deep enough to overflow the stack without a depth limit
+	data := append([]byte("d4:info"), bytes.Repeat([]byte("l"), 20<<20)...)
+	if _, err := parseTorrentFile(data); !errors.Is(err, errBencode) {
+		t.Errorf("deeply nested lists: error = %v, want errBencode", err)
+	}
+	ok := "d4:infod4:name1:x4:deep" + string(bytes.Repeat([]byte("l"), 10)) + string(bytes.Repeat([]byte("e"), 10)) + "ee"
+	if _, err := parseTorrentFile([]byte(ok)); err != nil {
+		t.Errorf("lists nested 10 deep: %v", err)
+	}
+}
+
+func TestParseTorrentFile(t *testing.T) {
+	data := []byte("d8:announce9:udp://a:113:announce-listll9:udp://a:1el9:udp://b:2ee4:infod4:name4:test7:privatei1eee")
+	meta, err := parseTorrentFile(data)
+	if err != nil {
+		t.Fatalf("parseTorrentFile: %v", err)
+	}
+	if meta.Name != "test" || !meta.Private {
+		t.Errorf("got name %q private %v, want test and true", meta.Name, meta.Private)
+	}
+	if len(meta.Trackers) != 2 || meta.Trackers[0] != "udp://a:1" || meta.Trackers[1] != "udp://b:2" {
+		t.Errorf("got trackers %v", meta.Trackers)
+	}
+	if len(meta.InfoHash) != 40 {
+		t.Errorf("got info-hash %q", meta.InfoHash)
+	}
+}
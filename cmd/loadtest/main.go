@@ -0,0 +1,131 @@
+// Command loadtest simulates many dashboard clients polling a
+// transmission-web instance and reports request latency, so regressions in
+// the poller and list endpoints show up as numbers.
+//
+// Without a daemon to hand, load a demo instance, which serves DEMO_TORRENTS
+// synthetic torrents:
+//
+//	DEMO_TORRENTS=15000 ./transmission-web --demo &
+//	go run ./cmd/loadtest -url http://localhost:8080 -clients 100 -duration 1m
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func main() {
+	target := flag.String("url", "", "transmission-web base URL to load, e.g. http://localhost:8080")
+	clients := flag.Int("clients", 50, "number of simulated polling clients")
+	interval := flag.Duration("interval", 2*time.Second, "how often each client polls")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	paths := flag.String("paths", "/api/torrents", "comma-separated paths each client polls in turn")
+	flag.Parse()
+
+	if *target == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	res := run(*target, strings.Split(*paths, ","), *clients, *interval, *duration)
+	res.report(os.Stdout, *duration)
+}
+
+// results collects latencies by path
+type results struct {
+	mu        sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+func (r *results) add(path string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors[path]++
+		return
+	}
+	r.latencies[path] = append(r.latencies[path], d)
+}
+
+func run(base string, paths []string, clients int, interval, duration time.Duration) *results {
+	res := &results{latencies: map[string][]time.Duration{}, errors: map[string]int{}}
+	httpClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConnsPerHost: clients},
+	}
+	deadline := time.Now().Add(duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Spread the clients over the interval like real browsers
+			time.Sleep(interval * time.Duration(i) / time.Duration(clients))
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for n := 0; time.Now().Before(deadline); n++ {
+				path := paths[n%len(paths)]
+				start := time.Now()
+				err := fetch(httpClient, base+path)
+				res.add(path, time.Since(start), err)
+				<-ticker.C
+			}
+		}()
+	}
+	wg.Wait()
+	return res
+}
+
+func fetch(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (r *results) report(w io.Writer, duration time.Duration) {
+	var paths []string
+	for p := range r.latencies {
+		paths = append(paths, p)
+	}
+	for p := range r.errors {
+		if _, ok := r.latencies[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintf(w, "%-30s %8s %8s %8s %10s %10s %10s %10s\n", "path", "requests", "errors", "req/s", "p50", "p90", "p99", "max")
+	for _, p := range paths {
+		lat := r.latencies[p]
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		fmt.Fprintf(w, "%-30s %8d %8d %8.1f %10s %10s %10s %10s\n",
+			p, len(lat), r.errors[p], float64(len(lat))/duration.Seconds(),
+			percentile(lat, 0.50), percentile(lat, 0.90), percentile(lat, 0.99), percentile(lat, 1))
+	}
+}
+
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(q * float64(len(sorted)-1))
+	return sorted[i].Round(time.Microsecond)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Parameter types a command can declare
+const (
+	ParamInt    = "int"
+	ParamBool   = "bool"
+	ParamString = "string"
+)
+
+// CommandParam describes one argument of a command
+type CommandParam struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Command is an action the command palette can offer and run. New actions
+// are added to the commands list and show up in /api/commands without any
+// template changes.
+type Command struct {
+	Name        string         `json:"name"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Params      []CommandParam `json:"params"`
+
+	// available hides commands whose subsystem isn't configured
+	available func(s *Server) bool
+	run       func(ctx context.Context, s *Server, args commandArgs) (interface{}, error)
+}
+
+// commandArgs holds a command's validated arguments. Missing optional
+// arguments read as zero values.
+type commandArgs map[string]interface{}
+
+func (a commandArgs) Int(name string) int {
+	n, _ := a[name].(int)
+	return n
+}
+
+func (a commandArgs) Bool(name string) bool {
+	b, _ := a[name].(bool)
+	return b
+}
+
+func (a commandArgs) String(name string) string {
+	v, _ := a[name].(string)
+	return v
+}
+
+var idParam = CommandParam{Name: "id", Type: ParamInt, Required: true, Description: "Torrent ID"}
+
+var commands = []Command{
+	{
+		Name: "torrent.start", Title: "Start torrent", Params: []CommandParam{idParam},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.client.StartTorrent(a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.stop", Title: "Stop torrent", Params: []CommandParam{idParam},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.client.StopTorrent(a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.remove", Title: "Remove torrent",
+		Params: []CommandParam{
+			idParam,
+			{Name: "deleteData", Type: ParamBool, Description: "Also delete the downloaded files"},
+			{Name: "force", Type: ParamBool, Description: "Remove even if it still owes its tracker seeding"},
+		},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.removeTorrent(a.Int("id"), a.Bool("deleteData"), a.Bool("force"))
+		},
+	},
+	{
+		Name: "torrent.reannounce", Title: "Reannounce torrent", Params: []CommandParam{idParam},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.client.ReannounceTorrent(a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.reannounce-when-allowed", Title: "Reannounce once the trackers allow it", Params: []CommandParam{idParam},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			t, err := s.client.GetTorrent(a.Int("id"))
+			if err != nil {
+				return nil, err
+			}
+			at, err := s.reannounce.Schedule(t)
+			if err != nil || at.IsZero() {
+				return nil, err
+			}
+			return map[string]interface{}{"reannounceAt": at}, nil
+		},
+	},
+	{
+		Name: "torrent.verify", Title: "Verify torrent data", Params: []CommandParam{idParam},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.client.VerifyTorrent(a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.queue", Title: "Move torrent in queue",
+		Params: []CommandParam{idParam, {Name: "move", Type: ParamString, Required: true, Description: "top, up, down or bottom"}},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			move, ok := queueActions["queue-"+a.String("move")]
+			if !ok {
+				return nil, fmt.Errorf("move must be top, up, down or bottom")
+			}
+			return nil, move(s.client, a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.labels", Title: "Set torrent labels",
+		Params: []CommandParam{idParam, {Name: "labels", Type: ParamString, Required: true, Description: "Comma-separated labels; empty clears them"}},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			labels, err := parseLabelList(a.String("labels"))
+			if err != nil {
+				return nil, err
+			}
+			return labelEdit{Set: labels}.apply(s.client, a.Int("id"))
+		},
+	},
+	{
+		Name: "torrent.move", Title: "Move torrent data",
+		Params: []CommandParam{
+			idParam,
+			{Name: "location", Type: ParamString, Required: true, Description: "Absolute directory on the daemon"},
+			{Name: "keepData", Type: ParamBool, Description: "Only point the torrent at data already in the new location"},
+		},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return moveTorrent(s.client, a.Int("id"), a.String("location"), !a.Bool("keepData"))
+		},
+	},
+	{
+		Name: "torrents.reannounce-all", Title: "Reannounce all torrents",
+		run: func(_ context.Context, s *Server, _ commandArgs) (interface{}, error) {
+			return nil, s.client.ReannounceAll()
+		},
+	},
+	{
+		Name: "torrent.add", Title: "Add torrent", Description: "Add a magnet link or .torrent URL",
+		Params: []CommandParam{
+			{Name: "url", Type: ParamString, Description: "Magnet link or .torrent URL"},
+			{Name: "metainfo", Type: ParamString, Description: "Base64 .torrent file, instead of url"},
+			{Name: "dir", Type: ParamString, Description: "Download directory"},
+			{Name: "labels", Type: ParamString, Description: "Comma-separated labels"},
+		},
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			dir, err := cleanAddDir(s.client, a.String("dir"))
+			if err != nil {
+				return nil, err
+			}
+			req := AddRequest{URL: a.String("url"), Source: SourceUI, Dir: dir}
+			if metainfo := a.String("metainfo"); metainfo != "" {
+				data, err := base64.StdEncoding.DecodeString(metainfo)
+				if err != nil {
+					return nil, fmt.Errorf("metainfo is not base64: %w", err)
+				}
+				req.URL, req.Data = "", data
+			} else if req.URL == "" {
+				return nil, fmt.Errorf("url or metainfo is required")
+			}
+			labels, err := parseLabelList(a.String("labels"))
+			if err != nil {
+				return nil, err
+			}
+			req.Labels = labels
+			return s.adder.Add(req)
+		},
+	},
+	{
+		Name: "lpd.set", Title: "Toggle local peer discovery",
+		Params:    []CommandParam{{Name: "enabled", Type: ParamBool, Required: true}},
+		available: func(*Server) bool { return features.Enabled(FeatureSettings) },
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return nil, s.client.SetLPDEnabled(a.Bool("enabled"))
+		},
+	},
+	{
+		Name: "feed.check", Title: "Check feed now",
+		Params:    []CommandParam{{Name: "id", Type: ParamInt, Required: true, Description: "Feed ID"}},
+		available: func(*Server) bool { return features.Enabled(FeatureRSS) },
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			id := a.Int("id")
+			go func() {
+				if err := s.feedManager.CheckFeed(id); err != nil {
+					log.Printf("Error checking feed: %v", err)
+				}
+			}()
+			return nil, nil
+		},
+	},
+	{
+		Name: "automation.pause", Title: "Pause automation", Description: "Pause RSS polling, IRC announce adds and the watch folder",
+		Params: []CommandParam{
+			{Name: "minutes", Type: ParamInt, Description: "Pause length; omit to pause until resumed"},
+			{Name: "reason", Type: ParamString},
+		},
+		available: func(s *Server) bool { return s.pause != nil },
+		run: func(_ context.Context, s *Server, a commandArgs) (interface{}, error) {
+			var until time.Time
+			if m := a.Int("minutes"); m > 0 {
+				until = time.Now().Add(time.Duration(m) * time.Minute)
+			}
+			if err := s.pause.Pause(until, a.String("reason")); err != nil {
+				return nil, err
+			}
+			return s.pause.Status(), nil
+		},
+	},
+	{
+		Name: "automation.resume", Title: "Resume automation",
+		available: func(s *Server) bool { return s.pause != nil },
+		run: func(_ context.Context, s *Server, _ commandArgs) (interface{}, error) {
+			if err := s.pause.Resume(); err != nil {
+				return nil, err
+			}
+			return s.pause.Status(), nil
+		},
+	},
+	{
+		Name: "search", Title: "Search index", Description: "Search the bitmagnet index",
+		Params:    []CommandParam{{Name: "q", Type: ParamString, Required: true, Description: "Search terms"}},
+		available: func(s *Server) bool { return s.search != nil },
+		run: func(ctx context.Context, s *Server, a commandArgs) (interface{}, error) {
+			return s.search.Search(ctx, a.String("q"), 50)
+		},
+	},
+}
+
+// availableCommands lists the commands this server can run, by name
+func (s *Server) availableCommands() []Command {
+	var list []Command
+	for _, c := range commands {
+		if c.available == nil || c.available(s) {
+			if c.Params == nil {
+				c.Params = []CommandParam{}
+			}
+			list = append(list, c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// parseArgs checks raw arguments against the command's parameters
+func (c *Command) parseArgs(raw map[string]json.RawMessage) (commandArgs, error) {
+	args := commandArgs{}
+	for _, p := range c.Params {
+		v, ok := raw[p.Name]
+		if !ok || string(v) == "null" {
+			if p.Required {
+				return nil, fmt.Errorf("missing argument %q", p.Name)
+			}
+			continue
+		}
+		var err error
+		switch p.Type {
+		case ParamInt:
+			var n int
+			err = json.Unmarshal(v, &n)
+			args[p.Name] = n
+		case ParamBool:
+			var b bool
+			err = json.Unmarshal(v, &b)
+			args[p.Name] = b
+		default:
+			var str string
+			err = json.Unmarshal(v, &str)
+			if err == nil && p.Required && str == "" {
+				return nil, fmt.Errorf("missing argument %q", p.Name)
+			}
+			args[p.Name] = str
+		}
+		if err != nil {
+			return nil, fmt.Errorf("argument %q must be of type %s", p.Name, p.Type)
+		}
+	}
+	for name := range raw {
+		if c.param(name) == nil {
+			return nil, fmt.Errorf("unknown argument %q", name)
+		}
+	}
+	return args, nil
+}
+
+func (c *Command) param(name string) *CommandParam {
+	for i := range c.Params {
+		if c.Params[i].Name == name {
+			return &c.Params[i]
+		}
+	}
+	return nil
+}
+
+// handleCommands lists the available commands for the command palette
+func (s *Server) handleCommands(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{"commands": s.availableCommands()})
+}
+
+// handleRunCommand runs a command: {"command": "torrent.stop", "args": {"id": 3}}
+func (s *Server) handleRunCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Command string                     `json:"command"`
+		Args    map[string]json.RawMessage `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+
+	var cmd *Command
+	for _, c := range s.availableCommands() {
+		if c.Name == req.Command {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		writeJSONError(w, fmt.Sprintf("unknown command %q", req.Command))
+		return
+	}
+	args, err := cmd.parseArgs(req.Args)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, err.Error())
+		return
+	}
+
+	result, err := cmd.run(r.Context(), s, args)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(addErrorStatus(err))
+		writeJSONError(w, err.Error())
+		return
+	}
+	log.Printf("Ran command %s", cmd.Name)
+	writeJSON(w, map[string]interface{}{"status": "ok", "result": result})
+}
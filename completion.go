@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The completion subcommand prints a shell completion script:
+//
+//	source <(transmission-web completion bash)
+//
+// The scripts are generated from the subcommands' own flag sets, so new
+// flags complete without anyone editing them.
+
+// completionShells are the shells a script can be generated for
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionValues are the choices for flags that take one of a fixed set
+var completionValues = map[string][]string{
+	"status": torrentStatusNames,
+	"sort":   listSortKeys,
+}
+
+// completionNode is one command: the server itself, a subcommand, or a ctl
+// subcommand
+type completionNode struct {
+	path     []string // subcommand names leading here
+	flags    *flag.FlagSet
+	children []*completionNode
+	choices  []string // fixed positional arguments
+}
+
+func (n *completionNode) key() string { return strings.Join(n.path, " ") }
+
+// words are what can follow the command's flags
+func (n *completionNode) words() []string {
+	var words []string
+	for _, c := range n.children {
+		words = append(words, c.path[len(c.path)-1])
+	}
+	return append(words, n.choices...)
+}
+
+// flagNames returns the command's flags, dash included, split by whether
+// they take a value
+func (n *completionNode) flagNames() (all, valued []string) {
+	n.flags.VisitAll(func(f *flag.Flag) {
+		all = append(all, "-"+f.Name)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			valued = append(valued, "-"+f.Name)
+		}
+	})
+	return all, valued
+}
+
+// walk returns n and every command below it
+func (n *completionNode) walk() []*completionNode {
+	nodes := []*completionNode{n}
+	for _, c := range n.children {
+		nodes = append(nodes, c.walk()...)
+	}
+	return nodes
+}
+
+// completionTree describes every command. The subcommand names are listed
+// here rather than read from subcommands, which refers back to this file.
+func completionTree() *completionNode {
+	newFlags := func(name string) *flag.FlagSet {
+		return flag.NewFlagSet(name, flag.ContinueOnError)
+	}
+	root := &completionNode{flags: newFlags("transmission-web")}
+	serverFlags(root.flags)
+
+	tui := &completionNode{path: []string{"tui"}, flags: newFlags("tui")}
+	tuiFlags(tui.flags)
+
+	ctl := &completionNode{path: []string{"ctl"}, flags: newFlags("ctl")}
+	ctlFlags(ctl.flags)
+	for _, cmd := range ctlCommands {
+		sub := &completionNode{path: []string{"ctl", cmd.name}, flags: newFlags(cmd.name)}
+		cmd.flags(sub.flags)
+		ctl.children = append(ctl.children, sub)
+	}
+
+	completion := &completionNode{path: []string{"completion"}, flags: newFlags("completion"), choices: completionShells}
+	update := &completionNode{path: []string{"update"}, flags: newFlags("update")}
+	updateFlags(update.flags)
+	install := &completionNode{path: []string{"install"}, flags: newFlags("install")}
+	installFlags(install.flags)
+	uninstall := &completionNode{path: []string{"uninstall"}, flags: newFlags("uninstall")}
+	uninstallFlags(uninstall.flags)
+	root.children = []*completionNode{tui, ctl, completion, update, install, uninstall}
+	return root
+}
+
+func runCompletion(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: transmission-web completion %s\n", strings.Join(completionShells, "|"))
+		return 2
+	}
+	root := completionTree()
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(root))
+	case "zsh":
+		// zsh runs the bash script through its compatibility layer
+		fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(root))
+	case "fish":
+		fmt.Print(fishCompletion(root))
+	default:
+		fmt.Fprintf(os.Stderr, "completion: unknown shell %q\n", args[0])
+		return 2
+	}
+	return 0
+}
+
+// bashCompletion walks the words typed so far to find which command is
+// being completed, skipping flag values, then offers that command's flags,
+// flag values or subcommands. Flags typed with -- are completed too.
+func bashCompletion(root *completionNode) string {
+	nodes := root.walk()
+	var b strings.Builder
+	b.WriteString(`# bash completion for transmission-web
+_transmission_web() {
+	local cur prev path="" skip="" w i dash=""
+	cur="${COMP_WORDS[COMP_CWORD]}"
+	prev="${COMP_WORDS[COMP_CWORD-1]/#--/-}"
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		w="${COMP_WORDS[i]/#--/-}"
+		if [[ -n $skip ]]; then
+			skip=""
+			continue
+		fi
+		case "$path:$w" in
+`)
+	for _, n := range nodes {
+		_, valued := n.flagNames()
+		if len(valued) > 0 {
+			fmt.Fprintf(&b, "\t\t%s) skip=1 ;;\n", bashPatterns(n.key(), valued))
+		}
+	}
+	for _, n := range nodes {
+		for _, c := range n.children {
+			fmt.Fprintf(&b, "\t\t%q) path=%q ;;\n", n.key()+":"+c.path[len(c.path)-1], c.key())
+		}
+	}
+	b.WriteString(`		esac
+	done
+
+	case "$path:$prev" in
+`)
+	for _, n := range nodes {
+		_, valued := n.flagNames()
+		var free []string
+		for _, f := range valued {
+			if values, ok := completionValues[f[1:]]; ok {
+				fmt.Fprintf(&b, "\t%q) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n",
+					n.key()+":"+f, strings.Join(values, " "))
+			} else {
+				free = append(free, f)
+			}
+		}
+		if len(free) > 0 {
+			// Anything goes; -o default falls back to file names
+			fmt.Fprintf(&b, "\t%s) return ;;\n", bashPatterns(n.key(), free))
+		}
+	}
+	b.WriteString(`	esac
+
+	if [[ $cur == -* ]]; then
+		[[ $cur == --* ]] && dash=-
+		case "$path" in
+`)
+	for _, n := range nodes {
+		all, _ := n.flagNames()
+		if len(all) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "\t\t%q) COMPREPLY=($(compgen -P \"$dash\" -W %q -- \"${cur#$dash}\")) ;;\n", n.key(), strings.Join(all, " "))
+	}
+	b.WriteString(`		esac
+		return
+	fi
+	case "$path" in
+`)
+	for _, n := range nodes {
+		if words := n.words(); len(words) > 0 {
+			fmt.Fprintf(&b, "\t%q) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", n.key(), strings.Join(words, " "))
+		}
+	}
+	b.WriteString(`	esac
+}
+complete -o default -F _transmission_web transmission-web
+`)
+	return b.String()
+}
+
+// bashPatterns is a case pattern matching any of flags under path
+func bashPatterns(path string, flags []string) string {
+	patterns := make([]string, len(flags))
+	for i, f := range flags {
+		patterns[i] = fmt.Sprintf("%q", path+":"+f)
+	}
+	return strings.Join(patterns, "|")
+}
+
+// fishCompletion writes one complete line per subcommand and flag, each
+// conditioned on the subcommands already typed
+func fishCompletion(root *completionNode) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for transmission-web\n")
+	for _, n := range root.walk() {
+		var conds []string
+		if len(n.path) == 0 {
+			conds = append(conds, "__fish_use_subcommand")
+		}
+		for _, p := range n.path {
+			conds = append(conds, "__fish_seen_subcommand_from "+p)
+		}
+		words := n.words()
+		if len(words) > 0 && len(n.path) > 0 {
+			conds = append(conds, "not __fish_seen_subcommand_from "+strings.Join(words, " "))
+		}
+		cond := fishQuote(strings.Join(conds, "; and "))
+
+		if len(words) > 0 {
+			fmt.Fprintf(&b, "complete -c transmission-web -f -n %s -a %s\n", cond, fishQuote(strings.Join(words, " ")))
+		}
+		n.flags.VisitAll(func(f *flag.Flag) {
+			line := fmt.Sprintf("complete -c transmission-web -n %s -o %s -d %s", cond, f.Name, fishQuote(f.Usage))
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+				if values, ok := completionValues[f.Name]; ok {
+					line += " -x -a " + fishQuote(strings.Join(values, " "))
+				} else {
+					line += " -r"
+				}
+			}
+			b.WriteString(line + "\n")
+		})
+	}
+	return b.String()
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
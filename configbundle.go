@@ -0,0 +1,540 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	configBundleVersion = 1
+	maxConfigBundleSize = 10 << 20
+)
+
+// ConfigBundle is everything needed to rebuild an instance: the feeds,
+// automation policies and indexers stored in SQLite, plus the daemon's
+// preferences. Runtime state (IDs, check history, pauses) is left out.
+type ConfigBundle struct {
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exportedAt"`
+	AppVersion string          `json:"appVersion"`
+	Feeds      []feedConfig    `json:"feeds"`
+	Policies   []policyConfig  `json:"policies"`
+	Indexers   []indexerConfig `json:"indexers"`
+	Session    *SessionConfig  `json:"session,omitempty"`
+}
+
+// feedConfig is a Feed without its runtime state; feeds are matched by URL.
+// Unless secrets are exported the URL has its credentials redacted, and an
+// import matches it against the existing feeds' redacted URLs.
+type feedConfig struct {
+	Name          string         `json:"name"`
+	URL           string         `json:"url"`
+	Pattern       string         `json:"pattern"`
+	Enabled       bool           `json:"enabled"`
+	CheckInterval int            `json:"checkInterval"`
+	Filter        *ReleaseFilter `json:"filter,omitempty"`
+	Patterns      []FeedPattern  `json:"patterns,omitempty"`
+	ItemKey       string         `json:"itemKey,omitempty"`
+	DownloadDir   string         `json:"downloadDir,omitempty"`
+	Labels        []string       `json:"labels,omitempty"`
+	EpisodeDedup  bool           `json:"episodeDedup,omitempty"`
+}
+
+func newFeedConfig(f Feed, secrets bool) feedConfig {
+	u := f.URL
+	if !secrets {
+		u = redactText(u)
+	}
+	return feedConfig{
+		Name: f.Name, URL: u, Pattern: f.Pattern, Enabled: f.Enabled, CheckInterval: f.CheckInterval,
+		Filter: f.Filter, Patterns: f.Patterns, ItemKey: f.ItemKey, DownloadDir: f.DownloadDir, Labels: f.Labels,
+		EpisodeDedup: f.EpisodeDedup,
+	}
+}
+
+func (c feedConfig) feed(id int) *Feed {
+	return &Feed{
+		ID: id, Name: c.Name, URL: c.URL, Pattern: c.Pattern, Enabled: c.Enabled, CheckInterval: c.CheckInterval,
+		Filter: c.Filter, Patterns: c.Patterns, ItemKey: c.ItemKey, DownloadDir: c.DownloadDir, Labels: c.Labels,
+		EpisodeDedup: c.EpisodeDedup,
+	}
+}
+
+// policyConfig is a PolicyRule without its ID; rules are matched by name
+type policyConfig struct {
+	Name       string           `json:"name"`
+	Enabled    bool             `json:"enabled"`
+	Action     string           `json:"action"`
+	Conditions PolicyConditions `json:"conditions"`
+	Trackers   []string         `json:"trackers,omitempty"`
+}
+
+func newPolicyConfig(r PolicyRule) policyConfig {
+	return policyConfig{Name: r.Name, Enabled: r.Enabled, Action: r.Action, Conditions: r.Conditions, Trackers: r.Trackers}
+}
+
+func (c policyConfig) rule(id int) *PolicyRule {
+	return &PolicyRule{ID: id, Name: c.Name, Enabled: c.Enabled, Action: c.Action, Conditions: c.Conditions, Trackers: c.Trackers}
+}
+
+// indexerConfig is an Indexer without its ID; indexers are matched by name.
+// The API key is only exported on request, and an empty key on import
+// keeps the existing one.
+type indexerConfig struct {
+	Name       string `json:"name"`
+	BaseURL    string `json:"baseUrl"`
+	APIPath    string `json:"apiPath"`
+	APIKey     string `json:"apiKey,omitempty"`
+	Categories []int  `json:"categories"`
+	Enabled    bool   `json:"enabled"`
+	Priority   int    `json:"priority"`
+	Source     string `json:"source"`
+}
+
+func newIndexerConfig(ix Indexer, secrets bool) indexerConfig {
+	c := indexerConfig{
+		Name: ix.Name, BaseURL: ix.BaseURL, APIPath: ix.APIPath, Categories: ix.Categories,
+		Enabled: ix.Enabled, Priority: ix.Priority, Source: ix.Source,
+	}
+	if secrets {
+		c.APIKey = ix.APIKey
+	}
+	return c
+}
+
+func (c indexerConfig) indexer(id int) *Indexer {
+	return &Indexer{
+		ID: id, Name: c.Name, BaseURL: c.BaseURL, APIPath: c.APIPath, APIKey: c.APIKey, Categories: c.Categories,
+		Enabled: c.Enabled, Priority: c.Priority, Source: c.Source,
+	}
+}
+
+// exportConfig collects the bundle. The daemon's preferences are left out
+// when it can't be reached rather than failing the export.
+func (s *Server) exportConfig(secrets bool) (*ConfigBundle, error) {
+	bundle := &ConfigBundle{
+		Version:    configBundleVersion,
+		ExportedAt: time.Now().UTC(),
+		AppVersion: Version,
+		Feeds:      []feedConfig{},
+		Policies:   []policyConfig{},
+		Indexers:   []indexerConfig{},
+	}
+	feeds, err := s.feedManager.GetFeeds()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range feeds {
+		bundle.Feeds = append(bundle.Feeds, newFeedConfig(f, secrets))
+	}
+	rules, err := s.policy.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rules {
+		bundle.Policies = append(bundle.Policies, newPolicyConfig(r))
+	}
+	indexers, err := s.indexers.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, ix := range indexers {
+		bundle.Indexers = append(bundle.Indexers, newIndexerConfig(ix, secrets))
+	}
+	if session, err := s.client.GetSession(); err == nil {
+		session.Version, session.RPCVersion = "", 0
+		bundle.Session = session
+	}
+	return bundle, nil
+}
+
+// configZipFiles are the ZIP export's entries
+var configZipFiles = []string{"manifest.json", "feeds.json", "policies.json", "indexers.json", "session.json"}
+
+// writeZip writes the bundle with each section in its own file
+func (b *ConfigBundle) writeZip(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	manifest := map[string]interface{}{"version": b.Version, "exportedAt": b.ExportedAt, "appVersion": b.AppVersion}
+	sections := []interface{}{manifest, b.Feeds, b.Policies, b.Indexers, b.Session}
+	for i, name := range configZipFiles {
+		if name == "session.json" && b.Session == nil {
+			continue
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sections[i]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readConfigBundle parses a JSON bundle or a ZIP export
+func readConfigBundle(data []byte) (*ConfigBundle, error) {
+	var bundle ConfigBundle
+	if !bytes.HasPrefix(data, []byte("PK")) {
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("invalid bundle: %w", err)
+		}
+		return &bundle, nil
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid zip: %w", err)
+	}
+	targets := map[string]interface{}{
+		"manifest.json": &bundle,
+		"feeds.json":    &bundle.Feeds,
+		"policies.json": &bundle.Policies,
+		"indexers.json": &bundle.Indexers,
+		"session.json":  &bundle.Session,
+	}
+	for _, f := range zr.File {
+		target, ok := targets[f.Name]
+		if !ok {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = json.NewDecoder(io.LimitReader(rc, maxConfigBundleSize)).Decode(target)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", f.Name, err)
+		}
+	}
+	return &bundle, nil
+}
+
+// Validate checks everything in the bundle the way adding it one item at a
+// time would, and reports every problem at once
+func (b *ConfigBundle) Validate() error {
+	var problems []string
+	if b.Version < 1 || b.Version > configBundleVersion {
+		problems = append(problems, fmt.Sprintf("unsupported bundle version %d", b.Version))
+	}
+	urls := make(map[string]bool)
+	for i, c := range b.Feeds {
+		f := c.feed(0)
+		label := fmt.Sprintf("feeds[%d] %q", i, c.Name)
+		if c.URL == "" {
+			problems = append(problems, label+": url is required")
+		} else if urls[c.URL] {
+			problems = append(problems, label+": duplicate url "+c.URL)
+		}
+		urls[c.URL] = true
+		if _, err := f.compilePatterns(); err != nil {
+			problems = append(problems, label+": "+err.Error())
+		}
+		if err := validItemKey(c.ItemKey); err != nil {
+			problems = append(problems, label+": "+err.Error())
+		}
+		if _, err := encodeFilter(c.Filter); err != nil {
+			problems = append(problems, label+": "+err.Error())
+		}
+	}
+	names := make(map[string]bool)
+	for i, c := range b.Policies {
+		label := fmt.Sprintf("policies[%d] %q", i, c.Name)
+		if names[c.Name] {
+			problems = append(problems, label+": duplicate name")
+		}
+		names[c.Name] = true
+		if err := c.rule(0).Validate(); err != nil {
+			problems = append(problems, label+": "+err.Error())
+		}
+	}
+	names = make(map[string]bool)
+	for i, c := range b.Indexers {
+		label := fmt.Sprintf("indexers[%d] %q", i, c.Name)
+		if names[c.Name] {
+			problems = append(problems, label+": duplicate name")
+		}
+		names[c.Name] = true
+		if err := c.indexer(0).Validate(); err != nil {
+			problems = append(problems, label+": "+err.Error())
+		}
+	}
+	if b.Session != nil {
+		if err := b.Session.Validate(); err != nil {
+			problems = append(problems, "session: "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ConfigChange is one line of an import's diff
+type ConfigChange struct {
+	Section string   `json:"section"` // feeds, policies, indexers or session
+	Name    string   `json:"name"`
+	Action  string   `json:"action"` // add, update or delete
+	Fields  []string `json:"fields,omitempty"`
+}
+
+// changedFields lists the JSON fields that differ between two values
+func changedFields(before, after interface{}) []string {
+	var a, b map[string]json.RawMessage
+	raw, _ := json.Marshal(before)
+	json.Unmarshal(raw, &a)
+	raw, _ = json.Marshal(after)
+	json.Unmarshal(raw, &b)
+	var fields []string
+	for k, v := range b {
+		if !bytes.Equal(a[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// configPlan is an import's diff with the steps that apply it
+type configPlan struct {
+	changes []ConfigChange
+	steps   []func() error
+}
+
+func (p *configPlan) add(change ConfigChange, step func() error) {
+	p.changes = append(p.changes, change)
+	p.steps = append(p.steps, step)
+}
+
+// planImport diffs the bundle against the current configuration. Items are
+// added or updated; with replace, ones missing from the bundle are deleted
+// too. The daemon's preferences are skipped when withSession is false.
+func (s *Server) planImport(b *ConfigBundle, replace, withSession bool) (*configPlan, error) {
+	plan := &configPlan{changes: []ConfigChange{}}
+
+	feeds, err := s.feedManager.GetFeeds()
+	if err != nil {
+		return nil, err
+	}
+	existingFeeds := make(map[string]Feed)
+	redactedFeeds := make(map[string]string) // redacted URL to the real one, "" if several share it
+	for _, f := range feeds {
+		existingFeeds[f.URL] = f
+		r := redactText(f.URL)
+		if _, ok := redactedFeeds[r]; ok {
+			redactedFeeds[r] = ""
+		} else {
+			redactedFeeds[r] = f.URL
+		}
+	}
+	for _, c := range b.Feeds {
+		c := c
+		if strings.Contains(c.URL, redacted) {
+			u := redactedFeeds[c.URL]
+			if u == "" {
+				return nil, fmt.Errorf("feed %q has a redacted url that doesn't match exactly one existing feed; export with secrets=true to import it", c.Name)
+			}
+			c.URL = u
+		}
+		if c.CheckInterval <= 0 {
+			c.CheckInterval = 15 // as AddFeed defaults it
+		}
+		cur, ok := existingFeeds[c.URL]
+		delete(existingFeeds, c.URL)
+		if !ok {
+			plan.add(ConfigChange{Section: "feeds", Name: c.Name, Action: "add"}, func() error { return s.feedManager.AddFeed(c.feed(0)) })
+		} else if fields := changedFields(newFeedConfig(cur, true), c); len(fields) > 0 {
+			plan.add(ConfigChange{Section: "feeds", Name: c.Name, Action: "update", Fields: fields}, func() error { return s.feedManager.UpdateFeed(c.feed(cur.ID)) })
+		}
+	}
+	if replace {
+		for _, f := range feeds {
+			if _, ok := existingFeeds[f.URL]; ok {
+				id := f.ID
+				plan.add(ConfigChange{Section: "feeds", Name: f.Name, Action: "delete"}, func() error { return s.feedManager.DeleteFeed(id) })
+			}
+		}
+	}
+
+	rules, err := s.policy.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	existingRules := make(map[string]PolicyRule)
+	for _, r := range rules {
+		existingRules[r.Name] = r
+	}
+	for _, c := range b.Policies {
+		c := c
+		cur, ok := existingRules[c.Name]
+		delete(existingRules, c.Name)
+		if !ok {
+			plan.add(ConfigChange{Section: "policies", Name: c.Name, Action: "add"}, func() error { return s.policy.AddRule(c.rule(0)) })
+		} else if fields := changedFields(newPolicyConfig(cur), c); len(fields) > 0 {
+			plan.add(ConfigChange{Section: "policies", Name: c.Name, Action: "update", Fields: fields}, func() error { return s.policy.UpdateRule(c.rule(cur.ID)) })
+		}
+	}
+	if replace {
+		for _, r := range rules {
+			if _, ok := existingRules[r.Name]; ok {
+				id := r.ID
+				plan.add(ConfigChange{Section: "policies", Name: r.Name, Action: "delete"}, func() error { return s.policy.DeleteRule(id) })
+			}
+		}
+	}
+
+	indexers, err := s.indexers.List()
+	if err != nil {
+		return nil, err
+	}
+	existingIndexers := make(map[string]Indexer)
+	for _, ix := range indexers {
+		existingIndexers[ix.Name] = ix
+	}
+	for _, c := range b.Indexers {
+		c := c
+		if c.APIPath == "" {
+			c.APIPath = "/api" // as Validate defaults it
+		}
+		cur, ok := existingIndexers[c.Name]
+		delete(existingIndexers, c.Name)
+		if !ok {
+			plan.add(ConfigChange{Section: "indexers", Name: c.Name, Action: "add"}, func() error { return s.indexers.Add(c.indexer(0)) })
+			continue
+		}
+		if c.APIKey == "" {
+			c.APIKey = cur.APIKey
+		}
+		if fields := changedFields(newIndexerConfig(cur, true), c); len(fields) > 0 {
+			plan.add(ConfigChange{Section: "indexers", Name: c.Name, Action: "update", Fields: fields}, func() error { return s.indexers.Update(c.indexer(cur.ID)) })
+		}
+	}
+	if replace {
+		for _, ix := range indexers {
+			if _, ok := existingIndexers[ix.Name]; ok {
+				id := ix.ID
+				plan.add(ConfigChange{Section: "indexers", Name: ix.Name, Action: "delete"}, func() error { return s.indexers.Delete(id) })
+			}
+		}
+	}
+
+	if b.Session != nil && withSession {
+		cur, err := s.client.GetSession()
+		if err != nil {
+			return nil, fmt.Errorf("can't compare daemon preferences: %w (import with session=false to skip them)", err)
+		}
+		// Only fields the bundle sets are compared, so a bundle from an
+		// older daemon doesn't clear anything
+		var want, have map[string]json.RawMessage
+		raw, _ := json.Marshal(b.Session)
+		json.Unmarshal(raw, &want)
+		raw, _ = json.Marshal(cur)
+		json.Unmarshal(raw, &have)
+		changed := make(map[string]json.RawMessage)
+		var fields []string
+		for k, v := range want {
+			if !bytes.Equal(have[k], v) {
+				changed[k] = v
+				fields = append(fields, k)
+			}
+		}
+		if len(fields) > 0 {
+			sort.Strings(fields)
+			var update SessionConfig
+			raw, _ = json.Marshal(changed)
+			if err := json.Unmarshal(raw, &update); err != nil {
+				return nil, err
+			}
+			plan.add(ConfigChange{Section: "session", Name: "daemon", Action: "update", Fields: fields}, func() error { return s.client.SetSession(&update) })
+		}
+	}
+	return plan, nil
+}
+
+// handleConfigExport serves the bundle as JSON, or as a ZIP with
+// ?format=zip. Indexer API keys and feed URL credentials are only included
+// with ?secrets=true.
+func (s *Server) handleConfigExport(w http.ResponseWriter, r *http.Request) {
+	secrets, _ := parseBoolParam(r.URL.Query().Get("secrets"))
+	bundle, err := s.exportConfig(secrets)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	name := "transmission-web-config-" + bundle.ExportedAt.Format("20060102-150405")
+	if r.URL.Query().Get("format") == "zip" {
+		var buf bytes.Buffer
+		if err := bundle.writeZip(&buf); err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+	writeJSON(w, bundle)
+}
+
+// handleConfigImport validates a bundle (the raw JSON or ZIP as the body)
+// and returns its diff against the current configuration, applying it
+// unless ?dry_run=true. ?replace=true also deletes what the bundle doesn't
+// have; ?session=false leaves the daemon's preferences alone.
+func (s *Server) handleConfigImport(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigBundleSize))
+	if err != nil {
+		writeJSONError(w, "bundle too large or unreadable")
+		return
+	}
+	q := r.URL.Query()
+	dryRun, _ := parseBoolParam(q.Get("dry_run"))
+	replace, _ := parseBoolParam(q.Get("replace"))
+	withSession := true
+	if v, ok := parseBoolParam(q.Get("session")); ok {
+		withSession = v
+	}
+
+	bundle, err := readConfigBundle(data)
+	if err == nil {
+		err = bundle.Validate()
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	plan, err := s.planImport(bundle, replace, withSession)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if !dryRun {
+		// Everything was validated up front, so a failure here is the
+		// database or daemon; report how far it got
+		for i, step := range plan.steps {
+			if err := step(); err != nil {
+				c := plan.changes[i]
+				writeJSONError(w, fmt.Sprintf("applied %d of %d changes; %s %s %q failed: %v", i, len(plan.steps), c.Action, c.Section, c.Name, err))
+				return
+			}
+		}
+		log.Printf("Imported configuration: %d changes", len(plan.changes))
+	}
+	writeJSON(w, map[string]interface{}{"dryRun": dryRun, "changes": plan.changes})
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultConfigReloadInterval = 5 * time.Second
+
+// ConfigFile is an optional YAML file of settings, given with -config or
+// CONFIG_FILE. Its keys are the environment variable names, with nested
+// maps joined by underscores, so
+//
+//	transmission:
+//	  url: http://nas:9091/transmission/rpc
+//	notify:
+//	  events: [completed, errored]
+//
+// sets TRANSMISSION_URL and NOTIFY_EVENTS=completed,errored. Lists of maps,
+// such as TRANSMISSION_INSTANCES, become JSON. Variables set in the real
+// environment win over the file.
+//
+// The file is watched, and re-read on SIGHUP. Settings with a reloader are
+// applied to the running process; anything else that changed is reported
+// as needing a restart.
+type ConfigFile struct {
+	path string
+
+	mu        sync.RWMutex
+	values    map[string]string
+	modTime   time.Time
+	loadedAt  time.Time
+	err       string
+	restart   []string // changed keys waiting for a restart
+	reloaders []configReloader
+}
+
+// configReloader re-applies the settings named by keys
+type configReloader struct {
+	name  string
+	keys  []string
+	apply func() error
+}
+
+// ConfigFileStatus is reported on /api/config/file. Only key names are
+// shown, since values include passwords.
+type ConfigFileStatus struct {
+	Path          string     `json:"path"`
+	LoadedAt      *time.Time `json:"loadedAt,omitempty"`
+	Keys          []string   `json:"keys"`
+	Reloadable    []string   `json:"reloadable"`
+	RestartNeeded []string   `json:"restartNeeded"`
+	Error         string     `json:"error,omitempty"`
+}
+
+// configFile is the loaded file, nil without one. getEnv and friends read
+// it through lookupEnv.
+var configFile *ConfigFile
+
+// loadConfigFile reads path into configFile; "" means no file
+func loadConfigFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	cf := &ConfigFile{path: path}
+	values, modTime, err := cf.read()
+	if err != nil {
+		return err
+	}
+	cf.values, cf.modTime, cf.loadedAt = values, modTime, time.Now()
+	configFile = cf
+	return nil
+}
+
+// settingsRead holds every key lookupEnv was asked for, so the support
+// bundle can list the settings in use
+var settingsRead sync.Map
+
+// lookupEnv reads a setting from the environment, then the config file
+func lookupEnv(key string) string {
+	settingsRead.Store(key, true)
+	if val := os.Getenv(key); val != "" || configFile == nil {
+		return val
+	}
+	configFile.mu.RLock()
+	defer configFile.mu.RUnlock()
+	return configFile.values[key]
+}
+
+func (cf *ConfigFile) read() (map[string]string, time.Time, error) {
+	info, err := os.Stat(cf.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(cf.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", cf.path, err)
+	}
+	values := map[string]string{}
+	if err := flattenConfig("", doc, values); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", cf.path, err)
+	}
+	return values, info.ModTime(), nil
+}
+
+// flattenConfig turns a YAML document into environment-style settings
+func flattenConfig(prefix string, v interface{}, out map[string]string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(k))
+			if prefix != "" {
+				key = prefix + "_" + key
+			}
+			if err := flattenConfig(key, child, out); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if prefix == "" {
+			return fmt.Errorf("expected a map of settings")
+		}
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, ok := item.(map[string]interface{}); ok {
+				data, err := json.Marshal(v)
+				if err != nil {
+					return fmt.Errorf("%s: %w", prefix, err)
+				}
+				out[prefix] = string(data)
+				return nil
+			}
+			items = append(items, fmt.Sprint(item))
+		}
+		out[prefix] = strings.Join(items, ",")
+	case nil:
+		if prefix != "" {
+			out[prefix] = ""
+		}
+	default:
+		if prefix == "" {
+			return fmt.Errorf("expected a map of settings")
+		}
+		out[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// OnReload registers apply to run when any of keys changes in the file
+func (cf *ConfigFile) OnReload(name string, keys []string, apply func() error) {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	cf.reloaders = append(cf.reloaders, configReloader{name: name, keys: keys, apply: apply})
+}
+
+// Reload re-reads the file and applies what changed, returning the changed
+// keys. A file that no longer parses leaves the old settings in place.
+func (cf *ConfigFile) Reload() ([]string, error) {
+	values, modTime, err := cf.read()
+	cf.mu.Lock()
+	if err != nil {
+		cf.err = err.Error()
+		cf.mu.Unlock()
+		return nil, err
+	}
+	var changed []string
+	for key := range mergeKeys(cf.values, values) {
+		if os.Getenv(key) == "" && cf.values[key] != values[key] {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	cf.values, cf.modTime, cf.loadedAt, cf.err = values, modTime, time.Now(), ""
+	reloaders := cf.reloaders
+	cf.mu.Unlock()
+
+	// Reloaders read the new values through getEnv, so run them unlocked
+	handled := map[string]bool{}
+	var failures []string
+	for _, r := range reloaders {
+		if !slices.ContainsFunc(r.keys, func(k string) bool { return slices.Contains(changed, k) }) {
+			continue
+		}
+		for _, k := range r.keys {
+			handled[k] = true
+		}
+		if err := r.apply(); err != nil {
+			log.Printf("⚠️ Failed to reload %s from %s: %v", r.name, cf.path, err)
+			failures = append(failures, r.name+": "+err.Error())
+			continue
+		}
+		log.Printf("Reloaded %s from %s", r.name, cf.path)
+	}
+
+	cf.mu.Lock()
+	for _, k := range changed {
+		if !handled[k] && !slices.Contains(cf.restart, k) {
+			log.Printf("⚠️ %s changed in %s; restart to apply it", k, cf.path)
+			cf.restart = append(cf.restart, k)
+		}
+	}
+	sort.Strings(cf.restart)
+	if len(failures) > 0 {
+		cf.err = strings.Join(failures, "; ")
+	}
+	cf.mu.Unlock()
+	return changed, nil
+}
+
+func mergeKeys(a, b map[string]string) map[string]bool {
+	keys := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	return keys
+}
+
+// Watch reloads the file when its modification time changes, checked
+// every interval, and on SIGHUP
+func (cf *ConfigFile) Watch(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultConfigReloadInterval
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	cf.mu.RLock()
+	seen := cf.modTime
+	cf.mu.RUnlock()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hup:
+			case <-ticker.C:
+				// Editors often replace the file, so it can briefly be
+				// missing; a broken edit is reported once, not every tick
+				info, err := os.Stat(cf.path)
+				if err != nil || info.ModTime().Equal(seen) {
+					continue
+				}
+				seen = info.ModTime()
+			}
+			if _, err := cf.Reload(); err != nil {
+				log.Printf("⚠️ Failed to reload %s: %v", cf.path, err)
+			}
+		}
+	}()
+}
+
+// Status describes the file and what's waiting for a restart
+func (cf *ConfigFile) Status() ConfigFileStatus {
+	cf.mu.RLock()
+	defer cf.mu.RUnlock()
+	loadedAt := cf.loadedAt
+	status := ConfigFileStatus{
+		Path:          cf.path,
+		LoadedAt:      &loadedAt,
+		Keys:          make([]string, 0, len(cf.values)),
+		Reloadable:    []string{},
+		RestartNeeded: append([]string{}, cf.restart...),
+		Error:         cf.err,
+	}
+	for k := range cf.values {
+		status.Keys = append(status.Keys, k)
+	}
+	sort.Strings(status.Keys)
+	for _, r := range cf.reloaders {
+		status.Reloadable = append(status.Reloadable, r.keys...)
+	}
+	sort.Strings(status.Reloadable)
+	return status
+}
+
+func (s *Server) handleConfigFile(w http.ResponseWriter, _ *http.Request) {
+	if configFile == nil {
+		writeJSON(w, ConfigFileStatus{Keys: []string{}, Reloadable: []string{}, RestartNeeded: []string{}})
+		return
+	}
+	writeJSON(w, configFile.Status())
+}
+
+// handleConfigFileReload re-reads the file now rather than waiting for the
+// watcher
+func (s *Server) handleConfigFileReload(w http.ResponseWriter, _ *http.Request) {
+	if configFile == nil {
+		writeJSONError(w, "no config file; start with -config or CONFIG_FILE")
+		return
+	}
+	changed, err := configFile.Reload()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	writeJSON(w, map[string]interface{}{"changed": changed, "config": configFile.Status()})
+}
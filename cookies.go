@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StoredCookie is one cookie from an imported cookies.txt
+type StoredCookie struct {
+	Domain            string
+	IncludeSubdomains bool
+	Path              string
+	Secure            bool
+	Expires           time.Time // zero for session cookies
+	Name              string
+	Value             string
+}
+
+func (c *StoredCookie) matches(u *url.URL, now time.Time) bool {
+	if !c.Expires.IsZero() && now.After(c.Expires) {
+		return false
+	}
+	if c.Secure && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host != c.Domain && !(c.IncludeSubdomains && strings.HasSuffix(host, "."+c.Domain)) {
+		return false
+	}
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.HasPrefix(path, c.Path)
+}
+
+// parseCookiesTxt reads the Netscape cookies.txt format exported by
+// browser extensions and curl
+func parseCookiesTxt(r io.Reader) ([]StoredCookie, error) {
+	var cookies []StoredCookie
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		// HttpOnly cookies are written as comments with this prefix
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", lineNo, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", lineNo, fields[4])
+		}
+		c := StoredCookie{
+			Domain:            strings.ToLower(strings.TrimPrefix(fields[0], ".")),
+			IncludeSubdomains: strings.EqualFold(fields[1], "TRUE"),
+			Path:              fields[2],
+			Secure:            strings.EqualFold(fields[3], "TRUE"),
+			Name:              fields[5],
+			Value:             fields[6],
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, scanner.Err()
+}
+
+// CookieDomain summarizes the cookies held for a domain. Values are never
+// sent back to the browser.
+type CookieDomain struct {
+	Domain      string     `json:"domain"`
+	Count       int        `json:"count"`
+	NextExpires *time.Time `json:"nextExpires,omitempty"`
+}
+
+// CookieStore holds tracker session cookies, persisted in the cookies table.
+// It is the http.CookieJar for RSS fetches and supplies the cookies
+// Transmission sends when it downloads a .torrent URL.
+type CookieStore struct {
+	db *sql.DB
+
+	mu      sync.Mutex
+	cookies []StoredCookie
+}
+
+// NewCookieStore loads stored cookies from the cookies table
+func NewCookieStore(db *sql.DB) (*CookieStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS cookies (
+		domain TEXT NOT NULL,
+		include_subdomains INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		secure INTEGER NOT NULL,
+		expires INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (domain, path, name)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT domain, include_subdomains, path, secure, expires, name, value FROM cookies")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cs := &CookieStore{db: db}
+	for rows.Next() {
+		var c StoredCookie
+		var expires int64
+		if err := rows.Scan(&c.Domain, &c.IncludeSubdomains, &c.Path, &c.Secure, &expires, &c.Name, &c.Value); err != nil {
+			return nil, err
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cs.cookies = append(cs.cookies, c)
+	}
+	return cs, rows.Err()
+}
+
+// Import stores cookies, replacing any with the same domain, path and name
+func (cs *CookieStore) Import(cookies []StoredCookie) error {
+	tx, err := cs.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after Commit
+
+	for _, c := range cookies {
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		_, err := tx.Exec(
+			`INSERT OR REPLACE INTO cookies (domain, include_subdomains, path, secure, expires, name, value)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			c.Domain, c.IncludeSubdomains, c.Path, c.Secure, expires, c.Name, c.Value,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for _, c := range cookies {
+		cs.replace(c)
+	}
+	return nil
+}
+
+// replace swaps c into the in-memory list; callers hold mu
+func (cs *CookieStore) replace(c StoredCookie) {
+	for i := range cs.cookies {
+		old := &cs.cookies[i]
+		if old.Domain == c.Domain && old.Path == c.Path && old.Name == c.Name {
+			*old = c
+			return
+		}
+	}
+	cs.cookies = append(cs.cookies, c)
+}
+
+// forget deletes the stored cookie with c's domain, path and name
+func (cs *CookieStore) forget(c StoredCookie) error {
+	if _, err := cs.db.Exec("DELETE FROM cookies WHERE domain = ? AND path = ? AND name = ?", c.Domain, c.Path, c.Name); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	kept := cs.cookies[:0]
+	for _, old := range cs.cookies {
+		if old.Domain != c.Domain || old.Path != c.Path || old.Name != c.Name {
+			kept = append(kept, old)
+		}
+	}
+	cs.cookies = kept
+	return nil
+}
+
+// DeleteDomain forgets every cookie for domain
+func (cs *CookieStore) DeleteDomain(domain string) error {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if _, err := cs.db.Exec("DELETE FROM cookies WHERE domain = ?", domain); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	kept := cs.cookies[:0]
+	for _, c := range cs.cookies {
+		if c.Domain != domain {
+			kept = append(kept, c)
+		}
+	}
+	cs.cookies = kept
+	return nil
+}
+
+// Domains summarizes the stored cookies per domain
+func (cs *CookieStore) Domains() []CookieDomain {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	byDomain := make(map[string]*CookieDomain)
+	for _, c := range cs.cookies {
+		d, ok := byDomain[c.Domain]
+		if !ok {
+			d = &CookieDomain{Domain: c.Domain}
+			byDomain[c.Domain] = d
+		}
+		d.Count++
+		if !c.Expires.IsZero() && (d.NextExpires == nil || c.Expires.Before(*d.NextExpires)) {
+			expires := c.Expires
+			d.NextExpires = &expires
+		}
+	}
+
+	domains := make([]CookieDomain, 0, len(byDomain))
+	for _, d := range byDomain {
+		domains = append(domains, *d)
+	}
+	sort.Slice(domains, func(i, j int) bool { return domains[i].Domain < domains[j].Domain })
+	return domains
+}
+
+// Cookies implements http.CookieJar
+func (cs *CookieStore) Cookies(u *url.URL) []*http.Cookie {
+	if cs == nil {
+		return nil
+	}
+	now := time.Now()
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var cookies []*http.Cookie
+	for _, c := range cs.cookies {
+		if c.matches(u, now) {
+			cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+		}
+	}
+	return cookies
+}
+
+// SetCookies implements http.CookieJar. Only domains the user imported
+// cookies for are updated, so refreshed tracker sessions are kept without
+// collecting cookies from every feed host. A cookie's Domain must be the
+// host or a parent of it, and one already expired deletes the stored one.
+func (cs *CookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := strings.ToLower(u.Hostname())
+	cs.mu.Lock()
+	known := false
+	for _, c := range cs.cookies {
+		if c.Domain == host {
+			known = true
+			break
+		}
+	}
+	cs.mu.Unlock()
+	if !known {
+		return
+	}
+
+	var updated []StoredCookie
+	for _, hc := range cookies {
+		c := StoredCookie{
+			Domain: host,
+			Path:   hc.Path,
+			Secure: hc.Secure,
+			Name:   hc.Name,
+			Value:  hc.Value,
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		if hc.Domain != "" {
+			domain := strings.ToLower(strings.TrimPrefix(hc.Domain, "."))
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue
+			}
+			c.Domain, c.IncludeSubdomains = domain, true
+		}
+		switch {
+		case hc.MaxAge < 0:
+			c.Expires = time.Unix(0, 0)
+		case hc.MaxAge > 0:
+			c.Expires = time.Now().Add(time.Duration(hc.MaxAge) * time.Second)
+		case !hc.Expires.IsZero():
+			c.Expires = hc.Expires
+		}
+		if !c.Expires.IsZero() && !c.Expires.After(time.Now()) {
+			if err := cs.forget(c); err != nil {
+				log.Printf("Failed to delete an expired cookie for %s: %v", host, err)
+			}
+			continue
+		}
+		updated = append(updated, c)
+	}
+	if err := cs.Import(updated); err != nil {
+		log.Printf("Failed to store refreshed cookies for %s: %v", host, err)
+	}
+}
+
+// Header returns the cookies for rawURL in Cookie header form, as
+// torrent-add's cookies argument expects
+func (cs *CookieStore) Header(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	cookies := cs.Cookies(u)
+	parts := make([]string, 0, len(cookies))
+	for _, c := range cookies {
+		parts = append(parts, c.Name+"="+c.Value)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (s *Server) handleGetCookies(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{"domains": s.cookies.Domains()})
+}
+
+// handleImportCookies accepts a cookies.txt as the "cookies" form file or as
+// the raw request body
+func (s *Server) handleImportCookies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, 1<<20)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("cookies")
+		if err != nil {
+			writeJSONError(w, "missing cookies file")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	cookies, err := parseCookiesTxt(body)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if len(cookies) == 0 {
+		writeJSONError(w, "no cookies found")
+		return
+	}
+	if err := s.cookies.Import(cookies); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	log.Printf("Imported %d cookies", len(cookies))
+	writeJSON(w, map[string]interface{}{"imported": len(cookies), "domains": s.cookies.Domains()})
+}
+
+func (s *Server) handleDeleteCookies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	domain := r.URL.Query().Get("domain")
+	if domain == "" {
+		writeJSONError(w, "missing domain parameter")
+		return
+	}
+	if err := s.cookies.DeleteDomain(domain); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The ctl subcommand scripts a running server through its API, for cron
+// jobs and shell pipelines:
+//
+//	transmission-web ctl list -status seeding -ids | transmission-web ctl stop -
+//
+// With -json every subcommand prints JSON instead: the torrents for list,
+// and one result per torrent or link for the others.
+
+// ctlContext is what every ctl subcommand gets
+type ctlContext struct {
+	remote   *RemoteClient
+	instance string
+	json     bool
+	stdin    io.Reader
+	stdout   io.Writer
+}
+
+// ctlCommand is one ctl subcommand. flags registers its own flags and
+// returns the function that runs it with the remaining arguments.
+type ctlCommand struct {
+	name  string
+	usage string
+	flags func(fs *flag.FlagSet) func(c *ctlContext, args []string) error
+}
+
+// ctlResult is one entry of a batch subcommand's -json output
+type ctlResult struct {
+	ID      int             `json:"id,omitempty"`
+	Input   string          `json:"input,omitempty"`   // for add
+	Torrent json.RawMessage `json:"torrent,omitempty"` // what add added
+	OK      bool            `json:"ok"`
+	Error   string          `json:"error,omitempty"`
+}
+
+var ctlCommands = []ctlCommand{
+	{"list", "list [-status S] [-label L] [-search Q] [-sort K] [-desc] [-ids]", ctlList},
+	{"add", "add [-dir D] [-labels a,b] MAGNET|URL|HASH|FILE.torrent...", ctlAdd},
+	{"rm", "rm [-delete-data] ID...", ctlRemove},
+	{"start", "start ID...", ctlSimple("start")},
+	{"stop", "stop ID...", ctlSimple("stop")},
+	{"label", "label [-set a,b | -add a,b | -remove a,b] ID...", ctlLabel},
+}
+
+// ctlOptions are the flags shared by every ctl subcommand
+type ctlOptions struct {
+	remote   func() *RemoteClient
+	instance *string
+	json     *bool
+}
+
+func ctlFlags(fs *flag.FlagSet) *ctlOptions {
+	return &ctlOptions{
+		remote:   remoteFlags(fs),
+		instance: fs.String("instance", "", "daemon instance, for servers with TRANSMISSION_INSTANCES"),
+		json:     fs.Bool("json", false, "print JSON instead of text"),
+	}
+}
+
+func runCtl(args []string) int {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	opts := ctlFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: transmission-web ctl [flags] COMMAND [args]\n\nCommands:\n")
+		for _, c := range ctlCommands {
+			fmt.Fprintf(fs.Output(), "  %s\n", c.usage)
+		}
+		fmt.Fprintf(fs.Output(), "\nIDs can be given as - to read them from stdin.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	for _, cmd := range ctlCommands {
+		if cmd.name != fs.Arg(0) {
+			continue
+		}
+		sub := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		sub.Usage = func() {
+			fmt.Fprintf(sub.Output(), "Usage: transmission-web ctl %s\n", cmd.usage)
+			sub.PrintDefaults()
+		}
+		run := cmd.flags(sub)
+		sub.Parse(fs.Args()[1:])
+		c := &ctlContext{remote: opts.remote(), instance: *opts.instance, json: *opts.json, stdin: os.Stdin, stdout: os.Stdout}
+		if err := run(c, sub.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "ctl %s: %v\n", cmd.name, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "ctl: unknown command %q\n", fs.Arg(0))
+	fs.Usage()
+	return 2
+}
+
+// ids reads torrent IDs from args, or from stdin for "-"
+func (c *ctlContext) ids(args []string) ([]int, error) {
+	var words []string
+	for _, a := range args {
+		if a != "-" {
+			words = append(words, a)
+			continue
+		}
+		scanner := bufio.NewScanner(c.stdin)
+		scanner.Split(bufio.ScanWords)
+		for scanner.Scan() {
+			words = append(words, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("no torrent IDs given")
+	}
+	ids := make([]int, 0, len(words))
+	for _, w := range words {
+		id, err := strconv.Atoi(w)
+		if err != nil {
+			return nil, fmt.Errorf("invalid torrent ID %q", w)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// each runs fn on every ID, carrying on past failures so one bad ID
+// doesn't stop a batch, and reports how many failed
+func (c *ctlContext) each(args []string, fn func(id int) error) error {
+	ids, err := c.ids(args)
+	if err != nil {
+		return err
+	}
+	results := make([]ctlResult, 0, len(ids))
+	for _, id := range ids {
+		result := ctlResult{ID: id}
+		if err := fn(id); err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return c.report(results)
+}
+
+// report prints a batch's failures, or every result as JSON, and returns
+// an error if any failed
+func (c *ctlContext) report(results []ctlResult) error {
+	failed := 0
+	for i, r := range results {
+		results[i].OK = r.Error == ""
+		if results[i].OK {
+			continue
+		}
+		failed++
+		if c.json {
+			continue
+		}
+		if r.Input != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", r.Input, r.Error)
+		} else {
+			fmt.Fprintf(os.Stderr, "torrent %d: %s\n", r.ID, r.Error)
+		}
+	}
+	if c.json {
+		if err := c.printJSON(results); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d failed", failed, len(results))
+	}
+	return nil
+}
+
+func (c *ctlContext) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// action posts one /api/action request for id
+func (c *ctlContext) action(action string, id int, extra map[string]interface{}) error {
+	req := map[string]interface{}{"action": action, "id": id, "instance": c.instance}
+	for k, v := range extra {
+		req[k] = v
+	}
+	return c.remote.Action(req)
+}
+
+func ctlList(fs *flag.FlagSet) func(c *ctlContext, args []string) error {
+	status := fs.String("status", "", "status filter: "+strings.Join(torrentStatusNames, ", "))
+	label := fs.String("label", "", "only torrents with this label")
+	search := fs.String("search", "", "name substring")
+	sortKey := fs.String("sort", "name", "sort key: "+strings.Join(listSortKeys, ", "))
+	desc := fs.Bool("desc", false, "sort descending")
+	idsOnly := fs.Bool("ids", false, "print only IDs, one per line")
+	return func(c *ctlContext, _ []string) error {
+		q := url.Values{}
+		for k, v := range map[string]string{"status": *status, "label": *label, "search": *search, "sort": *sortKey, "instance": c.instance} {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+		if *desc {
+			q.Set("order", "desc")
+		}
+		torrents, err := c.remote.Torrents(q)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			return c.printJSON(torrents)
+		}
+		if *idsOnly {
+			for _, t := range torrents {
+				fmt.Fprintln(c.stdout, t.ID)
+			}
+			return nil
+		}
+		tw := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tDONE\tSIZE\tRATIO\tLABELS\tNAME")
+		for _, t := range torrents {
+			status := statusText(t.Status)
+			if t.Error != 0 {
+				status = "Error"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, status, display.Percent(t.PercentDone),
+				display.Bytes(t.SizeWhenDone), display.Ratio(t.UploadRatio), strings.Join(t.Labels, ","), t.Name)
+		}
+		return tw.Flush()
+	}
+}
+
+func ctlAdd(fs *flag.FlagSet) func(c *ctlContext, args []string) error {
+	dir := fs.String("dir", "", "download directory")
+	labels := fs.String("labels", "", "comma-separated labels")
+	return func(c *ctlContext, args []string) error {
+		if len(args) == 0 {
+			return fmt.Errorf("nothing to add")
+		}
+		if c.instance != "" {
+			return fmt.Errorf("add only reaches the default instance")
+		}
+		results := make([]ctlResult, 0, len(args))
+		for _, arg := range args {
+			result := ctlResult{Input: arg}
+			params := map[string]interface{}{"dir": *dir, "labels": *labels}
+			link := arg
+			if isInfoHash(link) {
+				link, _ = magnetFromHash(link, "", nil)
+			}
+			if strings.Contains(link, "://") || strings.HasPrefix(link, "magnet:") {
+				params["url"] = link
+			} else if data, err := os.ReadFile(arg); err == nil {
+				params["metainfo"] = base64.StdEncoding.EncodeToString(data)
+			} else {
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+			added, err := c.remote.Run("torrent.add", params)
+			if err != nil {
+				result.Error = err.Error()
+			} else if !c.json {
+				fmt.Fprintf(c.stdout, "added %s\n", arg)
+			}
+			result.Torrent = added
+			results = append(results, result)
+		}
+		return c.report(results)
+	}
+}
+
+func ctlRemove(fs *flag.FlagSet) func(c *ctlContext, args []string) error {
+	deleteData := fs.Bool("delete-data", false, "also delete the downloaded files")
+	force := fs.Bool("force", false, "remove even if a seed rule isn't met yet")
+	return func(c *ctlContext, args []string) error {
+		return c.each(args, func(id int) error {
+			return c.action("remove", id, map[string]interface{}{"deleteData": *deleteData, "force": *force})
+		})
+	}
+}
+
+// ctlSimple is a subcommand that posts action for each ID
+func ctlSimple(action string) func(fs *flag.FlagSet) func(c *ctlContext, args []string) error {
+	return func(*flag.FlagSet) func(c *ctlContext, args []string) error {
+		return func(c *ctlContext, args []string) error {
+			return c.each(args, func(id int) error { return c.action(action, id, nil) })
+		}
+	}
+}
+
+func ctlLabel(fs *flag.FlagSet) func(c *ctlContext, args []string) error {
+	set := fs.String("set", "", "replace the labels with these")
+	add := fs.String("add", "", "labels to add")
+	remove := fs.String("remove", "", "labels to remove")
+	clearAll := fs.Bool("clear", false, "remove every label")
+	return func(c *ctlContext, args []string) error {
+		split := func(s string) []string {
+			out := []string{}
+			for _, l := range strings.Split(s, ",") {
+				if l = strings.TrimSpace(l); l != "" {
+					out = append(out, l)
+				}
+			}
+			return out
+		}
+		edit := map[string]interface{}{}
+		switch {
+		case *clearAll:
+			edit["labels"] = []string{}
+		case *set != "":
+			edit["labels"] = split(*set)
+		}
+		if *add != "" {
+			edit["addLabels"] = split(*add)
+		}
+		if *remove != "" {
+			edit["removeLabels"] = split(*remove)
+		}
+		if len(edit) == 0 {
+			return fmt.Errorf("give -set, -add, -remove or -clear")
+		}
+		return c.each(args, func(id int) error { return c.action("labels", id, edit) })
+	}
+}
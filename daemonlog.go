@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// daemonLogTailBytes bounds how much of a log file is read per view
+	daemonLogTailBytes = 1 << 20
+	daemonLogTimeout   = 5 * time.Second
+)
+
+// daemonLogLayouts are the timestamp formats Transmission writes at the
+// start of its log lines, inside square brackets
+var daemonLogLayouts = []string{
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+	time.RFC3339Nano,
+	"01/02/06 15:04:05",
+	"15:04:05.000",
+}
+
+// DaemonLog tails Transmission's own log, from a file (DAEMON_LOG_FILE) or
+// a journald unit (DAEMON_LOG_UNIT), so it can be read next to the app log.
+// A nil DaemonLog has nothing to show.
+type DaemonLog struct {
+	path string
+	unit string
+}
+
+// NewDaemonLog returns nil when neither a file nor a unit is configured
+func NewDaemonLog(path, unit string) *DaemonLog {
+	if path == "" && unit == "" {
+		return nil
+	}
+	return &DaemonLog{path: path, unit: unit}
+}
+
+// Source describes where the log is read from
+func (d *DaemonLog) Source() string {
+	if d == nil {
+		return ""
+	}
+	if d.path != "" {
+		return d.path
+	}
+	return "journald unit " + d.unit
+}
+
+// Tail returns up to n of the most recent entries, oldest first, under the
+// "daemon" subsystem
+func (d *DaemonLog) Tail(n int) ([]LogEntry, error) {
+	if d == nil {
+		return nil, nil
+	}
+	if d.path != "" {
+		return d.tailFile(n)
+	}
+	return d.tailJournal(n)
+}
+
+func (d *DaemonLog) tailFile(n int) ([]LogEntry, error) {
+	f, err := os.Open(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(0, info.Size()-daemonLogTailBytes)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the partial first line
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	var entries []LogEntry
+	var last time.Time
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), daemonLogTailBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		e := parseDaemonLine(line, last, info.ModTime())
+		last = e.Time
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+// parseDaemonLine reads a "[timestamp] message" line. Lines without a
+// timestamp, such as continuations, take the previous line's time, or the
+// file's modification time when there's none yet.
+func parseDaemonLine(line string, last, fallback time.Time) LogEntry {
+	e := LogEntry{Time: last, Subsystem: "daemon", Message: line}
+	if e.Time.IsZero() {
+		e.Time = fallback
+	}
+	if strings.HasPrefix(line, "[") {
+		if end := strings.IndexByte(line, ']'); end > 0 {
+			stamp := line[1:end]
+			for _, layout := range daemonLogLayouts {
+				if t, err := time.ParseInLocation(layout, stamp, time.Local); err == nil {
+					if t.Year() == 0 {
+						// Time-only stamps are from today
+						now := time.Now()
+						t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+					}
+					e.Time = t
+					e.Message = strings.TrimSpace(line[end+1:])
+					break
+				}
+			}
+		}
+	}
+	e.Level = logLevel(e.Message)
+	return e
+}
+
+// tailJournal reads the unit's journal as JSON, which carries the syslog
+// priority for the level
+func (d *DaemonLog) tailJournal(n int) ([]LogEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), daemonLogTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "journalctl", "-u", d.unit, "-n", strconv.Itoa(n), "-o", "json", "--no-pager").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("journalctl: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("journalctl: %w", err)
+	}
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), daemonLogTailBytes)
+	for scanner.Scan() {
+		var rec struct {
+			Timestamp string      `json:"__REALTIME_TIMESTAMP"` // microseconds
+			Priority  string      `json:"PRIORITY"`
+			Message   interface{} `json:"MESSAGE"` // an array of bytes when not valid UTF-8
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		msg, ok := rec.Message.(string)
+		if !ok {
+			continue
+		}
+		usec, _ := strconv.ParseInt(rec.Timestamp, 10, 64)
+		e := LogEntry{Time: time.UnixMicro(usec), Subsystem: "daemon", Message: msg, Level: logLevel(msg)}
+		if p, err := strconv.Atoi(rec.Priority); err == nil {
+			switch {
+			case p <= 3:
+				e.Level = LogError
+			case p == 4:
+				e.Level = LogWarn
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// logEntries returns the app log entries matching f, merged by time with
+// the daemon's when it's configured and the filter allows them. Failing to
+// read the daemon's log returns the app entries along with the error.
+// Daemon entries have no ID, so polling with ?after= skips them.
+func (s *Server) logEntries(f LogFilter) ([]LogEntry, error) {
+	entries := s.logs.Entries(f)
+	if s.daemonLog == nil || (f.Subsystem != "" && f.Subsystem != "daemon") {
+		return entries, nil
+	}
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultLogBufferSize
+	}
+	daemon, err := s.daemonLog.Tail(limit)
+	if err != nil {
+		return entries, err
+	}
+	for _, e := range daemon {
+		if f.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	if f.Limit > 0 && len(entries) > f.Limit {
+		entries = entries[len(entries)-f.Limit:]
+	}
+	return entries, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dashboard panels, in their default order. Each is a panel-<id> template
+// in dashboard.html.
+const (
+	PanelStats       = "stats"
+	PanelSpeed       = "speed"
+	PanelCompletions = "completions"
+	PanelFeeds       = "feeds"
+	PanelDisk        = "disk"
+)
+
+var dashboardPanels = []struct{ ID, Title string }{
+	{PanelStats, "Stats"},
+	{PanelSpeed, "Speed graph"},
+	{PanelCompletions, "Recent completions"},
+	{PanelFeeds, "Feed health"},
+	{PanelDisk, "Disk usage"},
+}
+
+const (
+	recentCompletions = 5
+	speedWindow       = time.Hour
+	speedGraphWidth   = 300
+	speedGraphHeight  = 60
+)
+
+// DashboardPanel is one panel's place in a layout
+type DashboardPanel struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Visible bool   `json:"visible"`
+}
+
+// DashboardLayouts stores each user's panel order and visibility. Users
+// are WEB_USER logins; without a login everyone shares the "" layout.
+type DashboardLayouts struct {
+	db *sql.DB
+}
+
+// NewDashboardLayouts creates the layouts table
+func NewDashboardLayouts(db *sql.DB) (*DashboardLayouts, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS dashboard_layouts (
+		username TEXT PRIMARY KEY,
+		panels TEXT NOT NULL,
+		updated_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dashboard_layouts table: %w", err)
+	}
+	return &DashboardLayouts{db: db}, nil
+}
+
+func panelTitle(id string) string {
+	for _, p := range dashboardPanels {
+		if p.ID == id {
+			return p.Title
+		}
+	}
+	return ""
+}
+
+// Get returns the user's layout: their saved panels in order, then any
+// panel they've never placed (one added since they saved) at the end
+func (d *DashboardLayouts) Get(user string) ([]DashboardPanel, error) {
+	var stored string
+	err := d.db.QueryRow("SELECT panels FROM dashboard_layouts WHERE username = ?", user).Scan(&stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	var saved []DashboardPanel
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &saved); err != nil {
+			return nil, fmt.Errorf("invalid stored dashboard layout: %w", err)
+		}
+	}
+	return mergeLayout(saved), nil
+}
+
+// mergeLayout fills in a saved layout's titles and appends the panels it
+// doesn't place; a nil layout gives the default
+func mergeLayout(saved []DashboardPanel) []DashboardPanel {
+	layout := make([]DashboardPanel, 0, len(dashboardPanels))
+	placed := map[string]bool{}
+	for _, p := range saved {
+		if title := panelTitle(p.ID); title != "" && !placed[p.ID] {
+			layout = append(layout, DashboardPanel{ID: p.ID, Title: title, Visible: p.Visible})
+			placed[p.ID] = true
+		}
+	}
+	for _, p := range dashboardPanels {
+		if !placed[p.ID] {
+			layout = append(layout, DashboardPanel{ID: p.ID, Title: p.Title, Visible: true})
+		}
+	}
+	return layout
+}
+
+// Set saves the user's layout. Panels it leaves out are hidden.
+func (d *DashboardLayouts) Set(user string, panels []DashboardPanel) error {
+	seen := map[string]bool{}
+	layout := make([]DashboardPanel, 0, len(dashboardPanels))
+	for _, p := range panels {
+		if panelTitle(p.ID) == "" {
+			return fmt.Errorf("unknown dashboard panel %q", p.ID)
+		}
+		if seen[p.ID] {
+			return fmt.Errorf("dashboard panel %q is listed twice", p.ID)
+		}
+		seen[p.ID] = true
+		layout = append(layout, DashboardPanel{ID: p.ID, Visible: p.Visible})
+	}
+	for _, p := range dashboardPanels {
+		if !seen[p.ID] {
+			layout = append(layout, DashboardPanel{ID: p.ID})
+		}
+	}
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	_, err = d.db.Exec(`INSERT INTO dashboard_layouts (username, panels, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(username) DO UPDATE SET panels = excluded.panels, updated_at = excluded.updated_at`,
+		user, string(data), time.Now())
+	return err
+}
+
+// Reset drops the user's layout, back to every panel in the default order
+func (d *DashboardLayouts) Reset(user string) error {
+	_, err := d.db.Exec("DELETE FROM dashboard_layouts WHERE username = ?", user)
+	return err
+}
+
+// SpeedGraph is the speed graph panel's data: SVG polyline points for
+// each direction, scaled so Peak reaches the top
+type SpeedGraph struct {
+	Down, Up string
+	Peak     int64
+	Since    time.Time
+}
+
+// speedGraph scales the last hour of bandwidth samples to the panel's SVG;
+// nil before there are two. The panel's day and week views are drawn the
+// same way in the browser, from /api/stats/history.
+func speedGraph(samples []BandwidthPoint, now time.Time) *SpeedGraph {
+	if len(samples) < 2 {
+		return nil
+	}
+	g := &SpeedGraph{Peak: 1, Since: samples[0].Time}
+	for _, s := range samples {
+		g.Peak = max(g.Peak, s.Download, s.Upload)
+	}
+	start := now.Add(-speedWindow)
+	point := func(t time.Time, v int64) string {
+		x := float64(t.Sub(start)) / float64(speedWindow) * speedGraphWidth
+		y := speedGraphHeight - float64(v)/float64(g.Peak)*speedGraphHeight
+		return strconv.FormatFloat(max(x, 0), 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+	}
+	down := make([]string, len(samples))
+	up := make([]string, len(samples))
+	for i, s := range samples {
+		down[i] = point(s.Time, s.Download)
+		up[i] = point(s.Time, s.Upload)
+	}
+	g.Down, g.Up = strings.Join(down, " "), strings.Join(up, " ")
+	return g
+}
+
+// lastCompleted returns the most recently finished torrents, newest first
+func lastCompleted(torrents []Torrent, n int) []Torrent {
+	var done []Torrent
+	for _, t := range torrents {
+		if t.DoneDate > 0 {
+			done = append(done, t)
+		}
+	}
+	sort.Slice(done, func(i, j int) bool { return done[i].DoneDate > done[j].DoneDate })
+	if len(done) > n {
+		done = done[:n]
+	}
+	return done
+}
+
+// dashboardLayout reads the signed-in user's layout. A broken layout falls
+// back to the default rather than failing the page.
+func (s *Server) dashboardLayout(r *http.Request) []DashboardPanel {
+	layout, err := s.dashboard.Get(s.auth.requestUser(r))
+	if err != nil {
+		log.Printf("Failed to load dashboard layout: %v", err)
+		return mergeLayout(nil)
+	}
+	return layout
+}
+
+// addPanelData fetches what the visible panels show into the page data;
+// stats and disk use what the page already has
+func (s *Server) addPanelData(data map[string]interface{}, panels []DashboardPanel, torrents []Torrent) {
+	for _, p := range panels {
+		if !p.Visible {
+			continue
+		}
+		switch p.ID {
+		case PanelSpeed:
+			now := time.Now()
+			samples, _, err := s.bandwidth.History("hour", now)
+			if err != nil {
+				log.Printf("Failed to load bandwidth history for the dashboard: %v", err)
+				continue
+			}
+			data["Speeds"] = speedGraph(samples, now)
+		case PanelCompletions:
+			data["Completed"] = lastCompleted(torrents, recentCompletions)
+		case PanelFeeds:
+			if !features.Enabled(FeatureRSS) {
+				continue
+			}
+			feeds, err := s.feedManager.GetFeeds()
+			if err != nil {
+				log.Printf("Failed to load feeds for the dashboard: %v", err)
+				continue
+			}
+			data["FeedHealth"] = feeds
+		}
+	}
+}
+
+// handleDashboard serves GET and POST /api/dashboard: the signed-in user's
+// panel layout, and saving a new one as {"panels": [{"id", "visible"}]}
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	user := s.auth.requestUser(r)
+	if r.Method == "POST" {
+		var req struct {
+			Panels []DashboardPanel `json:"panels"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "invalid request")
+			return
+		}
+		if err := s.dashboard.Set(user, req.Panels); err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+	}
+	layout, err := s.dashboard.Get(user)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"panels": layout})
+}
+
+// handleResetDashboard serves POST /api/dashboard/reset
+func (s *Server) handleResetDashboard(w http.ResponseWriter, r *http.Request) {
+	user := s.auth.requestUser(r)
+	if err := s.dashboard.Reset(user); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	layout, err := s.dashboard.Get(user)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"panels": layout})
+}
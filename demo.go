@@ -0,0 +1,878 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	demoRPCPath  = "/transmission/rpc"
+	demoFeedPath = "/demo/feed.xml"
+	demoPieces   = 256
+)
+
+// demoTorrentSeeds are the synthetic library: freely distributable releases
+// in every state the UI has to show
+var demoTorrentSeeds = []struct {
+	name    string
+	size    int64
+	done    float64
+	status  int
+	tracker string
+	labels  []string
+	private bool
+	errMsg  string
+}{
+	{"ubuntu-24.04.1-desktop-amd64.iso", 6 << 30, 1, 6, "torrent.ubuntu.com", []string{"linux"}, false, ""},
+	{"debian-12.7.0-amd64-netinst.iso", 631 << 20, 1, 6, "bttracker.debian.org", []string{"linux"}, false, ""},
+	{"Fedora-Workstation-Live-x86_64-40", 2 << 30, 0.62, 4, "torrent.fedoraproject.org", []string{"linux"}, false, ""},
+	{"archlinux-2024.10.01-x86_64.iso", 1 << 30, 0.18, 4, "tracker.archlinux.org", []string{"linux"}, false, ""},
+	{"linuxmint-22-cinnamon-64bit.iso", 2900 << 20, 1, 0, "tracker.linuxmint.com", []string{"linux"}, false, ""},
+	{"Big.Buck.Bunny.2008.1080p.BluRay.x264", 885 << 20, 1, 6, "tracker.opentracker.example", []string{"movies"}, false, ""},
+	{"Sintel.2010.2160p.WEB-DL.H265", 4 << 30, 0.41, 4, "tracker.opentracker.example", []string{"movies"}, false, ""},
+	{"Tears.of.Steel.2012.1080p.WEB-DL", 1600 << 20, 1, 6, "tracker.opentracker.example", []string{"movies"}, false, ""},
+	{"Cosmos.Laundromat.2015.1080p", 1200 << 20, 0, 3, "tracker.opentracker.example", []string{"movies"}, false, ""},
+	{"Spring.2019.2160p.HDR", 3 << 30, 0.05, 4, "tracker.opentracker.example", []string{"movies"}, false, ""},
+	{"Jamendo.Creative.Commons.Collection.FLAC", 8 << 30, 1, 6, "music.tracker.example", []string{"music"}, true, ""},
+	{"Internet.Archive.78rpm.Collection.Vol.3", 2 << 30, 0.88, 4, "music.tracker.example", []string{"music"}, true, ""},
+	{"Free.Music.Archive.2024.Sampler", 900 << 20, 1, 6, "music.tracker.example", []string{"music"}, true, ""},
+	{"Wikipedia.Dump.enwiki-20241001-pages-articles", 22 << 30, 0.33, 4, "academictorrents.example", []string{"data"}, false, ""},
+	{"OpenStreetMap.planet-241007.osm.pbf", 76 << 30, 1, 6, "academictorrents.example", []string{"data"}, false, ""},
+	{"ImageNet.Sample.Validation.Set", 6 << 30, 0.97, 2, "academictorrents.example", []string{"data"}, false, ""},
+	{"Common.Voice.Corpus.17.0.en", 80 << 30, 0.12, 0, "academictorrents.example", []string{"data"}, false, "Tracker gave an error: \"unregistered torrent\""},
+	{"The.Art.of.Computer.Programming.Lectures", 14 << 30, 1, 6, "edu.tracker.example", nil, true, ""},
+	{"MIT.OCW.6.006.Introduction.to.Algorithms", 9 << 30, 1, 6, "edu.tracker.example", nil, true, ""},
+	{"Project.Gutenberg.Top.1000.EPUB", 1300 << 20, 1, 6, "edu.tracker.example", nil, true, ""},
+	{"NASA.Apollo.11.Restored.Footage.1080p", 11 << 30, 0.74, 4, "", nil, false, ""},
+	{"Blender.4.2.LTS.Demo.Files", 3 << 30, 1, 6, "", nil, false, ""},
+}
+
+var demoClients = []string{"Transmission 4.0.6", "qBittorrent 4.6.7", "Deluge 2.1.1", "rTorrent 0.9.8", "libtorrent (Rasterbar) 2.0.10", "BiglyBT 3.7.0.0"}
+
+type demoFile struct {
+	name   string
+	length int64
+	wanted bool
+	prio   int
+}
+
+type demoPeer struct {
+	address  string
+	client   string
+	progress float64
+	flags    string
+	lan      bool
+}
+
+type demoTorrent struct {
+	id         int
+	name       string
+	hash       string
+	size       int64
+	have       float64 // bytes done, fractional so slow rates still move
+	status     int
+	down, up   int64 // target rates; actual ones jitter around them
+	uploaded   int64
+	added      int64
+	doneDate   int64
+	tracker    string
+	deadBackup bool // a second tracker that never answers, until removed
+	labels     []string
+	private    bool
+	err        int
+	errMsg     string
+	files      []demoFile
+	peers      []demoPeer
+	downloadTo string
+	ratioMode  int
+	ratioLimit float64
+	idleMode   int
+	idleLimit  int
+	// Speed limits in kB/s, applied when the matching flag is set
+	downLimit, upLimit     int
+	downLimited, upLimited bool
+	announced              int64 // the last reannounce the tracker took, unix seconds
+}
+
+// capped keeps a rate within a torrent's speed limit
+func capped(rate int64, limited bool, limit int) int64 {
+	if limited {
+		return min(rate, int64(limit)*1000)
+	}
+	return rate
+}
+
+// downRate and upRate are the torrent's jittered current rates
+func (d *DemoDaemon) downRate(t *demoTorrent) int64 {
+	return capped(d.jitter(t.down), t.downLimited, t.downLimit)
+}
+
+func (d *DemoDaemon) upRate(t *demoTorrent) int64 {
+	return capped(d.jitter(t.up), t.upLimited, t.upLimit)
+}
+
+// DemoDaemon is an in-process stand-in for Transmission's RPC, so the UI
+// can be tried and developed without a daemon (--demo). Torrents download
+// and seed on a clock; every action the UI offers changes the state.
+type DemoDaemon struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	torrents []*demoTorrent
+	nextID   int
+	session  map[string]interface{}
+	last     time.Time
+	started  time.Time
+	baseURL  string
+	downTot  int64
+	upTot    int64
+}
+
+// NewDemoDaemon builds the synthetic library. A size larger than the seed
+// list repeats the seeds under numbered names, for load testing.
+func NewDemoDaemon(size int) *DemoDaemon {
+	now := time.Now()
+	d := &DemoDaemon{
+		rng:     rand.New(rand.NewSource(now.UnixNano())),
+		last:    now,
+		started: now,
+		session: map[string]interface{}{
+			"version":                    "4.0.6 (demo)",
+			"rpc-version":                17,
+			"rpc-version-minimum":        14,
+			"download-dir":               "/downloads/complete",
+			"incomplete-dir":             "/downloads/incomplete",
+			"incomplete-dir-enabled":     true,
+			"speed-limit-down":           10000,
+			"speed-limit-down-enabled":   false,
+			"speed-limit-up":             2000,
+			"speed-limit-up-enabled":     true,
+			"alt-speed-down":             500,
+			"alt-speed-up":               100,
+			"alt-speed-enabled":          false,
+			"alt-speed-time-enabled":     false,
+			"alt-speed-time-begin":       540,
+			"alt-speed-time-end":         1020,
+			"alt-speed-time-day":         127,
+			"peer-limit-global":          200,
+			"peer-limit-per-torrent":     50,
+			"peer-port":                  51413,
+			"encryption":                 "preferred",
+			"dht-enabled":                true,
+			"pex-enabled":                true,
+			"lpd-enabled":                false,
+			"utp-enabled":                true,
+			"seedRatioLimit":             2.0,
+			"seedRatioLimited":           false,
+			"idle-seeding-limit":         30,
+			"idle-seeding-limit-enabled": false,
+			"download-queue-enabled":     true,
+			"download-queue-size":        5,
+			"seed-queue-enabled":         false,
+			"seed-queue-size":            10,
+			"queue-stalled-enabled":      true,
+			"queue-stalled-minutes":      30,
+			"start-added-torrents":       true,
+			"rename-partial-files":       true,
+		},
+	}
+	size = max(size, len(demoTorrentSeeds))
+	for i := 0; i < size; i++ {
+		seed := demoTorrentSeeds[i%len(demoTorrentSeeds)]
+		name := seed.name
+		if i >= len(demoTorrentSeeds) {
+			name = fmt.Sprintf("%s.%d", seed.name, i/len(demoTorrentSeeds))
+		}
+		t := &demoTorrent{
+			id:         i + 1,
+			name:       name,
+			size:       seed.size,
+			have:       float64(seed.size) * seed.done,
+			status:     seed.status,
+			tracker:    seed.tracker,
+			labels:     append([]string{}, seed.labels...),
+			private:    seed.private,
+			added:      now.Add(-time.Duration(d.rng.Intn(60*24*90)) * time.Minute).Unix(),
+			downloadTo: "/downloads/complete",
+		}
+		if seed.errMsg != "" {
+			t.err, t.errMsg = 2, seed.errMsg
+		}
+		t.deadBackup = !seed.private && seed.tracker != "" && t.id%4 == 0
+		if seed.done >= 1 {
+			t.doneDate = t.added + int64(d.rng.Intn(3*3600))
+			t.uploaded = int64(float64(seed.size) * (0.2 + d.rng.Float64()*3))
+		}
+		d.populate(t)
+		d.torrents = append(d.torrents, t)
+	}
+	d.nextID = len(d.torrents) + 1
+	return d
+}
+
+// populate invents a torrent's hash, files and swarm
+func (d *DemoDaemon) populate(t *demoTorrent) {
+	t.hash = fmt.Sprintf("%016x%016x%08x", d.rng.Uint64(), d.rng.Uint64(), d.rng.Uint32())
+	t.ratioLimit, t.idleLimit = 2, 30 // Transmission's defaults
+	t.downLimit, t.upLimit = 100, 100
+	n := 1 + d.rng.Intn(5)
+	if n == 1 {
+		t.files = []demoFile{{name: t.name, length: t.size, wanted: true}}
+	} else {
+		left := t.size
+		for i := 0; i < n; i++ {
+			length := left / int64(n-i)
+			left -= length
+			t.files = append(t.files, demoFile{name: fmt.Sprintf("%s/part%02d.bin", t.name, i+1), length: length, wanted: true})
+		}
+		t.files = append(t.files, demoFile{name: t.name + "/README.txt", length: 2048, wanted: true})
+	}
+	t.down = int64(200+d.rng.Intn(8000)) << 10
+	t.up = int64(20+d.rng.Intn(1500)) << 10
+	for i := 0; i < 2+d.rng.Intn(12); i++ {
+		p := demoPeer{
+			address:  fmt.Sprintf("%s.%d", []string{"203.0.113", "198.51.100", "192.0.2"}[d.rng.Intn(3)], 1+d.rng.Intn(254)),
+			client:   demoClients[d.rng.Intn(len(demoClients))],
+			progress: d.rng.Float64(),
+			flags:    []string{"TDEI", "UXE", "dH", "TUEI", "DEX"}[d.rng.Intn(5)],
+		}
+		if i == 0 && d.rng.Intn(4) == 0 {
+			p.address, p.lan, p.flags = fmt.Sprintf("192.168.1.%d", 10+d.rng.Intn(200)), true, "TDEL"
+		}
+		t.peers = append(t.peers, p)
+	}
+}
+
+// Start serves the RPC and the demo feed on a loopback port and returns
+// the RPC URL
+func (d *DemoDaemon) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	d.baseURL = "http://" + ln.Addr().String()
+	mux := http.NewServeMux()
+	mux.HandleFunc(demoRPCPath, d.handleRPC)
+	mux.HandleFunc(demoFeedPath, d.handleFeed)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			log.Printf("Demo daemon stopped: %v", err)
+		}
+	}()
+	return d.baseURL + demoRPCPath, nil
+}
+
+// FeedURL is the demo RSS feed, for seeding the feed list
+func (d *DemoDaemon) FeedURL() string {
+	return d.baseURL + demoFeedPath
+}
+
+// advance moves downloads and seeding along to now. Callers must hold d.mu.
+func (d *DemoDaemon) advance(now time.Time) {
+	dt := now.Sub(d.last).Seconds()
+	d.last = now
+	for _, t := range d.torrents {
+		switch t.status {
+		case 4:
+			got := float64(capped(t.down, t.downLimited, t.downLimit)) * dt
+			t.have = min(float64(t.size), t.have+got)
+			d.downTot += int64(got)
+			if t.have >= float64(t.size) {
+				t.status, t.doneDate = 6, now.Unix()
+			}
+			fallthrough
+		case 6:
+			sent := int64(float64(capped(t.up, t.upLimited, t.upLimit)) * dt)
+			t.uploaded += sent
+			d.upTot += sent
+			// Demo seeds never go idle, so only the ratio limit stops them
+			if t.status == 6 && t.ratioMode == SeedModeSingle && float64(t.uploaded) >= t.ratioLimit*t.have {
+				t.status = 0
+			}
+		}
+	}
+}
+
+func (d *DemoDaemon) jitter(rate int64) int64 {
+	return int64(float64(rate) * (0.7 + d.rng.Float64()*0.6))
+}
+
+func (d *DemoDaemon) find(id int) *demoTorrent {
+	for _, t := range d.torrents {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// selected resolves an RPC "ids" argument: absent means every torrent
+func (d *DemoDaemon) selected(args map[string]interface{}) []*demoTorrent {
+	raw, ok := args["ids"]
+	if !ok {
+		return d.torrents
+	}
+	var ids []interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		ids = v
+	case string:
+		if v == "recently-active" {
+			return d.torrents
+		}
+		ids = []interface{}{v}
+	default:
+		ids = []interface{}{v}
+	}
+	var out []*demoTorrent
+	for _, id := range ids {
+		for _, t := range d.torrents {
+			if n, ok := id.(float64); ok && int(n) == t.id {
+				out = append(out, t)
+			} else if s, ok := id.(string); ok && strings.EqualFold(s, t.hash) {
+				out = append(out, t)
+			}
+		}
+	}
+	return out
+}
+
+func (d *DemoDaemon) handleRPC(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method    string                 `json:"method"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Arguments == nil {
+		req.Arguments = map[string]interface{}{}
+	}
+
+	d.mu.Lock()
+	d.advance(time.Now())
+	args, err := d.call(req.Method, req.Arguments)
+	d.mu.Unlock()
+
+	result := "success"
+	if err != nil {
+		result = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "arguments": args}); err != nil {
+		log.Printf("Failed to encode demo response: %v", err)
+	}
+}
+
+// call runs one RPC method. Callers must hold d.mu.
+func (d *DemoDaemon) call(method string, args map[string]interface{}) (map[string]interface{}, error) {
+	switch method {
+	case "session-get":
+		return d.session, nil
+	case "session-set":
+		for k, v := range args {
+			if k != "version" && k != "rpc-version" {
+				d.session[k] = v
+			}
+		}
+	case "session-stats":
+		return d.stats(), nil
+	case "port-test":
+		return map[string]interface{}{"port-is-open": true}, nil
+	case "free-space":
+		return map[string]interface{}{"path": args["path"], "size-bytes": int64(1200) << 30}, nil
+	case "torrent-get":
+		fields := map[string]bool{}
+		if list, ok := args["fields"].([]interface{}); ok {
+			for _, f := range list {
+				fields[fmt.Sprint(f)] = true
+			}
+		}
+		torrents := []map[string]interface{}{}
+		for _, t := range d.selected(args) {
+			torrents = append(torrents, d.torrentFields(t, fields))
+		}
+		return map[string]interface{}{"torrents": torrents}, nil
+	case "torrent-start", "torrent-start-now":
+		for _, t := range d.selected(args) {
+			if t.have >= float64(t.size) {
+				t.status = 6
+			} else {
+				t.status = 4
+			}
+			t.err, t.errMsg = 0, ""
+		}
+	case "torrent-stop":
+		for _, t := range d.selected(args) {
+			t.status = 0
+		}
+	case "torrent-verify":
+		for _, t := range d.selected(args) {
+			t.status = 2
+		}
+	case "torrent-reannounce":
+		now := time.Now().Unix()
+		for _, t := range d.selected(args) {
+			// Like the daemon, drop a reannounce the tracker wouldn't take yet
+			if at := d.manualAnnounceTime(t, now); at < 0 || at > now {
+				continue
+			}
+			t.announced = now
+			if t.err == 2 {
+				t.err, t.errMsg = 0, ""
+			}
+		}
+	case "torrent-remove":
+		remove := d.selected(args)
+		kept := d.torrents[:0]
+		for _, t := range d.torrents {
+			if !containsTorrent(remove, t) {
+				kept = append(kept, t)
+			}
+		}
+		d.torrents = kept
+	case "torrent-set":
+		for _, t := range d.selected(args) {
+			d.set(t, args)
+		}
+	case "torrent-set-location":
+		location, _ := args["location"].(string)
+		for _, t := range d.selected(args) {
+			t.downloadTo = location
+		}
+	case "torrent-add":
+		return d.add(args)
+	case "queue-move-top", "queue-move-up", "queue-move-down", "queue-move-bottom":
+		for _, t := range d.selected(args) {
+			d.moveQueue(t, strings.TrimPrefix(method, "queue-move-"))
+		}
+	}
+	return map[string]interface{}{}, nil
+}
+
+// The demo's queue order is the order of d.torrents
+func (d *DemoDaemon) queuePosition(t *demoTorrent) int {
+	for i, x := range d.torrents {
+		if x == t {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *DemoDaemon) moveQueue(t *demoTorrent, move string) {
+	from := d.queuePosition(t)
+	to := from
+	switch move {
+	case "top":
+		to = 0
+	case "up":
+		to = max(from-1, 0)
+	case "down":
+		to = min(from+1, len(d.torrents)-1)
+	case "bottom":
+		to = len(d.torrents) - 1
+	}
+	if from < 0 || to == from {
+		return
+	}
+	d.torrents = append(d.torrents[:from], d.torrents[from+1:]...)
+	d.torrents = append(d.torrents[:to], append([]*demoTorrent{t}, d.torrents[to:]...)...)
+}
+
+func containsTorrent(list []*demoTorrent, t *demoTorrent) bool {
+	for _, x := range list {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DemoDaemon) stats() map[string]interface{} {
+	active, paused := 0, 0
+	var down, up int64
+	for _, t := range d.torrents {
+		switch t.status {
+		case 0:
+			paused++
+		case 4:
+			active++
+			down += d.downRate(t)
+			up += d.upRate(t)
+		case 6:
+			active++
+			up += d.upRate(t)
+		}
+	}
+	return map[string]interface{}{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       len(d.torrents),
+		"downloadSpeed":      down,
+		"uploadSpeed":        up,
+		"cumulative-stats": map[string]interface{}{
+			"downloadedBytes": int64(3<<40) + d.downTot,
+			"uploadedBytes":   int64(9<<40) + d.upTot,
+		},
+		"current-stats": map[string]interface{}{
+			"downloadedBytes": d.downTot,
+			"uploadedBytes":   d.upTot,
+			"secondsActive":   int64(time.Since(d.started).Seconds()),
+		},
+	}
+}
+
+// torrentFields renders a torrent as torrent-get would, limited to the
+// requested fields when any are given
+func (d *DemoDaemon) torrentFields(t *demoTorrent, fields map[string]bool) map[string]interface{} {
+	now := time.Now().Unix()
+	percent := t.have / float64(t.size)
+	var rateDown, rateUp int64
+	switch t.status {
+	case 4:
+		rateDown, rateUp = d.downRate(t), d.upRate(t)
+	case 6:
+		rateUp = d.upRate(t)
+	}
+	eta := -1
+	if rateDown > 0 {
+		eta = int((float64(t.size) - t.have) / float64(rateDown))
+	}
+	downloaded := int64(t.have)
+	ratio := -1.0
+	if downloaded > 0 {
+		ratio = float64(t.uploaded) / float64(downloaded)
+	}
+	var seeding int64
+	if t.doneDate > 0 {
+		seeding = now - t.doneDate
+	}
+	peersConnected := 0
+	if t.status == 4 || t.status == 6 {
+		peersConnected = len(t.peers)
+	}
+
+	m := map[string]interface{}{
+		"id":                  t.id,
+		"name":                t.name,
+		"status":              t.status,
+		"percentDone":         percent,
+		"rateDownload":        rateDown,
+		"rateUpload":          rateUp,
+		"uploadRatio":         ratio,
+		"sizeWhenDone":        t.size,
+		"totalSize":           t.size,
+		"leftUntilDone":       t.size - downloaded,
+		"downloadedEver":      downloaded,
+		"uploadedEver":        t.uploaded,
+		"peersConnected":      peersConnected,
+		"eta":                 eta,
+		"error":               t.err,
+		"errorString":         t.errMsg,
+		"addedDate":           t.added,
+		"doneDate":            t.doneDate,
+		"activityDate":        now,
+		"secondsSeeding":      seeding,
+		"hashString":          t.hash,
+		"isPrivate":           t.private,
+		"labels":              t.labels,
+		"downloadDir":         t.downloadTo,
+		"pieceCount":          demoPieces,
+		"pieceSize":           t.size / demoPieces,
+		"pieces":              demoPieceMap(percent),
+		"peer-limit":          50,
+		"bandwidthPriority":   0,
+		"honorsSessionLimits": true,
+		"queuePosition":       d.queuePosition(t),
+		"seedRatioLimit":      t.ratioLimit,
+		"seedRatioMode":       t.ratioMode,
+		"seedIdleLimit":       t.idleLimit,
+		"seedIdleMode":        t.idleMode,
+		"downloadLimit":       t.downLimit,
+		"downloadLimited":     t.downLimited,
+		"uploadLimit":         t.upLimit,
+		"uploadLimited":       t.upLimited,
+		"manualAnnounceTime":  d.manualAnnounceTime(t, now),
+	}
+	var trackers, stats []map[string]interface{}
+	if t.tracker != "" {
+		announce := "https://" + t.tracker + "/announce"
+		trackers = append(trackers, map[string]interface{}{"id": 0, "announce": announce, "tier": 0})
+		result := "Success"
+		last := max(now-600, t.announced)
+		if t.err == 2 {
+			result = strings.TrimPrefix(t.errMsg, "Tracker gave an error: ")
+		}
+		stats = append(stats, map[string]interface{}{
+			"id": 0, "tier": 0, "announce": announce, "host": t.tracker,
+			"scrape": "https://" + t.tracker + "/scrape", "hasAnnounced": true, "hasScraped": true,
+			"lastAnnounceSucceeded": t.err != 2, "lastAnnounceResult": result,
+			"lastAnnounceTime": last, "nextAnnounceTime": last + 1800, "lastAnnouncePeerCount": len(t.peers),
+			"lastScrapeSucceeded": true, "lastScrapeTime": now - 300, "nextScrapeTime": now + 1500,
+			"seederCount": 5 + t.id*7%90, "leecherCount": t.id * 3 % 40, "downloadCount": 100 + t.id*37,
+		})
+	}
+	if t.deadBackup {
+		announce := "udp://tracker.defunct.example:6969/announce"
+		trackers = append(trackers, map[string]interface{}{"id": 1, "announce": announce, "tier": 1})
+		stats = append(stats, map[string]interface{}{
+			"id": 1, "tier": 1, "announce": announce, "host": "tracker.defunct.example",
+			"hasAnnounced": true, "lastAnnounceSucceeded": false, "lastAnnounceResult": "Connection failed",
+			"lastAnnounceTime": now - 3600, "nextAnnounceTime": now + 7200,
+		})
+	}
+	m["trackers"], m["trackerStats"] = orEmpty(trackers), orEmpty(stats)
+
+	var files, fileStats []map[string]interface{}
+	var wanted, high, normal, low []int
+	for i, f := range t.files {
+		done := int64(float64(f.length) * percent)
+		files = append(files, map[string]interface{}{"name": f.name, "length": f.length, "bytesCompleted": done})
+		fileStats = append(fileStats, map[string]interface{}{"bytesCompleted": done, "wanted": f.wanted, "priority": f.prio})
+		if f.wanted {
+			wanted = append(wanted, 1)
+		} else {
+			wanted = append(wanted, 0)
+		}
+		switch f.prio {
+		case 1:
+			high = append(high, i)
+		case -1:
+			low = append(low, i)
+		default:
+			normal = append(normal, i)
+		}
+	}
+	m["files"], m["fileStats"], m["wanted"] = files, fileStats, wanted
+
+	var peers []map[string]interface{}
+	if peersConnected > 0 {
+		for _, p := range t.peers {
+			peers = append(peers, map[string]interface{}{
+				"address": p.address, "port": 51413, "clientName": p.client, "flagStr": p.flags,
+				"progress": p.progress, "rateToClient": d.jitter(rateDown / int64(len(t.peers))), "rateToPeer": d.jitter(rateUp / int64(len(t.peers))),
+				"isEncrypted": strings.Contains(p.flags, "E"), "isIncoming": strings.Contains(p.flags, "I"),
+				"isUTP": strings.Contains(p.flags, "T"), "isDownloadingFrom": rateDown > 0, "isUploadingTo": rateUp > 0,
+			})
+		}
+	}
+	m["peers"] = orEmpty(peers)
+
+	if len(fields) == 0 {
+		return m
+	}
+	out := make(map[string]interface{}, len(fields))
+	for k := range fields {
+		if v, ok := m[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func orEmpty(list []map[string]interface{}) []map[string]interface{} {
+	if list == nil {
+		return []map[string]interface{}{}
+	}
+	return list
+}
+
+// demoPieceMap is the base64 bitfield Transmission sends, with the first
+// share of pieces present
+func demoPieceMap(percent float64) string {
+	bits := make([]byte, demoPieces/8)
+	have := int(percent * demoPieces)
+	for i := 0; i < have; i++ {
+		bits[i/8] |= 0x80 >> (i % 8)
+	}
+	return base64.StdEncoding.EncodeToString(bits)
+}
+
+// set applies the torrent-set arguments the UI sends
+func (d *DemoDaemon) set(t *demoTorrent, args map[string]interface{}) {
+	indices := func(key string) []int {
+		list, _ := args[key].([]interface{})
+		var out []int
+		for _, v := range list {
+			if n, ok := v.(float64); ok && int(n) < len(t.files) {
+				out = append(out, int(n))
+			}
+		}
+		return out
+	}
+	if labels, ok := args["labels"].([]interface{}); ok {
+		t.labels = []string{}
+		for _, l := range labels {
+			t.labels = append(t.labels, fmt.Sprint(l))
+		}
+	}
+	if dir, ok := args["location"].(string); ok {
+		t.downloadTo = dir
+	}
+	if remove, ok := args["trackerRemove"].([]interface{}); ok && slices.Contains(remove, interface{}(float64(1))) {
+		t.deadBackup = false
+	}
+	if limit, ok := args["seedRatioLimit"].(float64); ok {
+		t.ratioLimit = limit
+	}
+	if mode, ok := args["seedRatioMode"].(float64); ok {
+		t.ratioMode = int(mode)
+	}
+	if limit, ok := args["seedIdleLimit"].(float64); ok {
+		t.idleLimit = int(limit)
+	}
+	if mode, ok := args["seedIdleMode"].(float64); ok {
+		t.idleMode = int(mode)
+	}
+	if limit, ok := args["downloadLimit"].(float64); ok {
+		t.downLimit = int(limit)
+	}
+	if limit, ok := args["uploadLimit"].(float64); ok {
+		t.upLimit = int(limit)
+	}
+	if limited, ok := args["downloadLimited"].(bool); ok {
+		t.downLimited = limited
+	}
+	if limited, ok := args["uploadLimited"].(bool); ok {
+		t.upLimited = limited
+	}
+	for _, i := range indices("files-wanted") {
+		t.files[i].wanted = true
+	}
+	for _, i := range indices("files-unwanted") {
+		t.files[i].wanted = false
+	}
+	for key, prio := range map[string]int{"priority-high": 1, "priority-normal": 0, "priority-low": -1} {
+		for _, i := range indices(key) {
+			t.files[i].prio = prio
+		}
+	}
+}
+
+// add creates a torrent from a magnet link or metainfo, named from the
+// magnet's dn= when there is one
+func (d *DemoDaemon) add(args map[string]interface{}) (map[string]interface{}, error) {
+	name := fmt.Sprintf("New.Torrent.%d", d.nextID)
+	if link, ok := args["filename"].(string); ok {
+		if u, err := url.Parse(link); err == nil && u.Scheme == "magnet" {
+			if dn := u.Query().Get("dn"); dn != "" {
+				name = dn
+			}
+		}
+		for _, t := range d.torrents {
+			if t.name == name {
+				return map[string]interface{}{"torrent-duplicate": map[string]interface{}{"id": t.id, "name": t.name, "hashString": t.hash}}, nil
+			}
+		}
+	}
+	t := &demoTorrent{
+		id:         d.nextID,
+		name:       name,
+		size:       int64(500+d.rng.Intn(4000)) << 20,
+		status:     4,
+		added:      time.Now().Unix(),
+		tracker:    "tracker.opentracker.example",
+		labels:     []string{},
+		downloadTo: "/downloads/complete",
+	}
+	if dir, ok := args["download-dir"].(string); ok && dir != "" {
+		t.downloadTo = dir
+	}
+	if labels, ok := args["labels"].([]interface{}); ok {
+		for _, l := range labels {
+			t.labels = append(t.labels, fmt.Sprint(l))
+		}
+	}
+	if paused, _ := args["paused"].(bool); paused {
+		t.status = 0
+	}
+	d.populate(t)
+	d.nextID++
+	d.torrents = append(d.torrents, t)
+	return map[string]interface{}{"torrent-added": map[string]interface{}{"id": t.id, "name": t.name, "hashString": t.hash}}, nil
+}
+
+// demoAnnounceMinInterval is the demo trackers' minimum announce interval
+const demoAnnounceMinInterval = 5 * 60
+
+// manualAnnounceTime is when t's tracker next accepts a manual announce:
+// its minimum interval after the last one, or -1 while stopped
+func (d *DemoDaemon) manualAnnounceTime(t *demoTorrent, now int64) int64 {
+	if t.status == 0 || t.tracker == "" {
+		return -1
+	}
+	return max(now-600, t.announced) + demoAnnounceMinInterval
+}
+
+// handleFeed serves an RSS feed of releases, a new one every few minutes,
+// with magnet links the demo daemon can add
+func (d *DemoDaemon) handleFeed(w http.ResponseWriter, _ *http.Request) {
+	shows := []string{"Open.Source.Weekly", "Linux.Action.Show", "Blender.Studio.Shorts", "Public.Domain.Theatre"}
+	now := time.Now().Truncate(5 * time.Minute)
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Demo releases</title>`)
+	for i := 0; i < 12; i++ {
+		published := now.Add(-time.Duration(i) * 5 * time.Minute)
+		episode := int(published.Unix()/300) % 90
+		name := fmt.Sprintf("%s.S01E%02d.1080p.WEB.x264-DEMO", shows[i%len(shows)], episode+1)
+		hash := fmt.Sprintf("%x", sha1.Sum([]byte(name)))
+		link := "magnet:?xt=urn:btih:" + hash + "&dn=" + url.QueryEscape(name)
+		fmt.Fprintf(&b, `<item><title>%s</title><guid>%s</guid><link>%s</link><pubDate>%s</pubDate></item>`,
+			html.EscapeString(name), hash, html.EscapeString(link), published.Format(time.RFC1123Z))
+	}
+	b.WriteString(`</channel></rss>`)
+	w.Header().Set("Content-Type", "application/rss+xml")
+	fmt.Fprint(w, b.String())
+}
+
+// demoDatabase makes a fresh temporary directory for the demo's database.
+// It's removed on SIGINT or SIGTERM, and by the returned cleanup on other
+// exits.
+func demoDatabase() (string, func(), error) {
+	dir, err := os.MkdirTemp("", "transmission-web-demo-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("Failed to remove demo data: %v", err)
+		}
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cleanup()
+		os.Exit(0)
+	}()
+	return filepath.Join(dir, "feeds.db"), cleanup, nil
+}
+
+// seedDemoFeeds adds the demo feed when the feed list is empty
+func seedDemoFeeds(fm *FeedManager, d *DemoDaemon) {
+	feeds, err := fm.GetFeeds()
+	if err != nil || len(feeds) > 0 {
+		return
+	}
+	feed := &Feed{Name: "Demo releases", URL: d.FeedURL(), Pattern: `(?i)linux|blender`, Enabled: true, CheckInterval: 15}
+	if err := fm.AddFeed(feed); err != nil {
+		log.Printf("Failed to add demo feed: %v", err)
+	}
+}
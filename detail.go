@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pieceBuckets is how many segments the piece map is drawn with
+const pieceBuckets = 100
+
+// detailFields are requested on top of torrentFields for the detail view
+var detailFields = []string{"files", "fileStats", "trackerStats", "pieces", "pieceCount", "pieceSize", "wanted", "manualAnnounceTime"}
+
+// PieceInfo summarizes which pieces a torrent has
+type PieceInfo struct {
+	Count int   `json:"count"`
+	Size  int64 `json:"size"`
+	Have  int   `json:"have"`
+	// Buckets is the fraction of pieces present in each of up to
+	// pieceBuckets equal runs, for drawing a piece map
+	Buckets []float64 `json:"buckets"`
+}
+
+// newPieceInfo decodes Transmission's base64 piece bitfield, whose most
+// significant bit is the first piece
+func newPieceInfo(count int, size int64, bitfield string) (*PieceInfo, error) {
+	raw, err := base64.StdEncoding.DecodeString(bitfield)
+	if err != nil {
+		return nil, fmt.Errorf("invalid piece bitfield: %w", err)
+	}
+	info := &PieceInfo{Count: count, Size: size, Buckets: []float64{}}
+	if count <= 0 {
+		return info, nil
+	}
+	has := func(i int) bool {
+		return i/8 < len(raw) && raw[i/8]&(0x80>>(i%8)) != 0
+	}
+	for _, b := range raw {
+		info.Have += bits.OnesCount8(b)
+	}
+	info.Have = min(info.Have, count)
+
+	n := min(count, pieceBuckets)
+	info.Buckets = make([]float64, n)
+	for b := range n {
+		start, end := b*count/n, (b+1)*count/n
+		got := 0
+		for i := start; i < end; i++ {
+			if has(i) {
+				got++
+			}
+		}
+		info.Buckets[b] = float64(got) / float64(end-start)
+	}
+	return info, nil
+}
+
+// rpcFlag decodes per-file flags, which Transmission sends as booleans or 0/1
+// depending on version
+type rpcFlag bool
+
+func (f *rpcFlag) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", "1":
+		*f = true
+	case "false", "0", "null":
+		*f = false
+	default:
+		return fmt.Errorf("invalid flag %s", data)
+	}
+	return nil
+}
+
+// GetTorrentDetail fetches a torrent with its files, tracker stats and piece
+// map in a single torrent-get
+func (c *TransmissionClient) GetTorrentDetail(id int) (*TorrentDetail, error) {
+	req := &RPCRequest{
+		Method: "torrent-get",
+		Arguments: map[string]interface{}{
+			"ids":    []int{id},
+			"fields": append(append([]string{}, torrentFields...), detailFields...),
+		},
+	}
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Torrents []json.RawMessage `json:"torrents"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &list); err != nil {
+		return nil, err
+	}
+
+	for _, raw := range list.Torrents {
+		var t Torrent
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return nil, err
+		}
+		if t.ID != id {
+			continue
+		}
+		var extra struct {
+			torrentFiles
+			TrackerStats   []TrackerStats `json:"trackerStats"`
+			Pieces         string         `json:"pieces"`
+			PieceCount     int            `json:"pieceCount"`
+			PieceSize      int64          `json:"pieceSize"`
+			Wanted         []rpcFlag      `json:"wanted"`
+			ManualAnnounce int64          `json:"manualAnnounceTime"`
+		}
+		if err := json.Unmarshal(raw, &extra); err != nil {
+			return nil, err
+		}
+
+		d := &TorrentDetail{Torrent: &t, Trackers: extra.TrackerStats, Files: []TorrentFile{}, ManualAnnounceTime: extra.ManualAnnounce}
+		if d.Trackers == nil {
+			d.Trackers = []TrackerStats{}
+		}
+		extra.ID = t.ID
+		if err := extra.each(func(f TorrentFile) error {
+			if f.Index < len(extra.Wanted) {
+				f.Wanted = bool(extra.Wanted[f.Index])
+			}
+			d.Files = append(d.Files, f)
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		if d.Pieces, err = newPieceInfo(extra.PieceCount, extra.PieceSize, extra.Pieces); err != nil {
+			return nil, err
+		}
+		return d, nil
+	}
+	return nil, &TorrentNotFoundError{ID: id}
+}
+
+// handleTorrentPage renders the detail page for /torrent/{id}
+func (s *Server) handleTorrentPage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	instance := r.URL.Query().Get("instance")
+	client, err := s.clientFor(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	d, err := client.GetTorrentDetail(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	torrents := []Torrent{*d.Torrent}
+	addDisplay(torrents, nil)
+	s.seedRules.Mark(torrents)
+	s.swarm.Mark(torrents)
+	d.Torrent = &torrents[0]
+
+	// Timelines, traffic and scheduled reannounces are only kept for the
+	// default daemon
+	var activity []ActivityEntry
+	var traffic *TrafficReport
+	if client == s.client {
+		d.ReannounceAt = s.reannounce.Scheduled(d.Torrent.HashString)
+		if activity, err = s.activity.For(d.Torrent.HashString, 100); err != nil {
+			log.Printf("Failed to load torrent activity: %v", err)
+		}
+		if traffic, err = s.traffic.Report(d.Torrent.HashString, d.Torrent, time.Now(), trafficGraphPoints); err != nil {
+			log.Printf("Failed to load torrent traffic: %v", err)
+		}
+	}
+	var graph *TrafficGraph
+	if traffic != nil {
+		graph = trafficGraph(traffic.Points)
+	}
+
+	s.render(w, "torrent.html", map[string]interface{}{
+		"Detail":   d,
+		"Activity": activity,
+		"Traffic":  traffic,
+		"Graph":    graph,
+		"Instance": instance,
+		"Version":  Version,
+	})
+}
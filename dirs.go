@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// maxDirChoices caps how many torrent directories /api/dirs offers
+const maxDirChoices = 20
+
+// DirChoice is a download directory the add form offers, with free space
+// as the daemon reports it
+type DirChoice struct {
+	Path     string `json:"path"`
+	Default  bool   `json:"default,omitempty"` // the session's download-dir
+	Torrents int    `json:"torrents"`          // torrents saving there now
+	Free     int64  `json:"free"`
+	Total    int64  `json:"total,omitempty"` // 0 before Transmission 4
+	Level    string `json:"level,omitempty"` // DiskOK, DiskWarning or DiskLow
+	// Where free space was read, when Path doesn't exist yet and the
+	// daemon will create it
+	Nearest string `json:"nearest,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// dirChoice checks free space at dir. Transmission can't list directories,
+// so this is as close to browsing the daemon's disk as RPC gets.
+func (s *Server) dirChoice(client *TransmissionClient, dir string) DirChoice {
+	c := DirChoice{Path: dir}
+	free, err := nearestFreeSpace(client, dir)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+	c.Free, c.Total, c.Level = free.SizeBytes, free.TotalSize, s.disks.level(free.SizeBytes)
+	if free.Path != "" && free.Path != dir {
+		c.Nearest = free.Path
+	}
+	return c
+}
+
+// handleDirs serves GET /api/dirs: the directories a torrent could be
+// added to, being the daemon's download and incomplete directories,
+// DISK_PATHS and the ones its torrents already use, most used first.
+// ?path= checks a typed directory instead.
+func (s *Server) handleDirs(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	if p := r.URL.Query().Get("path"); p != "" {
+		dir, err := cleanLocation(p)
+		if err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+		writeJSON(w, s.dirChoice(client, dir))
+		return
+	}
+
+	counts := map[string]int{}
+	var order []string
+	note := func(dir string) {
+		if dir == "" {
+			return
+		}
+		if _, ok := counts[dir]; !ok {
+			order = append(order, dir)
+			counts[dir] = 0
+		}
+	}
+	var defaultDir string
+	if cfg, err := client.GetSession(); err == nil {
+		if cfg.DownloadDir != nil {
+			defaultDir = *cfg.DownloadDir
+			note(defaultDir)
+		}
+		if cfg.IncompleteDir != nil && cfg.IncompleteDirEnabled != nil && *cfg.IncompleteDirEnabled {
+			note(*cfg.IncompleteDir)
+		}
+	}
+	if client == s.client {
+		for _, p := range s.disks.paths {
+			note(p)
+		}
+	}
+	fixed := len(order)
+	torrents, err := client.GetTorrents()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	for _, t := range torrents {
+		note(t.DownloadDir)
+		counts[t.DownloadDir]++
+	}
+
+	// The configured directories stay first, then the busiest others
+	rest := order[fixed:]
+	sort.SliceStable(rest, func(i, j int) bool { return counts[rest[i]] > counts[rest[j]] })
+	if len(order) > maxDirChoices {
+		order = order[:maxDirChoices]
+	}
+
+	choices := make([]DirChoice, len(order))
+	calls := make(map[string]func() error, len(order))
+	for i, dir := range order {
+		calls[dir] = func() error {
+			choices[i] = s.dirChoice(client, dir)
+			choices[i].Default, choices[i].Torrents = dir == defaultDir, counts[dir]
+			return nil
+		}
+	}
+	fanOut(calls)
+	writeJSON(w, map[string]interface{}{"dirs": choices})
+}
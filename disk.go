@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDiskPollInterval = time.Minute
+	defaultDiskWarnGB       = 10
+)
+
+// Disk levels, from the thresholds
+const (
+	DiskOK      = "ok"
+	DiskWarning = "warning" // below DISK_WARN_GB
+	DiskLow     = "low"     // below DISK_MIN_FREE_GB: adds there are refused
+)
+
+// DiskUsage is free space at a watched path, as the daemon reports it
+type DiskUsage struct {
+	Path    string    `json:"path"`
+	Free    int64     `json:"free"`
+	Total   int64     `json:"total,omitempty"` // 0 before Transmission 4
+	Level   string    `json:"level"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// DiskSpaceError is returned when adding to a path below the minimum
+type DiskSpaceError struct {
+	Path string
+	Free int64
+	Min  int64
+}
+
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("not enough space at %s: %s free, %s required to add torrents", e.Path, display.Bytes(e.Free), display.Bytes(e.Min))
+}
+
+// DiskMonitor polls free space at the DISK_PATHS on the default daemon,
+// or at its download directory when none are given, for the dashboard,
+// and refuses adds to a path with less than the minimum free.
+type DiskMonitor struct {
+	client *TransmissionClient
+	paths  []string
+	warn   int64 // bytes
+	min    int64 // bytes; 0 never refuses
+
+	mu    sync.Mutex
+	usage []DiskUsage
+}
+
+func NewDiskMonitor(client *TransmissionClient, paths []string, warn, min int64) *DiskMonitor {
+	return &DiskMonitor{client: client, paths: paths, warn: warn, min: min}
+}
+
+// Start polls on an interval
+func (dm *DiskMonitor) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDiskPollInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			usage := dm.Check(dm.client, dm.paths)
+			dm.mu.Lock()
+			dm.usage = usage
+			dm.mu.Unlock()
+			<-ticker.C
+		}
+	}()
+}
+
+// Usage returns the last poll's results, in DISK_PATHS order
+func (dm *DiskMonitor) Usage() []DiskUsage {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return slices.Clone(dm.usage)
+}
+
+// level grades free space against the thresholds
+func (dm *DiskMonitor) level(free int64) string {
+	switch {
+	case dm.min > 0 && free < dm.min:
+		return DiskLow
+	case free < dm.warn:
+		return DiskWarning
+	}
+	return DiskOK
+}
+
+// Check asks client for free space at each path; with no paths, at its
+// download directory
+func (dm *DiskMonitor) Check(client *TransmissionClient, paths []string) []DiskUsage {
+	if len(paths) == 0 {
+		cfg, err := client.GetSession()
+		if err != nil || cfg.DownloadDir == nil {
+			return []DiskUsage{{Level: DiskOK, Error: "download directory unavailable", Checked: time.Now()}}
+		}
+		paths = []string{*cfg.DownloadDir}
+	}
+	usage := make([]DiskUsage, len(paths))
+	for i, p := range paths {
+		u := DiskUsage{Path: p, Level: DiskOK, Checked: time.Now()}
+		if free, err := client.GetFreeSpace(p); err != nil {
+			u.Error = err.Error()
+		} else {
+			u.Free, u.Total, u.Level = free.SizeBytes, free.TotalSize, dm.level(free.SizeBytes)
+		}
+		usage[i] = u
+	}
+	return usage
+}
+
+// CheckAdd refuses an add to dir, or to the daemon's download directory
+// when dir is empty, if it has less than DISK_MIN_FREE_GB free. When the
+// space can't be checked the add goes ahead. A nil monitor allows all.
+func (dm *DiskMonitor) CheckAdd(dir string) error {
+	if dm == nil || dm.min <= 0 {
+		return nil
+	}
+	if dir == "" {
+		cfg, err := dm.client.GetSession()
+		if err != nil || cfg.DownloadDir == nil {
+			return nil
+		}
+		dir = *cfg.DownloadDir
+	}
+	free, err := nearestFreeSpace(dm.client, dir)
+	if err != nil {
+		log.Printf("Couldn't check free space at %s before adding: %v", dir, err)
+		return nil
+	}
+	if free.SizeBytes < dm.min {
+		return &DiskSpaceError{Path: dir, Free: free.SizeBytes, Min: dm.min}
+	}
+	return nil
+}
+
+// diskUsageFor returns the dashboard's disks for a daemon: the polled
+// paths for the default one, the download directory checked now for the
+// others
+func (s *Server) diskUsageFor(client *TransmissionClient) []DiskUsage {
+	if client == s.client {
+		return s.disks.Usage()
+	}
+	return s.disks.Check(client, nil)
+}
+
+// handleDisks serves GET /api/disks: free space at each watched path
+func (s *Server) handleDisks(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"disks":   s.diskUsageFor(client),
+		"warn":    s.disks.warn,
+		"minFree": s.disks.min,
+	})
+}
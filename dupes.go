@@ -0,0 +1,321 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Duplicate title handling modes for DUPLICATE_TITLE_MODE
+const (
+	DuplicateModeOff     = "off"
+	DuplicateModeSkip    = "skip"    // record the item as skipped
+	DuplicateModeApprove = "approve" // hold the item until a user approves it
+)
+
+// Feed history statuses for items held back as likely duplicates
+const (
+	ItemStatusSkipped = "skipped"
+	ItemStatusPending = "pending"
+)
+
+// sizeTolerance is how far apart two sizes may be and still count as the
+// same release; beyond it they're different encodes, e.g. an upgrade
+const sizeTolerance = 0.10
+
+// LikelyDuplicate is an existing torrent an RSS item seems to repeat
+type LikelyDuplicate struct {
+	Name  string `json:"name"`
+	Where string `json:"where"` // transmission, graveyard or feed history
+	Size  int64  `json:"size,omitempty"`
+}
+
+func (d *LikelyDuplicate) String() string {
+	return fmt.Sprintf("likely duplicate of %s (%s)", d.Name, d.Where)
+}
+
+// releaseKey identifies the content of a release regardless of quality,
+// group or naming style. Unparseable names give "".
+func releaseKey(name string) string {
+	r := ParseRelease(name)
+	if r.Normalized == "" {
+		return ""
+	}
+	switch {
+	case r.Season > 0 || r.Episode > 0:
+		return fmt.Sprintf("%s|s%de%d-%d", r.Normalized, r.Season, r.Episode, r.EpisodeEnd)
+	case r.AirDate != "":
+		return r.Normalized + "|" + r.AirDate
+	case r.Year > 0:
+		return fmt.Sprintf("%s|%d", r.Normalized, r.Year)
+	}
+	return r.Normalized
+}
+
+// DuplicateGuard finds RSS items that repeat something already in
+// Transmission, the graveyard or the feed history, whichever source added it
+type DuplicateGuard struct {
+	db     *sql.DB
+	poller *Poller
+
+	mu   sync.RWMutex
+	mode string
+}
+
+// NewDuplicateGuard creates a guard in the given mode
+func NewDuplicateGuard(db *sql.DB, poller *Poller, mode string) (*DuplicateGuard, error) {
+	g := &DuplicateGuard{db: db, poller: poller}
+	if err := g.SetMode(mode); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetMode switches the mode, for config reloads
+func (g *DuplicateGuard) SetMode(mode string) error {
+	switch mode {
+	case DuplicateModeOff, DuplicateModeSkip, DuplicateModeApprove:
+	default:
+		return fmt.Errorf("unknown duplicate mode %q", mode)
+	}
+	g.mu.Lock()
+	g.mode = mode
+	g.mu.Unlock()
+	return nil
+}
+
+// Mode returns the current mode
+func (g *DuplicateGuard) Mode() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.mode
+}
+
+// titleIndex maps release keys to what's already there. It's built once
+// per feed check.
+type titleIndex map[string]LikelyDuplicate
+
+func (idx titleIndex) add(name string, size int64, where string) {
+	if key := releaseKey(name); key != "" {
+		if _, ok := idx[key]; !ok {
+			idx[key] = LikelyDuplicate{Name: name, Where: where, Size: size}
+		}
+	}
+}
+
+// lookup returns what name duplicates, if anything. PROPER and REPACK
+// releases replace a broken one and are never duplicates.
+func (idx titleIndex) lookup(name string, size int64) *LikelyDuplicate {
+	if idx == nil || ParseRelease(name).Proper {
+		return nil
+	}
+	dup, ok := idx[releaseKey(name)]
+	if !ok {
+		return nil
+	}
+	if size > 0 && dup.Size > 0 {
+		diff := float64(size-dup.Size) / float64(dup.Size)
+		if diff > sizeTolerance || diff < -sizeTolerance {
+			return nil
+		}
+	}
+	return &dup
+}
+
+// Index builds the lookup for a feed check; nil when the guard is off
+func (g *DuplicateGuard) Index() titleIndex {
+	if g == nil || g.Mode() == DuplicateModeOff {
+		return nil
+	}
+	idx := make(titleIndex)
+	if snap := g.poller.Latest(); snap != nil {
+		for _, t := range snap.Torrents {
+			idx.add(t.Name, t.SizeWhenDone, "transmission")
+		}
+	}
+
+	queries := []struct {
+		where, query string
+	}{
+		{"graveyard", "SELECT name, size_when_done FROM removed_torrents ORDER BY id DESC LIMIT 5000"},
+		{"feed history", "SELECT item_title, 0 FROM downloaded_items WHERE status IN ('added', 'marked') ORDER BY id DESC LIMIT 5000"},
+	}
+	for _, q := range queries {
+		rows, err := g.db.Query(q.query)
+		if err != nil {
+			log.Printf("Duplicate check: failed to read %s: %v", q.where, err)
+			continue
+		}
+		for rows.Next() {
+			var name string
+			var size int64
+			if err := rows.Scan(&name, &size); err == nil {
+				idx.add(name, size, q.where)
+			}
+		}
+		rows.Close()
+	}
+	return idx
+}
+
+// itemSize reads an RSS item's size from its enclosure or Torznab attributes
+func itemSize(item *gofeed.Item) int64 {
+	for _, enc := range item.Enclosures {
+		if n, err := strconv.ParseInt(enc.Length, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	for _, ext := range item.Extensions["torznab"]["attr"] {
+		if ext.Attrs["name"] == "size" {
+			if n, err := strconv.ParseInt(ext.Attrs["value"], 10, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// PendingAdd is an RSS match held for approval as a likely duplicate
+type PendingAdd struct {
+	ID          int      `json:"id"`
+	FeedID      int      `json:"feedId"`
+	ItemGUID    string   `json:"itemGuid"`
+	Title       string   `json:"title"`
+	URL         string   `json:"url"`
+	Dir         string   `json:"dir,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	DuplicateOf string   `json:"duplicateOf"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+func createPendingTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS pending_adds (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		feed_id INTEGER NOT NULL,
+		item_guid TEXT NOT NULL,
+		title TEXT NOT NULL,
+		url TEXT NOT NULL,
+		dir TEXT NOT NULL DEFAULT '',
+		label TEXT NOT NULL DEFAULT '', -- comma-separated, as labels can't hold commas
+		duplicate_of TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	return err
+}
+
+// holdDuplicate records a likely duplicate according to the guard's mode
+func (fm *FeedManager) holdDuplicate(feedID int, key string, item *gofeed.Item, req AddRequest, dup *LikelyDuplicate) error {
+	if fm.dupes.Mode() == DuplicateModeSkip {
+		return fm.markItem(feedID, key, item, ItemStatusSkipped, dup.String())
+	}
+	_, err := fm.db.Exec(
+		`INSERT INTO pending_adds (feed_id, item_guid, title, url, dir, label, duplicate_of, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, datetime('now'))`,
+		feedID, key, item.Title, req.URL, req.Dir, strings.Join(req.Labels, ","), dup.String(),
+	)
+	if err != nil {
+		return err
+	}
+	return fm.markItem(feedID, key, item, ItemStatusPending, dup.String())
+}
+
+// GetPendingAdds lists the items awaiting approval
+func (fm *FeedManager) GetPendingAdds() ([]PendingAdd, error) {
+	rows, err := fm.db.Query(`SELECT id, feed_id, item_guid, title, url, dir, label, duplicate_of, created_at
+		FROM pending_adds ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := []PendingAdd{}
+	for rows.Next() {
+		var p PendingAdd
+		var labels string
+		if err := rows.Scan(&p.ID, &p.FeedID, &p.ItemGUID, &p.Title, &p.URL, &p.Dir, &labels, &p.DuplicateOf, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		if labels != "" {
+			p.Labels = strings.Split(labels, ",")
+		}
+		pending = append(pending, p)
+	}
+	return pending, rows.Err()
+}
+
+// ResolvePending adds an approved item, or records a refused one as rejected
+func (fm *FeedManager) ResolvePending(id int, approve bool) (*AddedTorrent, error) {
+	var p PendingAdd
+	var labels string
+	err := fm.db.QueryRow(`SELECT id, feed_id, item_guid, title, url, dir, label FROM pending_adds WHERE id = ?`, id).
+		Scan(&p.ID, &p.FeedID, &p.ItemGUID, &p.Title, &p.URL, &p.Dir, &labels)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("pending add %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var added *AddedTorrent
+	status, reason, hash := ItemStatusRejected, "refused as a duplicate", ""
+	if approve {
+		req := AddRequest{URL: p.URL, Source: SourceRSS, Dir: p.Dir}
+		if labels != "" {
+			req.Labels = strings.Split(labels, ",")
+		}
+		if added, err = fm.adder.Add(req); err != nil {
+			return nil, err
+		}
+		status, reason, hash = ItemStatusAdded, "", strings.ToLower(added.HashString)
+	}
+
+	if _, err := fm.db.Exec(
+		"UPDATE downloaded_items SET status = ?, reason = ?, hash = ? WHERE feed_id = ? AND item_guid = ?",
+		status, reason, hash, p.FeedID, p.ItemGUID,
+	); err != nil {
+		return added, err
+	}
+	_, err = fm.db.Exec("DELETE FROM pending_adds WHERE id = ?", id)
+	return added, err
+}
+
+func (s *Server) handleGetPending(w http.ResponseWriter, _ *http.Request) {
+	pending, err := s.feedManager.GetPendingAdds()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"pending": pending})
+}
+
+// handleResolvePending serves /api/pending/approve and /api/pending/reject
+func (s *Server) handleResolvePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, "invalid id")
+		return
+	}
+	approve := strings.HasSuffix(r.URL.Path, "/approve")
+	added, err := s.feedManager.ResolvePending(id, approve)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(addErrorStatus(err))
+		writeJSONError(w, err.Error())
+		return
+	}
+	if added != nil {
+		log.Printf("Approved likely duplicate: %s", added.Name)
+	}
+	writeJSON(w, map[string]interface{}{"status": "ok", "added": added})
+}
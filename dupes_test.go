@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestReleaseKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Show.S01E02.720p.HDTV.x264-GRP", "Show.S01E02.1080p.WEB-DL-OTHER", true},
+		{"Show.S01E02.720p.HDTV.x264-GRP", "Show.S01E03.720p.HDTV.x264-GRP", false},
+		{"Daily.Show.2024.03.14.720p.WEB.h264-GRP", "Daily.Show.2024.03.14.1080p.WEB.h264-OTHER", true},
+		{"Daily.Show.2024.03.14.720p.WEB.h264-GRP", "Daily.Show.2024.03.15.720p.WEB.h264-GRP", false},
+		{"Some.Movie.2019.1080p.BluRay.x264-GRP", "Some.Movie.2019.720p.WEB-DL-OTHER", true},
+	}
+	for _, tt := range tests {
+		ka, kb := releaseKey(tt.a), releaseKey(tt.b)
+		if ka == "" || kb == "" {
+			t.Errorf("releaseKey(%q) = %q, releaseKey(%q) = %q, want both set", tt.a, ka, tt.b, kb)
+			continue
+		}
+		if (ka == kb) != tt.same {
+			t.Errorf("releaseKey(%q) = %q, releaseKey(%q) = %q, same = %v, want %v", tt.a, ka, tt.b, kb, ka == kb, tt.same)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultEgressCheckInterval = 5 * time.Minute
+	egressIPSetting            = "egress_ip"
+)
+
+// EgressChecker watches the external address the daemon's peers see. When
+// it changes every torrent is reannounced, since peers keep trying the old
+// address until the trackers hear the new one, and the port is re-tested.
+// The address comes from polling EGRESS_CHECK_URL, or from a dynamic DNS
+// client posting to /api/webhooks/ip. Only the default instance is
+// reannounced; the others may well be behind other addresses.
+type EgressChecker struct {
+	db       *sql.DB
+	client   *TransmissionClient
+	ports    *PortChecker
+	events   *EventBus
+	url      string
+	interval time.Duration
+	portTest bool
+	token    string
+	http     *http.Client
+
+	mu     sync.Mutex
+	status EgressStatus
+}
+
+// EgressStatus is reported on /api/egress
+type EgressStatus struct {
+	IP         string     `json:"ip,omitempty"`
+	PreviousIP string     `json:"previousIp,omitempty"`
+	CheckedAt  *time.Time `json:"checkedAt,omitempty"`
+	ChangedAt  *time.Time `json:"changedAt,omitempty"`
+	PortOpen   *bool      `json:"portOpen,omitempty"` // re-tested after the last change
+	Error      string     `json:"error,omitempty"`
+	Polling    bool       `json:"polling"`
+}
+
+// NewEgressChecker creates a checker polling checkURL every interval, or
+// only fed by the webhook if checkURL is empty. The last address seen is
+// kept in the settings table so a change across a restart still counts.
+func NewEgressChecker(db *sql.DB, client *TransmissionClient, ports *PortChecker, events *EventBus, checkURL string, interval time.Duration, portTest bool, token string) (*EgressChecker, error) {
+	if interval <= 0 {
+		interval = defaultEgressCheckInterval
+	}
+	ip, err := getSetting(db, egressIPSetting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load egress address: %w", err)
+	}
+	return &EgressChecker{
+		db:       db,
+		client:   client,
+		ports:    ports,
+		events:   events,
+		url:      checkURL,
+		interval: interval,
+		portTest: portTest,
+		token:    token,
+		http:     &http.Client{Timeout: 15 * time.Second},
+		status:   EgressStatus{IP: ip, Polling: checkURL != ""},
+	}, nil
+}
+
+// Start begins polling, if there's a URL to poll
+func (ec *EgressChecker) Start() {
+	if ec.url == "" {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(ec.interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if err := ec.Check(context.Background()); err != nil {
+				log.Printf("Egress check failed: %v", err)
+			}
+		}
+	}()
+}
+
+// Check fetches the current address from the check URL, which may answer
+// with plain text or JSON with an "ip" field as ipify and most others do
+func (ec *EgressChecker) Check(ctx context.Context) error {
+	if ec.url == "" {
+		return fmt.Errorf("EGRESS_CHECK_URL is not set")
+	}
+	ip, err := ec.fetch(ctx)
+	if err != nil {
+		ec.mu.Lock()
+		ec.status.Error = err.Error()
+		ec.mu.Unlock()
+		return err
+	}
+	return ec.Observe(ip)
+}
+
+func (ec *EgressChecker) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", ec.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json, text/plain")
+	resp, err := ec.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", ec.url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	var reply struct {
+		IP string `json:"ip"`
+	}
+	if json.Unmarshal(body, &reply) == nil && reply.IP != "" {
+		return reply.IP, nil
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// Observe records the current address, and handles the change in the
+// background if it differs from the last one. The first address ever seen
+// is only a baseline.
+func (ec *EgressChecker) Observe(ip string) error {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return fmt.Errorf("invalid address %q", ip)
+	}
+	ip = parsed.String()
+
+	now := time.Now()
+	ec.mu.Lock()
+	prev := ec.status.IP
+	ec.status.IP, ec.status.CheckedAt, ec.status.Error = ip, &now, ""
+	changed := prev != "" && prev != ip
+	if changed {
+		ec.status.PreviousIP, ec.status.ChangedAt, ec.status.PortOpen = prev, &now, nil
+	}
+	ec.mu.Unlock()
+
+	if prev == ip {
+		return nil
+	}
+	if err := setSetting(ec.db, egressIPSetting, ip); err != nil {
+		log.Printf("Failed to save egress address: %v", err)
+	}
+	if changed {
+		go ec.changed(prev, ip)
+	}
+	return nil
+}
+
+// changed reannounces everything, re-tests the port and announces the
+// change on the event bus for notifications
+func (ec *EgressChecker) changed(prev, ip string) {
+	log.Printf("🌐 External address changed from %s to %s, reannouncing all torrents", prev, ip)
+	msg := prev + " → " + ip
+	if err := ec.client.ReannounceAll(); err != nil {
+		log.Printf("Failed to reannounce after address change: %v", err)
+		msg += ", reannounce failed"
+	}
+	if ec.portTest && ec.ports != nil {
+		open, err := ec.ports.check()
+		switch {
+		case err != nil:
+			log.Printf("Port test after address change failed: %v", err)
+		case open:
+			msg += ", port open"
+		default:
+			msg += ", port closed"
+		}
+		if err == nil {
+			ec.mu.Lock()
+			ec.status.PortOpen = &open
+			ec.mu.Unlock()
+		}
+	}
+	ec.events.Publish(Event{Type: EventIPChanged, Message: msg})
+}
+
+// Status describes the last address seen
+func (ec *EgressChecker) Status() EgressStatus {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	return ec.status
+}
+
+// egressAuthorized checks the webhook token passed as ?token= or as the
+// basic auth password, as the *arr webhook does. Without
+// EGRESS_WEBHOOK_TOKEN the webhook needs the usual login instead.
+func (s *Server) egressAuthorized(r *http.Request) bool {
+	ec := s.egress
+	if ec.token == "" {
+		return s.auth == nil || s.auth.authenticated(r)
+	}
+	given := r.URL.Query().Get("token")
+	if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(ec.token)) == 1
+}
+
+func (s *Server) handleEgress(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.egress.Status())
+}
+
+// handleEgressCheck polls the check URL now
+func (s *Server) handleEgressCheck(w http.ResponseWriter, r *http.Request) {
+	if err := s.egress.Check(r.Context()); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, s.egress.Status())
+}
+
+// handleEgressWebhook takes the new address from a dynamic DNS client, as
+// JSON {"ip": ...} or an ip form or query parameter. Without one it polls
+// the check URL instead, for clients that can only say something changed.
+func (s *Server) handleEgressWebhook(w http.ResponseWriter, r *http.Request) {
+	if !s.egressAuthorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req struct {
+		IP string `json:"ip"`
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "invalid request")
+			return
+		}
+	} else {
+		req.IP = r.FormValue("ip")
+	}
+
+	var err error
+	if req.IP != "" {
+		err = s.egress.Observe(req.IP)
+	} else {
+		err = s.egress.Check(r.Context())
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
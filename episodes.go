@@ -0,0 +1,161 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// CheckOutcomeSameEpisode is an item for an episode a feed with episode
+// tracking already added, from another release group or source
+const CheckOutcomeSameEpisode = "same-episode"
+
+// TrackedEpisode is an episode (or dated release, for movies) some feed
+// with EpisodeDedup has added, and the release it was added as
+type TrackedEpisode struct {
+	Key        string    `json:"key"`
+	Title      string    `json:"title"` // the release that was added
+	FeedID     int       `json:"feedId"`
+	Resolution string    `json:"resolution,omitempty"`
+	Version    int       `json:"version,omitempty"`
+	Proper     bool      `json:"proper,omitempty"`
+	AddedAt    time.Time `json:"addedAt"`
+}
+
+func (e *TrackedEpisode) String() string {
+	return "episode already added as " + e.Title
+}
+
+func createEpisodesTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS feed_episodes (
+		key TEXT PRIMARY KEY,
+		title TEXT NOT NULL,
+		feed_id INTEGER NOT NULL,
+		resolution TEXT NOT NULL DEFAULT '',
+		version INTEGER NOT NULL DEFAULT 0,
+		proper INTEGER NOT NULL DEFAULT 0,
+		added_at DATETIME NOT NULL,
+		FOREIGN KEY (feed_id) REFERENCES feeds(id) ON DELETE CASCADE
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create feed_episodes table: %w", err)
+	}
+	return nil
+}
+
+// episodeKey is the show and episode of a release name, ignoring group,
+// quality and naming style; daily shows are keyed by their full air date.
+// It's "" when the name isn't an episode or dated release, so those are
+// never tracked: a year alone would make every episode of one year the same.
+func episodeKey(r *Release) string {
+	if r.Normalized == "" || r.Season == 0 && r.Episode == 0 && r.AirDate == "" {
+		return ""
+	}
+	if r.Season == 0 && r.Episode == 0 {
+		return r.Normalized + "|" + r.AirDate
+	}
+	return fmt.Sprintf("%s|s%de%d-%d", r.Normalized, r.Season, r.Episode, r.EpisodeEnd)
+}
+
+// replacedBy reports whether r is a fix of the release that was added: a
+// PROPER or REPACK of one that wasn't, or a higher version (v2)
+func (e *TrackedEpisode) replacedBy(r *Release) bool {
+	return r.Proper && !e.Proper || r.Version > e.Version
+}
+
+// trackedEpisode returns the episode title repeats, if it's been added and
+// title doesn't replace it
+func (fm *FeedManager) trackedEpisode(title string) *TrackedEpisode {
+	r := ParseRelease(title)
+	key := episodeKey(&r)
+	if key == "" {
+		return nil
+	}
+	have := &TrackedEpisode{Key: key}
+	err := fm.db.QueryRow("SELECT title, feed_id, resolution, version, proper, added_at FROM feed_episodes WHERE key = ?", key).
+		Scan(&have.Title, &have.FeedID, &have.Resolution, &have.Version, &have.Proper, &have.AddedAt)
+	if err != nil || have.replacedBy(&r) {
+		return nil
+	}
+	return have
+}
+
+// trackEpisode records the episode a feed just added
+func (fm *FeedManager) trackEpisode(feedID int, title string) error {
+	r := ParseRelease(title)
+	key := episodeKey(&r)
+	if key == "" {
+		return nil
+	}
+	_, err := fm.db.Exec(`INSERT OR REPLACE INTO feed_episodes (key, title, feed_id, resolution, version, proper, added_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, key, title, feedID, r.Resolution, r.Version, r.Proper, time.Now())
+	return err
+}
+
+// GetEpisodes lists tracked episodes, newest first; feedID 0 lists all
+func (fm *FeedManager) GetEpisodes(feedID, limit int) ([]TrackedEpisode, error) {
+	query := "SELECT key, title, feed_id, resolution, version, proper, added_at FROM feed_episodes"
+	args := []interface{}{}
+	if feedID != 0 {
+		query += " WHERE feed_id = ?"
+		args = append(args, feedID)
+	}
+	query += " ORDER BY added_at DESC LIMIT ?"
+	args = append(args, limit)
+	rows, err := fm.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	episodes := []TrackedEpisode{}
+	for rows.Next() {
+		var e TrackedEpisode
+		if err := rows.Scan(&e.Key, &e.Title, &e.FeedID, &e.Resolution, &e.Version, &e.Proper, &e.AddedAt); err != nil {
+			return nil, err
+		}
+		episodes = append(episodes, e)
+	}
+	return episodes, rows.Err()
+}
+
+// ForgetEpisode stops tracking an episode, so the next release of it is
+// added again
+func (fm *FeedManager) ForgetEpisode(key string) error {
+	res, err := fm.db.Exec("DELETE FROM feed_episodes WHERE key = ?", key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("episode not tracked")
+	}
+	return nil
+}
+
+// handleGetEpisodes serves GET /api/feeds/episodes, optionally for one
+// ?feed=
+func (s *Server) handleGetEpisodes(w http.ResponseWriter, r *http.Request) {
+	feedID, _ := strconv.Atoi(r.URL.Query().Get("feed"))
+	episodes, err := s.feedManager.GetEpisodes(feedID, 500)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, episodes)
+}
+
+// handleForgetEpisode serves POST /api/feeds/episodes/forget?key=
+func (s *Server) handleForgetEpisode(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSONError(w, "missing key parameter")
+		return
+	}
+	if err := s.feedManager.ForgetEpisode(key); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventTorrentAdded     = "torrent.added"
+	EventTorrentRemoved   = "torrent.removed"
+	EventTorrentCompleted = "torrent.completed"
+	EventTorrentErrored   = "torrent.errored"
+	EventTorrentLate      = "torrent.late"
+	EventTorrentSeeded    = "torrent.seeded"
+	EventDiskLow          = "disk.low"
+	EventIPChanged        = "ip.changed"
+)
+
+// Event describes something that happened to a torrent or subsystem
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Hash    string    `json:"hash,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// EventBus fans events out to subscribers and keeps a short history for the API
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []func(Event)
+	recent      []Event
+	maxRecent   int
+}
+
+// NewEventBus creates an event bus remembering the last maxRecent events
+func NewEventBus(maxRecent int) *EventBus {
+	return &EventBus{maxRecent: maxRecent}
+}
+
+// Subscribe registers fn to receive every published event. Subscribers are
+// called synchronously and must hand off slow work to their own goroutine.
+func (b *EventBus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, fn)
+	b.mu.Unlock()
+}
+
+// Publish delivers e to all subscribers. A nil bus discards events.
+func (b *EventBus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	b.recent = append(b.recent, e)
+	if len(b.recent) > b.maxRecent {
+		b.recent = b.recent[len(b.recent)-b.maxRecent:]
+	}
+	subscribers := make([]func(Event), len(b.subscribers))
+	copy(subscribers, b.subscribers)
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// Recent returns up to n of the most recent events, newest first
+func (b *EventBus) Recent(n int) []Event {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if n <= 0 || n > len(b.recent) {
+		n = len(b.recent)
+	}
+	events := make([]Event, 0, n)
+	for i := len(b.recent) - 1; i >= len(b.recent)-n; i-- {
+		events = append(events, b.recent[i])
+	}
+	return events
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	writeJSON(w, map[string]interface{}{
+		"events": s.events.Recent(limit),
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// fanOutLimit bounds how many RPC calls one request has in flight; more
+// than the connection pool would just queue
+const fanOutLimit = defaultRPCConns
+
+// fanOut runs independent calls concurrently and returns the errors of the
+// ones that failed, by name. A failure doesn't cancel the others, so
+// callers can still serve whatever succeeded.
+func fanOut(calls map[string]func() error) map[string]error {
+	var g errgroup.Group
+	g.SetLimit(fanOutLimit)
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	for name, call := range calls {
+		g.Go(func() error {
+			if err := call(); err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // calls never fail the group
+	return errs
+}
+
+// errorStrings formats fanOut errors for a JSON "errors" field
+func errorStrings(errs map[string]error) map[string]string {
+	if len(errs) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(errs))
+	for name, err := range errs {
+		out[name] = err.Error()
+	}
+	return out
+}
+
+// TorrentDetail is everything the detail view shows about one torrent
+type TorrentDetail struct {
+	Torrent  *Torrent          `json:"torrent"`
+	Peers    []Peer            `json:"peers"`
+	Trackers []TrackerStats    `json:"trackers"`
+	Files    []TorrentFile     `json:"files"`
+	Pieces   *PieceInfo        `json:"pieces"`
+	Tuning   *TorrentTuning    `json:"tuning"`
+	Errors   map[string]string `json:"errors,omitempty"` // parts that couldn't be loaded
+	// When the daemon next accepts a manual reannounce, as unix seconds:
+	// in the past means now, -1 a stopped torrent
+	ManualAnnounceTime int64 `json:"manualAnnounceTime"`
+	// Set while a reannounce-when-allowed waits for that time
+	ReannounceAt *time.Time `json:"reannounceAt,omitempty"`
+}
+
+// handleTorrentDetail fetches a torrent with its trackers, files and
+// pieces, and its peers and tuning, in parallel. Parts that fail are
+// reported in "errors" and the rest are still returned; only a failure to
+// load the torrent itself is fatal.
+func (s *Server) handleTorrentDetail(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+
+	var (
+		d      *TorrentDetail
+		peers  []Peer
+		tuning *TorrentTuning
+	)
+	parts := map[string]func() error{
+		"torrent": func() (err error) {
+			d, err = client.GetTorrentDetail(id)
+			return err
+		},
+		"peers": func() (err error) {
+			peers, err = client.GetPeers(id)
+			return err
+		},
+		"tuning": func() (err error) {
+			tuning, err = client.GetTorrentTuning(id)
+			return err
+		},
+	}
+	if !features.Enabled(FeaturePeers) {
+		delete(parts, "peers")
+	}
+	errs := fanOut(parts)
+	if err := errs["torrent"]; err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	d.Peers, d.Tuning = peers, tuning
+	if client == s.client {
+		d.ReannounceAt = s.reannounce.Scheduled(d.Torrent.HashString)
+	}
+	torrents := []Torrent{*d.Torrent}
+	addDisplay(torrents, nil)
+	d.Torrent = &torrents[0]
+	d.Errors = errorStrings(errs)
+	writeJSON(w, d)
+}
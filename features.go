@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Features that DISABLE_FEATURES can switch off
+const (
+	FeatureRSS        = "rss"
+	FeatureRemoveData = "remove-data"
+	FeatureSettings   = "settings"
+	FeaturePeers      = "peers"
+)
+
+var featureNames = []string{FeatureRSS, FeatureRemoveData, FeatureSettings, FeaturePeers}
+
+// featureTitles name features in error messages
+var featureTitles = map[string]string{
+	FeatureRSS:        "RSS",
+	FeatureRemoveData: "Deleting torrent data",
+	FeatureSettings:   "Settings editing",
+	FeaturePeers:      "The peer view",
+}
+
+// features is set in main and read by handlers and templates; the zero
+// value has everything enabled
+var features FeatureFlags
+
+// FeatureFlags records the features a locked-down deployment turned off
+type FeatureFlags struct {
+	disabled map[string]bool
+}
+
+// parseFeatureFlags takes the DISABLE_FEATURES list
+func parseFeatureFlags(disabled []string) (FeatureFlags, error) {
+	f := FeatureFlags{disabled: make(map[string]bool)}
+	for _, name := range disabled {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(featureNames, name) {
+			return f, fmt.Errorf("unknown feature %q, expected one of %s", name, strings.Join(featureNames, ", "))
+		}
+		f.disabled[name] = true
+	}
+	return f, nil
+}
+
+// Enabled reports whether a feature is on
+func (f FeatureFlags) Enabled(name string) bool {
+	return !f.disabled[name]
+}
+
+// Disabled lists the features turned off, for the startup log
+func (f FeatureFlags) Disabled() []string {
+	var names []string
+	for _, name := range featureNames {
+		if f.disabled[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// featureError is returned by operations a disabled feature covers
+func featureError(name string) error {
+	return fmt.Errorf("%s is disabled on this server", featureTitles[name])
+}
+
+// writeFeatureDisabled refuses a request: 403 with a JSON error for the
+// API, 404 for pages
+func writeFeatureDisabled(w http.ResponseWriter, r *http.Request, name string) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	writeJSONError(w, featureError(name).Error())
+}
+
+// requireFeature serves h only while the feature is enabled
+func requireFeature(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !features.Enabled(name) {
+			writeFeatureDisabled(w, r, name)
+			return
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// ItemStatusMarked is a history item marked as downloaded by hand, without
+// being added; it counts as already downloaded for checks and duplicate
+// detection alike
+const ItemStatusMarked = "marked"
+
+// seenReason explains why a check skipped an item already in the history
+func seenReason(status string) string {
+	if status == ItemStatusMarked {
+		return "marked as downloaded"
+	}
+	return "already " + status + " by an earlier check"
+}
+
+// HistoryItem names an item to mark as downloaded. Only the title is
+// needed; the GUID and link default the way the feed's ItemKey picks them.
+type HistoryItem struct {
+	GUID  string `json:"guid"`
+	Title string `json:"title"`
+	Link  string `json:"link"`
+}
+
+// MarkItems records items in a feed's history as downloaded without adding
+// them, so checks skip them and DUPLICATE_TITLE_MODE and episode tracking
+// know them. Items already in the history are left alone; it returns how
+// many were new.
+func (fm *FeedManager) MarkItems(feed *Feed, items []HistoryItem) (int, error) {
+	marked := 0
+	for _, hi := range items {
+		item := &gofeed.Item{GUID: strings.TrimSpace(hi.GUID), Title: strings.TrimSpace(hi.Title), Link: strings.TrimSpace(hi.Link)}
+		if item.Title == "" {
+			return marked, errors.New("every item needs a title")
+		}
+		key := feed.itemKey(item)
+		res, err := fm.db.Exec(
+			`INSERT OR IGNORE INTO downloaded_items (feed_id, item_guid, item_title, item_link, downloaded_at, status, reason)
+			 VALUES (?, ?, ?, ?, datetime('now'), ?, '')`,
+			feed.ID, key, item.Title, item.Link, ItemStatusMarked,
+		)
+		if err != nil {
+			return marked, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		marked++
+		if feed.EpisodeDedup && fm.trackedEpisode(item.Title) == nil {
+			if err := fm.trackEpisode(feed.ID, item.Title); err != nil {
+				return marked, err
+			}
+		}
+	}
+	return marked, nil
+}
+
+// Backfill fetches the feed and marks what's in it now as downloaded: the
+// items its patterns and release filter match, or every item with all.
+// It's for moving from another downloader without re-adding its backlog.
+func (fm *FeedManager) Backfill(ctx context.Context, feed *Feed, all bool) (found, marked int, err error) {
+	patterns, err := feed.compilePatterns()
+	if err != nil {
+		return 0, 0, err
+	}
+	parsed, err := fm.parser.ParseURLWithContext(feed.URL, ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	var items []HistoryItem
+	for _, item := range parsed.Items {
+		if !all {
+			if _, matched := match(patterns, item.Title); !matched {
+				continue
+			}
+			if feed.Filter != nil {
+				release := ParseRelease(item.Title)
+				if !feed.Filter.Matches(&release) {
+					continue
+				}
+			}
+		}
+		items = append(items, HistoryItem{GUID: item.GUID, Title: item.Title, Link: fm.findTorrentLink(item)})
+	}
+	marked, err = fm.MarkItems(feed, items)
+	return len(items), marked, err
+}
+
+// DeleteHistoryItem removes an item from a feed's history, so the next
+// check considers it again
+func (fm *FeedManager) DeleteHistoryItem(feedID, itemID int) error {
+	res, err := fm.db.Exec("DELETE FROM downloaded_items WHERE id = ? AND feed_id = ?", itemID, feedID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("history item %d not found", itemID)
+	}
+	return nil
+}
+
+// historyFeed loads the feed named by the {id} path value, writing the
+// error response itself
+func (s *Server) historyFeed(w http.ResponseWriter, r *http.Request) (*Feed, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return nil, false
+	}
+	feed, err := s.feedManager.GetFeed(id)
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("feed %d not found", id))
+		return nil, false
+	}
+	return feed, true
+}
+
+// handleGetFeedHistory serves GET /api/feeds/{id}/history, newest first;
+// ?limit= defaults to 100
+func (s *Server) handleGetFeedHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	items, err := s.feedManager.GetDownloadedItems(id, min(limit, 5000))
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if items == nil {
+		items = []DownloadedItem{}
+	}
+	writeJSON(w, map[string]interface{}{"items": items})
+}
+
+// handleMarkFeedHistory serves POST /api/feeds/{id}/history, marking the
+// listed items as downloaded without adding them
+func (s *Server) handleMarkFeedHistory(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.historyFeed(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Items []HistoryItem `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	marked, err := s.feedManager.MarkItems(feed, req.Items)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]int{"marked": marked})
+}
+
+// handleBackfillFeed serves POST /api/feeds/{id}/backfill; ?all=true marks
+// every item rather than the matching ones
+func (s *Server) handleBackfillFeed(w http.ResponseWriter, r *http.Request) {
+	feed, ok := s.historyFeed(w, r)
+	if !ok {
+		return
+	}
+	all, _ := parseBoolParam(r.URL.Query().Get("all"))
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	found, marked, err := s.feedManager.Backfill(ctx, feed, all)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]int{"found": found, "marked": marked})
+}
+
+// handleDeleteFeedHistory serves POST /api/feeds/{id}/history/delete?item=
+func (s *Server) handleDeleteFeedHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	itemID, err := strconv.Atoi(r.URL.Query().Get("item"))
+	if err != nil {
+		writeJSONError(w, "invalid item")
+		return
+	}
+	if err := s.feedManager.DeleteHistoryItem(id, itemID); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// Outcomes recorded for each item seen by a feed check
+const (
+	CheckOutcomeAdded     = "added"
+	CheckOutcomeNoMatch   = "no-match"  // no pattern matched the title
+	CheckOutcomeFiltered  = "filtered"  // the release filter refused it
+	CheckOutcomeSeen      = "seen"      // in the feed history from an earlier check
+	CheckOutcomeNoLink    = "no-link"   // no magnet or .torrent link found
+	CheckOutcomeRejected  = "rejected"  // refused by an exclusion pattern
+	CheckOutcomeDuplicate = "duplicate" // another source added it first
+	// CheckOutcomeLikelyDuplicate repeats a title already in Transmission or
+	// the history; it's skipped or held for approval
+	CheckOutcomeLikelyDuplicate = "likely-duplicate"
+	CheckOutcomeFailed          = "failed" // Transmission returned an error
+)
+
+// checkRunsKept is how many checks per feed keep their item diagnostics
+const checkRunsKept = 50
+
+// FeedCheckItem is what a feed check decided about one item, and why
+type FeedCheckItem struct {
+	CheckID int    `json:"checkId"`
+	Title   string `json:"title"`
+	GUID    string `json:"guid"`
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+	Pattern string `json:"pattern,omitempty"` // the pattern that matched
+}
+
+// checkRun collects the outcomes of one CheckFeed call
+type checkRun struct {
+	items []FeedCheckItem
+}
+
+func (r *checkRun) record(item *gofeed.Item, key, outcome, reason, pattern string) {
+	r.items = append(r.items, FeedCheckItem{
+		Title:   item.Title,
+		GUID:    key,
+		Outcome: outcome,
+		Reason:  reason,
+		Pattern: pattern,
+	})
+}
+
+func (r *checkRun) count(outcomes ...string) int {
+	n := 0
+	for _, it := range r.items {
+		for _, o := range outcomes {
+			if it.Outcome == o {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func createCheckItemsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS feed_check_items (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		check_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		guid TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		pattern TEXT NOT NULL DEFAULT ''
+	)`)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_check_items_check ON feed_check_items(check_id)`); err != nil {
+		return err
+	}
+	return addColumn(db, "feed_check_log", "error", "TEXT NOT NULL DEFAULT ''")
+}
+
+// saveCheck stores a check and its item outcomes, and drops the oldest
+// checks beyond checkRunsKept
+func (fm *FeedManager) saveCheck(feedID int, run *checkRun, sampleTitles []string, checkErr string) {
+	tx, err := fm.db.Begin()
+	if err != nil {
+		log.Printf("Failed to save check log: %v", err)
+		return
+	}
+	defer tx.Rollback() //nolint:errcheck // no-op after Commit
+
+	if sampleTitles == nil {
+		sampleTitles = []string{}
+	}
+	sampleJSON, _ := json.Marshal(sampleTitles)
+	result, err := tx.Exec(
+		`INSERT INTO feed_check_log (feed_id, checked_at, items_found, items_matched, items_downloaded, sample_titles, error)
+		 VALUES (?, datetime('now'), ?, ?, ?, ?, ?)`,
+		feedID, len(run.items), len(run.items)-run.count(CheckOutcomeNoMatch, CheckOutcomeFiltered),
+		run.count(CheckOutcomeAdded), string(sampleJSON), checkErr,
+	)
+	if err != nil {
+		log.Printf("Failed to save check log: %v", err)
+		return
+	}
+	checkID, _ := result.LastInsertId()
+
+	for _, it := range run.items {
+		_, err := tx.Exec(
+			`INSERT INTO feed_check_items (check_id, title, guid, outcome, reason, pattern)
+			 VALUES (?, ?, ?, ?, ?, ?)`,
+			checkID, it.Title, it.GUID, it.Outcome, it.Reason, it.Pattern,
+		)
+		if err != nil {
+			log.Printf("Failed to save check items: %v", err)
+			return
+		}
+	}
+
+	_, err = tx.Exec(
+		`DELETE FROM feed_check_log WHERE feed_id = ? AND id NOT IN (
+			SELECT id FROM feed_check_log WHERE feed_id = ? ORDER BY id DESC LIMIT ?
+		)`, feedID, feedID, checkRunsKept,
+	)
+	if err == nil {
+		_, err = tx.Exec(`DELETE FROM feed_check_items WHERE check_id NOT IN (SELECT id FROM feed_check_log)`)
+	}
+	if err != nil {
+		log.Printf("Failed to prune check log: %v", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Failed to save check log: %v", err)
+	}
+}
+
+// GetCheckItems returns the item outcomes of one check
+func (fm *FeedManager) GetCheckItems(checkID int) ([]FeedCheckItem, error) {
+	return fm.queryCheckItems(`WHERE check_id = ? ORDER BY id`, checkID)
+}
+
+// FindCheckItems searches a feed's recent checks for items whose title
+// contains query, newest first, to answer "why wasn't this grabbed?"
+func (fm *FeedManager) FindCheckItems(feedID int, query string, limit int) ([]FeedCheckItem, error) {
+	return fm.queryCheckItems(
+		`WHERE check_id IN (SELECT id FROM feed_check_log WHERE feed_id = ?)
+		   AND title LIKE ? ESCAPE '\'
+		 ORDER BY id DESC LIMIT ?`,
+		feedID, "%"+escapeLike(query)+"%", limit,
+	)
+}
+
+func (fm *FeedManager) queryCheckItems(where string, args ...interface{}) ([]FeedCheckItem, error) {
+	rows, err := fm.db.Query(
+		"SELECT check_id, title, guid, outcome, reason, pattern FROM feed_check_items "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []FeedCheckItem{}
+	for rows.Next() {
+		var it FeedCheckItem
+		if err := rows.Scan(&it.CheckID, &it.Title, &it.GUID, &it.Outcome, &it.Reason, &it.Pattern); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// handleFeedCheckItems serves ?check=ID for one check's items, or
+// ?id=FEED&q=TITLE to search a feed's recent checks
+func (s *Server) handleFeedCheckItems(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var items []FeedCheckItem
+	var err error
+	if checkID, convErr := strconv.Atoi(q.Get("check")); convErr == nil {
+		items, err = s.feedManager.GetCheckItems(checkID)
+	} else if feedID, convErr := strconv.Atoi(q.Get("id")); convErr == nil && q.Get("q") != "" {
+		items, err = s.feedManager.FindCheckItems(feedID, q.Get("q"), 100)
+	} else {
+		writeJSONError(w, "check, or id and q, parameters required")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"items": items})
+}
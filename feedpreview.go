@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// PreviewOutcomeWouldAdd is a preview's stand-in for CheckOutcomeAdded: the
+// item would be sent to Transmission. The other outcomes are the check's.
+const PreviewOutcomeWouldAdd = "would-add"
+
+// FeedPreviewItem is what a check of the candidate feed would do with one
+// item, and the link, directory and label it would be added with
+type FeedPreviewItem struct {
+	Title   string   `json:"title"`
+	GUID    string   `json:"guid"`
+	Outcome string   `json:"outcome"`
+	Reason  string   `json:"reason,omitempty"`
+	Pattern string   `json:"pattern,omitempty"` // the pattern that matched
+	Link    string   `json:"link,omitempty"`
+	Dir     string   `json:"dir,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+}
+
+// FeedPreview is the result of POST /api/feeds/preview
+type FeedPreview struct {
+	Title    string            `json:"title"`
+	Items    []FeedPreviewItem `json:"items"`
+	Found    int               `json:"found"`
+	Matched  int               `json:"matched"`
+	WouldAdd int               `json:"wouldAdd"`
+}
+
+// Preview fetches feed.URL once and runs its items through the same steps
+// as CheckFeed, stopping short of adding anything or writing the history.
+// Items are only reported as already seen when feed.ID is an existing feed.
+func (fm *FeedManager) Preview(ctx context.Context, feed *Feed) (*FeedPreview, error) {
+	if feed.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+	if err := validItemKey(feed.ItemKey); err != nil {
+		return nil, err
+	}
+	patterns, err := feed.compilePatterns()
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := fm.parser.ParseURLWithContext(feed.URL, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+
+	preview := &FeedPreview{Title: parsed.Title, Items: []FeedPreviewItem{}, Found: len(parsed.Items)}
+	dupIndex := fm.dupes.Index()
+	episodes := map[string]*TrackedEpisode{} // what this preview would add
+	for _, item := range parsed.Items {
+		entry := FeedPreviewItem{Title: item.Title, GUID: feed.itemKey(item)}
+		entry.Outcome = fm.previewItem(feed, patterns, dupIndex, episodes, item, &entry)
+		if entry.Outcome != CheckOutcomeNoMatch && entry.Outcome != CheckOutcomeFiltered {
+			preview.Matched++
+		}
+		if entry.Outcome == PreviewOutcomeWouldAdd {
+			preview.WouldAdd++
+		}
+		preview.Items = append(preview.Items, entry)
+	}
+	return preview, nil
+}
+
+// previewItem decides what a check would do with item, filling in the
+// pattern, reason and add options of entry along the way. episodes holds
+// the episodes earlier items of the preview would have added.
+func (fm *FeedManager) previewItem(feed *Feed, patterns []compiledPattern, dupIndex titleIndex, episodes map[string]*TrackedEpisode, item *gofeed.Item, entry *FeedPreviewItem) string {
+	pattern, matched := match(patterns, item.Title)
+	if !matched {
+		return CheckOutcomeNoMatch
+	}
+	entry.Pattern = pattern.Pattern
+	entry.Dir, entry.Labels = feed.addOptions(pattern)
+	if feed.Filter != nil {
+		release := ParseRelease(item.Title)
+		if entry.Reason = feed.Filter.Mismatch(&release); entry.Reason != "" {
+			return CheckOutcomeFiltered
+		}
+	}
+	if feed.ID != 0 {
+		if status := fm.itemStatus(feed.ID, entry.GUID); status != "" {
+			entry.Reason = seenReason(status)
+			return CheckOutcomeSeen
+		}
+	}
+	if entry.Link = fm.findTorrentLink(item); entry.Link == "" {
+		return CheckOutcomeNoLink
+	}
+	if likely := dupIndex.lookup(item.Title, itemSize(item)); likely != nil {
+		entry.Reason = likely.String()
+		return CheckOutcomeLikelyDuplicate
+	}
+	release := ParseRelease(item.Title)
+	key := episodeKey(&release)
+	if feed.EpisodeDedup && key != "" {
+		have, ok := episodes[key]
+		if !ok {
+			have = fm.trackedEpisode(item.Title)
+		} else if have.replacedBy(&release) {
+			have = nil
+		}
+		if have != nil {
+			entry.Reason = have.String()
+			return CheckOutcomeSameEpisode
+		}
+	}
+	if err := fm.adder.excluded(entry.Link); err != nil {
+		entry.Reason = err.Error()
+		return CheckOutcomeRejected
+	}
+	if feed.EpisodeDedup && key != "" {
+		episodes[key] = &TrackedEpisode{Key: key, Title: item.Title, Version: release.Version, Proper: release.Proper}
+	}
+	return PreviewOutcomeWouldAdd
+}
+
+func (s *Server) handlePreviewFeed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var feed Feed
+	if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	preview, err := s.feedManager.Preview(ctx, &feed)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, preview)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// filePriorityFields maps file priority names to their torrent-set fields
+var filePriorityFields = map[string]string{
+	"high":   "priority-high",
+	"normal": "priority-normal",
+	"low":    "priority-low",
+}
+
+// SetTorrentFiles changes which files of a torrent are downloaded and their
+// priorities. Files are given by their index in the torrent's file list;
+// priorities is keyed by "high", "normal" or "low".
+func (c *TransmissionClient) SetTorrentFiles(id int, wanted []int, unwanted []int, priorities map[string][]int) error {
+	args := make(map[string]interface{})
+	if len(wanted) > 0 {
+		args["files-wanted"] = wanted
+	}
+	if len(unwanted) > 0 {
+		args["files-unwanted"] = unwanted
+	}
+	for name, indices := range priorities {
+		field, ok := filePriorityFields[name]
+		if !ok {
+			return fmt.Errorf("unknown file priority %q", name)
+		}
+		if len(indices) > 0 {
+			args[field] = indices
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.setTorrent(id, args)
+}
+
+// fileSelection is the body of POST /api/torrent/{id}/files
+type fileSelection struct {
+	Wanted     []int            `json:"wanted"`
+	Unwanted   []int            `json:"unwanted"`
+	Priorities map[string][]int `json:"priorities"`
+}
+
+// validate rejects negative indices, unknown priorities, and files that are
+// both wanted and unwanted or given two priorities
+func (f *fileSelection) validate() error {
+	wanted := make(map[int]bool)
+	for _, i := range f.Wanted {
+		if i < 0 {
+			return errors.New("file indices must not be negative")
+		}
+		wanted[i] = true
+	}
+	for _, i := range f.Unwanted {
+		if i < 0 {
+			return errors.New("file indices must not be negative")
+		}
+		if wanted[i] {
+			return fmt.Errorf("file %d is both wanted and unwanted", i)
+		}
+	}
+	prioritized := make(map[int]string)
+	for name, indices := range f.Priorities {
+		if _, ok := filePriorityFields[name]; !ok {
+			return fmt.Errorf("priority must be high, normal or low, not %q", name)
+		}
+		for _, i := range indices {
+			if i < 0 {
+				return errors.New("file indices must not be negative")
+			}
+			if other, ok := prioritized[i]; ok && other != name {
+				return fmt.Errorf("file %d has two priorities", i)
+			}
+			prioritized[i] = name
+		}
+	}
+	if len(f.Wanted)+len(f.Unwanted)+len(prioritized) == 0 {
+		return errors.New("no file changes given")
+	}
+	return nil
+}
+
+func (s *Server) handleSetFiles(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var sel fileSelection
+	if err := json.NewDecoder(r.Body).Decode(&sel); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := sel.validate(); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	if err := client.SetTorrentFiles(id, sel.Wanted, sel.Unwanted, sel.Priorities); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// filterTorrents applies the list filters from the query string:
+//
+//	private=true|false  only private or only public torrents
+func filterTorrents(torrents []Torrent, q url.Values) []Torrent {
+	private, hasPrivate := parseBoolParam(q.Get("private"))
+
+	if !hasPrivate {
+		return torrents
+	}
+
+	filtered := make([]Torrent, 0, len(torrents))
+	for _, t := range torrents {
+		if hasPrivate && t.IsPrivate != private {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered
+}
+
+// parseBoolParam parses an optional boolean query parameter
+func parseBoolParam(v string) (value, ok bool) {
+	if v == "" {
+		return false, false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, false
+	}
+	return b, true
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 500
+)
+
+// torrentStatusNames orders the status filters for the list form
+var torrentStatusNames = []string{"downloading", "seeding", "active", "complete", "stopped", "queued", "checking", "error", "late", "owed", "slow", "dead"}
+
+// listSortKeys orders the sort keys for the list form
+var listSortKeys = []string{"name", "added", "size", "progress", "ratio", "rateDownload", "rateUpload", "eta", "peers", "status", "queue"}
+
+// Status filter names for status=, matched against the torrent's state
+var torrentStatusFilters = map[string]func(t *Torrent) bool{
+	"downloading": func(t *Torrent) bool { return t.Status == 4 },
+	"seeding":     func(t *Torrent) bool { return t.Status == 6 },
+	"stopped":     func(t *Torrent) bool { return t.Status == 0 },
+	"checking":    func(t *Torrent) bool { return t.Status == 1 || t.Status == 2 },
+	"queued":      func(t *Torrent) bool { return t.Queued() },
+	"error":       func(t *Torrent) bool { return t.Error != 0 },
+	"late":        func(t *Torrent) bool { return t.Late != nil },
+	"owed":        func(t *Torrent) bool { return t.Obligation != nil && !t.Obligation.Met },
+	"slow":        func(t *Torrent) bool { return t.Swarm != nil && t.Swarm.Class == SwarmSlow },
+	"dead":        func(t *Torrent) bool { return t.Swarm != nil && t.Swarm.Class == SwarmDead },
+	"active":      func(t *Torrent) bool { return t.RateDownload > 0 || t.RateUpload > 0 },
+	"complete":    func(t *Torrent) bool { return t.PercentDone >= 1 },
+}
+
+// TorrentQuery is the list view's server-side sorting, filtering and
+// paging, from the query string:
+//
+//	sort=KEY                  any of the torrentSortKeys, ties broken
+//	                          by ID; the daemon's order when unset
+//	order=asc|desc            (default asc)
+//	status=downloading,error  any of the torrentStatusFilters
+//	tracker=host              primary tracker host
+//	label=name                torrents carrying the label
+//	search=text               case-insensitive name substring
+//	page=N&per_page=N         1-based; unpaged unless either is given
+type TorrentQuery struct {
+	Sort    string
+	Desc    bool
+	Status  []string
+	Tracker string
+	Label   string
+	Search  string
+	Page    int
+	PerPage int // 0 returns every match
+}
+
+// parseTorrentQuery reads a TorrentQuery, ignoring unknown sort keys and
+// statuses. perPage is the page size used when only page= is given.
+func parseTorrentQuery(q url.Values, perPage int) TorrentQuery {
+	tq := TorrentQuery{
+		Desc:    q.Get("order") == "desc",
+		Tracker: strings.ToLower(q.Get("tracker")),
+		Label:   strings.TrimSpace(q.Get("label")),
+		Search:  strings.ToLower(strings.TrimSpace(q.Get("search"))),
+	}
+	if _, ok := torrentSortKeys[q.Get("sort")]; ok {
+		tq.Sort = q.Get("sort")
+	}
+	for _, s := range strings.Split(q.Get("status"), ",") {
+		if _, ok := torrentStatusFilters[s]; ok {
+			tq.Status = append(tq.Status, s)
+		}
+	}
+	tq.Page, _ = strconv.Atoi(q.Get("page"))
+	if n, err := strconv.Atoi(q.Get("per_page")); err == nil && n > 0 {
+		perPage = n
+	}
+	if perPage > 0 || tq.Page > 0 {
+		if perPage <= 0 {
+			perPage = defaultPageSize
+		}
+		tq.PerPage = min(perPage, maxPageSize)
+		tq.Page = max(tq.Page, 1)
+	}
+	return tq
+}
+
+// TrackerCount is a tracker host and how many torrents use it, for the
+// tracker filter's choices
+type TrackerCount struct {
+	Host  string `json:"host"`
+	Count int    `json:"count"`
+}
+
+// TorrentPage is one page of a TorrentQuery's results
+type TorrentPage struct {
+	Torrents []Torrent      `json:"-"`
+	Total    int            `json:"total"` // matches across all pages
+	Page     int            `json:"page,omitempty"`
+	PerPage  int            `json:"perPage,omitempty"`
+	Pages    int            `json:"pages,omitempty"`
+	Trackers []TrackerCount `json:"trackers"`
+	Labels   []LabelCount   `json:"labels"`
+}
+
+// torrentIndex holds what the list is filtered and searched on, computed
+// once per list rather than for every torrent on every comparison
+type torrentIndex struct {
+	torrents  []Torrent
+	names     []string         // lowercased
+	hosts     []string         // lowercased primary tracker hosts
+	byTracker map[string][]int // primary tracker host → positions
+	byStatus  map[string][]int // status filter → positions
+	byLabel   map[string][]int // label → positions
+}
+
+func newTorrentIndex(torrents []Torrent) *torrentIndex {
+	ix := &torrentIndex{
+		torrents:  torrents,
+		names:     make([]string, len(torrents)),
+		hosts:     make([]string, len(torrents)),
+		byTracker: make(map[string][]int),
+		byStatus:  make(map[string][]int),
+		byLabel:   make(map[string][]int),
+	}
+	for i := range torrents {
+		t := &torrents[i]
+		ix.names[i] = strings.ToLower(t.Name)
+		host := strings.ToLower(t.PrimaryTrackerHost())
+		ix.hosts[i] = host
+		ix.byTracker[host] = append(ix.byTracker[host], i)
+		for _, l := range t.Labels {
+			ix.byLabel[l] = append(ix.byLabel[l], i)
+		}
+		for name, match := range torrentStatusFilters {
+			if match(t) {
+				ix.byStatus[name] = append(ix.byStatus[name], i)
+			}
+		}
+	}
+	return ix
+}
+
+// trackers lists the tracker hosts by use, leaving out trackerless torrents
+func (ix *torrentIndex) trackers() []TrackerCount {
+	counts := []TrackerCount{}
+	for host, positions := range ix.byTracker {
+		if host != "" {
+			counts = append(counts, TrackerCount{Host: host, Count: len(positions)})
+		}
+	}
+	slices.SortFunc(counts, func(a, b TrackerCount) int {
+		if c := compareInt(int64(b.Count), int64(a.Count)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Host, b.Host)
+	})
+	return counts
+}
+
+// query filters, sorts and pages the indexed list
+func (ix *torrentIndex) query(q TorrentQuery) TorrentPage {
+	// Start from the narrowest indexed set, then check the rest per torrent
+	matched := make([]bool, len(ix.torrents))
+	var candidates []int
+	switch {
+	case q.Label != "":
+		candidates = ix.byLabel[q.Label]
+	case q.Tracker != "":
+		candidates = ix.byTracker[q.Tracker]
+	case len(q.Status) == 1:
+		candidates = ix.byStatus[q.Status[0]]
+	default:
+		candidates = make([]int, len(ix.torrents))
+		for i := range candidates {
+			candidates[i] = i
+		}
+	}
+	statuses := make(map[int]bool)
+	for _, s := range q.Status {
+		for _, i := range ix.byStatus[s] {
+			statuses[i] = true
+		}
+	}
+
+	var positions []int
+	for _, i := range candidates {
+		if matched[i] {
+			continue
+		}
+		if q.Tracker != "" && ix.hosts[i] != q.Tracker {
+			continue
+		}
+		if len(q.Status) > 0 && !statuses[i] {
+			continue
+		}
+		if q.Search != "" && !strings.Contains(ix.names[i], q.Search) {
+			continue
+		}
+		matched[i] = true
+		positions = append(positions, i)
+	}
+	// The indexed sets are in list order, so without a sort the daemon's
+	// order is kept
+	slices.Sort(positions)
+	if compare, ok := torrentSortKeys[q.Sort]; ok {
+		slices.SortStableFunc(positions, func(a, b int) int {
+			ta, tb := &ix.torrents[a], &ix.torrents[b]
+			c := compare(ta, tb)
+			if c == 0 {
+				c = compareInt(int64(ta.ID), int64(tb.ID))
+			}
+			if q.Desc {
+				return -c
+			}
+			return c
+		})
+	}
+
+	page := TorrentPage{Total: len(positions), Trackers: ix.trackers(), Labels: ix.labels()}
+	if q.PerPage > 0 {
+		page.Page, page.PerPage = q.Page, q.PerPage
+		page.Pages = max(1, (len(positions)+q.PerPage-1)/q.PerPage)
+		start := min((q.Page-1)*q.PerPage, len(positions))
+		positions = positions[start:min(start+q.PerPage, len(positions))]
+	}
+	page.Torrents = make([]Torrent, len(positions))
+	for n, i := range positions {
+		page.Torrents[n] = ix.torrents[i]
+	}
+	return page
+}
+
+// StatusValue is the status= parameter, for the list form
+func (q TorrentQuery) StatusValue() string {
+	return strings.Join(q.Status, ",")
+}
+
+// values encodes the query back into list parameters, with the given page
+func (q TorrentQuery) values(page int) url.Values {
+	v := url.Values{}
+	if q.Sort != "" {
+		v.Set("sort", q.Sort)
+	}
+	if q.Desc {
+		v.Set("order", "desc")
+	}
+	if len(q.Status) > 0 {
+		v.Set("status", strings.Join(q.Status, ","))
+	}
+	if q.Tracker != "" {
+		v.Set("tracker", q.Tracker)
+	}
+	if q.Label != "" {
+		v.Set("label", q.Label)
+	}
+	if q.Search != "" {
+		v.Set("search", q.Search)
+	}
+	if page > 0 {
+		v.Set("page", strconv.Itoa(page))
+		if q.PerPage != defaultPageSize {
+			v.Set("per_page", strconv.Itoa(q.PerPage))
+		}
+	}
+	return v
+}
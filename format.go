@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unit preferences for DISPLAY_UNITS
+const (
+	UnitsIEC = "iec" // powers of 1024: KiB, MiB, GiB
+	UnitsSI  = "si"  // powers of 1000: kB, MB, GB
+)
+
+// DisplayFormat controls how sizes, speeds, numbers and times are shown in
+// templates and the JSON "display" fields
+type DisplayFormat struct {
+	Units     string `json:"units"`
+	Locale    string `json:"locale"`
+	decimal   string
+	thousands string
+}
+
+// localeSeparators holds decimal and thousands separators by language,
+// matching what browsers' Intl.NumberFormat uses. Locales not listed use
+// English.
+var localeSeparators = map[string][2]string{
+	"en": {".", ","},
+	"de": {",", "."},
+	"es": {",", "."},
+	"it": {",", "."},
+	"nl": {",", "."},
+	"pt": {",", "."},
+	"da": {",", "."},
+	"tr": {",", "."},
+	"id": {",", "."},
+	"fr": {",", "\u202f"},
+	"ru": {",", "\u00a0"},
+	"pl": {",", "\u00a0"},
+	"cs": {",", "\u00a0"},
+	"sv": {",", "\u00a0"},
+	"fi": {",", "\u00a0"},
+	"nb": {",", "\u00a0"},
+	"uk": {",", "\u00a0"},
+	"ja": {".", ","},
+	"zh": {".", ","},
+}
+
+// display is the process-wide format, set from DISPLAY_UNITS and
+// DISPLAY_LOCALE at startup
+var display = DisplayFormat{Units: UnitsIEC, Locale: "en", decimal: ".", thousands: ","}
+
+// NewDisplayFormat validates a unit preference and a BCP 47 locale such as
+// "de-DE"; an empty locale means English
+func NewDisplayFormat(units, locale string) (DisplayFormat, error) {
+	units = strings.ToLower(units)
+	if units != UnitsIEC && units != UnitsSI {
+		return DisplayFormat{}, fmt.Errorf("invalid DISPLAY_UNITS %q (want iec or si)", units)
+	}
+	if locale == "" {
+		locale = "en"
+	}
+	lang, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	seps, ok := localeSeparators[strings.ToLower(lang)]
+	if !ok {
+		seps = localeSeparators["en"]
+	}
+	return DisplayFormat{Units: units, Locale: locale, decimal: seps[0], thousands: seps[1]}, nil
+}
+
+// Number formats v with the given number of decimals and the locale's
+// separators
+func (d DisplayFormat) Number(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, c := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(d.thousands)
+		}
+		b.WriteRune(c)
+	}
+	if frac != "" {
+		b.WriteString(d.decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// Bytes formats a size in the preferred units, e.g. "1.5 GiB" or "1.6 GB"
+func (d DisplayFormat) Bytes(n int64) string {
+	unit, suffixes := int64(1024), []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	if d.Units == UnitsSI {
+		unit, suffixes = 1000, []string{"kB", "MB", "GB", "TB", "PB", "EB"}
+	}
+	if n < unit && n > -unit {
+		return d.Number(float64(n), 0) + " B"
+	}
+	div, exp := unit, 0
+	for m := n / unit; (m >= unit || m <= -unit) && exp < len(suffixes)-1; m /= unit {
+		div *= unit
+		exp++
+	}
+	return d.Number(float64(n)/float64(div), 1) + " " + suffixes[exp]
+}
+
+// Speed formats a transfer rate, e.g. "2.3 MiB/s"
+func (d DisplayFormat) Speed(bytesPerSec int64) string {
+	return d.Bytes(bytesPerSec) + "/s"
+}
+
+// Percent formats a 0-1 fraction as a percentage
+func (d DisplayFormat) Percent(pct float64) string {
+	return d.Number(pct*100, 1) + "%"
+}
+
+// Ratio formats an upload ratio; Transmission reports negative ratios when
+// there's nothing to compare against
+func (d DisplayFormat) Ratio(ratio float64) string {
+	if ratio < 0 {
+		return "N/A"
+	}
+	return d.Number(ratio, 2)
+}
+
+// RelativeTime describes t relative to now, e.g. "3h ago" or "in 5m".
+// Beyond a month it gives the date in the configured timezone.
+func (d DisplayFormat) RelativeTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	diff := now.Sub(t)
+	future := diff < 0
+	if future {
+		diff = -diff
+	}
+
+	var s string
+	switch {
+	case diff < time.Minute:
+		return "just now"
+	case diff < time.Hour:
+		s = fmt.Sprintf("%dm", int(diff/time.Minute))
+	case diff < 24*time.Hour:
+		s = fmt.Sprintf("%dh", int(diff/time.Hour))
+	case diff < 30*24*time.Hour:
+		s = fmt.Sprintf("%dd", int(diff/(24*time.Hour)))
+	default:
+		return localTime(t).Format("2006-01-02")
+	}
+	if future {
+		return "in " + s
+	}
+	return s + " ago"
+}
+
+// TorrentDisplay holds a torrent's values formatted for display
+type TorrentDisplay struct {
+	Size         string `json:"size"`
+	Downloaded   string `json:"downloaded"`
+	Uploaded     string `json:"uploaded"`
+	RateDownload string `json:"rateDownload"`
+	RateUpload   string `json:"rateUpload"`
+	Percent      string `json:"percentDone"`
+	Ratio        string `json:"uploadRatio"`
+	Added        string `json:"added"`
+}
+
+// StatsDisplay holds session stats formatted for display
+type StatsDisplay struct {
+	DownloadSpeed string `json:"downloadSpeed"`
+	UploadSpeed   string `json:"uploadSpeed"`
+	Downloaded    string `json:"downloaded"`
+	Uploaded      string `json:"uploaded"`
+}
+
+// addDisplay fills in the display fields of torrents and stats
+func addDisplay(torrents []Torrent, stats *SessionStats) {
+	now := time.Now()
+	for i := range torrents {
+		t := &torrents[i]
+		var added time.Time
+		if t.AddedDate > 0 {
+			added = time.Unix(t.AddedDate, 0)
+		}
+		t.Display = &TorrentDisplay{
+			Size:         display.Bytes(t.SizeWhenDone),
+			Downloaded:   display.Bytes(t.DownloadedEver),
+			Uploaded:     display.Bytes(t.UploadedEver),
+			RateDownload: display.Speed(t.RateDownload),
+			RateUpload:   display.Speed(t.RateUpload),
+			Percent:      display.Percent(t.PercentDone),
+			Ratio:        display.Ratio(t.UploadRatio),
+			Added:        display.RelativeTime(added, now),
+		}
+		t.Remedy = remedyFor(t)
+	}
+	if stats != nil {
+		stats.Display = &StatsDisplay{
+			DownloadSpeed: display.Speed(stats.DownloadSpeed),
+			UploadSpeed:   display.Speed(stats.UploadSpeed),
+			Downloaded:    display.Bytes(stats.CumulativeStats.DownloadedBytes),
+			Uploaded:      display.Bytes(stats.CumulativeStats.UploadedBytes),
+		}
+	}
+}
+
+// toFloat accepts the numeric types templates pass to formatNumber
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// statusText names a Transmission torrent status
+func statusText(status int) string {
+	switch status {
+	case 0:
+		return "Stopped"
+	case 1:
+		return "Queued (check)"
+	case 2:
+		return "Checking"
+	case 3:
+		return "Queued (dl)"
+	case 4:
+		return "Downloading"
+	case 5:
+		return "Queued (seed)"
+	case 6:
+		return "Seeding"
+	default:
+		return "Unknown"
+	}
+}
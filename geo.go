@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// GeoIP resolves peer addresses to coordinates using a MaxMind
+// GeoLite2/GeoIP2 City database
+type GeoIP struct {
+	db *geoip2.Reader
+}
+
+// GeoLocation is the result of a GeoIP lookup
+type GeoLocation struct {
+	Latitude    float64
+	Longitude   float64
+	Country     string
+	CountryCode string
+	City        string
+}
+
+// OpenGeoIP opens the .mmdb database at path
+func OpenGeoIP(path string) (*GeoIP, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
+	}
+	return &GeoIP{db: db}, nil
+}
+
+// Lookup returns the location of addr, or false if it's unknown
+func (g *GeoIP) Lookup(addr string) (*GeoLocation, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, false
+	}
+	rec, err := g.db.City(ip)
+	if err != nil || (rec.Location.Latitude == 0 && rec.Location.Longitude == 0) {
+		return nil, false
+	}
+	return &GeoLocation{
+		Latitude:    rec.Location.Latitude,
+		Longitude:   rec.Location.Longitude,
+		Country:     rec.Country.Names["en"],
+		CountryCode: rec.Country.IsoCode,
+		City:        rec.City.Names["en"],
+	}, true
+}
+
+// Close closes the underlying database
+func (g *GeoIP) Close() error {
+	return g.db.Close()
+}
+
+// GeoJSON types for the peer map
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONPoint           `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+// peerCluster aggregates peers sharing (rounded) coordinates
+type peerCluster struct {
+	loc          *GeoLocation
+	peers        int
+	torrents     map[int]struct{}
+	rateToClient int64
+	rateToPeer   int64
+}
+
+// buildPeerGeoJSON aggregates peers into one point feature per location.
+// Coordinates are rounded to two decimals (~1km) so peers in the same city
+// collapse into a single point.
+func buildPeerGeoJSON(geo *GeoIP, result *TorrentPeers) (*GeoJSONFeatureCollection, int) {
+	clusters := make(map[[2]float64]*peerCluster)
+	unresolved := 0
+
+	for _, t := range result.Torrents {
+		for _, p := range t.Peers {
+			loc, ok := geo.Lookup(p.Address)
+			if !ok {
+				unresolved++
+				continue
+			}
+			key := [2]float64{math.Round(loc.Longitude*100) / 100, math.Round(loc.Latitude*100) / 100}
+			c, exists := clusters[key]
+			if !exists {
+				c = &peerCluster{loc: loc, torrents: make(map[int]struct{})}
+				clusters[key] = c
+			}
+			c.peers++
+			c.torrents[t.ID] = struct{}{}
+			c.rateToClient += p.RateToClient
+			c.rateToPeer += p.RateToPeer
+		}
+	}
+
+	// Largest clusters first so renderers draw small ones on top
+	keys := make([][2]float64, 0, len(clusters))
+	for key := range clusters {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return clusters[keys[i]].peers > clusters[keys[j]].peers
+	})
+
+	fc := &GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []GeoJSONFeature{}}
+	for _, key := range keys {
+		c := clusters[key]
+		fc.Features = append(fc.Features, GeoJSONFeature{
+			Type:     "Feature",
+			Geometry: GeoJSONPoint{Type: "Point", Coordinates: key},
+			Properties: map[string]interface{}{
+				"peers":        c.peers,
+				"torrents":     len(c.torrents),
+				"country":      c.loc.Country,
+				"countryCode":  c.loc.CountryCode,
+				"city":         c.loc.City,
+				"rateToClient": c.rateToClient,
+				"rateToPeer":   c.rateToPeer,
+			},
+		})
+	}
+
+	return fc, unresolved
+}
+
+func (s *Server) handlePeersGeo(w http.ResponseWriter, _ *http.Request) {
+	if s.geoip == nil {
+		writeJSONError(w, "GeoIP database not configured (set GEOIP_DB_PATH)")
+		return
+	}
+
+	result, err := s.client.GetAllPeers()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+
+	fc, unresolved := buildPeerGeoJSON(s.geoip, result)
+	w.Header().Set("X-Unresolved-Peers", fmt.Sprintf("%d", unresolved))
+	w.Header().Set("Content-Type", "application/geo+json")
+	writeJSON(w, fc)
+}
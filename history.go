@@ -0,0 +1,198 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// RemovedTorrent is the archived final state of a removed torrent
+type RemovedTorrent struct {
+	ID             int       `json:"id"`
+	HashString     string    `json:"hashString"`
+	Name           string    `json:"name"`
+	SizeWhenDone   int64     `json:"sizeWhenDone"`
+	DownloadedEver int64     `json:"downloadedEver"`
+	UploadedEver   int64     `json:"uploadedEver"`
+	UploadRatio    float64   `json:"uploadRatio"`
+	SecondsSeeding int64     `json:"secondsSeeding"`
+	Tracker        string    `json:"tracker"`
+	AddedAt        time.Time `json:"addedAt"`
+	DoneAt         time.Time `json:"doneAt"`
+	RemovedAt      time.Time `json:"removedAt"`
+	RemovedBy      string    `json:"removedBy"`
+	DeletedData    bool      `json:"deletedData"`
+}
+
+// LifetimeTotals sums the stats of every archived torrent
+type LifetimeTotals struct {
+	Count          int     `json:"count"`
+	DownloadedEver int64   `json:"downloadedEver"`
+	UploadedEver   int64   `json:"uploadedEver"`
+	SecondsSeeding int64   `json:"secondsSeeding"`
+	Ratio          float64 `json:"ratio"`
+}
+
+// TorrentHistory archives removed torrents into SQLite
+type TorrentHistory struct {
+	db *sql.DB
+}
+
+// NewTorrentHistory creates the history store and its tables
+func NewTorrentHistory(db *sql.DB) (*TorrentHistory, error) {
+	queries := []string{
+		`CREATE TABLE IF NOT EXISTS removed_torrents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hash TEXT NOT NULL,
+			name TEXT NOT NULL,
+			size_when_done INTEGER NOT NULL,
+			downloaded_ever INTEGER NOT NULL,
+			uploaded_ever INTEGER NOT NULL,
+			upload_ratio REAL NOT NULL,
+			seconds_seeding INTEGER NOT NULL,
+			tracker TEXT NOT NULL,
+			added_at TEXT NOT NULL DEFAULT '', -- '' when unknown, so UNIQUE still applies
+			done_at DATETIME,
+			removed_at DATETIME NOT NULL,
+			removed_by TEXT NOT NULL,
+			deleted_data INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(hash, added_at)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_removed_at ON removed_torrents(removed_at DESC)`,
+		// Tables from before added_at was NOT NULL: SQLite never treated
+		// their NULLs as equal, so repeats may have piled up
+		`DELETE FROM removed_torrents WHERE added_at IS NULL AND id NOT IN
+		 (SELECT MIN(id) FROM removed_torrents WHERE added_at IS NULL GROUP BY hash)`,
+		`UPDATE removed_torrents SET added_at = '' WHERE added_at IS NULL`,
+	}
+	for _, query := range queries {
+		if _, err := db.Exec(query); err != nil {
+			return nil, err
+		}
+	}
+	return &TorrentHistory{db: db}, nil
+}
+
+// sqliteTime formats t the way SQLite's datetime() does
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// unixOrNull converts a Transmission timestamp for storage, treating 0 as unset
+func unixOrNull(ts int64) interface{} {
+	if ts <= 0 {
+		return nil
+	}
+	return sqliteTime(time.Unix(ts, 0))
+}
+
+// Archive records the final stats of t. A torrent is only archived once per
+// add, so archiving the same removal twice is a no-op.
+func (h *TorrentHistory) Archive(t *Torrent, removedBy string, deletedData bool) error {
+	addedAt := ""
+	if t.AddedDate > 0 {
+		addedAt = sqliteTime(time.Unix(t.AddedDate, 0))
+	}
+	_, err := h.db.Exec(
+		`INSERT OR IGNORE INTO removed_torrents
+		 (hash, name, size_when_done, downloaded_ever, uploaded_ever, upload_ratio,
+		  seconds_seeding, tracker, added_at, done_at, removed_at, removed_by, deleted_data)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.HashString, t.Name, t.SizeWhenDone, t.DownloadedEver, t.UploadedEver, t.UploadRatio,
+		t.SecondsSeeding, t.PrimaryTrackerHost(), addedAt, unixOrNull(t.DoneDate),
+		sqliteTime(time.Now()), removedBy, deletedData,
+	)
+	return err
+}
+
+// GetRemoved returns archived torrents, most recently removed first
+func (h *TorrentHistory) GetRemoved(limit int) ([]RemovedTorrent, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+
+	rows, err := h.db.Query(`
+		SELECT id, hash, name, size_when_done, downloaded_ever, uploaded_ever, upload_ratio,
+		       seconds_seeding, tracker, added_at, COALESCE(done_at, ''),
+		       removed_at, removed_by, deleted_data
+		FROM removed_torrents
+		ORDER BY removed_at DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []RemovedTorrent
+	for rows.Next() {
+		var item RemovedTorrent
+		var addedAt, doneAt string
+		err := rows.Scan(
+			&item.ID, &item.HashString, &item.Name, &item.SizeWhenDone, &item.DownloadedEver,
+			&item.UploadedEver, &item.UploadRatio, &item.SecondsSeeding, &item.Tracker,
+			&addedAt, &doneAt, &item.RemovedAt, &item.RemovedBy, &item.DeletedData,
+		)
+		if err != nil {
+			return nil, err
+		}
+		item.AddedAt = parseSQLiteDate(addedAt)
+		item.DoneAt = parseSQLiteDate(doneAt)
+		items = append(items, item)
+	}
+
+	return items, rows.Err()
+}
+
+// Totals returns lifetime totals across the graveyard
+func (h *TorrentHistory) Totals() (*LifetimeTotals, error) {
+	var totals LifetimeTotals
+	err := h.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(downloaded_ever), 0), COALESCE(SUM(uploaded_ever), 0),
+		       COALESCE(SUM(seconds_seeding), 0)
+		FROM removed_torrents
+	`).Scan(&totals.Count, &totals.DownloadedEver, &totals.UploadedEver, &totals.SecondsSeeding)
+	if err != nil {
+		return nil, err
+	}
+	if totals.DownloadedEver > 0 {
+		totals.Ratio = float64(totals.UploadedEver) / float64(totals.DownloadedEver)
+	}
+	return &totals, nil
+}
+
+func (s *Server) handleGraveyard(w http.ResponseWriter, _ *http.Request) {
+	items, err := s.history.GetRemoved(500)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	totals, err := s.history.Totals()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, "graveyard.html", map[string]interface{}{
+		"Items":   items,
+		"Totals":  totals,
+		"Version": Version,
+	})
+}
+
+func (s *Server) handleGraveyardAPI(w http.ResponseWriter, _ *http.Request) {
+	items, err := s.history.GetRemoved(500)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	totals, err := s.history.Totals()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"items":  items,
+		"totals": totals,
+	})
+}
@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Completion hook actions
+const (
+	HookActionScript  = "script"
+	HookActionWebhook = "webhook"
+	HookActionMove    = "move"
+	HookActionRatio   = "stop-at-ratio"
+)
+
+const (
+	defaultHookTimeout = 10 * time.Minute
+	maxHookRuns        = 50
+)
+
+// CompletionHook is an action run once when a torrent finishes downloading.
+// Label and NamePattern narrow which torrents it runs for.
+type CompletionHook struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Enabled     bool    `json:"enabled"`
+	Action      string  `json:"action"`
+	Label       string  `json:"label,omitempty"`
+	NamePattern string  `json:"namePattern,omitempty"`
+	Script      string  `json:"script,omitempty"`   // file name in HOOK_SCRIPT_DIR
+	URL         string  `json:"url,omitempty"`      // for webhook
+	Location    string  `json:"location,omitempty"` // category folder for move
+	Ratio       float64 `json:"ratio,omitempty"`    // for stop-at-ratio
+}
+
+// HookRun records the outcome of one hook on one torrent
+type HookRun struct {
+	Hook    string    `json:"hook"`
+	Torrent string    `json:"torrent"`
+	Time    time.Time `json:"time"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// HookRunner runs the completion hooks for torrents that reach 100%. The
+// hashes it has run them for are stored, so a torrent that's verified or
+// re-downloaded doesn't run them twice.
+type HookRunner struct {
+	db        *sql.DB
+	client    *TransmissionClient
+	poller    *Poller
+	scriptDir string // scripts must live here; empty disables script hooks
+	timeout   time.Duration
+	http      *http.Client
+
+	mu   sync.Mutex
+	runs []HookRun
+}
+
+// NewHookRunner creates the runner and its completion_hooks and
+// completion_hooks_done tables
+func NewHookRunner(db *sql.DB, client *TransmissionClient, poller *Poller, scriptDir string, timeout time.Duration) (*HookRunner, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS completion_hooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		action TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		name_pattern TEXT NOT NULL DEFAULT '',
+		script TEXT NOT NULL DEFAULT '',
+		url TEXT NOT NULL DEFAULT '',
+		location TEXT NOT NULL DEFAULT '',
+		ratio REAL NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS completion_hooks_done (
+		hash TEXT PRIMARY KEY,
+		done_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	return &HookRunner{
+		db:        db,
+		client:    client,
+		poller:    poller,
+		scriptDir: scriptDir,
+		timeout:   timeout,
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Validate checks h's action has what it needs. Scripts are looked up by
+// file name in the script directory only, so the API can't run arbitrary
+// commands.
+func (hr *HookRunner) Validate(h *CompletionHook) error {
+	switch h.Action {
+	case HookActionScript:
+		if _, err := hr.scriptPath(h.Script); err != nil {
+			return err
+		}
+	case HookActionWebhook:
+		if !strings.HasPrefix(h.URL, "http://") && !strings.HasPrefix(h.URL, "https://") {
+			return fmt.Errorf("webhook needs an http(s) URL")
+		}
+	case HookActionMove:
+		location, err := cleanLocation(h.Location)
+		if err != nil {
+			return err
+		}
+		h.Location = location
+	case HookActionRatio:
+		if h.Ratio <= 0 {
+			return fmt.Errorf("stop-at-ratio needs a ratio above 0")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", h.Action)
+	}
+	if h.NamePattern != "" {
+		if _, err := regexp.Compile(h.NamePattern); err != nil {
+			return fmt.Errorf("invalid name pattern: %w", err)
+		}
+	}
+	return nil
+}
+
+// scriptPath resolves a script name inside the script directory
+func (hr *HookRunner) scriptPath(name string) (string, error) {
+	if hr.scriptDir == "" {
+		return "", fmt.Errorf("script hooks are disabled; set HOOK_SCRIPT_DIR")
+	}
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("script must be a file name in %s", hr.scriptDir)
+	}
+	path := filepath.Join(hr.scriptDir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("script %s: %w", name, err)
+	}
+	if info.IsDir() || info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("script %s is not executable", name)
+	}
+	return path, nil
+}
+
+// Hooks returns all hooks in the order they run
+func (hr *HookRunner) Hooks() ([]CompletionHook, error) {
+	rows, err := hr.db.Query("SELECT id, name, enabled, action, label, name_pattern, script, url, location, ratio FROM completion_hooks ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []CompletionHook
+	for rows.Next() {
+		var h CompletionHook
+		if err := rows.Scan(&h.ID, &h.Name, &h.Enabled, &h.Action, &h.Label, &h.NamePattern, &h.Script, &h.URL, &h.Location, &h.Ratio); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+func (hr *HookRunner) hook(id int) (*CompletionHook, error) {
+	hooks, err := hr.Hooks()
+	if err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		if hooks[i].ID == id {
+			return &hooks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("hook %d not found", id)
+}
+
+// AddHook validates and stores a new hook
+func (hr *HookRunner) AddHook(h *CompletionHook) error {
+	if err := hr.Validate(h); err != nil {
+		return err
+	}
+	result, err := hr.db.Exec(
+		"INSERT INTO completion_hooks (name, enabled, action, label, name_pattern, script, url, location, ratio) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		h.Name, h.Enabled, h.Action, h.Label, h.NamePattern, h.Script, h.URL, h.Location, h.Ratio,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	h.ID = int(id)
+	return nil
+}
+
+// UpdateHook validates and replaces an existing hook
+func (hr *HookRunner) UpdateHook(h *CompletionHook) error {
+	if err := hr.Validate(h); err != nil {
+		return err
+	}
+	_, err := hr.db.Exec(
+		"UPDATE completion_hooks SET name = ?, enabled = ?, action = ?, label = ?, name_pattern = ?, script = ?, url = ?, location = ?, ratio = ? WHERE id = ?",
+		h.Name, h.Enabled, h.Action, h.Label, h.NamePattern, h.Script, h.URL, h.Location, h.Ratio, h.ID,
+	)
+	return err
+}
+
+// DeleteHook deletes a hook
+func (hr *HookRunner) DeleteHook(id int) error {
+	_, err := hr.db.Exec("DELETE FROM completion_hooks WHERE id = ?", id)
+	return err
+}
+
+// Runs returns the most recent hook runs, newest first
+func (hr *HookRunner) Runs() []HookRun {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	out := make([]HookRun, len(hr.runs))
+	for i, run := range hr.runs {
+		out[len(hr.runs)-1-i] = run
+	}
+	return out
+}
+
+func (hr *HookRunner) record(h *CompletionHook, t *Torrent, err error) {
+	run := HookRun{Hook: h.Name, Torrent: t.Name, Time: time.Now()}
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("Hook %q failed on %s: %v", h.Name, t.Name, err)
+	} else {
+		log.Printf("Hook %q: %s %s", h.Name, h.Action, t.Name)
+	}
+	hr.mu.Lock()
+	hr.runs = append(hr.runs, run)
+	if len(hr.runs) > maxHookRuns {
+		hr.runs = hr.runs[len(hr.runs)-maxHookRuns:]
+	}
+	hr.mu.Unlock()
+}
+
+// OnEvent runs the hooks for a completed torrent the first time it
+// completes, and forgets a removed torrent
+func (hr *HookRunner) OnEvent(e Event) {
+	if e.Hash == "" {
+		return
+	}
+	switch e.Type {
+	case EventTorrentCompleted:
+		go hr.complete(e.Hash)
+	case EventTorrentRemoved:
+		if _, err := hr.db.Exec("DELETE FROM completion_hooks_done WHERE hash = ?", e.Hash); err != nil {
+			log.Printf("Failed to forget completion hooks for %s: %v", e.Hash, err)
+		}
+	}
+}
+
+// complete claims hash and runs the hooks on the torrent as last polled
+func (hr *HookRunner) complete(hash string) {
+	res, err := hr.db.Exec("INSERT OR IGNORE INTO completion_hooks_done (hash, done_at) VALUES (?, ?)", hash, time.Now())
+	if err != nil {
+		log.Printf("Failed to record completion hooks for %s: %v", hash, err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return // already ran
+	}
+	snap := hr.poller.Latest()
+	if snap == nil {
+		return
+	}
+	for _, t := range snap.Torrents {
+		if t.HashString == hash {
+			hr.runAll(t)
+			return
+		}
+	}
+}
+
+// runAll runs every matching hook on t in order, so a move lands before a
+// later script sees the directory
+func (hr *HookRunner) runAll(t Torrent) {
+	hooks, err := hr.Hooks()
+	if err != nil {
+		log.Printf("Failed to load completion hooks: %v", err)
+		return
+	}
+	for i := range hooks {
+		h := &hooks[i]
+		if h.Enabled && hookMatches(h, &t) {
+			hr.record(h, &t, hr.Run(h, &t))
+		}
+	}
+}
+
+// hookMatches checks h's label and name filters against t
+func hookMatches(h *CompletionHook, t *Torrent) bool {
+	if h.Label != "" {
+		found := false
+		for _, l := range t.Labels {
+			if strings.EqualFold(l, h.Label) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if h.NamePattern != "" {
+		re, err := regexp.Compile(h.NamePattern)
+		if err != nil || !re.MatchString(t.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run performs h's action on t. A move updates t.DownloadDir for the hooks
+// after it.
+func (hr *HookRunner) Run(h *CompletionHook, t *Torrent) error {
+	switch h.Action {
+	case HookActionScript:
+		return hr.runScript(h, t)
+
+	case HookActionWebhook:
+		body, _ := json.Marshal(map[string]interface{}{
+			"event":   EventTorrentCompleted,
+			"hook":    h.Name,
+			"torrent": t,
+		})
+		req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "transmission-web/"+Version)
+		resp, err := hr.http.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		}
+		return nil
+
+	case HookActionMove:
+		if t.DownloadDir == h.Location {
+			return nil
+		}
+		target, err := moveTorrent(hr.client, t.ID, h.Location, true)
+		if err != nil {
+			return err
+		}
+		t.DownloadDir = target
+		return nil
+
+	case HookActionRatio:
+		mode, ratio := SeedModeSingle, h.Ratio
+		return hr.client.SetTorrentLimits(t.ID, &SeedLimits{RatioMode: &mode, RatioLimit: &ratio})
+	}
+	return fmt.Errorf("unknown action %q", h.Action)
+}
+
+// runScript executes a hook script with the torrent in the environment,
+// using the variable names Transmission's own script-torrent-done-filename
+// sets so existing scripts work unchanged
+func (hr *HookRunner) runScript(h *CompletionHook, t *Torrent) error {
+	path, err := hr.scriptPath(h.Script)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hr.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = hr.scriptDir
+	cmd.Env = append(os.Environ(),
+		"TR_TORRENT_ID="+strconv.Itoa(t.ID),
+		"TR_TORRENT_NAME="+t.Name,
+		"TR_TORRENT_HASH="+t.HashString,
+		"TR_TORRENT_DIR="+t.DownloadDir,
+		"TR_TORRENT_LABELS="+strings.Join(t.Labels, ","),
+		"TR_TORRENT_BYTES_DOWNLOADED="+strconv.FormatInt(t.SizeWhenDone, 10),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return err
+	}
+	return nil
+}
+
+// lastLine keeps the end of a script's output for the error message
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+func (s *Server) handleGetHooks(w http.ResponseWriter, _ *http.Request) {
+	hooks, err := s.hooks.Hooks()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"hooks":   hooks,
+		"runs":    s.hooks.Runs(),
+		"scripts": s.hooks.scriptDir != "",
+	})
+}
+
+func (s *Server) handleAddHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h := CompletionHook{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.hooks.AddHook(&h); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, h)
+}
+
+func (s *Server) handleUpdateHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var h CompletionHook
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.hooks.UpdateHook(&h); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, h)
+}
+
+func (s *Server) handleDeleteHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.hooks.DeleteHook(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleRunHook runs one hook now on ?torrent=, whether or not it matches,
+// to try it out
+func (s *Server) handleRunHook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	torrentID, err := strconv.Atoi(r.URL.Query().Get("torrent"))
+	if err != nil {
+		writeJSONError(w, "invalid torrent")
+		return
+	}
+	h, err := s.hooks.hook(id)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	t, err := s.client.GetTorrent(torrentID)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	err = s.hooks.Run(h, t)
+	s.hooks.record(h, t, err)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// hubSendBuffer is how many messages a browser may fall behind by before
+// it's disconnected
+const hubSendBuffer = 8
+
+// HubMessage is pushed to browsers on /ws. The first message after
+// connecting is a "snapshot" of every torrent; after each poll a "delta"
+// carries only the torrents that changed or appeared, the IDs of removed
+// ones and the session stats.
+type HubMessage struct {
+	Type     string        `json:"type"` // snapshot or delta
+	Torrents []Torrent     `json:"torrents"`
+	Removed  []int         `json:"removed,omitempty"`
+	Stats    *SessionStats `json:"stats,omitempty"`
+}
+
+// Hub broadcasts the poller's snapshots to every connected browser, so
+// Transmission is polled once however many dashboards are open
+type Hub struct {
+	poller *Poller
+
+	mu      sync.Mutex
+	clients map[chan []byte]struct{}
+}
+
+// NewHub creates a hub fed by poller
+func NewHub(poller *Poller) *Hub {
+	h := &Hub{poller: poller, clients: make(map[chan []byte]struct{})}
+	poller.Subscribe(h.OnSnapshot)
+	return h
+}
+
+// OnSnapshot sends the changes since the previous poll to every client
+func (h *Hub) OnSnapshot(prev, cur *Snapshot) {
+	if h.Clients() == 0 {
+		return
+	}
+	h.broadcast(snapshotDelta(prev, cur))
+}
+
+// Clients returns how many browsers are connected
+func (h *Hub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.clients)
+}
+
+// snapshotDelta builds the delta between two polls. The torrents are copies
+// so adding display fields doesn't touch the shared snapshot.
+func snapshotDelta(prev, cur *Snapshot) *HubMessage {
+	msg := &HubMessage{Type: "delta", Torrents: []Torrent{}}
+	old := make(map[int]*Torrent)
+	if prev != nil {
+		for i := range prev.Torrents {
+			old[prev.Torrents[i].ID] = &prev.Torrents[i]
+		}
+	}
+	for _, t := range cur.Torrents {
+		if o, ok := old[t.ID]; !ok || !reflect.DeepEqual(*o, t) {
+			msg.Torrents = append(msg.Torrents, t)
+		}
+		delete(old, t.ID)
+	}
+	for id := range old {
+		msg.Removed = append(msg.Removed, id)
+	}
+	msg.Stats = copyStats(cur.Stats)
+	addDisplay(msg.Torrents, msg.Stats)
+	return msg
+}
+
+// snapshotMessage is the full list for a newly connected client
+func snapshotMessage(snap *Snapshot) *HubMessage {
+	msg := &HubMessage{
+		Type:     "snapshot",
+		Torrents: append([]Torrent{}, snap.Torrents...),
+		Stats:    copyStats(snap.Stats),
+	}
+	addDisplay(msg.Torrents, msg.Stats)
+	return msg
+}
+
+func copyStats(stats *SessionStats) *SessionStats {
+	if stats == nil {
+		return nil
+	}
+	c := *stats
+	return &c
+}
+
+func (h *Hub) broadcast(msg *HubMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Hub: failed to encode message: %v", err)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for send := range h.clients {
+		select {
+		case send <- data:
+		default:
+			// Too slow to keep up; closing makes the writer hang up
+			delete(h.clients, send)
+			close(send)
+		}
+	}
+}
+
+func (h *Hub) register() chan []byte {
+	send := make(chan []byte, hubSendBuffer)
+	h.mu.Lock()
+	h.clients[send] = struct{}{}
+	h.mu.Unlock()
+	return send
+}
+
+func (h *Hub) unregister(send chan []byte) {
+	h.mu.Lock()
+	if _, ok := h.clients[send]; ok {
+		delete(h.clients, send)
+		close(send)
+	}
+	h.mu.Unlock()
+}
+
+// serve pushes messages to one browser until either side hangs up
+func (h *Hub) serve(ws *websocket.Conn) {
+	defer ws.Close()
+	send := h.register()
+	defer h.unregister(send)
+	h.poller.Wake()
+
+	if snap := h.poller.Latest(); snap != nil {
+		if err := websocket.JSON.Send(ws, snapshotMessage(snap)); err != nil {
+			return
+		}
+	}
+
+	// Browsers don't send anything; reading only notices the close
+	go func() {
+		var discard string
+		for {
+			if err := websocket.Message.Receive(ws, &discard); err != nil {
+				break
+			}
+		}
+		h.unregister(send)
+	}()
+
+	for data := range send {
+		if err := websocket.Message.Send(ws, string(data)); err != nil {
+			return
+		}
+	}
+}
+
+// ServeHTTP upgrades /ws requests to a websocket
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	websocket.Handler(h.serve).ServeHTTP(w, r)
+}
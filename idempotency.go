@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdempotencyTTL = 24 * time.Hour
+	idempotencyKeyHeader  = "Idempotency-Key"
+	maxIdempotentBody     = 32 << 20
+)
+
+// Idempotency replays the stored response when a POST is retried with the
+// same Idempotency-Key header, so a flaky connection that never saw the
+// first reply doesn't add a torrent twice. Successful responses are kept
+// in SQLite for the TTL; failures aren't, so those can be retried for real.
+type Idempotency struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+// NewIdempotency creates the idempotency_keys table
+func NewIdempotency(db *sql.DB, ttl time.Duration) (*Idempotency, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		fingerprint TEXT NOT NULL,
+		status INTEGER NOT NULL,
+		content_type TEXT NOT NULL DEFAULT '',
+		location TEXT NOT NULL DEFAULT '',
+		body BLOB NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create idempotency_keys table: %w", err)
+	}
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	return &Idempotency{db: db, ttl: ttl, inFlight: make(map[string]bool)}, nil
+}
+
+// storedResponse is a response kept for replay
+type storedResponse struct {
+	fingerprint string
+	status      int
+	contentType string
+	location    string
+	body        []byte
+}
+
+func (idem *Idempotency) lookup(key string) (*storedResponse, error) {
+	var resp storedResponse
+	err := idem.db.QueryRow(`SELECT fingerprint, status, content_type, location, body FROM idempotency_keys
+		WHERE key = ? AND created_at > ?`, key, time.Now().Add(-idem.ttl)).
+		Scan(&resp.fingerprint, &resp.status, &resp.contentType, &resp.location, &resp.body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (idem *Idempotency) store(key string, resp *storedResponse) {
+	// Expired keys are only swept here; lookups ignore them anyway
+	if _, err := idem.db.Exec("DELETE FROM idempotency_keys WHERE created_at <= ?", time.Now().Add(-idem.ttl)); err != nil {
+		log.Printf("Failed to delete expired idempotency keys: %v", err)
+	}
+	_, err := idem.db.Exec(`INSERT OR REPLACE INTO idempotency_keys (key, fingerprint, status, content_type, location, body, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, key, resp.fingerprint, resp.status, resp.contentType, resp.location, resp.body, time.Now())
+	if err != nil {
+		log.Printf("Failed to store idempotent response: %v", err)
+	}
+}
+
+// begin claims key for a request, failing if one is already running with it
+func (idem *Idempotency) begin(key string) bool {
+	idem.mu.Lock()
+	defer idem.mu.Unlock()
+	if idem.inFlight[key] {
+		return false
+	}
+	idem.inFlight[key] = true
+	return true
+}
+
+func (idem *Idempotency) end(key string) {
+	idem.mu.Lock()
+	delete(idem.inFlight, key)
+	idem.mu.Unlock()
+}
+
+// fingerprint identifies what was asked, so a key reused for a different
+// request is refused rather than answered with the wrong response. API
+// tokens are part of it, keeping integrations' keys apart. Multipart
+// bodies are hashed by part, as a retry gets a new boundary.
+func fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	contentType := r.Header.Get("Content-Type")
+	mediaType, params, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		if parts, err := multipartDigest(body, params["boundary"]); err == nil {
+			contentType, body = mediaType, parts
+		}
+	}
+	fmt.Fprintf(h, "%s %s\n%s\n%s\n", r.Method, r.URL.RequestURI(), scopeLabel(r), contentType)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// multipartDigest hashes a multipart body's part headers and contents,
+// leaving out the boundary
+func multipartDigest(body []byte, boundary string) ([]byte, error) {
+	if boundary == "" {
+		return nil, errors.New("no multipart boundary")
+	}
+	h := sha256.New()
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return h.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(h, "%q %q %d\n", part.Header.Get("Content-Disposition"), part.Header.Get("Content-Type"), len(data))
+		h.Write(data)
+	}
+}
+
+// Middleware handles POSTs carrying an Idempotency-Key header; everything
+// else passes straight through
+func (idem *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if key == "" || r.Method != "POST" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			idempotencyError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			idempotencyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			idempotencyError(w, http.StatusRequestEntityTooLarge, "request too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fp := fingerprint(r, body)
+
+		if !idem.begin(key) {
+			w.Header().Set("Retry-After", "1")
+			idempotencyError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+			return
+		}
+		defer idem.end(key)
+
+		stored, err := idem.lookup(key)
+		if err != nil {
+			log.Printf("Failed to look up idempotency key: %v", err)
+		}
+		if stored != nil {
+			if stored.fingerprint != fp {
+				idempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				return
+			}
+			if stored.contentType != "" {
+				w.Header().Set("Content-Type", stored.contentType)
+			}
+			if stored.location != "" {
+				w.Header().Set("Location", stored.location)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.status)
+			w.Write(stored.body) //nolint:errcheck // the client went away
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 400 || isErrorReply(rec.body.Bytes()) {
+			return
+		}
+		idem.store(key, &storedResponse{
+			fingerprint: fp,
+			status:      rec.status,
+			contentType: w.Header().Get("Content-Type"),
+			location:    w.Header().Get("Location"),
+			body:        append([]byte{}, rec.body.Bytes()...),
+		})
+	})
+}
+
+// isErrorReply spots the {"error": ...} envelope, which many handlers send
+// with a 200
+func isErrorReply(body []byte) bool {
+	var reply struct {
+		Error string `json:"error"`
+	}
+	return json.Unmarshal(body, &reply) == nil && reply.Error != ""
+}
+
+func idempotencyError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	writeJSONError(w, msg)
+}
+
+// responseRecorder copies a response as it's written
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Indexer sources
+const (
+	IndexerSourceManual   = "manual"
+	IndexerSourceProwlarr = "prowlarr"
+)
+
+// Indexer is a Torznab endpoint used for searching
+type Indexer struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	BaseURL    string `json:"baseUrl"`
+	APIPath    string `json:"apiPath"`
+	APIKey     string `json:"apiKey,omitempty"`
+	Categories []int  `json:"categories"`
+	Enabled    bool   `json:"enabled"`
+	Priority   int    `json:"priority"`
+	Source     string `json:"source"`
+}
+
+// Validate checks the indexer has somewhere to send queries
+func (ix *Indexer) Validate() error {
+	if ix.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if !strings.HasPrefix(ix.BaseURL, "http://") && !strings.HasPrefix(ix.BaseURL, "https://") {
+		return fmt.Errorf("baseUrl must be an http(s) URL")
+	}
+	if ix.APIPath == "" {
+		ix.APIPath = "/api"
+	}
+	return nil
+}
+
+// IndexerStore persists Torznab indexers in the indexers table
+type IndexerStore struct {
+	db *sql.DB
+}
+
+// NewIndexerStore creates the store and its indexers table
+func NewIndexerStore(db *sql.DB) (*IndexerStore, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS indexers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		base_url TEXT NOT NULL,
+		api_path TEXT NOT NULL DEFAULT '/api',
+		api_key TEXT NOT NULL DEFAULT '',
+		categories TEXT NOT NULL DEFAULT '[]',
+		enabled INTEGER NOT NULL DEFAULT 1,
+		priority INTEGER NOT NULL DEFAULT 25,
+		source TEXT NOT NULL DEFAULT 'manual'
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexerStore{db: db}, nil
+}
+
+const indexerColumns = "id, name, base_url, api_path, api_key, categories, enabled, priority, source"
+
+func scanIndexer(row interface{ Scan(...interface{}) error }) (*Indexer, error) {
+	var ix Indexer
+	var categories string
+	if err := row.Scan(&ix.ID, &ix.Name, &ix.BaseURL, &ix.APIPath, &ix.APIKey, &categories, &ix.Enabled, &ix.Priority, &ix.Source); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(categories), &ix.Categories); err != nil {
+		return nil, fmt.Errorf("indexer %d: %w", ix.ID, err)
+	}
+	return &ix, nil
+}
+
+// List returns all indexers by priority
+func (st *IndexerStore) List() ([]Indexer, error) {
+	rows, err := st.db.Query("SELECT " + indexerColumns + " FROM indexers ORDER BY priority, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	indexers := []Indexer{}
+	for rows.Next() {
+		ix, err := scanIndexer(rows)
+		if err != nil {
+			return nil, err
+		}
+		indexers = append(indexers, *ix)
+	}
+	return indexers, rows.Err()
+}
+
+// Get returns a single indexer
+func (st *IndexerStore) Get(id int) (*Indexer, error) {
+	return scanIndexer(st.db.QueryRow("SELECT "+indexerColumns+" FROM indexers WHERE id = ?", id))
+}
+
+// Add validates and stores a new indexer
+func (st *IndexerStore) Add(ix *Indexer) error {
+	if err := ix.Validate(); err != nil {
+		return err
+	}
+	if ix.Source == "" {
+		ix.Source = IndexerSourceManual
+	}
+	categories, _ := json.Marshal(ix.Categories)
+	result, err := st.db.Exec(
+		"INSERT INTO indexers (name, base_url, api_path, api_key, categories, enabled, priority, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		ix.Name, ix.BaseURL, ix.APIPath, ix.APIKey, string(categories), ix.Enabled, ix.Priority, ix.Source,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	ix.ID = int(id)
+	return nil
+}
+
+// Update validates and replaces an existing indexer
+func (st *IndexerStore) Update(ix *Indexer) error {
+	if err := ix.Validate(); err != nil {
+		return err
+	}
+	categories, _ := json.Marshal(ix.Categories)
+	result, err := st.db.Exec(
+		"UPDATE indexers SET name = ?, base_url = ?, api_path = ?, api_key = ?, categories = ?, enabled = ?, priority = ? WHERE id = ?",
+		ix.Name, ix.BaseURL, ix.APIPath, ix.APIKey, string(categories), ix.Enabled, ix.Priority, ix.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Delete deletes an indexer
+func (st *IndexerStore) Delete(id int) error {
+	_, err := st.db.Exec("DELETE FROM indexers WHERE id = ?", id)
+	return err
+}
+
+func (s *Server) handleGetIndexers(w http.ResponseWriter, _ *http.Request) {
+	indexers, err := s.indexers.List()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	// API keys stay server-side
+	for i := range indexers {
+		indexers[i].APIKey = ""
+	}
+	writeJSON(w, map[string]interface{}{"indexers": indexers})
+}
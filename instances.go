@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Instance selectors accepted by ?instance=
+const (
+	DefaultInstance = "default" // the daemon at TRANSMISSION_URL
+	AllInstances    = "all"     // every daemon, aggregated
+)
+
+// InstanceConfig is one extra daemon from TRANSMISSION_INSTANCES
+type InstanceConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	User string `json:"user,omitempty"`
+	Pass string `json:"pass,omitempty"`
+}
+
+// loadInstances parses TRANSMISSION_INSTANCES: a JSON array of instances,
+// or the path of a file holding one
+func loadInstances(spec string) ([]InstanceConfig, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	data := []byte(spec)
+	if !strings.HasPrefix(spec, "[") {
+		var err error
+		if data, err = os.ReadFile(spec); err != nil {
+			return nil, err
+		}
+	}
+	var instances []InstanceConfig
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return nil, fmt.Errorf("invalid TRANSMISSION_INSTANCES: %w", err)
+	}
+	seen := map[string]bool{DefaultInstance: true, AllInstances: true}
+	for _, inst := range instances {
+		if inst.Name == "" || inst.URL == "" {
+			return nil, fmt.Errorf("instance needs a name and url")
+		}
+		if seen[inst.Name] {
+			return nil, fmt.Errorf("duplicate or reserved instance name %q", inst.Name)
+		}
+		seen[inst.Name] = true
+	}
+	return instances, nil
+}
+
+// InstanceInfo describes a daemon for /api/instances
+type InstanceInfo struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// instanceNames lists the default instance followed by the extra ones
+func (s *Server) instanceNames() []string {
+	return append([]string{DefaultInstance}, s.instanceOrder...)
+}
+
+// clientFor returns the client of a named instance; "" is the default
+func (s *Server) clientFor(name string) (*TransmissionClient, error) {
+	if name == "" || name == DefaultInstance {
+		return s.client, nil
+	}
+	if c, ok := s.instances[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown instance %q", name)
+}
+
+// requestClient picks the client for ?instance=, writing the error
+// response itself when it's unknown
+func (s *Server) requestClient(w http.ResponseWriter, r *http.Request) (*TransmissionClient, bool) {
+	c, err := s.clientFor(r.URL.Query().Get("instance"))
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return nil, false
+	}
+	return c, true
+}
+
+// listTorrents fetches the torrents and stats of one instance, or of all of
+// them with the stats summed. Torrents from anything but the default
+// instance are tagged with its name, since IDs are only unique per daemon.
+// Parts that fail are reported in errs ("stats" for one instance, instance
+// names for all) and the rest are still returned; err is set only when no
+// torrents could be listed at all.
+func (s *Server) listTorrents(instance string) (torrents []Torrent, stats *SessionStats, errs map[string]error, err error) {
+	if instance != AllInstances {
+		client, err := s.clientFor(instance)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		errs = fanOut(map[string]func() error{
+			"torrents": func() (err error) {
+				torrents, err = client.GetTorrents()
+				return err
+			},
+			"stats": func() (err error) {
+				stats, err = client.GetSessionStats()
+				return err
+			},
+		})
+		if err := errs["torrents"]; err != nil {
+			return nil, nil, nil, err
+		}
+		if instance != "" && instance != DefaultInstance {
+			tagInstance(torrents, instance)
+		}
+		s.sla.Mark(torrents, time.Now())
+		s.seedRules.Mark(torrents)
+		s.swarm.Mark(torrents)
+		return torrents, stats, errs, nil
+	}
+
+	names := s.instanceNames()
+	type result struct {
+		torrents []Torrent
+		stats    *SessionStats
+	}
+	results := make(map[string]*result, len(names))
+	calls := make(map[string]func() error, len(names))
+	for _, name := range names {
+		client, _ := s.clientFor(name)
+		res := &result{}
+		results[name] = res
+		calls[name] = func() (err error) {
+			if res.torrents, err = client.GetTorrents(); err != nil {
+				return err
+			}
+			res.stats, err = client.GetSessionStats()
+			return err
+		}
+	}
+	errs = fanOut(calls)
+	if len(errs) == len(names) {
+		return nil, nil, nil, errs[DefaultInstance]
+	}
+
+	torrents, stats = []Torrent{}, &SessionStats{}
+	for _, name := range names {
+		if errs[name] != nil {
+			continue
+		}
+		res := results[name]
+		if name != DefaultInstance {
+			tagInstance(res.torrents, name)
+		}
+		torrents = append(torrents, res.torrents...)
+		stats.add(res.stats)
+	}
+	s.sla.Mark(torrents, time.Now())
+	s.seedRules.Mark(torrents)
+	s.swarm.Mark(torrents)
+	return torrents, stats, errs, nil
+}
+
+func tagInstance(torrents []Torrent, name string) {
+	for i := range torrents {
+		torrents[i].Instance = name
+	}
+}
+
+// add sums another daemon's stats into s
+func (s *SessionStats) add(o *SessionStats) {
+	s.ActiveTorrentCount += o.ActiveTorrentCount
+	s.PausedTorrentCount += o.PausedTorrentCount
+	s.TorrentCount += o.TorrentCount
+	s.DownloadSpeed += o.DownloadSpeed
+	s.UploadSpeed += o.UploadSpeed
+	s.CumulativeStats.UploadedBytes += o.CumulativeStats.UploadedBytes
+	s.CumulativeStats.DownloadedBytes += o.CumulativeStats.DownloadedBytes
+}
+
+func (s *Server) handleInstances(w http.ResponseWriter, _ *http.Request) {
+	list := []InstanceInfo{{Name: DefaultInstance, URL: s.client.url}}
+	for _, name := range s.instanceOrder {
+		list = append(list, InstanceInfo{Name: name, URL: s.instances[name].url})
+	}
+	writeJSON(w, map[string]interface{}{"instances": list})
+}
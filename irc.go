@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceIRC attributes torrents added from tracker announce channels
+const SourceIRC = "irc"
+
+// IRCNetwork configures one IRC connection and how to read its announces
+type IRCNetwork struct {
+	Name            string   `json:"name"`
+	Server          string   `json:"server"` // host:port
+	TLS             bool     `json:"tls"`
+	Nick            string   `json:"nick"`
+	Password        string   `json:"password,omitempty"`         // server password
+	NickServ        string   `json:"nickservPassword,omitempty"` // sent to NickServ IDENTIFY
+	Channels        []string `json:"channels"`
+	Announcers      []string `json:"announcers"` // nicks allowed to announce; empty allows any
+	Pattern         string   `json:"pattern"`    // regex with named groups, at least "name"
+	URLTemplate     string   `json:"urlTemplate"`
+	Filters         []string `json:"filters"` // release names must match one; empty matches all
+	pattern         *regexp.Regexp
+	filters         []*regexp.Regexp
+	announcerLookup map[string]bool
+}
+
+// IRCConfig is the file named by IRC_ANNOUNCE_CONFIG
+type IRCConfig struct {
+	Networks []IRCNetwork `json:"networks"`
+}
+
+// loadIRCConfig reads and compiles the announce configuration
+func loadIRCConfig(path string) (*IRCConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg IRCConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Networks {
+		n := &cfg.Networks[i]
+		if n.Server == "" || n.Nick == "" || len(n.Channels) == 0 {
+			return nil, fmt.Errorf("network %q: server, nick and channels are required", n.Name)
+		}
+		if n.pattern, err = regexp.Compile(n.Pattern); err != nil {
+			return nil, fmt.Errorf("network %q: invalid pattern: %w", n.Name, err)
+		}
+		if n.pattern.SubexpIndex("name") < 0 {
+			return nil, fmt.Errorf("network %q: pattern needs a (?P<name>...) group", n.Name)
+		}
+		if n.URLTemplate == "" && n.pattern.SubexpIndex("url") < 0 {
+			return nil, fmt.Errorf("network %q: urlTemplate or a (?P<url>...) group is required", n.Name)
+		}
+		for _, f := range n.Filters {
+			re, err := regexp.Compile(f)
+			if err != nil {
+				return nil, fmt.Errorf("network %q: invalid filter %q: %w", n.Name, f, err)
+			}
+			n.filters = append(n.filters, re)
+		}
+		n.announcerLookup = make(map[string]bool, len(n.Announcers))
+		for _, a := range n.Announcers {
+			n.announcerLookup[strings.ToLower(a)] = true
+		}
+	}
+	return &cfg, nil
+}
+
+// Announce is a release parsed from an announce line
+type Announce struct {
+	Name string `json:"name"`
+	URL  string `json:"-"` // may carry a passkey
+}
+
+// parseAnnounce matches line against the network's pattern and fills in
+// the download URL template from the named groups
+func (n *IRCNetwork) parseAnnounce(line string) (*Announce, bool) {
+	m := n.pattern.FindStringSubmatch(line)
+	if m == nil {
+		return nil, false
+	}
+	a := &Announce{}
+	replacements := make([]string, 0, 2*len(m))
+	for i, group := range n.pattern.SubexpNames() {
+		if group == "" {
+			continue
+		}
+		replacements = append(replacements, "{"+group+"}", m[i])
+		switch group {
+		case "name":
+			a.Name = strings.TrimSpace(m[i])
+		case "url":
+			a.URL = m[i]
+		}
+	}
+	if n.URLTemplate != "" {
+		a.URL = strings.NewReplacer(replacements...).Replace(n.URLTemplate)
+	}
+	return a, a.Name != "" && a.URL != ""
+}
+
+// wants reports whether a release passes the network's filters
+func (n *IRCNetwork) wants(name string) bool {
+	if len(n.filters) == 0 {
+		return true
+	}
+	for _, re := range n.filters {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ircFormatting matches mIRC color and style control codes
+var ircFormatting = regexp.MustCompile("\x03\\d{0,2}(,\\d{1,2})?|[\x02\x0f\x16\x1d\x1f]")
+
+// ircMessage is a parsed protocol line
+type ircMessage struct {
+	Nick    string
+	Command string
+	Params  []string
+}
+
+func parseIRCLine(line string) ircMessage {
+	var msg ircMessage
+	if strings.HasPrefix(line, "@") { // IRCv3 tags
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		prefix, rest, _ := strings.Cut(line[1:], " ")
+		msg.Nick, _, _ = strings.Cut(prefix, "!")
+		line = rest
+	}
+	if head, trailing, ok := strings.Cut(line, " :"); ok {
+		msg.Params = append(strings.Fields(head), trailing)
+	} else {
+		msg.Params = strings.Fields(line)
+	}
+	if len(msg.Params) > 0 {
+		msg.Command = strings.ToUpper(msg.Params[0])
+		msg.Params = msg.Params[1:]
+	}
+	return msg
+}
+
+// IRCNetworkStatus reports a connection's state for /api/irc
+type IRCNetworkStatus struct {
+	Name         string     `json:"name"`
+	Connected    bool       `json:"connected"`
+	LastError    string     `json:"lastError,omitempty"`
+	Announces    int        `json:"announces"`
+	Added        int        `json:"added"`
+	LastAnnounce *Announce  `json:"lastAnnounce,omitempty"`
+	LastAddedAt  *time.Time `json:"lastAddedAt,omitempty"`
+}
+
+// IRCListener keeps one connection per configured network and races
+// matching announces to Transmission through the Adder
+type IRCListener struct {
+	adder    *Adder
+	pause    *AutomationPause
+	networks []IRCNetwork
+	stopCh   chan struct{}
+
+	mu     sync.Mutex
+	status []IRCNetworkStatus
+	conns  map[int]net.Conn
+}
+
+// NewIRCListener creates a listener for cfg's networks
+func NewIRCListener(cfg *IRCConfig, adder *Adder) *IRCListener {
+	l := &IRCListener{
+		adder:    adder,
+		networks: cfg.Networks,
+		stopCh:   make(chan struct{}),
+		status:   make([]IRCNetworkStatus, len(cfg.Networks)),
+		conns:    make(map[int]net.Conn),
+	}
+	for i, n := range cfg.Networks {
+		l.status[i].Name = n.Name
+	}
+	return l
+}
+
+// Start connects to every network in the background
+func (l *IRCListener) Start() {
+	for i := range l.networks {
+		go l.run(i)
+	}
+}
+
+// Stop disconnects from all networks
+func (l *IRCListener) Stop() {
+	close(l.stopCh)
+	l.mu.Lock()
+	for _, c := range l.conns {
+		c.Close()
+	}
+	l.mu.Unlock()
+}
+
+// Status returns the state of every network
+func (l *IRCListener) Status() []IRCNetworkStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]IRCNetworkStatus(nil), l.status...)
+}
+
+// run keeps network i connected, backing off between attempts
+func (l *IRCListener) run(i int) {
+	n := &l.networks[i]
+	backoff := 5 * time.Second
+	for {
+		connectedAt := time.Now()
+		err := l.session(i)
+		l.mu.Lock()
+		l.status[i].Connected = false
+		if err != nil {
+			l.status[i].LastError = err.Error()
+		}
+		l.mu.Unlock()
+
+		select {
+		case <-l.stopCh:
+			return
+		default:
+		}
+
+		// A session that lasted a while resets the backoff
+		if time.Since(connectedAt) > 5*time.Minute {
+			backoff = 5 * time.Second
+		}
+		log.Printf("IRC %s disconnected (%v), reconnecting in %v", n.Name, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-l.stopCh:
+			return
+		}
+		backoff = min(backoff*2, 5*time.Minute)
+	}
+}
+
+// session runs one connection until it drops
+func (l *IRCListener) session(i int) error {
+	n := &l.networks[i]
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: time.Minute}
+	var conn net.Conn
+	var err error
+	if n.TLS {
+		host, _, _ := net.SplitHostPort(n.Server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.Server, &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12})
+	} else {
+		conn, err = dialer.Dial("tcp", n.Server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	l.mu.Lock()
+	l.conns[i] = conn
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, i)
+		l.mu.Unlock()
+	}()
+
+	send := func(format string, args ...interface{}) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(30 * time.Second)); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(conn, format+"\r\n", args...)
+		return err
+	}
+
+	if n.Password != "" {
+		if err := send("PASS %s", n.Password); err != nil {
+			return err
+		}
+	}
+	if err := send("NICK %s", n.Nick); err != nil {
+		return err
+	}
+	if err := send("USER %s 0 * :transmission-web", n.Nick); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		// Servers ping every few minutes; silence this long means a dead link
+		if err := conn.SetReadDeadline(time.Now().Add(10 * time.Minute)); err != nil {
+			return err
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		msg := parseIRCLine(strings.TrimRight(line, "\r\n"))
+
+		switch msg.Command {
+		case "PING":
+			if err := send("PONG :%s", strings.Join(msg.Params, " ")); err != nil {
+				return err
+			}
+		case "001": // welcome
+			if n.NickServ != "" {
+				if err := send("PRIVMSG NickServ :IDENTIFY %s", n.NickServ); err != nil {
+					return err
+				}
+			}
+			if err := send("JOIN %s", strings.Join(n.Channels, ",")); err != nil {
+				return err
+			}
+			l.mu.Lock()
+			l.status[i].Connected = true
+			l.status[i].LastError = ""
+			l.mu.Unlock()
+			log.Printf("IRC %s connected, joining %s", n.Name, strings.Join(n.Channels, ", "))
+		case "433": // nick in use
+			n.Nick += "_"
+			if err := send("NICK %s", n.Nick); err != nil {
+				return err
+			}
+		case "ERROR":
+			return errors.New(strings.Join(msg.Params, " "))
+		case "PRIVMSG":
+			if len(msg.Params) == 2 && strings.HasPrefix(msg.Params[0], "#") {
+				l.handleMessage(i, msg.Nick, msg.Params[1])
+			}
+		}
+	}
+}
+
+func (l *IRCListener) handleMessage(i int, nick, text string) {
+	n := &l.networks[i]
+	if len(n.announcerLookup) > 0 && !n.announcerLookup[strings.ToLower(nick)] {
+		return
+	}
+	a, ok := n.parseAnnounce(ircFormatting.ReplaceAllString(text, ""))
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	l.status[i].Announces++
+	l.status[i].LastAnnounce = a
+	l.mu.Unlock()
+
+	if !n.wants(a.Name) || l.pause.Active() {
+		return
+	}
+
+	// Racing: add immediately, but off the read loop so PINGs keep flowing
+	go func() {
+		added, err := l.adder.Add(AddRequest{URL: a.URL, Source: SourceIRC})
+		var dup *DuplicateAddError
+		switch {
+		case errors.As(err, &dup):
+			log.Printf("IRC %s: already added by %s: %s", n.Name, dup.WonBy, a.Name)
+			return
+		case err != nil:
+			log.Printf("IRC %s: failed to add %s: %v", n.Name, a.Name, err)
+			return
+		}
+		log.Printf("IRC %s: added %s", n.Name, added.Name)
+		now := time.Now()
+		l.mu.Lock()
+		l.status[i].Added++
+		l.status[i].LastAddedAt = &now
+		l.mu.Unlock()
+	}()
+}
+
+func (s *Server) handleIRCStatus(w http.ResponseWriter, _ *http.Request) {
+	if s.irc == nil {
+		writeJSON(w, map[string]interface{}{"enabled": false, "networks": []IRCNetworkStatus{}})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"enabled": true, "networks": s.irc.Status()})
+}
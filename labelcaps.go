@@ -0,0 +1,480 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const defaultLabelCapInterval = 30 * time.Second
+
+// LabelCap shares a transfer rate between the torrents carrying Label.
+// Transmission has no limits of its own for labels, so the cap is split
+// evenly between the label's active torrents and set on each of them with
+// torrent-set, again every LABEL_CAP_INTERVAL as torrents start and stop.
+type LabelCap struct {
+	ID      int    `json:"id"`
+	Label   string `json:"label"`
+	Enabled bool   `json:"enabled"`
+	Down    *int   `json:"down,omitempty"` // KB/s, shared by the downloading torrents
+	Up      *int   `json:"up,omitempty"`   // KB/s, shared by the uploading torrents
+}
+
+// Validate checks the cap names one label and at least one rate
+func (c *LabelCap) Validate() error {
+	labels, err := normalizeLabels([]string{c.Label})
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("label is required")
+	}
+	c.Label = labels[0]
+	if c.Down == nil && c.Up == nil {
+		return fmt.Errorf("give down, up or both")
+	}
+	for name, v := range map[string]*int{"down": c.Down, "up": c.Up} {
+		if v != nil && *v < 1 {
+			return fmt.Errorf("%s must be at least 1 KB/s", name)
+		}
+	}
+	return nil
+}
+
+// LabelCapShare is what a cap last gave each of its torrents
+type LabelCapShare struct {
+	Downloading int  `json:"downloading"`
+	Uploading   int  `json:"uploading"`
+	Down        *int `json:"down,omitempty"` // KB/s per downloading torrent
+	Up          *int `json:"up,omitempty"`   // KB/s per uploading torrent
+}
+
+// labelCapTarget is the per-torrent limit the caps covering a torrent
+// want: the smallest share, when it's under several
+type labelCapTarget struct {
+	down, up *int
+}
+
+// LabelCaps applies the label caps to the default daemon's torrents. The
+// limits a torrent had before it was first capped are kept in SQLite and
+// put back once no cap covers it, so a restart doesn't lose them.
+type LabelCaps struct {
+	db       *sql.DB
+	client   *TransmissionClient
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	saved   map[string]SpeedLimits // by hash: the torrent's own limits
+	shares  map[int]LabelCapShare  // by cap ID
+}
+
+// NewLabelCaps creates the label_caps table, and label_cap_saved for the
+// limits of the torrents being capped
+func NewLabelCaps(db *sql.DB, client *TransmissionClient, interval time.Duration) (*LabelCaps, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS label_caps (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		label TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		down INTEGER,
+		up INTEGER
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create label_caps table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS label_cap_saved (
+		hash TEXT PRIMARY KEY,
+		download_limit INTEGER NOT NULL,
+		download_limited INTEGER NOT NULL,
+		upload_limit INTEGER NOT NULL,
+		upload_limited INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create label_cap_saved table: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultLabelCapInterval
+	}
+	lc := &LabelCaps{db: db, client: client, interval: interval, saved: map[string]SpeedLimits{}, shares: map[int]LabelCapShare{}}
+
+	rows, err := db.Query("SELECT hash, download_limit, download_limited, upload_limit, upload_limited FROM label_cap_saved")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var down, up int
+		var downLimited, upLimited bool
+		if err := rows.Scan(&hash, &down, &downLimited, &up, &upLimited); err != nil {
+			return nil, err
+		}
+		lc.saved[hash] = SpeedLimits{DownloadLimit: &down, DownloadLimited: &downLimited, UploadLimit: &up, UploadLimited: &upLimited}
+	}
+	return lc, rows.Err()
+}
+
+// GetCaps returns all caps
+func (lc *LabelCaps) GetCaps() ([]LabelCap, error) {
+	rows, err := lc.db.Query("SELECT id, label, enabled, down, up FROM label_caps ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	caps := []LabelCap{}
+	for rows.Next() {
+		var c LabelCap
+		var down, up sql.NullInt64
+		if err := rows.Scan(&c.ID, &c.Label, &c.Enabled, &down, &up); err != nil {
+			return nil, err
+		}
+		if down.Valid {
+			v := int(down.Int64)
+			c.Down = &v
+		}
+		if up.Valid {
+			v := int(up.Int64)
+			c.Up = &v
+		}
+		caps = append(caps, c)
+	}
+	return caps, rows.Err()
+}
+
+// AddCap validates and stores a new cap
+func (lc *LabelCaps) AddCap(c *LabelCap) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	result, err := lc.db.Exec("INSERT INTO label_caps (label, enabled, down, up) VALUES (?, ?, ?, ?)",
+		c.Label, c.Enabled, c.Down, c.Up)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	c.ID = int(id)
+	lc.recheck()
+	return nil
+}
+
+// UpdateCap validates and replaces an existing cap
+func (lc *LabelCaps) UpdateCap(c *LabelCap) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	_, err := lc.db.Exec("UPDATE label_caps SET label = ?, enabled = ?, down = ?, up = ? WHERE id = ?",
+		c.Label, c.Enabled, c.Down, c.Up, c.ID)
+	if err != nil {
+		return err
+	}
+	lc.recheck()
+	return nil
+}
+
+// DeleteCap deletes a cap; its torrents get their own limits back on the
+// next poll
+func (lc *LabelCaps) DeleteCap(id int) error {
+	if _, err := lc.db.Exec("DELETE FROM label_caps WHERE id = ?", id); err != nil {
+		return err
+	}
+	lc.recheck()
+	return nil
+}
+
+// recheck makes the next poll apply the caps
+func (lc *LabelCaps) recheck() {
+	lc.mu.Lock()
+	lc.lastRun = time.Time{}
+	lc.mu.Unlock()
+}
+
+// Shares returns what each cap last gave its torrents, by cap ID
+func (lc *LabelCaps) Shares() map[int]LabelCapShare {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	return maps.Clone(lc.shares)
+}
+
+// splitRate splits rate between n torrents, giving each at least 1 KB/s
+func splitRate(rate *int, n int) *int {
+	if rate == nil || n == 0 {
+		return nil
+	}
+	v := max(*rate/n, 1)
+	return &v
+}
+
+func minLimit(a, b *int) *int {
+	if a == nil || (b != nil && *b < *a) {
+		return b
+	}
+	return a
+}
+
+// OnSnapshot reapplies the caps, at most once per interval
+func (lc *LabelCaps) OnSnapshot(_, cur *Snapshot) {
+	lc.mu.Lock()
+	if cur.Time.Sub(lc.lastRun) < lc.interval {
+		lc.mu.Unlock()
+		return
+	}
+	lc.lastRun = cur.Time
+	idle := len(lc.saved) == 0
+	lc.mu.Unlock()
+
+	caps, err := lc.GetCaps()
+	if err != nil {
+		log.Printf("Failed to load label caps: %v", err)
+		return
+	}
+	caps = slices.DeleteFunc(caps, func(c LabelCap) bool { return !c.Enabled })
+	if len(caps) == 0 && idle {
+		return
+	}
+
+	targets := map[string]labelCapTarget{}
+	shares := map[int]LabelCapShare{}
+	for _, c := range caps {
+		var downloading, uploading []string
+		for i := range cur.Torrents {
+			t := &cur.Torrents[i]
+			if !slices.Contains(t.Labels, c.Label) {
+				continue
+			}
+			if _, ok := targets[t.HashString]; !ok {
+				targets[t.HashString] = labelCapTarget{} // covered, though maybe idle
+			}
+			if t.Status == 4 {
+				downloading = append(downloading, t.HashString)
+			}
+			if t.Status == 4 || t.Status == 6 {
+				uploading = append(uploading, t.HashString)
+			}
+		}
+		s := LabelCapShare{Downloading: len(downloading), Uploading: len(uploading), Down: splitRate(c.Down, len(downloading)), Up: splitRate(c.Up, len(uploading))}
+		shares[c.ID] = s
+		for _, hash := range downloading {
+			tgt := targets[hash]
+			tgt.down = minLimit(tgt.down, s.Down)
+			targets[hash] = tgt
+		}
+		for _, hash := range uploading {
+			tgt := targets[hash]
+			tgt.up = minLimit(tgt.up, s.Up)
+			targets[hash] = tgt
+		}
+	}
+
+	present := make(map[string]bool, len(cur.Torrents))
+	for i := range cur.Torrents {
+		t := &cur.Torrents[i]
+		present[t.HashString] = true
+		tgt, covered := targets[t.HashString]
+		lc.mu.Lock()
+		own, saved := lc.saved[t.HashString]
+		lc.mu.Unlock()
+		switch {
+		case covered && !saved:
+			down, downLimited, up, upLimited := t.DownloadLimit, t.DownloadLimited, t.UploadLimit, t.UploadLimited
+			own = SpeedLimits{DownloadLimit: &down, DownloadLimited: &downLimited, UploadLimit: &up, UploadLimited: &upLimited}
+			if err := lc.save(t.HashString, own); err != nil {
+				log.Printf("Failed to save %s's speed limits: %v", t.Name, err)
+				continue
+			}
+		case !covered && saved:
+			if err := lc.restore(t, own); err != nil {
+				log.Printf("Failed to restore %s's speed limits: %v", t.Name, err)
+			}
+			continue
+		case !covered:
+			continue
+		}
+		if err := lc.apply(t, tgt, own); err != nil {
+			log.Printf("Failed to cap %s's speed: %v", t.Name, err)
+		}
+	}
+
+	// Torrents that went away have nothing to restore
+	lc.mu.Lock()
+	lc.shares = shares
+	var gone []string
+	for hash := range lc.saved {
+		if !present[hash] {
+			gone = append(gone, hash)
+			delete(lc.saved, hash)
+		}
+	}
+	lc.mu.Unlock()
+	for _, hash := range gone {
+		if _, err := lc.db.Exec("DELETE FROM label_cap_saved WHERE hash = ?", hash); err != nil {
+			log.Printf("Failed to forget saved speed limits: %v", err)
+		}
+	}
+}
+
+// save keeps a torrent's own limits before it's first capped
+func (lc *LabelCaps) save(hash string, own SpeedLimits) error {
+	_, err := lc.db.Exec("INSERT OR REPLACE INTO label_cap_saved (hash, download_limit, download_limited, upload_limit, upload_limited) VALUES (?, ?, ?, ?, ?)",
+		hash, *own.DownloadLimit, *own.DownloadLimited, *own.UploadLimit, *own.UploadLimited)
+	if err != nil {
+		return err
+	}
+	lc.mu.Lock()
+	lc.saved[hash] = own
+	lc.mu.Unlock()
+	return nil
+}
+
+// SetOwn records speed limits set by hand on a torrent the caps are
+// holding, so they're what it goes back to once it's no longer capped
+// rather than what it had before
+func (lc *LabelCaps) SetOwn(id int, set *SpeedLimits) error {
+	lc.mu.Lock()
+	idle := len(lc.saved) == 0
+	lc.mu.Unlock()
+	if idle {
+		return nil
+	}
+	t, err := lc.client.GetTorrent(id)
+	if err != nil {
+		return err
+	}
+	lc.mu.Lock()
+	own, ok := lc.saved[t.HashString]
+	lc.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if set.DownloadLimit != nil {
+		own.DownloadLimit = set.DownloadLimit
+	}
+	if set.DownloadLimited != nil {
+		own.DownloadLimited = set.DownloadLimited
+	}
+	if set.UploadLimit != nil {
+		own.UploadLimit = set.UploadLimit
+	}
+	if set.UploadLimited != nil {
+		own.UploadLimited = set.UploadLimited
+	}
+	return lc.save(t.HashString, own)
+}
+
+// apply sets t's limits to the target, with the torrent's own limits for
+// a direction the caps don't limit right now or where its own limit is
+// already stricter than its share. Only changes are sent.
+func (lc *LabelCaps) apply(t *Torrent, tgt labelCapTarget, own SpeedLimits) error {
+	want := own
+	limited := true
+	if tgt.down != nil {
+		down := *tgt.down
+		if *own.DownloadLimited {
+			down = min(down, *own.DownloadLimit)
+		}
+		want.DownloadLimit, want.DownloadLimited = &down, &limited
+	}
+	if tgt.up != nil {
+		up := *tgt.up
+		if *own.UploadLimited {
+			up = min(up, *own.UploadLimit)
+		}
+		want.UploadLimit, want.UploadLimited = &up, &limited
+	}
+	change := speedLimitChanges(t, want)
+	if change == nil {
+		return nil
+	}
+	return lc.client.SetTorrentSpeedLimits(t.ID, change)
+}
+
+// restore puts back t's own limits and forgets them
+func (lc *LabelCaps) restore(t *Torrent, own SpeedLimits) error {
+	if change := speedLimitChanges(t, own); change != nil {
+		if err := lc.client.SetTorrentSpeedLimits(t.ID, change); err != nil {
+			return err
+		}
+	}
+	if _, err := lc.db.Exec("DELETE FROM label_cap_saved WHERE hash = ?", t.HashString); err != nil {
+		return err
+	}
+	lc.mu.Lock()
+	delete(lc.saved, t.HashString)
+	lc.mu.Unlock()
+	return nil
+}
+
+// speedLimitChanges returns the fields of want that differ from t's
+// current limits, or nil when there are none
+func speedLimitChanges(t *Torrent, want SpeedLimits) *SpeedLimits {
+	var change SpeedLimits
+	differs := false
+	if *want.DownloadLimited != t.DownloadLimited {
+		change.DownloadLimited, differs = want.DownloadLimited, true
+	}
+	if *want.DownloadLimit != t.DownloadLimit {
+		change.DownloadLimit, differs = want.DownloadLimit, true
+	}
+	if *want.UploadLimited != t.UploadLimited {
+		change.UploadLimited, differs = want.UploadLimited, true
+	}
+	if *want.UploadLimit != t.UploadLimit {
+		change.UploadLimit, differs = want.UploadLimit, true
+	}
+	if !differs {
+		return nil
+	}
+	return &change
+}
+
+func (s *Server) handleGetLabelCaps(w http.ResponseWriter, _ *http.Request) {
+	caps, err := s.labelCaps.GetCaps()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"caps": caps, "shares": s.labelCaps.Shares()})
+}
+
+func (s *Server) handleAddLabelCap(w http.ResponseWriter, r *http.Request) {
+	c := LabelCap{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.labelCaps.AddCap(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, c)
+}
+
+func (s *Server) handleUpdateLabelCap(w http.ResponseWriter, r *http.Request) {
+	var c LabelCap
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.labelCaps.UpdateCap(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, c)
+}
+
+func (s *Server) handleDeleteLabelCap(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.labelCaps.DeleteCap(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// maxLabelLength keeps labels short enough to show as badges
+const maxLabelLength = 64
+
+// normalizeLabels trims labels, drops empty ones and duplicates, and
+// rejects the ones Transmission would: labels can't contain commas
+func normalizeLabels(labels []string) ([]string, error) {
+	out := []string{}
+	for _, l := range labels {
+		l = strings.TrimSpace(l)
+		if l == "" || slices.Contains(out, l) {
+			continue
+		}
+		if strings.Contains(l, ",") {
+			return nil, fmt.Errorf("label %q contains a comma", l)
+		}
+		if len(l) > maxLabelLength {
+			return nil, fmt.Errorf("label %q is longer than %d characters", l, maxLabelLength)
+		}
+		out = append(out, l)
+	}
+	return out, nil
+}
+
+// parseLabelList splits a comma-separated label list, as typed into the
+// label editor or passed to the torrent.labels command
+func parseLabelList(s string) ([]string, error) {
+	return normalizeLabels(strings.Split(s, ","))
+}
+
+// errNoLabels is returned by label edits that would change nothing
+var errNoLabels = errors.New("no labels given")
+
+// editLabels applies additions and removals to a torrent's labels,
+// keeping their order
+func editLabels(current, add, remove []string) []string {
+	out := []string{}
+	for _, l := range current {
+		if !slices.Contains(remove, l) {
+			out = append(out, l)
+		}
+	}
+	for _, l := range add {
+		if !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// LabelCount is a label and how many torrents carry it, for the label
+// filter's choices
+type LabelCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// labels lists the labels in the index by use
+func (ix *torrentIndex) labels() []LabelCount {
+	counts := []LabelCount{}
+	for name, positions := range ix.byLabel {
+		counts = append(counts, LabelCount{Name: name, Count: len(positions)})
+	}
+	slices.SortFunc(counts, func(a, b LabelCount) int {
+		if c := compareInt(int64(b.Count), int64(a.Count)); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return counts
+}
+
+// labelEdit changes a torrent's labels. Set replaces them outright (an
+// empty, non-nil Set clears them); otherwise Add and Remove are applied to
+// the current labels.
+type labelEdit struct {
+	Set    []string
+	Add    []string
+	Remove []string
+}
+
+func (e *labelEdit) normalize() error {
+	var err error
+	if e.Set != nil {
+		if e.Set, err = normalizeLabels(e.Set); err != nil {
+			return err
+		}
+	} else if len(e.Add) == 0 && len(e.Remove) == 0 {
+		return errNoLabels
+	}
+	if e.Add, err = normalizeLabels(e.Add); err != nil {
+		return err
+	}
+	e.Remove, err = normalizeLabels(e.Remove)
+	return err
+}
+
+// apply normalizes the edit and sends it, returning the torrent's labels
+func (e labelEdit) apply(c *TransmissionClient, id int) ([]string, error) {
+	if err := e.normalize(); err != nil {
+		return nil, err
+	}
+	labels := e.Set
+	if labels == nil {
+		t, err := c.GetTorrent(id)
+		if err != nil {
+			return nil, err
+		}
+		labels = t.Labels
+	}
+	labels = editLabels(labels, e.Add, e.Remove)
+	return labels, c.SetLabels(id, labels)
+}
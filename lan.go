@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// isLANAddress reports whether addr is in a private (RFC1918/RFC4193),
+// loopback or link-local range
+func isLANAddress(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
+
+// markLANPeers flags peers connecting from the local network
+func markLANPeers(peers []Peer) {
+	for i := range peers {
+		peers[i].IsLAN = isLANAddress(peers[i].Address)
+	}
+}
+
+// GetLPDEnabled reports whether Local Peer Discovery is enabled
+func (c *TransmissionClient) GetLPDEnabled() (bool, error) {
+	req := &RPCRequest{
+		Method:    "session-get",
+		Arguments: map[string]interface{}{"fields": []string{"lpd-enabled"}},
+	}
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return false, err
+	}
+
+	var result struct {
+		LPDEnabled bool `json:"lpd-enabled"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return false, err
+	}
+	return result.LPDEnabled, nil
+}
+
+// SetLPDEnabled toggles Local Peer Discovery (announcing to LAN peers)
+func (c *TransmissionClient) SetLPDEnabled(enabled bool) error {
+	req := &RPCRequest{
+		Method:    "session-set",
+		Arguments: map[string]interface{}{"lpd-enabled": enabled},
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
+func (s *Server) handleLPD(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, "invalid request")
+			return
+		}
+		if err := s.client.SetLPDEnabled(req.Enabled); err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+	}
+
+	enabled, err := s.client.GetLPDEnabled()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]bool{"enabled": enabled})
+}
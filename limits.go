@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Seed limit modes for seedRatioMode and seedIdleMode
+const (
+	SeedModeGlobal    = 0 // follow the session's limit
+	SeedModeSingle    = 1 // use this torrent's own limit
+	SeedModeUnlimited = 2 // seed forever
+)
+
+// SeedLimits are a torrent's own seeding limits: stop at a ratio, or after
+// the given minutes without uploading. Nil fields are left unchanged by
+// SetTorrentLimits.
+type SeedLimits struct {
+	RatioMode  *int     `json:"seedRatioMode,omitempty"`
+	RatioLimit *float64 `json:"seedRatioLimit,omitempty"`
+	IdleMode   *int     `json:"seedIdleMode,omitempty"`
+	IdleLimit  *int     `json:"seedIdleLimit,omitempty"` // minutes
+}
+
+// seedLimitsOf returns the limits a torrent-get reported for t
+func seedLimitsOf(t *Torrent) *SeedLimits {
+	return &SeedLimits{
+		RatioMode:  &t.SeedRatioMode,
+		RatioLimit: &t.SeedRatioLimit,
+		IdleMode:   &t.SeedIdleMode,
+		IdleLimit:  &t.SeedIdleLimit,
+	}
+}
+
+// Validate checks the modes are known and the limits usable
+func (l *SeedLimits) Validate() error {
+	for _, mode := range []*int{l.RatioMode, l.IdleMode} {
+		if mode != nil && (*mode < SeedModeGlobal || *mode > SeedModeUnlimited) {
+			return fmt.Errorf("seed limit mode must be 0 (global), 1 (this torrent) or 2 (unlimited)")
+		}
+	}
+	if l.RatioLimit != nil && *l.RatioLimit < 0 {
+		return fmt.Errorf("seed ratio limit can't be negative")
+	}
+	if l.IdleLimit != nil && (*l.IdleLimit < 1 || *l.IdleLimit > 65535) {
+		return fmt.Errorf("seed idle limit must be between 1 and 65535 minutes")
+	}
+	return nil
+}
+
+// SetTorrentLimits applies the non-nil seeding limits to a torrent
+func (c *TransmissionClient) SetTorrentLimits(id int, limits *SeedLimits) error {
+	args := make(map[string]interface{})
+	if limits.RatioMode != nil {
+		args["seedRatioMode"] = *limits.RatioMode
+	}
+	if limits.RatioLimit != nil {
+		args["seedRatioLimit"] = *limits.RatioLimit
+	}
+	if limits.IdleMode != nil {
+		args["seedIdleMode"] = *limits.IdleMode
+	}
+	if limits.IdleLimit != nil {
+		args["seedIdleLimit"] = *limits.IdleLimit
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.setTorrent(id, args)
+}
+
+// SpeedLimits cap a torrent's own transfer rates, in kB/s, when the
+// matching Limited flag is on. Nil fields are left unchanged by
+// SetTorrentSpeedLimits.
+type SpeedLimits struct {
+	DownloadLimit   *int  `json:"downloadLimit,omitempty"`
+	DownloadLimited *bool `json:"downloadLimited,omitempty"`
+	UploadLimit     *int  `json:"uploadLimit,omitempty"`
+	UploadLimited   *bool `json:"uploadLimited,omitempty"`
+}
+
+// speedLimitsOf returns the speed limits a torrent-get reported for t
+func speedLimitsOf(t *Torrent) *SpeedLimits {
+	return &SpeedLimits{
+		DownloadLimit:   &t.DownloadLimit,
+		DownloadLimited: &t.DownloadLimited,
+		UploadLimit:     &t.UploadLimit,
+		UploadLimited:   &t.UploadLimited,
+	}
+}
+
+// Validate checks the limits are rates the daemon accepts
+func (l *SpeedLimits) Validate() error {
+	for _, limit := range []*int{l.DownloadLimit, l.UploadLimit} {
+		if limit != nil && *limit < 0 {
+			return fmt.Errorf("speed limits can't be negative")
+		}
+	}
+	return nil
+}
+
+// SetTorrentSpeedLimits applies the non-nil speed limits to a torrent
+func (c *TransmissionClient) SetTorrentSpeedLimits(id int, limits *SpeedLimits) error {
+	args := make(map[string]interface{})
+	if limits.DownloadLimit != nil {
+		args["downloadLimit"] = *limits.DownloadLimit
+	}
+	if limits.DownloadLimited != nil {
+		args["downloadLimited"] = *limits.DownloadLimited
+	}
+	if limits.UploadLimit != nil {
+		args["uploadLimit"] = *limits.UploadLimit
+	}
+	if limits.UploadLimited != nil {
+		args["uploadLimited"] = *limits.UploadLimited
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.setTorrent(id, args)
+}
+
+// TorrentLimits is the body of /api/torrent/{id}/limits: seeding and speed
+// limits side by side, each field optional
+type TorrentLimits struct {
+	SeedLimits
+	SpeedLimits
+}
+
+func (s *Server) handleGetLimits(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	t, err := client.GetTorrent(id)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, TorrentLimits{*seedLimitsOf(t), *speedLimitsOf(t)})
+}
+
+func (s *Server) handleSetLimits(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var limits TorrentLimits
+	if err := json.NewDecoder(r.Body).Decode(&limits); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := limits.SeedLimits.Validate(); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if err := limits.SpeedLimits.Validate(); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	if err := client.SetTorrentLimits(id, &limits.SeedLimits); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	if err := client.SetTorrentSpeedLimits(id, &limits.SpeedLimits); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	// Label caps only run on the default daemon
+	if client == s.client {
+		if err := s.labelCaps.SetOwn(id, &limits.SpeedLimits); err != nil {
+			log.Printf("Failed to update saved speed limits: %v", err)
+		}
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// windowsPath matches an absolute path on a Windows daemon
+var windowsPath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// SetTorrentLocation points a torrent at a new directory. With move the
+// daemon moves the data there; without it the data is expected to be there
+// already.
+func (c *TransmissionClient) SetTorrentLocation(id int, location string, move bool) error {
+	req := &RPCRequest{
+		Method: "torrent-set-location",
+		Arguments: map[string]interface{}{
+			"ids":      []int{id},
+			"location": location,
+			"move":     move,
+		},
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
+// cleanLocation checks a target directory is absolute on the daemon's side
+func cleanLocation(location string) (string, error) {
+	location = strings.TrimSpace(location)
+	switch {
+	case location == "":
+		return "", fmt.Errorf("location is required")
+	case windowsPath.MatchString(location):
+		return location, nil
+	case !strings.HasPrefix(location, "/"):
+		return "", fmt.Errorf("location must be an absolute path")
+	}
+	return path.Clean(location), nil
+}
+
+// nearestFreeSpace reports free space at dir, or at its closest existing
+// parent because the daemon creates missing directories when moving
+func nearestFreeSpace(c *TransmissionClient, dir string) (*FreeSpace, error) {
+	for {
+		free, err := c.GetFreeSpace(dir)
+		if err == nil || windowsPath.MatchString(dir) {
+			return free, err
+		}
+		parent := path.Dir(dir)
+		if parent == dir {
+			return nil, err
+		}
+		dir = parent
+	}
+}
+
+// cleanAddDir checks a download directory given with an add is absolute
+// and somewhere the daemon can report free space for. "" is left as it
+// is, for the daemon's default.
+func cleanAddDir(c *TransmissionClient, dir string) (string, error) {
+	if strings.TrimSpace(dir) == "" {
+		return "", nil
+	}
+	dir, err := cleanLocation(dir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := nearestFreeSpace(c, dir); err != nil {
+		return "", fmt.Errorf("couldn't check free space at %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// checkMoveSpace makes sure the target can hold the torrent's data. A
+// target reporting the same capacity and free space as the current
+// directory is taken to be the same filesystem, where a move is a rename.
+func checkMoveSpace(c *TransmissionClient, t *Torrent, target string) error {
+	dest, err := nearestFreeSpace(c, target)
+	if err != nil {
+		return fmt.Errorf("couldn't check free space at %s: %w", target, err)
+	}
+	if src, err := c.GetFreeSpace(t.DownloadDir); err == nil && src.TotalSize > 0 &&
+		src.TotalSize == dest.TotalSize && src.SizeBytes == dest.SizeBytes {
+		return nil
+	}
+	need := int64(float64(t.SizeWhenDone) * t.PercentDone)
+	if dest.SizeBytes < need {
+		return fmt.Errorf("not enough space at %s: %s needed, %s free", target, display.Bytes(need), display.Bytes(dest.SizeBytes))
+	}
+	return nil
+}
+
+// moveTorrent validates and issues a set-location, returning the cleaned
+// target
+func moveTorrent(c *TransmissionClient, id int, location string, move bool) (string, error) {
+	target, err := cleanLocation(location)
+	if err != nil {
+		return "", err
+	}
+	t, err := c.GetTorrent(id)
+	if err != nil {
+		return "", err
+	}
+	if target == t.DownloadDir {
+		return "", fmt.Errorf("torrent is already in %s", target)
+	}
+	if move {
+		if err := checkMoveSpace(c, t, target); err != nil {
+			return "", err
+		}
+	}
+	return target, c.SetTorrentLocation(id, target, move)
+}
+
+// handleMoveTorrent moves a torrent's data, or with "move": false only
+// tells the daemon where the data already is
+func (s *Server) handleMoveTorrent(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	req := struct {
+		Location string `json:"location"`
+		Move     *bool  `json:"move"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	move := req.Move == nil || *req.Move
+	target, err := moveTorrent(client, id, req.Location, move)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "ok", "location": target, "moved": move})
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLogBufferSize  = 1000
+	defaultLogPersistKeep = 10000
+
+	logTimeFormat = "2006/01/02 15:04:05 "
+)
+
+// Log levels, least to most severe
+const (
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+func logLevelRank(level string) int {
+	switch level {
+	case LogWarn:
+		return 1
+	case LogError:
+		return 2
+	}
+	return 0
+}
+
+// logLevel guesses a level from the message, since the app logs through the
+// standard logger without one. Errors are checked first, so "Failed to add
+// invalid torrent" isn't filed as a warning.
+func logLevel(msg string) string {
+	lower := strings.ToLower(msg)
+	for _, marker := range []string{"❌", "failed", "error", "unavailable", "couldn't", "unable"} {
+		if strings.Contains(lower, marker) {
+			return LogError
+		}
+	}
+	for _, marker := range []string{"⚠", "🚫", "⏭", "warning", "invalid", "retrying", "backing off", "rejected", "disabled"} {
+		if strings.Contains(lower, marker) {
+			return LogWarn
+		}
+	}
+	return LogInfo
+}
+
+// logSubsystem names the source file that called the logger, e.g. "feeds"
+// for feeds.go
+func logSubsystem() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "log.") && !strings.Contains(frame.Function, "LogBuffer") {
+			return strings.TrimSuffix(filepath.Base(frame.File), ".go")
+		}
+		if !more {
+			return "main"
+		}
+	}
+}
+
+// LogEntry is one captured log line
+type LogEntry struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Subsystem string    `json:"subsystem"`
+	Message   string    `json:"message"`
+}
+
+func (e LogEntry) String() string {
+	return fmt.Sprintf("%s %-5s [%s] %s", e.Time.Format(time.RFC3339), strings.ToUpper(e.Level), e.Subsystem, e.Message)
+}
+
+// LogFilter selects entries; zero values match everything
+type LogFilter struct {
+	Level     string // minimum level
+	Subsystem string
+	Query     string // case-insensitive substring
+	After     int64  // only entries with a higher ID
+	Limit     int    // newest entries kept when more match
+}
+
+func (f LogFilter) match(e LogEntry) bool {
+	if logLevelRank(e.Level) < logLevelRank(f.Level) {
+		return false
+	}
+	if f.Subsystem != "" && e.Subsystem != f.Subsystem {
+		return false
+	}
+	if f.After > 0 && e.ID <= f.After {
+		return false
+	}
+	return f.Query == "" || strings.Contains(strings.ToLower(e.Message), strings.ToLower(f.Query))
+}
+
+// LogBuffer is the standard logger's output: it passes lines through to out
+// and keeps the most recent ones in a ring for the log viewer. With Persist
+// they're also written to SQLite, so the viewer survives restarts.
+type LogBuffer struct {
+	out io.Writer
+
+	mu      sync.Mutex
+	entries []LogEntry // ring, oldest at next once full
+	next    int
+	full    bool
+	seq     int64
+	persist chan LogEntry // nil until Persist
+}
+
+// NewLogBuffer creates a buffer keeping size entries
+func NewLogBuffer(out io.Writer, size int) *LogBuffer {
+	if size <= 0 {
+		size = defaultLogBufferSize
+	}
+	return &LogBuffer{out: out, entries: make([]LogEntry, size)}
+}
+
+// Write implements io.Writer for log.SetOutput. The logger's own flags must
+// be 0; the timestamp is added here so stderr looks the same as before.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	now := time.Now()
+	msg := strings.TrimSpace(string(p))
+	entry := LogEntry{Time: now, Level: logLevel(msg), Subsystem: logSubsystem(), Message: msg}
+	b.add(entry)
+
+	if _, err := io.WriteString(b.out, now.Format(logTimeFormat)); err != nil {
+		return 0, err
+	}
+	return b.out.Write(p)
+}
+
+func (b *LogBuffer) add(entry LogEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addLocked(entry)
+}
+
+func (b *LogBuffer) addLocked(entry LogEntry) {
+	b.seq++
+	entry.ID = b.seq
+	b.entries[b.next] = entry
+	b.next = (b.next + 1) % len(b.entries)
+	if b.next == 0 {
+		b.full = true
+	}
+	if b.persist != nil {
+		select {
+		case b.persist <- entry:
+		default: // SQLite is behind; the ring still has it
+		}
+	}
+}
+
+// snapshot returns the buffered entries, oldest first
+func (b *LogBuffer) snapshot() []LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.snapshotLocked()
+}
+
+func (b *LogBuffer) snapshotLocked() []LogEntry {
+	if !b.full {
+		return append([]LogEntry(nil), b.entries[:b.next]...)
+	}
+	return append(append([]LogEntry(nil), b.entries[b.next:]...), b.entries[:b.next]...)
+}
+
+// Entries returns the matching entries, oldest first
+func (b *LogBuffer) Entries(f LogFilter) []LogEntry {
+	matched := []LogEntry{}
+	for _, e := range b.snapshot() {
+		if f.match(e) {
+			matched = append(matched, e)
+		}
+	}
+	if f.Limit > 0 && len(matched) > f.Limit {
+		matched = matched[len(matched)-f.Limit:]
+	}
+	return matched
+}
+
+// Subsystems lists the subsystems in the buffer
+func (b *LogBuffer) Subsystems() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range b.snapshot() {
+		if !seen[e.Subsystem] {
+			seen[e.Subsystem] = true
+			names = append(names, e.Subsystem)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Persist stores entries in the app_logs table, keeping the newest keep
+// rows. Entries from before the restart are loaded back into the ring ahead
+// of the ones logged since startup.
+func (b *LogBuffer) Persist(db *sql.DB, keep int) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS app_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time DATETIME NOT NULL,
+		level TEXT NOT NULL,
+		subsystem TEXT NOT NULL,
+		message TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create app_logs table: %w", err)
+	}
+	if keep <= 0 {
+		keep = defaultLogPersistKeep
+	}
+
+	rows, err := db.Query(`SELECT time, level, subsystem, message FROM
+		(SELECT * FROM app_logs ORDER BY id DESC LIMIT ?) ORDER BY id`, len(b.entries))
+	if err != nil {
+		return err
+	}
+	var stored []LogEntry
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.Time, &e.Level, &e.Subsystem, &e.Message); err != nil {
+			rows.Close()
+			return err
+		}
+		stored = append(stored, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Renumber everything so IDs stay in order across the restart; only
+	// the entries from this run are new to SQLite
+	queue := make(chan LogEntry, 256)
+	go b.store(db, queue, keep)
+	b.mu.Lock()
+	current := b.snapshotLocked()
+	b.next, b.full, b.seq = 0, false, 0
+	for _, e := range stored {
+		b.addLocked(e)
+	}
+	b.persist = queue
+	for _, e := range current {
+		b.addLocked(e)
+	}
+	b.mu.Unlock()
+	return nil
+}
+
+// store writes queued entries. Failures go straight to out: logging them
+// would queue another entry.
+func (b *LogBuffer) store(db *sql.DB, queue <-chan LogEntry, keep int) {
+	inserted := 0
+	for e := range queue {
+		res, err := db.Exec("INSERT INTO app_logs (time, level, subsystem, message) VALUES (?, ?, ?, ?)",
+			e.Time, e.Level, e.Subsystem, e.Message)
+		if err != nil {
+			fmt.Fprintf(b.out, "%sFailed to store log entry: %v\n", time.Now().Format(logTimeFormat), err)
+			continue
+		}
+		if inserted++; inserted%100 != 0 {
+			continue
+		}
+		if id, err := res.LastInsertId(); err == nil {
+			if _, err := db.Exec("DELETE FROM app_logs WHERE id <= ?", id-int64(keep)); err != nil {
+				fmt.Fprintf(b.out, "%sFailed to prune stored logs: %v\n", time.Now().Format(logTimeFormat), err)
+			}
+		}
+	}
+}
+
+// logFilter reads the viewer's query parameters
+func logFilter(r *http.Request) LogFilter {
+	q := r.URL.Query()
+	f := LogFilter{Level: q.Get("level"), Subsystem: q.Get("subsystem"), Query: q.Get("q")}
+	f.After, _ = strconv.ParseInt(q.Get("after"), 10, 64)
+	f.Limit, _ = strconv.Atoi(q.Get("limit"))
+	return f
+}
+
+// handleLogs serves matching entries as JSON; ?after= lets a viewer poll
+// for new ones
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.logEntries(logFilter(r))
+	resp := map[string]interface{}{
+		"entries":    entries,
+		"subsystems": s.logSubsystems(),
+	}
+	if err != nil {
+		resp["daemonError"] = err.Error()
+	}
+	writeJSON(w, resp)
+}
+
+// logSubsystems lists the app's subsystems, plus "daemon" when its log is
+// configured
+func (s *Server) logSubsystems() []string {
+	names := s.logs.Subsystems()
+	if s.daemonLog != nil {
+		names = append(names, "daemon")
+		sort.Strings(names)
+	}
+	return names
+}
+
+// handleLogsDownload serves matching entries as a text file
+func (s *Server) handleLogsDownload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transmission-web-%s.log"`, time.Now().Format("20060102-150405")))
+	entries, err := s.logEntries(logFilter(r))
+	for _, e := range entries {
+		fmt.Fprintln(w, e)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "# daemon log unavailable: %v\n", err)
+	}
+}
+
+// handleLogsPage renders the log viewer, newest entries first
+func (s *Server) handleLogsPage(w http.ResponseWriter, r *http.Request) {
+	f := logFilter(r)
+	if f.Limit <= 0 {
+		f.Limit = 500
+	}
+	entries, daemonErr := s.logEntries(f)
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	// Sends that failed for good are shown above the log they'd be lost in
+	dead, err := s.notifier.Queued(true)
+	if err != nil {
+		log.Printf("Failed to read the dead notifications: %v", err)
+	}
+	s.render(w, "logs.html", map[string]interface{}{
+		"Failed":     dead,
+		"Entries":    entries,
+		"Filter":     f,
+		"Subsystems": s.logSubsystems(),
+		"Daemon":     s.daemonLog.Source(),
+		"DaemonErr":  daemonErr,
+		"Query":      r.URL.RawQuery,
+		"Version":    Version,
+	})
+}
@@ -2,26 +2,39 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 //go:embed templates/*
 var templatesFS embed.FS
 
+//go:embed static/*
+var staticFS embed.FS
+
 // Version is set during build time via ldflags
 var Version = "dev"
 
+// defaultTransmissionURL is the daemon used when TRANSMISSION_URL is unset
+const defaultTransmissionURL = "http://192.168.86.61:9091/transmission/rpc"
+
 // Config holds application configuration
 type Config struct {
 	TransmissionURL  string
@@ -38,6 +51,15 @@ type TransmissionClient struct {
 	sessionID string
 	mu        sync.RWMutex
 	client    *http.Client
+
+	limiter          *rpcLimiter  // caps concurrent requests
+	lastTorrentCount atomic.Int64 // size of the last full torrent-get
+
+	sessionDB    *sql.DB // persists sessionID across restarts when set
+	savedSession string  // the session ID last written to sessionDB
+	sessionKey   []byte  // keys the credentials fingerprint stored with it
+	metrics      SessionMetrics
+	recorder     *rpcRecorder // per-minute health stats, when tracked
 }
 
 // RPC request/response structures
@@ -52,25 +74,73 @@ type RPCResponse struct {
 }
 
 type Torrent struct {
-	ID             int     `json:"id"`
-	Name           string  `json:"name"`
-	Status         int     `json:"status"`
-	PercentDone    float64 `json:"percentDone"`
-	RateDownload   int64   `json:"rateDownload"`
-	RateUpload     int64   `json:"rateUpload"`
-	UploadRatio    float64 `json:"uploadRatio"`
-	SizeWhenDone   int64   `json:"sizeWhenDone"`
-	DownloadedEver int64   `json:"downloadedEver"`
-	UploadedEver   int64   `json:"uploadedEver"`
-	PeersConnected int     `json:"peersConnected"`
-	ETA            int     `json:"eta"`
-	Error          int     `json:"error"`
-	ErrorString    string  `json:"errorString"`
-	AddedDate      int64   `json:"addedDate"`
-}
-
-type TorrentList struct {
-	Torrents []Torrent `json:"torrents"`
+	ID              int           `json:"id"`
+	Name            string        `json:"name"`
+	Status          int           `json:"status"`
+	PercentDone     float64       `json:"percentDone"`
+	RateDownload    int64         `json:"rateDownload"`
+	RateUpload      int64         `json:"rateUpload"`
+	UploadRatio     float64       `json:"uploadRatio"`
+	SizeWhenDone    int64         `json:"sizeWhenDone"`
+	DownloadedEver  int64         `json:"downloadedEver"`
+	UploadedEver    int64         `json:"uploadedEver"`
+	PeersConnected  int           `json:"peersConnected"`
+	ETA             int           `json:"eta"`
+	Error           int           `json:"error"`
+	ErrorString     string        `json:"errorString"`
+	AddedDate       int64         `json:"addedDate"`
+	DoneDate        int64         `json:"doneDate"`
+	SecondsSeeding  int64         `json:"secondsSeeding"`
+	HashString      string        `json:"hashString"`
+	IsPrivate       bool          `json:"isPrivate"`
+	Labels          []string      `json:"labels"`
+	DownloadDir     string        `json:"downloadDir"`
+	QueuePosition   int           `json:"queuePosition"`
+	SeedRatioLimit  float64       `json:"seedRatioLimit"`
+	SeedRatioMode   int           `json:"seedRatioMode"`
+	SeedIdleLimit   int           `json:"seedIdleLimit"` // minutes
+	SeedIdleMode    int           `json:"seedIdleMode"`
+	DownloadLimit   int           `json:"downloadLimit"` // kB/s, when DownloadLimited
+	DownloadLimited bool          `json:"downloadLimited"`
+	UploadLimit     int           `json:"uploadLimit"` // kB/s, when UploadLimited
+	UploadLimited   bool          `json:"uploadLimited"`
+	Trackers        []TrackerInfo `json:"trackers"`
+
+	Instance string          `json:"instance,omitempty"` // set outside the default instance
+	Display  *TorrentDisplay `json:"display,omitempty"`
+	Remedy   *Remediation    `json:"remedy,omitempty"` // set for errored torrents
+	Late     *SLALate        `json:"late,omitempty"`   // set past a completion SLA's deadline
+	// set when a seed rule covers its tracker
+	Obligation *SeedObligation `json:"obligation,omitempty"`
+	Swarm      *SwarmHealth    `json:"swarm,omitempty"` // set once a download's swarm is classed
+}
+
+// TrackerInfo is a tracker entry from the torrent-get "trackers" field
+type TrackerInfo struct {
+	ID       int    `json:"id"`
+	Announce string `json:"announce"`
+	Tier     int    `json:"tier"`
+}
+
+// PrimaryTrackerHost returns the host of the first tracker, or "" for
+// trackerless torrents
+func (t *Torrent) PrimaryTrackerHost() string {
+	if len(t.Trackers) == 0 {
+		return ""
+	}
+	u, err := url.Parse(t.Trackers[0].Announce)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// AddedTorrent identifies the torrent created (or found) by torrent-add
+type AddedTorrent struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	HashString string `json:"hashString"`
+	Duplicate  bool   `json:"duplicate"`
 }
 
 type SessionStats struct {
@@ -83,6 +153,8 @@ type SessionStats struct {
 		UploadedBytes   int64 `json:"uploadedBytes"`
 		DownloadedBytes int64 `json:"downloadedBytes"`
 	} `json:"cumulative-stats"`
+
+	Display *StatsDisplay `json:"display,omitempty"`
 }
 
 type PortTest struct {
@@ -106,6 +178,7 @@ type Peer struct {
 	Progress           float64 `json:"progress"`
 	RateToClient       int64   `json:"rateToClient"`
 	RateToPeer         int64   `json:"rateToPeer"`
+	IsLAN              bool    `json:"isLan"` // derived, not an RPC field
 }
 
 type TorrentPeers struct {
@@ -157,16 +230,22 @@ type FreeSpace struct {
 
 func NewTransmissionClient(url, user, pass string) *TransmissionClient {
 	return &TransmissionClient{
-		url:    url,
-		user:   user,
-		pass:   pass,
-		client: &http.Client{Timeout: 10 * time.Second},
+		url:  url,
+		user: user,
+		pass: pass,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: newRPCTransport(defaultRPCConns, false),
+		},
+		limiter: newRPCLimiter(defaultRPCConns, defaultRPCQueueTimeout),
 	}
 }
 
-func (c *TransmissionClient) doRequest(req *RPCRequest) (*RPCResponse, error) {
+// send posts an RPC request, picking up a new session ID on 409, and
+// returns the successful HTTP response for the caller to decode and close
+func (c *TransmissionClient) send(req *RPCRequest) (*http.Response, error) {
 	c.mu.RLock()
-	sessionID := c.sessionID
+	sessionID, rec := c.sessionID, c.recorder
 	c.mu.RUnlock()
 
 	body, _ := json.Marshal(req)
@@ -184,24 +263,56 @@ func (c *TransmissionClient) doRequest(req *RPCRequest) (*RPCResponse, error) {
 		httpReq.Header.Set("X-Transmission-Session-Id", sessionID)
 	}
 
+	if err := c.limiter.acquire(); err != nil {
+		rec.observe(0, true)
+		return nil, err
+	}
+	start := time.Now()
 	resp, err := c.client.Do(httpReq)
+	latency := time.Since(start)
 	if err != nil {
+		c.limiter.release()
+		rec.observe(latency, true)
 		return nil, err
 	}
-	defer resp.Body.Close()
+	resp.Body = &limitedBody{ReadCloser: resp.Body, release: c.limiter.release}
 
 	// Handle 409 - need to get new session ID
 	if resp.StatusCode == 409 {
-		newSessionID := resp.Header.Get("X-Transmission-Session-Id")
-		c.mu.Lock()
-		c.sessionID = newSessionID
-		c.mu.Unlock()
-		return c.doRequest(req) // Retry with new session ID
+		closeBody(resp)
+		rec.handshake()
+		c.recordHandshake(resp.Header.Get("X-Transmission-Session-Id"))
+		return c.send(req) // Retry with new session ID
 	}
 
+	if resp.StatusCode == http.StatusUnauthorized {
+		c.recordAuthFailure()
+	}
+	rec.observe(latency, resp.StatusCode != 200)
 	if resp.StatusCode != 200 {
+		closeBody(resp)
 		return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
 	}
+	c.recordSuccess(sessionID)
+	return resp, nil
+}
+
+// RPCResultError is a request the daemon answered with a result other than
+// "success", such as an invalid torrent; retrying it won't help
+type RPCResultError struct {
+	Result string
+}
+
+func (e *RPCResultError) Error() string {
+	return "RPC error: " + e.Result
+}
+
+func (c *TransmissionClient) doRequest(req *RPCRequest) (*RPCResponse, error) {
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBody(resp)
 
 	var rpcResp RPCResponse
 	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
@@ -209,35 +320,69 @@ func (c *TransmissionClient) doRequest(req *RPCRequest) (*RPCResponse, error) {
 	}
 
 	if rpcResp.Result != "success" {
-		return nil, fmt.Errorf("RPC error: %s", rpcResp.Result)
+		return nil, &RPCResultError{Result: rpcResp.Result}
 	}
 
 	return &rpcResp, nil
 }
 
+// torrentFields are the torrent-get fields decoded into Torrent
+var torrentFields = []string{
+	"id", "name", "status", "percentDone", "rateDownload", "rateUpload",
+	"uploadRatio", "sizeWhenDone", "downloadedEver", "uploadedEver",
+	"peersConnected", "eta", "error", "errorString", "addedDate",
+	"doneDate", "secondsSeeding", "hashString", "isPrivate", "trackers",
+	"labels", "downloadDir", "queuePosition", "seedRatioLimit",
+	"seedRatioMode", "seedIdleLimit", "seedIdleMode", "downloadLimit",
+	"downloadLimited", "uploadLimit", "uploadLimited",
+}
+
 func (c *TransmissionClient) GetTorrents() ([]Torrent, error) {
-	req := &RPCRequest{
-		Method: "torrent-get",
-		Arguments: map[string]interface{}{
-			"fields": []string{
-				"id", "name", "status", "percentDone", "rateDownload", "rateUpload",
-				"uploadRatio", "sizeWhenDone", "downloadedEver", "uploadedEver",
-				"peersConnected", "eta", "error", "errorString", "addedDate",
-			},
-		},
-	}
+	return c.getTorrents(nil)
+}
 
-	resp, err := c.doRequest(req)
+// TorrentNotFoundError is returned when the daemon has no torrent with
+// the ID
+type TorrentNotFoundError struct {
+	ID int
+}
+
+func (e *TorrentNotFoundError) Error() string {
+	return fmt.Sprintf("torrent %d not found", e.ID)
+}
+
+// GetTorrent returns a single torrent by ID
+func (c *TransmissionClient) GetTorrent(id int) (*Torrent, error) {
+	torrents, err := c.getTorrents([]int{id})
 	if err != nil {
 		return nil, err
 	}
+	if len(torrents) == 0 {
+		return nil, &TorrentNotFoundError{ID: id}
+	}
+	return &torrents[0], nil
+}
 
-	var list TorrentList
-	if err := json.Unmarshal(resp.Arguments, &list); err != nil {
-		return nil, err
+func (c *TransmissionClient) getTorrents(ids []int) ([]Torrent, error) {
+	args := map[string]interface{}{"fields": torrentFields}
+	if len(ids) > 0 {
+		args["ids"] = ids
 	}
 
-	return list.Torrents, nil
+	// Size for the last full list so a big seedbox doesn't regrow the
+	// slice on every poll
+	torrents := make([]Torrent, 0, c.torrentCountHint(ids))
+	err := c.streamTorrentGet(args, func(dec *json.Decoder) error {
+		torrents = append(torrents, Torrent{})
+		return dec.Decode(&torrents[len(torrents)-1])
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		c.lastTorrentCount.Store(int64(len(torrents)))
+	}
+	return torrents, nil
 }
 
 func (c *TransmissionClient) GetSessionStats() (*SessionStats, error) {
@@ -290,7 +435,19 @@ func (c *TransmissionClient) GetFreeSpace(path string) (*FreeSpace, error) {
 	return &fs, nil
 }
 
-func (c *TransmissionClient) AddTorrent(magnetOrURL string, torrentData []byte) error {
+// AddOptions are the optional torrent-add arguments
+type AddOptions struct {
+	Cookies     string // sent by the daemon when fetching a .torrent URL
+	DownloadDir string
+	Labels      []string
+	Paused      bool
+	Priority    *int // bandwidthPriority; nil leaves the daemon's default
+}
+
+// AddTorrent adds a torrent from a magnet link/URL or raw .torrent data. If
+// Transmission already has the torrent the existing one is returned with
+// Duplicate set.
+func (c *TransmissionClient) AddTorrent(magnetOrURL string, torrentData []byte, opts AddOptions) (*AddedTorrent, error) {
 	args := make(map[string]interface{})
 
 	switch {
@@ -299,7 +456,22 @@ func (c *TransmissionClient) AddTorrent(magnetOrURL string, torrentData []byte)
 	case magnetOrURL != "":
 		args["filename"] = magnetOrURL
 	default:
-		return fmt.Errorf("no torrent data provided")
+		return nil, fmt.Errorf("no torrent data provided")
+	}
+	if opts.Cookies != "" {
+		args["cookies"] = opts.Cookies
+	}
+	if opts.DownloadDir != "" {
+		args["download-dir"] = opts.DownloadDir
+	}
+	if len(opts.Labels) > 0 {
+		args["labels"] = opts.Labels
+	}
+	if opts.Paused {
+		args["paused"] = true
+	}
+	if opts.Priority != nil {
+		args["bandwidthPriority"] = *opts.Priority
 	}
 
 	req := &RPCRequest{
@@ -307,8 +479,26 @@ func (c *TransmissionClient) AddTorrent(magnetOrURL string, torrentData []byte)
 		Arguments: args,
 	}
 
-	_, err := c.doRequest(req)
-	return err
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Added     *AddedTorrent `json:"torrent-added"`
+		Duplicate *AddedTorrent `json:"torrent-duplicate"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return nil, err
+	}
+	if result.Duplicate != nil {
+		result.Duplicate.Duplicate = true
+		return result.Duplicate, nil
+	}
+	if result.Added == nil {
+		return nil, fmt.Errorf("torrent-add returned no torrent")
+	}
+	return result.Added, nil
 }
 
 func (c *TransmissionClient) StartTorrent(id int) error {
@@ -338,6 +528,16 @@ func (c *TransmissionClient) ReannounceTorrent(id int) error {
 	return err
 }
 
+// VerifyTorrent rechecks a torrent's data against its piece hashes
+func (c *TransmissionClient) VerifyTorrent(id int) error {
+	req := &RPCRequest{
+		Method:    "torrent-verify",
+		Arguments: map[string]interface{}{"ids": []int{id}},
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
 func (c *TransmissionClient) ReannounceAll() error {
 	req := &RPCRequest{
 		Method: "torrent-reannounce",
@@ -358,6 +558,42 @@ func (c *TransmissionClient) RemoveTorrent(id int, deleteData bool) error {
 	return err
 }
 
+// SetAltSpeedEnabled toggles Transmission's alternative speed limits (turtle mode)
+func (c *TransmissionClient) SetAltSpeedEnabled(enabled bool) error {
+	req := &RPCRequest{
+		Method:    "session-set",
+		Arguments: map[string]interface{}{"alt-speed-enabled": enabled},
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
+// AddTrackers appends announce URLs to a torrent
+func (c *TransmissionClient) AddTrackers(id int, announceURLs []string) error {
+	return c.setTorrent(id, map[string]interface{}{"trackerAdd": announceURLs})
+}
+
+// RemoveTrackers removes trackers from a torrent by tracker ID
+func (c *TransmissionClient) RemoveTrackers(id int, trackerIDs []int) error {
+	return c.setTorrent(id, map[string]interface{}{"trackerRemove": trackerIDs})
+}
+
+// SetLabels replaces a torrent's labels
+func (c *TransmissionClient) SetLabels(id int, labels []string) error {
+	return c.setTorrent(id, map[string]interface{}{"labels": labels})
+}
+
+// setTorrent issues a torrent-set for a single torrent
+func (c *TransmissionClient) setTorrent(id int, args map[string]interface{}) error {
+	args["ids"] = []int{id}
+	req := &RPCRequest{
+		Method:    "torrent-set",
+		Arguments: args,
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
 func (c *TransmissionClient) GetPeers(id int) ([]Peer, error) {
 	req := &RPCRequest{
 		Method: "torrent-get",
@@ -378,11 +614,36 @@ func (c *TransmissionClient) GetPeers(id int) ([]Peer, error) {
 	}
 
 	if len(result.Torrents) > 0 {
+		markLANPeers(result.Torrents[0].Peers)
 		return result.Torrents[0].Peers, nil
 	}
 	return []Peer{}, nil
 }
 
+// GetAllPeers returns the connected peers of every torrent
+func (c *TransmissionClient) GetAllPeers() (*TorrentPeers, error) {
+	req := &RPCRequest{
+		Method: "torrent-get",
+		Arguments: map[string]interface{}{
+			"fields": []string{"id", "peers"},
+		},
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result TorrentPeers
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return nil, err
+	}
+	for _, t := range result.Torrents {
+		markLANPeers(t.Peers)
+	}
+	return &result, nil
+}
+
 func (c *TransmissionClient) GetTrackers(id int) ([]TrackerStats, error) {
 	req := &RPCRequest{
 		Method: "torrent-get",
@@ -408,40 +669,59 @@ func (c *TransmissionClient) GetTrackers(id int) ([]TrackerStats, error) {
 	return []TrackerStats{}, nil
 }
 
+// GetAllTrackerStats returns every torrent's tracker stats by torrent ID
+func (c *TransmissionClient) GetAllTrackerStats() (map[int][]TrackerStats, error) {
+	req := &RPCRequest{
+		Method:    "torrent-get",
+		Arguments: map[string]interface{}{"fields": []string{"id", "trackerStats"}},
+	}
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var result TorrentTrackers
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return nil, err
+	}
+	stats := make(map[int][]TrackerStats, len(result.Torrents))
+	for _, t := range result.Torrents {
+		stats[t.ID] = t.TrackerStats
+	}
+	return stats, nil
+}
+
 // Template helper functions
 var funcMap = template.FuncMap{
+	"localTime":   localTime,
+	"authEnabled": func() bool { return authEnabled },
+	"readOnly":    func() ReadOnlyStatus { return readOnlyMode.Status() },
+	"feature":     func(name string) bool { return features.Enabled(name) },
+	"update":      func() UpdateStatus { return updateChecker.Status() },
+	// display is set in main, after funcMap is built, so these look it up
+	// on each call rather than binding method values
 	"formatBytes": func(bytes int64) string {
-		const unit = 1024
-		if bytes < unit {
-			return fmt.Sprintf("%d B", bytes)
-		}
-		div, exp := int64(unit), 0
-		for n := bytes / unit; n >= unit; n /= unit {
-			div *= unit
-			exp++
-		}
-		return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+		return display.Bytes(bytes)
 	},
 	"formatSpeed": func(bytesPerSec int64) string {
-		const unit = 1024
-		if bytesPerSec < unit {
-			return fmt.Sprintf("%d B/s", bytesPerSec)
-		}
-		div, exp := int64(unit), 0
-		for n := bytesPerSec / unit; n >= unit; n /= unit {
-			div *= unit
-			exp++
-		}
-		return fmt.Sprintf("%.1f %cB/s", float64(bytesPerSec)/float64(div), "KMGTPE"[exp])
+		return display.Speed(bytesPerSec)
 	},
 	"formatPercent": func(pct float64) string {
-		return fmt.Sprintf("%.1f%%", pct*100)
+		return display.Percent(pct)
 	},
 	"formatRatio": func(ratio float64) string {
-		if ratio < 0 {
-			return "N/A"
+		return display.Ratio(ratio)
+	},
+	"formatNumber": func(v interface{}, decimals int) string {
+		return display.Number(toFloat(v), decimals)
+	},
+	"timeAgo": func(t time.Time) string {
+		return display.RelativeTime(t, time.Now())
+	},
+	"unixTime": func(sec int64) time.Time {
+		if sec <= 0 {
+			return time.Time{}
 		}
-		return fmt.Sprintf("%.2f", ratio)
+		return time.Unix(sec, 0)
 	},
 	"formatETA": func(seconds int) string {
 		if seconds < 0 {
@@ -461,26 +741,22 @@ var funcMap = template.FuncMap{
 		}
 		return fmt.Sprintf("%ds", secs)
 	},
-	"statusText": func(status int) string {
-		switch status {
-		case 0:
-			return "Stopped"
-		case 1:
-			return "Queued (check)"
-		case 2:
-			return "Checking"
-		case 3:
-			return "Queued (dl)"
-		case 4:
-			return "Downloading"
-		case 5:
-			return "Queued (seed)"
-		case 6:
-			return "Seeding"
-		default:
-			return "Unknown"
+	"formatDuration": func(seconds int64) string {
+		if seconds <= 0 {
+			return "-"
+		}
+		days := seconds / 86400
+		hours := (seconds % 86400) / 3600
+		minutes := (seconds % 3600) / 60
+		if days > 0 {
+			return fmt.Sprintf("%dd %dh", days, hours)
 		}
+		if hours > 0 {
+			return fmt.Sprintf("%dh %dm", hours, minutes)
+		}
+		return fmt.Sprintf("%dm", minutes)
 	},
+	"statusText": statusText,
 	"statusClass": func(status int) string {
 		switch status {
 		case 0:
@@ -517,13 +793,89 @@ var funcMap = template.FuncMap{
 
 // Server holds the application state
 type Server struct {
-	client      *TransmissionClient
-	feedManager *FeedManager
-	tmpl        *template.Template
+	client       *TransmissionClient
+	feedManager  *FeedManager
+	poller       *Poller
+	usage        *UsageTracker
+	arr          *ArrTracker
+	indexers     *IndexerStore
+	prowlarrKey  string
+	metrics      *MetricsCollector
+	metricsToken string
+	notifier     *Notifier
+	transfers    *TransferHistory
+	geoip        *GeoIP
+	history      *TorrentHistory
+	registry     *TorrentRegistry
+	adder        *Adder
+	addDefaults  *AddDefaultsStore
+	events       *EventBus
+	irc          *IRCListener
+	search       *BitmagnetSearch
+	torznab      *TorznabSearch
+	cookies      *CookieStore
+	pause        *AutomationPause
+	readOnly     *ReadOnlyMode
+	altSpeed     *AltSpeedScheduler
+	labelCaps    *LabelCaps
+	peak         *PeakLimiter
+	hub          *Hub
+	auth         *WebAuth
+	presence     *Presence
+	rpcHealth    *RPCHealth
+	logs         *LogBuffer
+	daemonLog    *DaemonLog
+	ports        *PortChecker
+	egress       *EgressChecker
+	trackerCheck *TrackerHealth
+	tokens       *APITokens
+	policy       *PolicyEngine
+	hooks        *HookRunner
+	activity     *TorrentActivity
+	traffic      *TorrentTraffic
+	swarm        *SwarmMonitor
+	reannounce   *ReannounceScheduler
+	disks        *DiskMonitor
+	dashboard    *DashboardLayouts
+	bandwidth    *BandwidthHistory
+	sla          *SLAMonitor
+	seedRules    *SeedRules
+	api          *apiRouter
+	swaggerUI    string
+	tmpl         *template.Template
+
+	// Extra daemons from TRANSMISSION_INSTANCES, by name and in order
+	instances     map[string]*TransmissionClient
+	instanceOrder []string
 }
 
-func NewServer(client *TransmissionClient, feedManager *FeedManager) (*Server, error) {
+// parseTemplates parses the embedded templates, then any *.html in dir
+// over them. An override replaces the templates it defines, so a file can
+// restyle one page, or redefine a single block such as layout.html's
+// page-nav, and everything it leaves out comes from the build.
+func parseTemplates(dir string) (*template.Template, error) {
 	tmpl, err := template.New("").Funcs(funcMap).ParseFS(templatesFS, "templates/*.html")
+	if err != nil || dir == "" {
+		return tmpl, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("TEMPLATE_DIR: %w", err)
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range overrides {
+		if _, err := tmpl.ParseFiles(path); err != nil {
+			return nil, fmt.Errorf("template override: %w", err)
+		}
+		log.Printf("Using template override %s", path)
+	}
+	return tmpl, nil
+}
+
+func NewServer(client *TransmissionClient, feedManager *FeedManager) (*Server, error) {
+	tmpl, err := parseTemplates(getEnv("TEMPLATE_DIR", ""))
 	if err != nil {
 		return nil, err
 	}
@@ -541,28 +893,104 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	torrents, err := s.client.GetTorrents()
+	instance := r.URL.Query().Get("instance")
+	client, err := s.clientFor(instance)
+	if instance == AllInstances {
+		client, err = s.client, nil
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	stats, err := s.client.GetSessionStats()
-	if err != nil {
+	var (
+		torrents []Torrent
+		stats    *SessionStats
+		listErrs map[string]error
+		portOpen bool
+		disks    []DiskUsage
+	)
+	// The port test and free space are optional, and come from the default
+	// daemon in the aggregated view; the page renders without them
+	errs := fanOut(map[string]func() error{
+		"torrents": func() (err error) {
+			torrents, stats, listErrs, err = s.listTorrents(instance)
+			return err
+		},
+		"port": func() (err error) {
+			if client == s.client {
+				portOpen, err = s.ports.Open()
+			} else {
+				portOpen, err = client.TestPort()
+			}
+			return err
+		},
+		"disks": func() error {
+			disks = s.diskUsageFor(client)
+			return nil
+		},
+	})
+	if err := errs["torrents"]; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := listErrs["stats"]; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if instance == "" {
+		instance = DefaultInstance
+	}
 
-	portOpen, _ := s.client.TestPort()
-	freeSpace, _ := s.client.GetFreeSpace("/data/transmission")
+	// The page shows one page of the list; polling asks for the same one
+	query := parseTorrentQuery(r.URL.Query(), defaultPageSize)
+	list := newTorrentIndex(filterTorrents(torrents, r.URL.Query())).query(query)
+	for i := range list.Torrents {
+		list.Torrents[i].Remedy = remedyFor(&list.Torrents[i])
+	}
+	pageURL := func(n int) string {
+		v := query.values(n)
+		if instance != DefaultInstance {
+			v.Set("instance", instance)
+		}
+		return "/?" + v.Encode()
+	}
+	var prevPage, nextPage string
+	if list.Page > 1 {
+		prevPage = pageURL(min(list.Page-1, list.Pages))
+	}
+	if list.Page < list.Pages {
+		nextPage = pageURL(list.Page + 1)
+	}
 
+	panels := s.dashboardLayout(r)
 	data := map[string]interface{}{
-		"Torrents":  torrents,
-		"Stats":     stats,
-		"PortOpen":  portOpen,
-		"FreeSpace": freeSpace,
-		"Version":   Version,
-	}
+		"Torrents":  list.Torrents,
+		"Panels":    panels,
+		"List":      list,
+		"Query":     query,
+		"ListQuery": query.values(list.Page).Encode(),
+		"PrevPage":  prevPage,
+		"NextPage":  nextPage,
+		"Filtered":  query.Search != "" || query.Tracker != "" || len(query.Status) > 0,
+		// Choices for the list form
+		"StatusFilters": torrentStatusNames,
+		"SortKeys":      listSortKeys,
+		"Stats":         stats,
+		"PortOpen":      portOpen,
+		"Disks":         disks,
+		"AddDefaults":   s.addDefaults.Get(SourceUI),
+		"Usage":         s.usage.Current(),
+		"Search":        s.search != nil,
+		"Timezone":      timezoneName(),
+		"Display":       display,
+		"View":          listView(w, r),
+		"Instance":      instance,
+		"Instances":     s.instanceNames(),
+		"Down":          errorStrings(listErrs),
+		"Version":       Version,
+	}
+	s.addPanelData(data, panels, torrents)
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := s.tmpl.ExecuteTemplate(w, "index.html", data); err != nil {
@@ -573,38 +1001,63 @@ func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleAPI(w http.ResponseWriter, _ *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	torrents, err := s.client.GetTorrents()
-	if err != nil {
-		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
-			log.Printf("Failed to encode error response: %v", encErr)
+// render executes a page template, logging failures that aren't client disconnects
+func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.tmpl.ExecuteTemplate(w, name, data); err != nil {
+		if !isClientDisconnectError(err) {
+			log.Printf("Template error: %v", err)
 		}
-		return
 	}
+}
 
-	stats, err := s.client.GetSessionStats()
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	s.poller.Touch()
+	torrents, stats, errs, err := s.listTorrents(r.URL.Query().Get("instance"))
 	if err != nil {
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
 			log.Printf("Failed to encode error response: %v", encErr)
 		}
 		return
 	}
+	if label := scopeLabel(r); label != "" {
+		torrents = withLabel(torrents, label)
+	}
+	torrents = filterTorrents(torrents, r.URL.Query())
+	page := newTorrentIndex(torrents).query(parseTorrentQuery(r.URL.Query(), 0))
+	addDisplay(page.Torrents, stats)
 
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"torrents": torrents,
+	// Stats or other instances failing still returns the list, with the
+	// reasons in "errors"
+	resp := map[string]interface{}{
+		"torrents": page.Torrents,
 		"stats":    stats,
-	}); err != nil {
+		"total":    page.Total,
+		"trackers": page.Trackers,
+		"labels":   page.Labels,
+	}
+	if page.PerPage > 0 {
+		resp["page"], resp["perPage"], resp["pages"] = page.Page, page.PerPage, page.Pages
+	}
+	if len(errs) > 0 {
+		resp["errors"] = errorStrings(errs)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("Failed to encode response: %v", err)
 	}
 }
 
+// handleAdd adds a magnet, URL or info-hash from the "magnet" field or an
+// uploaded "torrent-file", with the optional "download-dir", "paused",
+// "priority" (-1, 0 or 1) and comma-separated "labels" fields
 func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAddBodySize)
 
 	// Check for file upload
 	file, _, err := r.FormFile("torrent-file")
@@ -615,28 +1068,210 @@ func (s *Server) handleAdd(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to read file", http.StatusBadRequest)
 			return
 		}
-		if err := s.client.AddTorrent("", data); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		req, err := s.addFormRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Data = data
+		added, err := s.adder.Add(req)
+		if err != nil {
+			http.Error(w, err.Error(), addErrorStatus(err))
 			return
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		s.addDone(w, r, added)
 		return
 	}
 
 	// Check for magnet link
-	magnet := r.FormValue("magnet")
+	magnet := strings.TrimSpace(r.FormValue("magnet"))
 	if magnet != "" {
-		if err := s.client.AddTorrent(magnet, nil); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// A bare info-hash pasted from a DHT indexer
+		if isInfoHash(magnet) {
+			magnet, _ = magnetFromHash(magnet, "", nil)
+		}
+		req, err := s.addFormRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.URL = magnet
+		added, err := s.adder.Add(req)
+		if err != nil {
+			http.Error(w, err.Error(), addErrorStatus(err))
 			return
 		}
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		s.addDone(w, r, added)
 		return
 	}
 
 	http.Error(w, "No torrent provided", http.StatusBadRequest)
 }
 
+// addFormRequest reads the add form's options. Adds with an API token
+// count as API adds for the source's defaults.
+func (s *Server) addFormRequest(r *http.Request) (AddRequest, error) {
+	req := AddRequest{Source: SourceUI}
+	if requestToken(r) != nil {
+		req.Source = SourceAPI
+	}
+	dir, err := cleanAddDir(s.client, r.FormValue("download-dir"))
+	if err != nil {
+		return req, err
+	}
+	req.Dir = dir
+	if paused, ok := parseBoolParam(r.FormValue("paused")); ok {
+		req.Paused = &paused
+	}
+	if p := strings.TrimSpace(r.FormValue("priority")); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil || priority < BandwidthPriorityLow || priority > BandwidthPriorityHigh {
+			return req, fmt.Errorf("priority must be -1, 0 or 1")
+		}
+		req.Priority = &priority
+	}
+	labels, err := normalizeLabels(append(strings.Split(r.FormValue("labels"), ","), scopeLabels(r)...))
+	if err != nil {
+		return req, err
+	}
+	if len(labels) > 0 {
+		req.Labels = labels
+	}
+	return req, nil
+}
+
+// addDone answers a form add with a redirect back to the list, or the
+// added torrent for API tokens
+func (s *Server) addDone(w http.ResponseWriter, r *http.Request, added *AddedTorrent) {
+	if requestToken(r) != nil {
+		writeJSON(w, added)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleAddHash adds a torrent from a bare info-hash, optionally with the
+// configured public trackers so the swarm can be found without DHT
+func (s *Server) handleAddHash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Hash     string `json:"hash"`
+		Name     string `json:"name"`
+		Trackers bool   `json:"trackers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+
+	var trackers []string
+	if req.Trackers {
+		trackers = s.adder.trackers.List()
+	}
+	magnet, err := magnetFromHash(req.Hash, req.Name, trackers)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+
+	add := AddRequest{URL: magnet, Source: SourceUI, Labels: scopeLabels(r)}
+	if requestToken(r) != nil {
+		add.Source = SourceAPI
+	}
+	added, err := s.adder.Add(add)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(addErrorStatus(err))
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, added)
+}
+
+// handleParseRelease shows how a release name is parsed, to help build feed
+// release filters
+func (s *Server) handleParseRelease(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSONError(w, "missing name parameter")
+		return
+	}
+	writeJSON(w, ParseRelease(name))
+}
+
+// addErrorStatus maps an Adder or RPC error to an HTTP status code
+func addErrorStatus(err error) int {
+	var dup *DuplicateAddError
+	if errors.As(err, &dup) {
+		return http.StatusConflict
+	}
+	var excluded *ExcludedURLError
+	if errors.As(err, &excluded) {
+		return http.StatusUnprocessableEntity
+	}
+	var busy *RPCBusyError
+	if errors.As(err, &busy) {
+		return http.StatusServiceUnavailable
+	}
+	var space *DiskSpaceError
+	if errors.As(err, &space) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// errUnknownAction is returned by torrentAction for actions it doesn't know
+var errUnknownAction = errors.New("unknown action")
+
+// torrentAction runs a single-torrent action on one daemon, for the action
+// API and the basic view's forms. force removes a torrent that still owes
+// its tracker seeding.
+func (s *Server) torrentAction(client *TransmissionClient, action string, id int, deleteData, force bool) error {
+	switch action {
+	case "start":
+		return client.StartTorrent(id)
+	case "stop":
+		return client.StopTorrent(id)
+	case "remove":
+		if deleteData && !features.Enabled(FeatureRemoveData) {
+			return featureError(FeatureRemoveData)
+		}
+		if client == s.client {
+			return s.removeTorrent(id, deleteData, force)
+		}
+		t, err := client.GetTorrent(id)
+		if err != nil {
+			return err
+		}
+		if err := s.seedRules.CheckRemove(t, force); err != nil {
+			return err
+		}
+		// History is only kept for the default daemon
+		return client.RemoveTorrent(id, deleteData)
+	case "reannounce":
+		return client.ReannounceTorrent(id)
+	case ActionReannounceWhenAllowed:
+		if client != s.client {
+			return fmt.Errorf("reannounces can only be scheduled on the default instance")
+		}
+		t, err := client.GetTorrent(id)
+		if err != nil {
+			return err
+		}
+		_, err = s.reannounce.Schedule(t)
+		return err
+	case "verify":
+		return client.VerifyTorrent(id)
+	}
+	if move, ok := queueActions[action]; ok {
+		return move(client, id)
+	}
+	return errUnknownAction
+}
+
 func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -647,28 +1282,40 @@ func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
 		Action     string `json:"action"`
 		ID         int    `json:"id"`
 		DeleteData bool   `json:"deleteData"`
+		Force      bool   `json:"force"` // remove despite a seed rule
+		Instance   string `json:"instance"`
+		// For "labels": labels replaces them, addLabels and removeLabels
+		// edit the current ones
+		Labels       []string `json:"labels"`
+		AddLabels    []string `json:"addLabels"`
+		RemoveLabels []string `json:"removeLabels"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	client, err := s.clientFor(req.Instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	var err error
 	switch req.Action {
-	case "start":
-		err = s.client.StartTorrent(req.ID)
-	case "stop":
-		err = s.client.StopTorrent(req.ID)
-	case "remove":
-		err = s.client.RemoveTorrent(req.ID, req.DeleteData)
-	case "reannounce":
-		err = s.client.ReannounceTorrent(req.ID)
 	case "reannounce-all":
-		err = s.client.ReannounceAll()
+		err = client.ReannounceAll()
+	case "labels":
+		var labels []string
+		edit := labelEdit{Set: req.Labels, Add: req.AddLabels, Remove: req.RemoveLabels}
+		if labels, err = edit.apply(client, req.ID); err == nil {
+			writeJSON(w, map[string]interface{}{"status": "ok", "labels": labels})
+			return
+		}
 	default:
-		http.Error(w, "Unknown action", http.StatusBadRequest)
-		return
+		if err = s.torrentAction(client, req.Action, req.ID, req.DeleteData, req.Force); errors.Is(err, errUnknownAction) {
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -683,6 +1330,26 @@ func (s *Server) handleAction(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// removeTorrent removes a torrent and archives its final stats, refusing
+// one that still owes its tracker seeding unless forced
+func (s *Server) removeTorrent(id int, deleteData, force bool) error {
+	if deleteData && !features.Enabled(FeatureRemoveData) {
+		return featureError(FeatureRemoveData)
+	}
+	t, err := s.client.GetTorrent(id)
+	if err != nil {
+		return err
+	}
+	if err := s.seedRules.CheckRemove(t, force); err != nil {
+		return err
+	}
+	if err := s.client.RemoveTorrent(id, deleteData); err != nil {
+		return err
+	}
+	s.registry.RecordRemove(t, SourceUI, deleteData)
+	return nil
+}
+
 func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -702,7 +1369,14 @@ func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	peers, err := s.client.GetPeers(id)
+	client, err := s.clientFor(r.URL.Query().Get("instance"))
+	if err != nil {
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
+			log.Printf("Failed to encode error response: %v", encErr)
+		}
+		return
+	}
+	peers, err := client.GetPeers(id)
 	if err != nil {
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
 			log.Printf("Failed to encode error response: %v", encErr)
@@ -736,7 +1410,14 @@ func (s *Server) handleTrackers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	trackers, err := s.client.GetTrackers(id)
+	client, err := s.clientFor(r.URL.Query().Get("instance"))
+	if err != nil {
+		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
+			log.Printf("Failed to encode error response: %v", encErr)
+		}
+		return
+	}
+	trackers, err := client.GetTrackers(id)
 	if err != nil {
 		if encErr := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); encErr != nil {
 			log.Printf("Failed to encode error response: %v", encErr)
@@ -769,6 +1450,20 @@ func (s *Server) handleGetFeeds(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// handleFeedsPage renders the feed management page
+func (s *Server) handleFeedsPage(w http.ResponseWriter, _ *http.Request) {
+	feeds, err := s.feedManager.GetFeeds()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.render(w, "feeds.html", map[string]interface{}{
+		"Feeds":   feeds,
+		"Paused":  s.pause.Status(),
+		"Version": Version,
+	})
+}
+
 func (s *Server) handleAddFeed(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -965,22 +1660,356 @@ func (s *Server) handleFeedCheckLogs(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// subcommands run instead of the server when named as the first argument
+var subcommands = map[string]func(args []string) int{
+	"tui":        runTUI,
+	"ctl":        runCtl,
+	"completion": runCompletion,
+	"update":     runUpdate,
+	"install":    runInstall,
+	"uninstall":  runUninstall,
+}
+
+// serverFlags registers the server's own flags on fs
+func serverFlags(fs *flag.FlagSet) (demo *bool, config, logFile *string) {
+	demo = fs.Bool("demo", false, "serve synthetic torrents, peers and feeds without a Transmission daemon")
+	config = fs.String("config", os.Getenv("CONFIG_FILE"), "YAML settings file, watched for changes (CONFIG_FILE)")
+	logFile = fs.String("log-file", os.Getenv("LOG_FILE"), "append the log to this file instead of stderr (LOG_FILE)")
+	return demo, config, logFile
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			// Subcommands share the server's defaults, such as LISTEN_ADDR
+			if err := loadConfigFile(os.Getenv("CONFIG_FILE")); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
+	demoFlag, configPath, logFile := serverFlags(flag.CommandLine)
+	flag.Parse()
+	startService()
+	if err := loadConfigFile(*configPath); err != nil {
+		log.Fatalf("Failed to load config file: %v", err)
+	}
+
+	var logOut io.Writer = os.Stderr
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			log.Fatalf("Failed to open log file: %v", err)
+		}
+		logOut = f
+	}
+	// Everything logged from here on is also kept for the log viewer
+	logs := NewLogBuffer(logOut, getEnvInt("LOG_BUFFER_SIZE", defaultLogBufferSize))
+	log.SetFlags(0)
+	log.SetOutput(logs)
+
 	config := Config{
-		TransmissionURL:  getEnv("TRANSMISSION_URL", "http://192.168.86.61:9091/transmission/rpc"),
+		TransmissionURL:  getEnv("TRANSMISSION_URL", defaultTransmissionURL),
 		TransmissionUser: getEnv("TRANSMISSION_USER", "transmission"),
 		TransmissionPass: getEnv("TRANSMISSION_PASS", ""),
 		ListenAddr:       getEnv("LISTEN_ADDR", ":8080"),
 	}
 
+	// Demo mode points the client at an in-process fake daemon and keeps
+	// its data in a throwaway database unless DB_PATH says otherwise
+	var demo *DemoDaemon
+	if demoEnv, _ := parseBoolParam(getEnv("DEMO", "")); *demoFlag || demoEnv {
+		demo = NewDemoDaemon(getEnvInt("DEMO_TORRENTS", 0))
+		rpcURL, err := demo.Start()
+		if err != nil {
+			log.Fatalf("Failed to start demo daemon: %v", err)
+		}
+		config.TransmissionURL, config.TransmissionUser, config.TransmissionPass = rpcURL, "", ""
+		log.Printf("🎭 Demo mode: serving synthetic data, no Transmission daemon needed")
+	}
+
+	loc, err := loadTimezone(getEnv("TIMEZONE", ""))
+	if err != nil {
+		log.Fatalf("Failed to load timezone: %v", err)
+	}
+	appLocation = loc
+
+	if display, err = NewDisplayFormat(getEnv("DISPLAY_UNITS", UnitsIEC), getEnv("DISPLAY_LOCALE", "")); err != nil {
+		log.Fatalf("Failed to configure display format: %v", err)
+	}
+	if features, err = parseFeatureFlags(getEnvList("DISABLE_FEATURES")); err != nil {
+		log.Fatalf("Failed to configure features: %v", err)
+	}
+	if disabled := features.Disabled(); len(disabled) > 0 {
+		log.Printf("Disabled features: %s", strings.Join(disabled, ", "))
+	}
+
 	client := NewTransmissionClient(config.TransmissionURL, config.TransmissionUser, config.TransmissionPass)
+	http2, _ := parseBoolParam(getEnv("TRANSMISSION_HTTP2", ""))
+	maxConns := getEnvInt("TRANSMISSION_MAX_CONNS", defaultRPCConns)
+	client.ConfigureTransport(maxConns, http2)
+	maxInflight := getEnvInt("TRANSMISSION_MAX_INFLIGHT", maxConns)
+	queueTimeout := getEnvDuration("TRANSMISSION_QUEUE_TIMEOUT", defaultRPCQueueTimeout)
+	client.SetRequestLimit(maxInflight, queueTimeout)
+
+	instances, err := loadInstances(getEnv("TRANSMISSION_INSTANCES", ""))
+	if err != nil {
+		log.Fatalf("Failed to load instances: %v", err)
+	}
 
-	// Initialize RSS feed manager
 	dbPath := getEnv("DB_PATH", "./feeds.db")
-	feedManager, err := NewFeedManager(dbPath, client)
+	removeDemoData := func() {}
+	if demo != nil {
+		instances = nil
+		if getEnv("DB_PATH", "") == "" {
+			if dbPath, removeDemoData, err = demoDatabase(); err != nil {
+				log.Fatalf("Failed to create demo database: %v", err)
+			}
+		}
+	}
+	db, err := openDatabase(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if persist, _ := parseBoolParam(getEnv("LOG_PERSIST", "")); persist {
+		if err := logs.Persist(db, getEnvInt("LOG_PERSIST_ENTRIES", defaultLogPersistKeep)); err != nil {
+			log.Printf("Failed to load stored logs: %v", err)
+		}
+	}
+	if err := client.PersistSession(db); err != nil {
+		log.Printf("Failed to restore RPC session: %v", err)
+	}
+
+	// Initialize RSS feed manager
+	feedManager, err := NewFeedManager(db, client)
 	if err != nil {
 		log.Fatalf("Failed to create feed manager: %v", err)
 	}
+	if demo != nil {
+		seedDemoFeeds(feedManager, demo)
+	}
+	feedManager.SetPoliteness(
+		getEnvInt("FEED_CONCURRENCY", 4),
+		getEnvInt("FEED_HOST_CONCURRENCY", 1),
+		getEnvDuration("FEED_HOST_DELAY", 5*time.Second),
+	)
+
+	// POLL_INTERVAL pins the old fixed interval; otherwise polling adapts
+	// to activity
+	schedule := PollSchedule{
+		Active:  getEnvDuration("POLL_ACTIVE_INTERVAL", defaultPollActive),
+		Idle:    getEnvDuration("POLL_IDLE_INTERVAL", defaultPollIdle),
+		IdleMax: getEnvDuration("POLL_IDLE_MAX", defaultPollIdleMax),
+	}
+	if getEnv("POLL_INTERVAL", "") != "" {
+		schedule = FixedSchedule(getEnvDuration("POLL_INTERVAL", 10*time.Second))
+	}
+	poller := NewPoller(client, schedule)
+
+	usage, err := NewUsageTracker(db, client, loadDataCapConfig())
+	if err != nil {
+		log.Fatalf("Failed to create usage tracker: %v", err)
+	}
+	poller.Subscribe(usage.OnSnapshot)
+
+	altSpeed, err := NewAltSpeedScheduler(db, client, usage)
+	if err != nil {
+		log.Fatalf("Failed to create alt-speed scheduler: %v", err)
+	}
+	altSpeed.Start()
+
+	labelCaps, err := NewLabelCaps(db, client, getEnvDuration("LABEL_CAP_INTERVAL", defaultLabelCapInterval))
+	if err != nil {
+		log.Fatalf("Failed to create label caps: %v", err)
+	}
+	poller.Subscribe(labelCaps.OnSnapshot)
+	peak, err := NewPeakLimiter(db, client, getEnvDuration("PEAK_LIMIT_INTERVAL", defaultPeakInterval))
+	if err != nil {
+		log.Fatalf("Failed to create peak-hours limiter: %v", err)
+	}
+	poller.Subscribe(peak.OnSnapshot)
+
+	transfers, err := NewTransferHistory(db)
+	if err != nil {
+		log.Fatalf("Failed to create transfer history: %v", err)
+	}
+	poller.Subscribe(transfers.OnSnapshot)
+	bandwidth, err := NewBandwidthHistory(db, client, getEnvDuration("BANDWIDTH_RETENTION", defaultBandwidthRetention))
+	if err != nil {
+		log.Fatalf("Failed to create bandwidth history: %v", err)
+	}
+	bandwidth.Start(getEnvDuration("BANDWIDTH_SAMPLE_INTERVAL", defaultBandwidthInterval))
+
+	dashboard, err := NewDashboardLayouts(db)
+	if err != nil {
+		log.Fatalf("Failed to create dashboard layouts: %v", err)
+	}
+
+	history, err := NewTorrentHistory(db)
+	if err != nil {
+		log.Fatalf("Failed to create torrent history: %v", err)
+	}
+
+	events := NewEventBus(200)
+	registry, err := NewTorrentRegistry(db, history, events)
+	if err != nil {
+		log.Fatalf("Failed to create torrent registry: %v", err)
+	}
+	poller.Subscribe(registry.OnSnapshot)
+
+	notifier, err := NewNotifier(db, loadNotifyEnvChannels(), getEnvInt("NOTIFY_RETRY_ATTEMPTS", defaultNotifyRetryAttempts))
+	if err != nil {
+		log.Fatalf("Failed to create notifier: %v", err)
+	}
+	events.Subscribe(notifier.OnEvent)
+	traffic, err := NewTorrentTraffic(db,
+		getEnvDuration("TORRENT_TRAFFIC_INTERVAL", defaultTrafficInterval),
+		getEnvDuration("TORRENT_TRAFFIC_RETENTION", defaultTrafficRetention))
+	if err != nil {
+		log.Fatalf("Failed to create torrent traffic: %v", err)
+	}
+	poller.Subscribe(traffic.OnSnapshot)
+
+	swarm, err := NewSwarmMonitor(db, client,
+		getEnvDuration("SWARM_SAMPLE_INTERVAL", defaultSwarmInterval),
+		getEnvDuration("SWARM_WINDOW", defaultSwarmWindow),
+		getEnvInt("SWARM_SLOW_KBPS", defaultSwarmSlowKBps))
+	if err != nil {
+		log.Fatalf("Failed to create swarm monitor: %v", err)
+	}
+	poller.Subscribe(swarm.OnSnapshot)
+
+	reannounce, err := NewReannounceScheduler(db, client)
+	if err != nil {
+		log.Fatalf("Failed to create reannounce scheduler: %v", err)
+	}
+	poller.Subscribe(reannounce.OnSnapshot)
+
+	activity, err := NewTorrentActivity(db, getEnvDuration("ACTIVITY_RETENTION", defaultActivityRetention))
+	if err != nil {
+		log.Fatalf("Failed to create torrent activity: %v", err)
+	}
+	events.Subscribe(activity.OnEvent)
+	poller.Subscribe(activity.OnSnapshot)
+	watcher := NewTorrentWatcher(events, client, int64(getEnvFloat("NOTIFY_DISK_MIN_FREE_GB", 10)*(1<<30)))
+	poller.Subscribe(watcher.OnSnapshot)
+	watcher.Start(getEnvDuration("NOTIFY_DISK_INTERVAL", defaultDiskCheckInterval))
+
+	cookies, err := NewCookieStore(db)
+	if err != nil {
+		log.Fatalf("Failed to create cookie store: %v", err)
+	}
+	feedManager.parser.Client.Jar = cookies
+
+	pause, err := NewAutomationPause(db)
+	if err != nil {
+		log.Fatalf("Failed to load automation pause: %v", err)
+	}
+	feedManager.pause = pause
+
+	lockReadOnly, _ := parseBoolParam(getEnv("READ_ONLY", ""))
+	readOnly, err := NewReadOnlyMode(db, lockReadOnly)
+	if err != nil {
+		log.Fatalf("Failed to load read-only mode: %v", err)
+	}
+	readOnlyMode = readOnly
+
+	dupes, err := NewDuplicateGuard(db, poller, getEnv("DUPLICATE_TITLE_MODE", DuplicateModeSkip))
+	if err != nil {
+		log.Fatalf("Failed to configure duplicate detection: %v", err)
+	}
+	feedManager.dupes = dupes
+
+	adder := NewAdder(client, registry, getEnvDuration("DEDUPE_WINDOW", 10*time.Minute))
+	if err := adder.SetExclusions(getEnvList("ADD_EXCLUDE_PATTERNS")); err != nil {
+		log.Fatalf("Failed to configure add exclusions: %v", err)
+	}
+	adder.cookies = cookies
+	feedManager.adder = adder
+
+	addDefaults, err := NewAddDefaultsStore(db)
+	if err != nil {
+		log.Fatalf("Failed to create add defaults store: %v", err)
+	}
+	adder.defaults = addDefaults
+	NewWatchFolder(getEnv("WATCH_DIR", ""), adder, pause).Start(getEnvDuration("WATCH_INTERVAL", defaultWatchInterval))
+
+	disks := NewDiskMonitor(client, getEnvList("DISK_PATHS"),
+		int64(getEnvFloat("DISK_WARN_GB", defaultDiskWarnGB)*(1<<30)),
+		int64(getEnvFloat("DISK_MIN_FREE_GB", 0)*(1<<30)))
+	disks.Start(getEnvDuration("DISK_CHECK_INTERVAL", defaultDiskPollInterval))
+	adder.disks = disks
+
+	adder.trackers = NewTrackerAugmenter(client,
+		getEnvList("PUBLIC_TRACKERS"),
+		getEnvList("TRACKER_AUGMENT_SOURCES"),
+	)
+	if adder.trackers != nil {
+		poller.Subscribe(adder.trackers.OnSnapshot)
+	}
+
+	arr, err := NewArrTracker(db, client, getEnv("ARR_WEBHOOK_TOKEN", ""))
+	if err != nil {
+		log.Fatalf("Failed to create *arr tracker: %v", err)
+	}
+	poller.Subscribe(arr.OnSnapshot)
+
+	// Removal is blocked unless SEED_RULES_BLOCK_REMOVAL is set to false
+	blockRemoval, ok := parseBoolParam(getEnv("SEED_RULES_BLOCK_REMOVAL", ""))
+	seedRules, err := NewSeedRules(db, events, blockRemoval || !ok)
+	if err != nil {
+		log.Fatalf("Failed to load seed rules: %v", err)
+	}
+	poller.Subscribe(seedRules.OnSnapshot)
+
+	policy, err := NewPolicyEngine(db, client, registry, getEnvDuration("POLICY_INTERVAL", 5*time.Minute))
+	if err != nil {
+		log.Fatalf("Failed to create policy engine: %v", err)
+	}
+	policy.arr = arr
+	policy.seeding = seedRules
+	policy.swarm = swarm
+	poller.Subscribe(policy.OnSnapshot)
+
+	sla, err := NewSLAMonitor(db, feedManager, events)
+	if err != nil {
+		log.Fatalf("Failed to create SLA monitor: %v", err)
+	}
+	poller.Subscribe(sla.OnSnapshot)
+
+	hooks, err := NewHookRunner(db, client, poller, getEnv("HOOK_SCRIPT_DIR", ""), getEnvDuration("HOOK_TIMEOUT", defaultHookTimeout))
+	if err != nil {
+		log.Fatalf("Failed to create completion hooks: %v", err)
+	}
+	events.Subscribe(hooks.OnEvent)
+
+	deadTrackerRemove, _ := parseBoolParam(getEnv("TRACKER_DEAD_REMOVE", ""))
+	trackerHealth, err := NewTrackerHealth(db, client, poller,
+		getEnvDuration("TRACKER_CHECK_INTERVAL", defaultTrackerCheckInterval),
+		getEnvDuration("TRACKER_DEAD_AFTER", defaultTrackerDeadAfter),
+		deadTrackerRemove,
+	)
+	if err != nil {
+		log.Fatalf("Failed to create tracker health check: %v", err)
+	}
+
+	idempotency, err := NewIdempotency(db, getEnvDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL))
+	if err != nil {
+		log.Fatalf("Failed to create idempotency keys: %v", err)
+	}
+
+	tokens, err := NewAPITokens(db)
+	if err != nil {
+		log.Fatalf("Failed to create API tokens: %v", err)
+	}
+
+	indexers, err := NewIndexerStore(db)
+	if err != nil {
+		log.Fatalf("Failed to create indexer store: %v", err)
+	}
 
 	server, err := NewServer(client, feedManager)
 	if err != nil {
@@ -989,57 +2018,456 @@ func main() {
 		}
 		log.Fatalf("Failed to create server: %v", err)
 	}
+	server.poller = poller
+	server.usage = usage
+	server.altSpeed = altSpeed
+	server.labelCaps = labelCaps
+	server.peak = peak
+	server.addDefaults = addDefaults
+	server.arr = arr
+	server.indexers = indexers
+	server.torznab = NewTorznabSearch(indexers)
+	server.cookies = cookies
+	server.pause = pause
+	server.readOnly = readOnly
+	server.prowlarrKey = getEnv("PROWLARR_API_KEY", "")
+	perTorrent, ok := parseBoolParam(getEnv("METRICS_PER_TORRENT", ""))
+	server.metrics = NewMetricsCollector(client, poller, feedManager, getEnvDuration("METRICS_CACHE_TTL", defaultMetricsCacheTTL), perTorrent || !ok)
+	server.metricsToken = getEnv("METRICS_TOKEN", "")
+	server.notifier = notifier
+	server.transfers = transfers
+	server.bandwidth = bandwidth
+	server.dashboard = dashboard
+	server.history = history
+	server.registry = registry
+	server.adder = adder
+	server.policy = policy
+	server.sla = sla
+	server.seedRules = seedRules
+	server.hooks = hooks
+	server.activity = activity
+	server.traffic = traffic
+	server.swarm = swarm
+	server.reannounce = reannounce
+	server.disks = disks
+	server.trackerCheck = trackerHealth
+	server.tokens = tokens
+	server.hub = NewHub(poller)
+	poller.Watch(server.hub.Clients)
+	server.presence = NewPresence(getEnvDuration("PRESENCE_TIMEOUT", defaultPresenceTimeout))
+	server.presence.Watch(server.hub.Clients)
+	server.ports = NewPortChecker(client, server.presence, getEnvDuration("PORT_CHECK_INTERVAL", defaultPortCheckInterval))
+	egressPortTest, ok := parseBoolParam(getEnv("EGRESS_PORT_TEST", ""))
+	server.egress, err = NewEgressChecker(db, client, server.ports, events,
+		getEnv("EGRESS_CHECK_URL", ""),
+		getEnvDuration("EGRESS_CHECK_INTERVAL", defaultEgressCheckInterval),
+		egressPortTest || !ok,
+		getEnv("EGRESS_WEBHOOK_TOKEN", ""),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create egress checker: %v", err)
+	}
+	server.events = events
+
+	sessionTTL := getEnvDuration("WEB_SESSION_TTL", defaultSessionTTL)
+	var secureCookie *bool
+	if v, ok := parseBoolParam(getEnv("WEB_COOKIE_SECURE", "")); ok {
+		secureCookie = &v
+	}
+	server.auth, err = NewWebAuth(db, getEnv("WEB_USER", ""), getEnv("WEB_PASS", ""), sessionTTL, secureCookie)
+	if err != nil {
+		log.Fatalf("Failed to configure web authentication: %v", err)
+	}
+	authEnabled = server.auth != nil
+	if !authEnabled {
+		log.Printf("WEB_USER is not set; the UI and API are open to anyone who can reach them")
+	}
+
+	// Settings the config file can change without a restart
+	if configFile != nil {
+		configFile.OnReload("notifications", notifyEnvKeys, func() error {
+			notifier.SetEnvChannels(loadNotifyEnvChannels())
+			return nil
+		})
+		configFile.OnReload("add exclusions", []string{"ADD_EXCLUDE_PATTERNS"}, func() error {
+			return adder.SetExclusions(getEnvList("ADD_EXCLUDE_PATTERNS"))
+		})
+		configFile.OnReload("duplicate detection", []string{"DUPLICATE_TITLE_MODE"}, func() error {
+			return dupes.SetMode(getEnv("DUPLICATE_TITLE_MODE", DuplicateModeSkip))
+		})
+		if authEnabled {
+			configFile.OnReload("login", []string{"WEB_USER", "WEB_PASS"}, func() error {
+				return server.auth.SetCredentials(getEnv("WEB_USER", ""), getEnv("WEB_PASS", ""))
+			})
+		}
+		log.Printf("Settings from %s, reloaded when it changes", configFile.path)
+	}
+	rpcHealth, err := NewRPCHealth(db, getEnvDuration("RPC_HEALTH_RETENTION", defaultRPCHealthRetention), getEnvFloat("RPC_SLO", defaultRPCSLO))
+	if err != nil {
+		log.Fatalf("Failed to create RPC health tracker: %v", err)
+	}
+	rpcHealth.Track(DefaultInstance, client)
+	server.rpcHealth = rpcHealth
+	server.logs = logs
+	server.daemonLog = NewDaemonLog(getEnv("DAEMON_LOG_FILE", ""), getEnv("DAEMON_LOG_UNIT", ""))
+	server.instances = make(map[string]*TransmissionClient, len(instances))
+	for _, inst := range instances {
+		c := NewTransmissionClient(inst.URL, inst.User, inst.Pass)
+		c.ConfigureTransport(maxConns, http2)
+		c.SetRequestLimit(maxInflight, queueTimeout)
+		if err := c.PersistSession(db); err != nil {
+			log.Printf("Failed to restore RPC session for %s: %v", inst.Name, err)
+		}
+		server.instances[inst.Name] = c
+		rpcHealth.Track(inst.Name, c)
+		server.instanceOrder = append(server.instanceOrder, inst.Name)
+		log.Printf("Added Transmission instance %s at %s", inst.Name, inst.URL)
+	}
+
+	if geoPath := getEnv("GEOIP_DB_PATH", ""); geoPath != "" {
+		geo, err := OpenGeoIP(geoPath)
+		if err != nil {
+			log.Printf("GeoIP disabled: %v", err)
+		} else {
+			defer geo.Close()
+			server.geoip = geo
+		}
+	}
+
+	if searchURL := getEnv("BITMAGNET_URL", ""); searchURL != "" {
+		server.search = NewBitmagnetSearch(searchURL)
+	}
 
+	if ircPath := getEnv("IRC_ANNOUNCE_CONFIG", ""); ircPath != "" {
+		cfg, err := loadIRCConfig(ircPath)
+		if err != nil {
+			log.Fatalf("Failed to load IRC announce config: %v", err)
+		}
+		server.irc = NewIRCListener(cfg, adder)
+		server.irc.pause = pause
+	}
+
+	http.HandleFunc("GET /metrics", server.handleMetrics)
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 	http.HandleFunc("/", server.handleIndex)
+	http.Handle("GET /static/", http.FileServer(http.FS(staticFS)))
 	http.HandleFunc("/api/torrents", server.handleAPI)
-	http.HandleFunc("/api/peers", server.handlePeers)
+	http.HandleFunc("/api/torrents/window", server.handleTorrentWindow)
+	http.HandleFunc("/api/instances", server.handleInstances)
+	http.HandleFunc("/api/rpc/session", server.handleRPCSession)
+	http.HandleFunc("/api/poller", server.handlePollerStatus)
+	http.HandleFunc("/api/presence", server.handlePresence)
+	http.HandleFunc("/api/rpc/health", server.handleRPCHealth)
+	http.HandleFunc("/admin/rpc", server.handleRPCHealthPage)
+	http.HandleFunc("GET /api/logs", server.handleLogs)
+	http.HandleFunc("GET /api/logs/download", server.handleLogsDownload)
+	http.HandleFunc("/admin/logs", server.handleLogsPage)
+	http.HandleFunc("GET /api/support/bundle", server.handleSupportBundle)
+	http.HandleFunc("GET /api/update", server.handleUpdateStatus)
+	http.HandleFunc("/api/peers", requireFeature(FeaturePeers, server.handlePeers))
+	http.HandleFunc("/api/peers/geo", requireFeature(FeaturePeers, server.handlePeersGeo))
+	http.HandleFunc("/api/peers/all", requireFeature(FeaturePeers, server.handlePeersStream))
+	http.HandleFunc("/api/files", server.handleFilesStream)
 	http.HandleFunc("/api/trackers", server.handleTrackers)
 	http.HandleFunc("/api/add", server.handleAdd)
 	http.HandleFunc("/api/action", server.handleAction)
+	server.api = server.apiV1()
+	server.swaggerUI = getEnv("SWAGGER_UI_URL", defaultSwaggerUI)
+	http.Handle("/api/v1/", server.api)
+	http.HandleFunc("GET /api/openapi.json", server.handleOpenAPI)
+	http.HandleFunc("GET /admin/api", server.handleAPIDocsPage)
+	http.HandleFunc("/api/commands", server.handleCommands)
+	http.HandleFunc("/api/commands/run", server.handleRunCommand)
+	http.HandleFunc("GET /api/torrent/{id}", server.handleTorrentDetail)
+	http.HandleFunc("GET /api/torrent/{id}/tuning", server.handleGetTuning)
+	http.HandleFunc("POST /api/torrent/{id}/tuning", server.handleSetTuning)
+	http.HandleFunc("GET /api/torrent/{id}/limits", server.handleGetLimits)
+	http.HandleFunc("POST /api/torrent/{id}/limits", server.handleSetLimits)
+	http.HandleFunc("POST /api/torrent/{id}/files", server.handleSetFiles)
+	http.HandleFunc("POST /api/torrent/{id}/move", server.handleMoveTorrent)
+	http.HandleFunc("GET /api/torrent/{id}/activity", server.handleTorrentActivity)
+	http.HandleFunc("/api/usage", server.handleUsage)
+	http.HandleFunc("/api/stats", server.handleStats)
+	http.HandleFunc("GET /api/stats/history", server.handleStatsHistory)
+	http.HandleFunc("GET /api/disks", server.handleDisks)
+	http.HandleFunc("GET /api/dirs", server.handleDirs)
+	http.HandleFunc("GET /api/swarm", server.handleSwarmHealth)
+	http.HandleFunc("/api/dashboard", server.handleDashboard)
+	http.HandleFunc("POST /api/dashboard/reset", server.handleResetDashboard)
+	http.HandleFunc("/api/lpd", requireFeature(FeatureSettings, server.handleLPD))
+	http.HandleFunc("/api/altspeed", requireFeature(FeatureSettings, server.handleAltSpeed))
+	http.HandleFunc("/api/altspeed/rules/add", requireFeature(FeatureSettings, server.handleAddAltSpeedRule))
+	http.HandleFunc("/api/altspeed/rules/update", requireFeature(FeatureSettings, server.handleUpdateAltSpeedRule))
+	http.HandleFunc("/api/altspeed/rules/delete", requireFeature(FeatureSettings, server.handleDeleteAltSpeedRule))
+	http.HandleFunc("GET /api/labelcaps", server.handleGetLabelCaps)
+	http.HandleFunc("POST /api/labelcaps/add", requireFeature(FeatureSettings, server.handleAddLabelCap))
+	http.HandleFunc("POST /api/labelcaps/update", requireFeature(FeatureSettings, server.handleUpdateLabelCap))
+	http.HandleFunc("POST /api/labelcaps/delete", requireFeature(FeatureSettings, server.handleDeleteLabelCap))
+	http.HandleFunc("GET /api/peakrules", server.handleGetPeakRules)
+	http.HandleFunc("POST /api/peakrules/add", requireFeature(FeatureSettings, server.handleAddPeakRule))
+	http.HandleFunc("POST /api/peakrules/update", requireFeature(FeatureSettings, server.handleUpdatePeakRule))
+	http.HandleFunc("POST /api/peakrules/delete", requireFeature(FeatureSettings, server.handleDeletePeakRule))
+	http.HandleFunc("GET /api/adddefaults", server.handleGetAddDefaults)
+	http.HandleFunc("POST /api/adddefaults/update", requireFeature(FeatureSettings, server.handleSetAddDefaults))
+	http.HandleFunc("/api/notifications", server.handleGetNotifications)
+	http.HandleFunc("/api/notifications/add", server.handleAddNotification)
+	http.HandleFunc("/api/notifications/update", server.handleUpdateNotification)
+	http.HandleFunc("/api/notifications/delete", server.handleDeleteNotification)
+	http.HandleFunc("/api/notifications/test", server.handleTestNotification)
+	http.HandleFunc("GET /api/notifications/queue", server.handleGetNotifyQueue)
+	http.HandleFunc("POST /api/notifications/queue/retry", server.handleRetryNotifyQueue)
+	http.HandleFunc("POST /api/notifications/queue/delete", server.handleDeleteNotifyQueue)
+	http.HandleFunc("/api/hooks", server.handleGetHooks)
+	http.HandleFunc("/api/hooks/add", server.handleAddHook)
+	http.HandleFunc("/api/hooks/update", server.handleUpdateHook)
+	http.HandleFunc("/api/hooks/delete", server.handleDeleteHook)
+	http.HandleFunc("/api/hooks/run", server.handleRunHook)
+	http.HandleFunc("/api/session", server.handleSession)
+	http.HandleFunc("/settings", server.handleSettingsPage)
+	http.HandleFunc("GET /api/config/export", server.handleConfigExport)
+	http.HandleFunc("POST /api/config/import", requireFeature(FeatureSettings, server.handleConfigImport))
+	http.HandleFunc("GET /api/config/file", server.handleConfigFile)
+	http.HandleFunc("POST /api/config/file/reload", server.handleConfigFileReload)
+	http.HandleFunc("/api/events", server.handleEvents)
+	http.Handle("/ws", server.hub)
+	http.HandleFunc("/graveyard", server.handleGraveyard)
+	http.HandleFunc("/search", server.handleSearchPage)
+	http.HandleFunc("GET /basic", server.handleBasicPage)
+	http.HandleFunc("GET /basic/remove", server.handleBasicRemove)
+	http.HandleFunc("POST /basic/action", server.handleBasicAction)
+	http.HandleFunc("POST /basic/add", server.handleBasicAdd)
+	http.HandleFunc("/api/graveyard", server.handleGraveyardAPI)
 
 	// RSS feed endpoints
-	http.HandleFunc("/api/feeds", server.handleGetFeeds)
-	http.HandleFunc("/api/feeds/add", server.handleAddFeed)
-	http.HandleFunc("/api/feeds/update", server.handleUpdateFeed)
-	http.HandleFunc("/api/feeds/delete", server.handleDeleteFeed)
-	http.HandleFunc("/api/feeds/check", server.handleCheckFeed)
-	http.HandleFunc("/api/feeds/history", server.handleFeedHistory)
-	http.HandleFunc("/api/feeds/logs", server.handleFeedCheckLogs)
+	http.HandleFunc("/feeds", requireFeature(FeatureRSS, server.handleFeedsPage))
+	http.HandleFunc("GET /torrent/{id}", server.handleTorrentPage)
+	http.HandleFunc("/login", server.handleLogin)
+	http.HandleFunc("/logout", server.handleLogout)
+	http.HandleFunc("/api/feeds", requireFeature(FeatureRSS, server.handleGetFeeds))
+	http.HandleFunc("/api/feeds/add", requireFeature(FeatureRSS, server.handleAddFeed))
+	http.HandleFunc("/api/feeds/update", requireFeature(FeatureRSS, server.handleUpdateFeed))
+	http.HandleFunc("/api/feeds/delete", requireFeature(FeatureRSS, server.handleDeleteFeed))
+	http.HandleFunc("/api/feeds/check", requireFeature(FeatureRSS, server.handleCheckFeed))
+	http.HandleFunc("/api/feeds/preview", requireFeature(FeatureRSS, server.handlePreviewFeed))
+	http.HandleFunc("/api/feeds/history", requireFeature(FeatureRSS, server.handleFeedHistory))
+	http.HandleFunc("GET /api/feeds/{id}/history", requireFeature(FeatureRSS, server.handleGetFeedHistory))
+	http.HandleFunc("POST /api/feeds/{id}/history", requireFeature(FeatureRSS, server.handleMarkFeedHistory))
+	http.HandleFunc("POST /api/feeds/{id}/history/delete", requireFeature(FeatureRSS, server.handleDeleteFeedHistory))
+	http.HandleFunc("POST /api/feeds/{id}/backfill", requireFeature(FeatureRSS, server.handleBackfillFeed))
+	http.HandleFunc("GET /api/feeds/episodes", requireFeature(FeatureRSS, server.handleGetEpisodes))
+	http.HandleFunc("POST /api/feeds/episodes/forget", requireFeature(FeatureRSS, server.handleForgetEpisode))
+	http.HandleFunc("/api/feeds/logs", requireFeature(FeatureRSS, server.handleFeedCheckLogs))
+	http.HandleFunc("/api/feeds/pause", requireFeature(FeatureRSS, server.handleFeedPause))
+	http.HandleFunc("/api/pending", requireFeature(FeatureRSS, server.handleGetPending))
+	http.HandleFunc("/api/pending/approve", requireFeature(FeatureRSS, server.handleResolvePending))
+	http.HandleFunc("/api/pending/reject", requireFeature(FeatureRSS, server.handleResolvePending))
+	http.HandleFunc("/api/automation/pause", server.handleAutomationPause)
+	http.HandleFunc("/api/readonly", server.handleReadOnly)
+	http.HandleFunc("/api/feeds/logs/items", requireFeature(FeatureRSS, server.handleFeedCheckItems))
+	http.HandleFunc("/api/release/parse", server.handleParseRelease)
+
+	// Automation policy endpoints
+	http.HandleFunc("/api/policies", server.handleGetPolicies)
+	http.HandleFunc("/api/policies/add", server.handleAddPolicy)
+	http.HandleFunc("/api/policies/update", server.handleUpdatePolicy)
+	http.HandleFunc("/api/policies/delete", server.handleDeletePolicy)
+	http.HandleFunc("GET /api/slas", server.handleGetSLAs)
+	http.HandleFunc("POST /api/slas/add", server.handleAddSLA)
+	http.HandleFunc("POST /api/slas/update", server.handleUpdateSLA)
+	http.HandleFunc("POST /api/slas/delete", server.handleDeleteSLA)
+	http.HandleFunc("GET /api/seedrules", server.handleGetSeedRules)
+	http.HandleFunc("POST /api/seedrules/add", server.handleAddSeedRule)
+	http.HandleFunc("POST /api/seedrules/update", server.handleUpdateSeedRule)
+	http.HandleFunc("POST /api/seedrules/delete", server.handleDeleteSeedRule)
+
+	// Webhooks and *arr integration
+	http.HandleFunc("/api/webhooks/arr", server.handleArrWebhook)
+	http.HandleFunc("POST /api/webhooks/ip", server.handleEgressWebhook)
+	http.HandleFunc("/api/arr/downloads", server.handleArrDownloads)
+
+	// Tracker and network health
+	http.HandleFunc("GET /api/trackers/health", server.handleTrackerHealth)
+	http.HandleFunc("POST /api/trackers/health/check", server.handleTrackerHealthCheck)
+	http.HandleFunc("POST /api/trackers/dead/remove", server.handleRemoveDeadTrackers)
+	http.HandleFunc("GET /api/egress", server.handleEgress)
+	http.HandleFunc("POST /api/egress/check", server.handleEgressCheck)
+
+	// API tokens
+	http.HandleFunc("GET /api/tokens", server.handleGetTokens)
+	http.HandleFunc("POST /api/tokens/add", server.handleAddToken)
+	http.HandleFunc("POST /api/tokens/delete", server.handleDeleteToken)
+
+	// Indexers, search and Prowlarr's Sonarr-compatible indexer sync
+	http.HandleFunc("/api/indexers", server.handleGetIndexers)
+	http.HandleFunc("GET /api/indexers/search", server.handleIndexerSearch)
+	http.HandleFunc("POST /api/indexers/add", server.handleAddIndexer)
+	http.HandleFunc("POST /api/indexers/delete", server.handleDeleteIndexer)
+	http.HandleFunc("/api/search", server.handleSearch)
+	http.HandleFunc("/api/search/add", server.handleSearchAdd)
+	http.HandleFunc("GET /api/v3/system/status", server.prowlarrAuth(server.handleArrSystemStatus))
+	http.HandleFunc("GET /api/v3/indexer/schema", server.prowlarrAuth(server.handleArrIndexerSchema))
+	http.HandleFunc("GET /api/v3/indexer", server.prowlarrAuth(server.handleArrListIndexers))
+	http.HandleFunc("POST /api/v3/indexer", server.prowlarrAuth(server.handleArrAddIndexer))
+	http.HandleFunc("GET /api/v3/indexer/{id}", server.prowlarrAuth(server.handleArrGetIndexer))
+	http.HandleFunc("PUT /api/v3/indexer/{id}", server.prowlarrAuth(server.handleArrUpdateIndexer))
+	http.HandleFunc("DELETE /api/v3/indexer/{id}", server.prowlarrAuth(server.handleArrDeleteIndexer))
+
+	// Other add paths
+	http.HandleFunc("/api/irc", server.handleIRCStatus)
+	http.HandleFunc("/api/add/hash", server.handleAddHash)
+	http.HandleFunc("/api/cookies", server.handleGetCookies)
+	http.HandleFunc("/api/cookies/import", server.handleImportCookies)
+	http.HandleFunc("/api/cookies/delete", server.handleDeleteCookies)
 
 	log.Printf("Starting server on %s", config.ListenAddr)
 	log.Printf("Connecting to Transmission at %s", config.TransmissionURL)
 	log.Printf("RSS feed database: %s", dbPath)
 
+	// API tokens skip the login; scoped ones only reach their own torrents
+	app := readOnly.Middleware(server.presence.Middleware(idempotency.Middleware(http.DefaultServeMux)))
+
 	// Create HTTP server with timeouts for security
 	srv := &http.Server{
 		Addr:              config.ListenAddr,
+		Handler:           server.tokenMiddleware(server.auth.Middleware(app), app),
 		ReadTimeout:       15 * time.Second,
 		WriteTimeout:      15 * time.Second,
 		IdleTimeout:       60 * time.Second,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
-	// Start RSS feed polling after server is configured and ready to serve
-	feedManager.Start()
+	// Start background polling after server is configured and ready to serve
+	poller.Start()
+	server.ports.Start()
+	server.egress.Start()
+	trackerHealth.Start()
+	// Release checks are on by default, but not for demos
+	if check, ok := parseBoolParam(getEnv("UPDATE_CHECK", "")); check || !ok && demo == nil {
+		updateChecker = NewUpdateChecker(getEnv("UPDATE_URL", defaultUpdateURL), getEnvDuration("UPDATE_CHECK_INTERVAL", defaultUpdateCheckInterval))
+		updateChecker.Start()
+	}
+	if configFile != nil {
+		configFile.Watch(getEnvDuration("CONFIG_RELOAD_INTERVAL", defaultConfigReloadInterval))
+	}
+	rpcHealth.Start()
+	notifier.StartRetries(getEnvDuration("NOTIFY_RETRY_INTERVAL", defaultNotifyRetryInterval))
+	if features.Enabled(FeatureRSS) {
+		feedManager.Start()
+	}
+	if server.irc != nil {
+		server.irc.Start()
+	}
 
 	if err := srv.ListenAndServe(); err != nil {
 		if closeErr := feedManager.Close(); closeErr != nil {
 			log.Printf("Failed to close feed manager: %v", closeErr)
 		}
+		removeDemoData()
 		log.Fatalf("Server failed: %v", err)
 	}
 }
 
 func getEnv(key, defaultVal string) string {
-	if val := os.Getenv(key); val != "" {
+	if val := lookupEnv(key); val != "" {
 		return val
 	}
 	return defaultVal
 }
 
+// getEnvList reads a comma-separated list, ignoring empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(lookupEnv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		log.Printf("Invalid integer for %s: %q, using default %d", key, val, defaultVal)
+		return defaultVal
+	}
+	return n
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		log.Printf("Invalid number for %s: %q, using default %g", key, val, defaultVal)
+		return defaultVal
+	}
+	return f
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	val := lookupEnv(key)
+	if val == "" {
+		return defaultVal
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Printf("Invalid duration for %s: %q, using default %v", key, val, defaultVal)
+		return defaultVal
+	}
+	return d
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// pathID parses the {id} path segment, writing the error response itself
+// when it's invalid
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// queryID parses the required ?id= parameter, writing the error response
+// itself when it's missing or invalid
+func queryID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	idStr := r.URL.Query().Get("id")
+	if idStr == "" {
+		writeJSONError(w, "missing id parameter")
+		return 0, false
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		writeJSONError(w, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+// writeJSONError encodes the {"error": msg} envelope used by the API
+func writeJSONError(w http.ResponseWriter, msg string) {
+	writeJSON(w, map[string]string{"error": msg})
+}
+
 // isClientDisconnectError checks if an error is due to client disconnecting
 func isClientDisconnectError(err error) bool {
 	if err == nil {
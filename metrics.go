@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultMetricsCacheTTL = 15 * time.Second
+
+// MetricsCollector gathers what /metrics exports. Torrents and session
+// stats come from the poller's latest snapshot while it's fresh and are
+// otherwise fetched and cached for the TTL, so frequent scrapes (or several
+// Prometheus servers) don't each cost a round of RPCs. Free space is
+// cached the same way.
+type MetricsCollector struct {
+	client     *TransmissionClient
+	poller     *Poller
+	feeds      *FeedManager
+	ttl        time.Duration
+	perTorrent bool // per-torrent series, left out for very large libraries
+
+	mu        sync.Mutex
+	snapshot  *Snapshot
+	freeSpace *FreeSpace
+	freeAt    time.Time
+	freeErr   error
+}
+
+// NewMetricsCollector creates a collector caching results for ttl
+func NewMetricsCollector(client *TransmissionClient, poller *Poller, feeds *FeedManager, ttl time.Duration, perTorrent bool) *MetricsCollector {
+	if ttl <= 0 {
+		ttl = defaultMetricsCacheTTL
+	}
+	return &MetricsCollector{client: client, poller: poller, feeds: feeds, ttl: ttl, perTorrent: perTorrent}
+}
+
+// current returns torrents and stats no older than the TTL
+func (m *MetricsCollector) current(now time.Time) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if latest := m.poller.Latest(); latest != nil && now.Sub(latest.Time) < m.ttl && latest.Stats != nil {
+		m.snapshot = latest
+	}
+	if m.snapshot != nil && now.Sub(m.snapshot.Time) < m.ttl {
+		return m.snapshot, nil
+	}
+	torrents, err := m.client.GetTorrents()
+	if err != nil {
+		return nil, err
+	}
+	stats, err := m.client.GetSessionStats()
+	if err != nil {
+		return nil, err
+	}
+	m.snapshot = &Snapshot{Time: now, Torrents: torrents, Stats: stats}
+	return m.snapshot, nil
+}
+
+// free returns the download directory's free space, cached for the TTL
+func (m *MetricsCollector) free(now time.Time) (*FreeSpace, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if now.Sub(m.freeAt) < m.ttl {
+		return m.freeSpace, m.freeErr
+	}
+	m.freeAt = now
+	m.freeSpace, m.freeErr = nil, nil
+	cfg, err := m.client.GetSession()
+	if err != nil {
+		m.freeErr = err
+		return nil, err
+	}
+	if cfg.DownloadDir == nil {
+		return nil, nil
+	}
+	m.freeSpace, m.freeErr = m.client.GetFreeSpace(*cfg.DownloadDir)
+	return m.freeSpace, m.freeErr
+}
+
+// metricWriter writes the Prometheus text exposition format
+type metricWriter struct {
+	w io.Writer
+}
+
+func (mw metricWriter) family(name, typ, help string) {
+	fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one value; labels alternate names and values
+func (mw metricWriter) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(mw.w, "%s %s\n", b.String(), formatMetricValue(value))
+}
+
+// labelEscaper escapes label values the way the text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case v == math.Trunc(v) && math.Abs(v) < 1e15:
+		// Byte counts read better without an exponent
+		return strconv.FormatInt(int64(v), 10)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// metricStatusNames labels Transmission's status codes
+var metricStatusNames = map[int]string{
+	0: "stopped", 1: "check_wait", 2: "checking", 3: "download_wait", 4: "downloading", 5: "seed_wait", 6: "seeding",
+}
+
+// Write exports everything; a daemon that can't be reached still gets
+// transmission_up 0 and the feed metrics
+func (m *MetricsCollector) Write(w io.Writer) {
+	mw := metricWriter{w}
+	now := time.Now()
+	snap, err := m.current(now)
+
+	mw.family("transmission_up", "gauge", "Whether the daemon answered the last RPCs.")
+	mw.sample("transmission_up", boolMetric(err == nil))
+	if err != nil {
+		log.Printf("Metrics: couldn't reach Transmission: %v", err)
+	} else {
+		m.writeDaemon(mw, snap)
+	}
+	if free, err := m.free(now); err == nil && free != nil {
+		mw.family("transmission_free_space_bytes", "gauge", "Free space in the download directory.")
+		mw.sample("transmission_free_space_bytes", float64(free.SizeBytes), "path", free.Path)
+	}
+	m.writeFeeds(mw)
+}
+
+func (m *MetricsCollector) writeDaemon(mw metricWriter, snap *Snapshot) {
+	stats := snap.Stats
+	mw.family("transmission_download_speed_bytes", "gauge", "Current download rate in bytes per second.")
+	mw.sample("transmission_download_speed_bytes", float64(stats.DownloadSpeed))
+	mw.family("transmission_upload_speed_bytes", "gauge", "Current upload rate in bytes per second.")
+	mw.sample("transmission_upload_speed_bytes", float64(stats.UploadSpeed))
+	mw.family("transmission_downloaded_bytes_total", "counter", "Bytes downloaded over the daemon's lifetime.")
+	mw.sample("transmission_downloaded_bytes_total", float64(stats.CumulativeStats.DownloadedBytes))
+	mw.family("transmission_uploaded_bytes_total", "counter", "Bytes uploaded over the daemon's lifetime.")
+	mw.sample("transmission_uploaded_bytes_total", float64(stats.CumulativeStats.UploadedBytes))
+	mw.family("transmission_active_torrents", "gauge", "Torrents the daemon counts as active.")
+	mw.sample("transmission_active_torrents", float64(stats.ActiveTorrentCount))
+
+	byStatus := make(map[string]int)
+	for _, name := range metricStatusNames {
+		byStatus[name] = 0
+	}
+	errored := 0
+	for _, t := range snap.Torrents {
+		if name, ok := metricStatusNames[t.Status]; ok {
+			byStatus[name]++
+		}
+		if t.Error != 0 {
+			errored++
+		}
+	}
+	names := make([]string, 0, len(byStatus))
+	for name := range byStatus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	mw.family("transmission_torrents", "gauge", "Torrents by status.")
+	for _, name := range names {
+		mw.sample("transmission_torrents", float64(byStatus[name]), "status", name)
+	}
+	mw.family("transmission_torrents_errored", "gauge", "Torrents with a tracker or local error.")
+	mw.sample("transmission_torrents_errored", float64(errored))
+
+	if !m.perTorrent {
+		return
+	}
+	torrents := append([]Torrent(nil), snap.Torrents...)
+	sort.Slice(torrents, func(i, j int) bool { return torrents[i].ID < torrents[j].ID })
+	series := []struct {
+		name, typ, help string
+		value           func(t *Torrent) float64
+	}{
+		{"transmission_torrent_download_speed_bytes", "gauge", "Torrent download rate in bytes per second.", func(t *Torrent) float64 { return float64(t.RateDownload) }},
+		{"transmission_torrent_upload_speed_bytes", "gauge", "Torrent upload rate in bytes per second.", func(t *Torrent) float64 { return float64(t.RateUpload) }},
+		{"transmission_torrent_ratio", "gauge", "Torrent upload ratio.", func(t *Torrent) float64 { return max(t.UploadRatio, 0) }},
+		{"transmission_torrent_peers", "gauge", "Connected peers.", func(t *Torrent) float64 { return float64(t.PeersConnected) }},
+		{"transmission_torrent_percent_done", "gauge", "Share of the wanted data downloaded, 0 to 1.", func(t *Torrent) float64 { return t.PercentDone }},
+		{"transmission_torrent_size_bytes", "gauge", "Size of the wanted data.", func(t *Torrent) float64 { return float64(t.SizeWhenDone) }},
+		{"transmission_torrent_uploaded_bytes_total", "counter", "Bytes uploaded for the torrent.", func(t *Torrent) float64 { return float64(t.UploadedEver) }},
+	}
+	for _, s := range series {
+		mw.family(s.name, s.typ, s.help)
+		for i := range torrents {
+			t := &torrents[i]
+			mw.sample(s.name, s.value(t), "id", strconv.Itoa(t.ID), "name", t.Name, "tracker", t.PrimaryTrackerHost())
+		}
+	}
+}
+
+func (m *MetricsCollector) writeFeeds(mw metricWriter) {
+	feeds, err := m.feeds.GetFeeds()
+	if err != nil {
+		log.Printf("Metrics: failed to load feeds: %v", err)
+		return
+	}
+	type feedResult struct {
+		feed Feed
+		last *FeedCheckLog
+	}
+	results := make([]feedResult, 0, len(feeds))
+	for _, f := range feeds {
+		r := feedResult{feed: f}
+		if logs, err := m.feeds.GetFeedCheckLogs(f.ID, 1); err == nil && len(logs) > 0 {
+			r.last = &logs[0]
+		}
+		results = append(results, r)
+	}
+
+	labels := func(f Feed) []string { return []string{"feed", f.Name, "id", strconv.Itoa(f.ID)} }
+	value := func(name, typ, help string, v func(r feedResult) (float64, bool)) {
+		mw.family(name, typ, help)
+		for _, r := range results {
+			if x, ok := v(r); ok {
+				mw.sample(name, x, labels(r.feed)...)
+			}
+		}
+	}
+	value("transmission_web_feed_enabled", "gauge", "Whether the feed is checked on schedule.", func(r feedResult) (float64, bool) {
+		return boolMetric(r.feed.Enabled && !r.feed.Paused()), true
+	})
+	value("transmission_web_feed_last_check_timestamp_seconds", "gauge", "When the feed was last checked.", func(r feedResult) (float64, bool) {
+		return float64(r.feed.LastChecked.Unix()), !r.feed.LastChecked.IsZero()
+	})
+	value("transmission_web_feed_error", "gauge", "Whether the last check failed.", func(r feedResult) (float64, bool) {
+		return boolMetric(r.feed.LastError != ""), true
+	})
+	value("transmission_web_feed_matches_total", "counter", "Items matched since the feed was added.", func(r feedResult) (float64, bool) {
+		return float64(r.feed.MatchCount), true
+	})
+	value("transmission_web_feed_last_check_items", "gauge", "Items in the feed at the last check.", func(r feedResult) (float64, bool) {
+		if r.last == nil {
+			return 0, false
+		}
+		return float64(r.last.ItemsFound), true
+	})
+	value("transmission_web_feed_last_check_downloaded", "gauge", "Items added at the last check.", func(r feedResult) (float64, bool) {
+		if r.last == nil {
+			return 0, false
+		}
+		return float64(r.last.ItemsDownloaded), true
+	})
+}
+
+// handleMetrics serves the Prometheus scrape. With METRICS_TOKEN set it
+// takes that bearer token instead of a login, since Prometheus can't sign
+// in.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metricsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.metricsToken)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else if s.auth != nil && !s.auth.authenticated(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.Write(w)
+}
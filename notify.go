@@ -0,0 +1,598 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notification kinds a channel can subscribe to (NOTIFY_EVENTS and a
+// channel's events)
+const (
+	NotifyCompleted = "completed"
+	NotifyErrored   = "errored"
+	NotifyRSS       = "rss"
+	NotifyDisk      = "disk"
+	NotifyIP        = "ip"
+	NotifyLate      = "late"
+	NotifySeeded    = "seeded"
+)
+
+var notifyKinds = []string{NotifyCompleted, NotifyErrored, NotifyRSS, NotifyDisk, NotifyIP, NotifyLate, NotifySeeded}
+
+// Channel types
+const (
+	ChannelWebhook  = "webhook"
+	ChannelEmail    = "email"
+	ChannelTelegram = "telegram"
+	ChannelDiscord  = "discord"
+)
+
+const (
+	notifyTimeout            = 10 * time.Second
+	telegramAPI              = "https://api.telegram.org"
+	defaultDiskCheckInterval = 10 * time.Minute
+)
+
+// notifyKind maps a bus event to the notification kind it triggers, or ""
+func notifyKind(e Event) string {
+	switch {
+	case e.Type == EventTorrentCompleted:
+		return NotifyCompleted
+	case e.Type == EventTorrentErrored:
+		return NotifyErrored
+	case e.Type == EventDiskLow:
+		return NotifyDisk
+	case e.Type == EventIPChanged:
+		return NotifyIP
+	case e.Type == EventTorrentLate:
+		return NotifyLate
+	case e.Type == EventTorrentSeeded:
+		return NotifySeeded
+	case e.Type == EventTorrentAdded && e.Source == SourceRSS:
+		return NotifyRSS
+	}
+	return ""
+}
+
+// notifyText is the one-line message every backend sends
+func notifyText(e Event) string {
+	switch notifyKind(e) {
+	case NotifyCompleted:
+		return "✅ Download complete: " + e.Name
+	case NotifyErrored:
+		return fmt.Sprintf("⚠️ Torrent error: %s (%s)", e.Name, e.Message)
+	case NotifyRSS:
+		return "📥 Added from RSS: " + e.Name
+	case NotifyDisk:
+		return "💾 Disk nearly full: " + e.Message
+	case NotifyIP:
+		return "🌐 External address changed: " + e.Message
+	case NotifyLate:
+		return fmt.Sprintf("⏰ Running late: %s (%s)", e.Name, e.Message)
+	case NotifySeeded:
+		return fmt.Sprintf("🌱 Safe to remove: %s (%s)", e.Name, e.Message)
+	}
+	return e.Type + ": " + e.Name
+}
+
+// NotifyChannel is one place notifications go. Which fields apply depends
+// on the type: URL for webhook and Discord, Token and ChatID for Telegram,
+// and the SMTP fields for email.
+type NotifyChannel struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Enabled bool     `json:"enabled"`
+	Events  []string `json:"events"` // notifyKinds; empty means all
+	// FromEnv marks channels configured by NOTIFY_* variables, which can't
+	// be edited through the API
+	FromEnv bool `json:"fromEnv,omitempty"`
+
+	URL      string   `json:"url,omitempty"`
+	Token    string   `json:"token,omitempty"`
+	ChatID   string   `json:"chatId,omitempty"`
+	SMTPHost string   `json:"smtpHost,omitempty"`
+	SMTPPort int      `json:"smtpPort,omitempty"`
+	SMTPUser string   `json:"smtpUser,omitempty"`
+	SMTPPass string   `json:"smtpPass,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// Validate checks the channel has what its type needs
+func (c *NotifyChannel) Validate() error {
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, k := range c.Events {
+		if !slices.Contains(notifyKinds, k) {
+			return fmt.Errorf("unknown event %q, expected one of %s", k, strings.Join(notifyKinds, ", "))
+		}
+	}
+	switch c.Type {
+	case ChannelWebhook, ChannelDiscord:
+		if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("url must be an http(s) URL")
+		}
+	case ChannelTelegram:
+		if c.Token == "" || c.ChatID == "" {
+			return fmt.Errorf("token and chatId are required")
+		}
+	case ChannelEmail:
+		if c.SMTPHost == "" || c.From == "" || len(c.To) == 0 {
+			return fmt.Errorf("smtpHost, from and to are required")
+		}
+		if c.SMTPPort == 0 {
+			c.SMTPPort = 587
+		}
+	default:
+		return fmt.Errorf("unknown channel type %q", c.Type)
+	}
+	return nil
+}
+
+// wants reports whether the channel takes a kind of notification
+func (c *NotifyChannel) wants(kind string) bool {
+	return c.Enabled && (len(c.Events) == 0 || slices.Contains(c.Events, kind))
+}
+
+// redacted hides the channel's secrets for the API
+func (c NotifyChannel) redacted() NotifyChannel {
+	if c.Token != "" {
+		c.Token = "***"
+	}
+	if c.SMTPPass != "" {
+		c.SMTPPass = "***"
+	}
+	if c.Type == ChannelDiscord || c.Type == ChannelWebhook {
+		// Webhook URLs usually carry their own secret
+		if u, err := url.Parse(c.URL); err == nil {
+			c.URL = u.Scheme + "://" + u.Host + "/***"
+		}
+	}
+	return c
+}
+
+// notifyEnvKeys are the settings loadNotifyEnvChannels reads
+var notifyEnvKeys = []string{
+	"NOTIFY_EVENTS", "NOTIFY_WEBHOOK_URL", "NOTIFY_DISCORD_WEBHOOK",
+	"NOTIFY_TELEGRAM_TOKEN", "NOTIFY_TELEGRAM_CHAT_ID",
+	"NOTIFY_SMTP_HOST", "NOTIFY_SMTP_PORT", "NOTIFY_SMTP_USER", "NOTIFY_SMTP_PASS", "NOTIFY_SMTP_FROM", "NOTIFY_SMTP_TO",
+}
+
+// loadNotifyEnvChannels reads the NOTIFY_* channels
+func loadNotifyEnvChannels() []NotifyChannel {
+	events := getEnvList("NOTIFY_EVENTS")
+	var channels []NotifyChannel
+	if u := getEnv("NOTIFY_WEBHOOK_URL", ""); u != "" {
+		channels = append(channels, NotifyChannel{Name: "webhook", Type: ChannelWebhook, URL: u})
+	}
+	if u := getEnv("NOTIFY_DISCORD_WEBHOOK", ""); u != "" {
+		channels = append(channels, NotifyChannel{Name: "discord", Type: ChannelDiscord, URL: u})
+	}
+	if token := getEnv("NOTIFY_TELEGRAM_TOKEN", ""); token != "" {
+		channels = append(channels, NotifyChannel{Name: "telegram", Type: ChannelTelegram, Token: token, ChatID: getEnv("NOTIFY_TELEGRAM_CHAT_ID", "")})
+	}
+	if host := getEnv("NOTIFY_SMTP_HOST", ""); host != "" {
+		channels = append(channels, NotifyChannel{
+			Name: "email", Type: ChannelEmail,
+			SMTPHost: host, SMTPPort: getEnvInt("NOTIFY_SMTP_PORT", 587),
+			SMTPUser: getEnv("NOTIFY_SMTP_USER", ""), SMTPPass: getEnv("NOTIFY_SMTP_PASS", ""),
+			From: getEnv("NOTIFY_SMTP_FROM", ""), To: getEnvList("NOTIFY_SMTP_TO"),
+		})
+	}
+	valid := channels[:0]
+	for _, c := range channels {
+		c.Enabled, c.Events, c.FromEnv = true, events, true
+		if err := c.Validate(); err != nil {
+			log.Printf("⚠️ Ignoring %s notifications: %v", c.Name, err)
+			continue
+		}
+		valid = append(valid, c)
+	}
+	return valid
+}
+
+// Notifier delivers bus events to the configured channels: the NOTIFY_*
+// ones from the environment plus any stored in the notify_channels table.
+// Failed sends are queued in notify_queue and retried with backoff.
+type Notifier struct {
+	db          *sql.DB
+	client      *http.Client
+	maxAttempts int // before a failed send becomes a dead letter
+
+	mu  sync.RWMutex
+	env []NotifyChannel
+}
+
+// NewNotifier creates the notify_channels and notify_queue tables
+func NewNotifier(db *sql.DB, env []NotifyChannel, maxAttempts int) (*Notifier, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS notify_channels (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		config TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if err := createNotifyQueueTable(db); err != nil {
+		return nil, err
+	}
+	return &Notifier{
+		db:          db,
+		env:         env,
+		client:      &http.Client{Timeout: notifyTimeout},
+		maxAttempts: maxAttempts,
+	}, nil
+}
+
+// SetEnvChannels replaces the NOTIFY_* channels, for config reloads
+func (n *Notifier) SetEnvChannels(env []NotifyChannel) {
+	n.mu.Lock()
+	n.env = env
+	n.mu.Unlock()
+}
+
+// Channels returns the environment channels followed by the stored ones
+func (n *Notifier) Channels() ([]NotifyChannel, error) {
+	n.mu.RLock()
+	channels := append([]NotifyChannel{}, n.env...)
+	n.mu.RUnlock()
+	rows, err := n.db.Query("SELECT id, name, enabled, config FROM notify_channels ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c NotifyChannel
+		var id int
+		var name, config string
+		var enabled bool
+		if err := rows.Scan(&id, &name, &enabled, &config); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(config), &c); err != nil {
+			return nil, fmt.Errorf("channel %d: %w", id, err)
+		}
+		c.ID, c.Name, c.Enabled = id, name, enabled
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+func (n *Notifier) channel(id int) (*NotifyChannel, error) {
+	channels, err := n.Channels()
+	if err != nil {
+		return nil, err
+	}
+	for i := range channels {
+		if !channels[i].FromEnv && channels[i].ID == id {
+			return &channels[i], nil
+		}
+	}
+	return nil, fmt.Errorf("channel %d not found", id)
+}
+
+// AddChannel validates and stores a channel, setting its ID
+func (n *Notifier) AddChannel(c *NotifyChannel) error {
+	c.FromEnv = false
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	config, _ := json.Marshal(c)
+	res, err := n.db.Exec("INSERT INTO notify_channels (name, enabled, config) VALUES (?, ?, ?)", c.Name, c.Enabled, string(config))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	c.ID = int(id)
+	return nil
+}
+
+// UpdateChannel replaces a stored channel. Empty secrets keep the stored
+// ones, so a redacted channel from the API can be sent back as it is.
+func (n *Notifier) UpdateChannel(c *NotifyChannel) error {
+	old, err := n.channel(c.ID)
+	if err != nil {
+		return err
+	}
+	if c.Token == "" || c.Token == "***" {
+		c.Token = old.Token
+	}
+	if c.SMTPPass == "" || c.SMTPPass == "***" {
+		c.SMTPPass = old.SMTPPass
+	}
+	if c.URL == "" || strings.HasSuffix(c.URL, "/***") {
+		c.URL = old.URL
+	}
+	c.FromEnv = false
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	config, _ := json.Marshal(c)
+	_, err = n.db.Exec("UPDATE notify_channels SET name = ?, enabled = ?, config = ? WHERE id = ?", c.Name, c.Enabled, string(config), c.ID)
+	return err
+}
+
+// DeleteChannel removes a stored channel
+func (n *Notifier) DeleteChannel(id int) error {
+	_, err := n.db.Exec("DELETE FROM notify_channels WHERE id = ?", id)
+	return err
+}
+
+// OnEvent is the event bus subscriber: matching channels are sent the
+// event in the background, and failures queued for a retry
+func (n *Notifier) OnEvent(e Event) {
+	kind := notifyKind(e)
+	if kind == "" {
+		return
+	}
+	channels, err := n.Channels()
+	if err != nil {
+		log.Printf("Failed to load notification channels: %v", err)
+		return
+	}
+	for _, c := range channels {
+		if !c.wants(kind) {
+			continue
+		}
+		go func(c NotifyChannel) {
+			if err := n.Send(c, e); err != nil {
+				n.enqueue(c, e, err)
+			}
+		}(c)
+	}
+}
+
+// Send delivers one event to one channel
+func (n *Notifier) Send(c NotifyChannel, e Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	text := notifyText(e)
+	switch c.Type {
+	case ChannelWebhook:
+		return n.postJSON(ctx, c.URL, map[string]interface{}{"event": notifyKind(e), "text": text, "data": e})
+	case ChannelDiscord:
+		return n.postJSON(ctx, c.URL, map[string]string{"content": text})
+	case ChannelTelegram:
+		return n.postJSON(ctx, telegramAPI+"/bot"+c.Token+"/sendMessage", map[string]string{"chat_id": c.ChatID, "text": text})
+	case ChannelEmail:
+		return sendNotifyEmail(c, text)
+	}
+	return fmt.Errorf("unknown channel type %q", c.Type)
+}
+
+func (n *Notifier) postJSON(ctx context.Context, target string, body interface{}) error {
+	data, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The URL may hold a token (Telegram, Discord); don't log it
+		if uerr, ok := err.(*url.Error); ok {
+			return uerr.Err
+		}
+		return err
+	}
+	defer closeBody(resp)
+	if resp.StatusCode >= 300 {
+		err := fmt.Errorf("HTTP %d", resp.StatusCode)
+		// A 4xx is the request itself being refused, bar timeouts and rate limits
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+			return &permanentNotifyError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+func sendNotifyEmail(c NotifyChannel, text string) error {
+	// The text can carry torrent names, so line breaks are dropped from the
+	// subject rather than letting them start new headers
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace("[transmission-web] " + text)
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		c.From, strings.Join(c.To, ", "), mime.QEncoding.Encode("utf-8", subject), text)
+	var auth smtp.Auth
+	if c.SMTPUser != "" {
+		auth = smtp.PlainAuth("", c.SMTPUser, c.SMTPPass, c.SMTPHost)
+	}
+	err := smtp.SendMail(c.SMTPHost+":"+strconv.Itoa(c.SMTPPort), auth, c.From, c.To, []byte(msg.String()))
+	// 5xx replies (bad login, unknown recipient) won't succeed on a retry
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return &permanentNotifyError{err}
+	}
+	return err
+}
+
+// TorrentWatcher diffs torrent state between polls and publishes the
+// completed and errored events, and watches the download directory's free
+// space
+type TorrentWatcher struct {
+	events  *EventBus
+	client  *TransmissionClient
+	minFree int64 // bytes; 0 disables the disk check
+
+	mu      sync.Mutex
+	diskLow bool // already reported, until space recovers
+}
+
+// NewTorrentWatcher creates a watcher publishing to events
+func NewTorrentWatcher(events *EventBus, client *TransmissionClient, minFree int64) *TorrentWatcher {
+	return &TorrentWatcher{events: events, client: client, minFree: minFree}
+}
+
+// OnSnapshot compares each torrent with its previous state. The first poll
+// only sets the baseline, so restarts don't repeat old completions.
+func (tw *TorrentWatcher) OnSnapshot(prev, cur *Snapshot) {
+	if prev == nil {
+		return
+	}
+	before := make(map[string]*Torrent, len(prev.Torrents))
+	for i := range prev.Torrents {
+		before[prev.Torrents[i].HashString] = &prev.Torrents[i]
+	}
+	for _, t := range cur.Torrents {
+		p, ok := before[t.HashString]
+		if !ok {
+			continue
+		}
+		if p.PercentDone < 1 && t.PercentDone >= 1 {
+			tw.events.Publish(Event{Type: EventTorrentCompleted, Hash: t.HashString, Name: t.Name})
+		}
+		if p.Error == 0 && t.Error != 0 {
+			tw.events.Publish(Event{Type: EventTorrentErrored, Hash: t.HashString, Name: t.Name, Message: t.ErrorString})
+		}
+	}
+}
+
+// Start checks free space on an interval
+func (tw *TorrentWatcher) Start(interval time.Duration) {
+	if tw.minFree <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultDiskCheckInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			tw.checkDisk()
+			<-ticker.C
+		}
+	}()
+}
+
+// checkDisk reports once when free space drops below the minimum and again
+// only after it has recovered
+func (tw *TorrentWatcher) checkDisk() {
+	cfg, err := tw.client.GetSession()
+	if err != nil || cfg.DownloadDir == nil {
+		return
+	}
+	free, err := tw.client.GetFreeSpace(*cfg.DownloadDir)
+	if err != nil {
+		return
+	}
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	low := free.SizeBytes < tw.minFree
+	if low && !tw.diskLow {
+		tw.events.Publish(Event{Type: EventDiskLow, Message: fmt.Sprintf("%s free in %s", display.Bytes(free.SizeBytes), free.Path)})
+	}
+	tw.diskLow = low
+}
+
+func (s *Server) handleGetNotifications(w http.ResponseWriter, _ *http.Request) {
+	channels, err := s.notifier.Channels()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	for i := range channels {
+		channels[i] = channels[i].redacted()
+	}
+	writeJSON(w, map[string]interface{}{"channels": channels, "events": notifyKinds})
+}
+
+func (s *Server) handleAddNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c := NotifyChannel{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.notifier.AddChannel(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, c.redacted())
+}
+
+func (s *Server) handleUpdateNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var c NotifyChannel
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.notifier.UpdateChannel(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, c.redacted())
+}
+
+func (s *Server) handleDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.notifier.DeleteChannel(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleTestNotification sends a sample completion to one channel, by ?id=
+// or ?name= for the environment ones, and reports the delivery error
+func (s *Server) handleTestNotification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	channels, err := s.notifier.Channels()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	name := r.URL.Query().Get("name")
+	for _, c := range channels {
+		if (id > 0 && !c.FromEnv && c.ID == id) || (name != "" && c.Name == name) {
+			e := Event{Type: EventTorrentCompleted, Time: time.Now(), Name: "transmission-web test notification"}
+			if err := s.notifier.Send(c, e); err != nil {
+				writeJSONError(w, err.Error())
+				return
+			}
+			writeJSON(w, map[string]string{"status": "ok"})
+			return
+		}
+	}
+	writeJSONError(w, "channel not found")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultNotifyRetryAttempts = 8
+	defaultNotifyRetryInterval = 15 * time.Second
+	notifyRetryBase            = 30 * time.Second
+	notifyRetryMax             = time.Hour
+)
+
+// permanentNotifyError is a send the channel refused outright, such as a
+// webhook answering 404 or Telegram rejecting the token; retrying won't
+// help, so it goes straight to the dead letters
+type permanentNotifyError struct{ err error }
+
+func (e *permanentNotifyError) Error() string { return e.err.Error() }
+func (e *permanentNotifyError) Unwrap() error { return e.err }
+
+// QueuedNotification is a send that failed, waiting for its next attempt
+// or, once Dead, for someone to look at it
+type QueuedNotification struct {
+	ID          int       `json:"id"`
+	ChannelID   int       `json:"channelId,omitempty"` // 0 for NOTIFY_* channels
+	ChannelName string    `json:"channelName"`
+	Event       Event     `json:"event"`
+	Text        string    `json:"text"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError"`
+	NextAttempt time.Time `json:"nextAttempt,omitempty"`
+	Dead        bool      `json:"dead"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func createNotifyQueueTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS notify_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		channel_id INTEGER NOT NULL DEFAULT 0,
+		channel_name TEXT NOT NULL,
+		event TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 1,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt DATETIME NOT NULL,
+		dead INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create notify_queue table: %w", err)
+	}
+	return nil
+}
+
+// notifyBackoff is how long to wait after the given number of attempts:
+// 30s, 1m, 2m and so on, up to an hour
+func notifyBackoff(attempts int) time.Duration {
+	wait := notifyRetryBase
+	for i := 1; i < attempts && wait < notifyRetryMax; i++ {
+		wait *= 2
+	}
+	return min(wait, notifyRetryMax)
+}
+
+// enqueue records a failed first attempt: queued for a retry, or dead
+// straight away if the failure is permanent
+func (n *Notifier) enqueue(c NotifyChannel, e Event, sendErr error) {
+	data, _ := json.Marshal(e)
+	var permanent *permanentNotifyError
+	dead := errors.As(sendErr, &permanent) || n.maxAttempts <= 1
+	if dead {
+		log.Printf("Failed to send %s notification to %s: %v", notifyKind(e), c.Name, sendErr)
+	} else {
+		log.Printf("Failed to send %s notification to %s, retrying in %s: %v", notifyKind(e), c.Name, notifyBackoff(1), sendErr)
+	}
+	channelID := c.ID
+	if c.FromEnv {
+		channelID = 0
+	}
+	_, err := n.db.Exec(`INSERT INTO notify_queue (channel_id, channel_name, event, attempts, last_error, next_attempt, dead, created_at)
+		VALUES (?, ?, ?, 1, ?, ?, ?, ?)`, channelID, c.Name, string(data), sendErr.Error(), time.Now().Add(notifyBackoff(1)), dead, time.Now())
+	if err != nil {
+		log.Printf("Failed to queue notification for %s: %v", c.Name, err)
+	}
+}
+
+// queuedChannel finds the channel a queued send was for; nil once it's
+// been deleted or disabled
+func (n *Notifier) queuedChannel(q *QueuedNotification) *NotifyChannel {
+	channels, err := n.Channels()
+	if err != nil {
+		return nil
+	}
+	for i, c := range channels {
+		if q.ChannelID == 0 && c.FromEnv && c.Name == q.ChannelName || q.ChannelID != 0 && !c.FromEnv && c.ID == q.ChannelID {
+			if !c.Enabled {
+				return nil
+			}
+			return &channels[i]
+		}
+	}
+	return nil
+}
+
+const queueColumns = "id, channel_id, channel_name, event, attempts, last_error, next_attempt, dead, created_at"
+
+func scanQueued(row interface{ Scan(...interface{}) error }) (*QueuedNotification, error) {
+	var q QueuedNotification
+	var event string
+	if err := row.Scan(&q.ID, &q.ChannelID, &q.ChannelName, &event, &q.Attempts, &q.LastError, &q.NextAttempt, &q.Dead, &q.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(event), &q.Event); err != nil {
+		return nil, fmt.Errorf("queued notification %d: %w", q.ID, err)
+	}
+	q.Text = notifyText(q.Event)
+	if q.Dead {
+		q.NextAttempt = time.Time{}
+	}
+	return &q, nil
+}
+
+// Queued lists the sends waiting for a retry, or the dead letters
+func (n *Notifier) Queued(dead bool) ([]QueuedNotification, error) {
+	rows, err := n.db.Query("SELECT "+queueColumns+" FROM notify_queue WHERE dead = ? ORDER BY created_at DESC LIMIT 500", dead)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	queued := []QueuedNotification{}
+	for rows.Next() {
+		q, err := scanQueued(rows)
+		if err != nil {
+			return nil, err
+		}
+		queued = append(queued, *q)
+	}
+	return queued, rows.Err()
+}
+
+// retryDue makes the next attempt at every send that's due. A success
+// leaves the queue; a failure waits longer, until the last attempt or a
+// permanent failure makes it a dead letter.
+func (n *Notifier) retryDue() {
+	rows, err := n.db.Query("SELECT "+queueColumns+" FROM notify_queue WHERE dead = 0 AND next_attempt <= ? ORDER BY next_attempt LIMIT 50", time.Now())
+	if err != nil {
+		log.Printf("Failed to read the notification queue: %v", err)
+		return
+	}
+	var due []*QueuedNotification
+	for rows.Next() {
+		q, err := scanQueued(rows)
+		if err != nil {
+			log.Printf("Skipping queued notification: %v", err)
+			continue
+		}
+		due = append(due, q)
+	}
+	rows.Close()
+
+	for _, q := range due {
+		c := n.queuedChannel(q)
+		if c == nil {
+			n.db.Exec("UPDATE notify_queue SET dead = 1, last_error = ? WHERE id = ?", "channel removed or disabled", q.ID)
+			continue
+		}
+		err := n.Send(*c, q.Event)
+		if err == nil {
+			log.Printf("Delivered queued %s notification to %s", notifyKind(q.Event), c.Name)
+			n.db.Exec("DELETE FROM notify_queue WHERE id = ?", q.ID)
+			continue
+		}
+		attempts := q.Attempts + 1
+		var permanent *permanentNotifyError
+		dead := errors.As(err, &permanent) || attempts >= n.maxAttempts
+		if dead {
+			log.Printf("Giving up on %s notification to %s after %d attempts: %v", notifyKind(q.Event), c.Name, attempts, err)
+		}
+		n.db.Exec("UPDATE notify_queue SET attempts = ?, last_error = ?, next_attempt = ?, dead = ? WHERE id = ?",
+			attempts, err.Error(), time.Now().Add(notifyBackoff(attempts)), dead, q.ID)
+	}
+}
+
+// StartRetries retries queued sends every interval in the background
+func (n *Notifier) StartRetries(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			n.retryDue()
+		}
+	}()
+}
+
+// RetryQueued puts a dead letter (or a waiting send) back in the queue
+// for an immediate attempt, with its attempts reset
+func (n *Notifier) RetryQueued(id int) error {
+	res, err := n.db.Exec("UPDATE notify_queue SET dead = 0, attempts = 0, next_attempt = ? WHERE id = ?", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return fmt.Errorf("queued notification %d not found", id)
+	}
+	return nil
+}
+
+// DeleteQueued drops a queued send or dead letter
+func (n *Notifier) DeleteQueued(id int) error {
+	_, err := n.db.Exec("DELETE FROM notify_queue WHERE id = ?", id)
+	return err
+}
+
+// handleGetNotifyQueue serves GET /api/notifications/queue: the sends
+// waiting for a retry and the dead letters
+func (s *Server) handleGetNotifyQueue(w http.ResponseWriter, _ *http.Request) {
+	pending, err := s.notifier.Queued(false)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	dead, err := s.notifier.Queued(true)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"pending": pending, "dead": dead})
+}
+
+// handleRetryNotifyQueue serves POST /api/notifications/queue/retry?id=
+func (s *Server) handleRetryNotifyQueue(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.notifier.RetryQueued(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleDeleteNotifyQueue serves POST /api/notifications/queue/delete?id=
+func (s *Server) handleDeleteNotifyQueue(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.notifier.DeleteQueued(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The OpenAPI document for /api/v1 is generated from the router's routes
+// and the Go types their handlers decode and encode, so it can't drift
+// from what the API does. Schemas follow the json tags: a field without
+// omitempty is always sent, so it's required.
+
+// defaultSwaggerUI is pinned to an exact release, so the CDN can't serve a
+// different script than the one the page was tested with
+const defaultSwaggerUI = "https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.17.14"
+
+// apiOp documents a v1 route
+type apiOp struct {
+	ID       string // operationId, for generated clients' method names
+	Summary  string
+	Query    []apiParam
+	Body     interface{} // the request body, as a zero value; nil for none
+	Response interface{} // the success body, as a zero value; nil for a 204
+	Status   int         // the success status; 200, or 204 without a Response
+	Also     []int       // other success statuses with the same body
+}
+
+// apiParam is a query or path parameter
+type apiParam struct {
+	Name        string
+	Type        string // string, integer or boolean
+	Description string
+}
+
+// apiRoute is a registered v1 route
+type apiRoute struct {
+	Method string
+	Path   string
+	Op     apiOp
+}
+
+// apiPathParams describe the {wildcards} in v1 paths
+var apiPathParams = map[string]apiParam{
+	"id":   {Type: "integer", Description: "Torrent ID"},
+	"move": {Type: "string", Description: "top, up, down or bottom"},
+}
+
+func instanceParam(all bool) apiParam {
+	p := apiParam{Name: "instance", Type: "string", Description: "Daemon from TRANSMISSION_INSTANCES; the default daemon when empty"}
+	if all {
+		p.Description += `, or "` + AllInstances + `" for every daemon`
+	}
+	return p
+}
+
+// torrentListParams are /api/torrents' filter, sort and paging parameters
+var torrentListParams = []apiParam{
+	{Name: "status", Type: "string", Description: "Comma-separated statuses: " + strings.Join(torrentStatusNames, ", ")},
+	{Name: "tracker", Type: "string", Description: "Tracker host"},
+	{Name: "label", Type: "string", Description: "Label"},
+	{Name: "search", Type: "string", Description: "Case-insensitive name search"},
+	{Name: "sort", Type: "string", Description: "One of " + strings.Join(listSortKeys, ", ")},
+	{Name: "order", Type: "string", Description: "asc or desc"},
+	{Name: "page", Type: "integer", Description: "Page number, from 1"},
+	{Name: "per_page", Type: "integer", Description: "Page size, up to " + strconv.Itoa(maxPageSize)},
+	{Name: "private", Type: "boolean", Description: "Only private (true) or only public (false) torrents"},
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// openAPISchemas builds JSON schemas from Go types, collecting named
+// structs as components
+type openAPISchemas map[string]interface{}
+
+func (g openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawType:
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g[t.Name()]; !ok {
+			g[t.Name()] = nil // placeholder, for types that contain themselves
+			g[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+func (g openAPISchemas) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	required := []string{}
+	g.fields(t, props, &required)
+	s := map[string]interface{}{"type": "object", "properties": props}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// fields adds t's JSON fields to props, flattening embedded structs the
+// way encoding/json does
+func (g openAPISchemas) fields(t reflect.Type, props map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.fields(ft, props, required)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Func, reflect.Chan:
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = g.schema(f.Type)
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func openAPIParam(in string, p apiParam) map[string]interface{} {
+	param := map[string]interface{}{
+		"name":   p.Name,
+		"in":     in,
+		"schema": map[string]interface{}{"type": p.Type},
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	if in == "path" {
+		param["required"] = true
+	}
+	return param
+}
+
+// openAPI builds the OpenAPI 3 document for the router's routes
+func (rt *apiRouter) openAPI() map[string]interface{} {
+	schemas := openAPISchemas{}
+	errorResponse := map[string]interface{}{
+		"description": "The error envelope",
+		"content":     jsonContent(schemas.schema(reflect.TypeOf(APIErrorBody{}))),
+	}
+	paths := map[string]map[string]interface{}{}
+	for _, route := range rt.routes {
+		op := route.Op
+		params := []interface{}{}
+		for _, part := range strings.Split(route.Path, "/") {
+			if name, ok := strings.CutPrefix(part, "{"); ok {
+				p := apiPathParams[strings.TrimSuffix(name, "}")]
+				p.Name = strings.TrimSuffix(name, "}")
+				if p.Type == "" {
+					p.Type = "string"
+				}
+				params = append(params, openAPIParam("path", p))
+			}
+		}
+		for _, p := range op.Query {
+			params = append(params, openAPIParam("query", p))
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+			if op.Response == nil {
+				status = http.StatusNoContent
+			}
+		}
+		responses := map[string]interface{}{"default": errorResponse}
+		for _, code := range append([]int{status}, op.Also...) {
+			resp := map[string]interface{}{"description": http.StatusText(code)}
+			if op.Response != nil {
+				resp["content"] = jsonContent(schemas.schema(reflect.TypeOf(op.Response)))
+			}
+			if code == http.StatusCreated {
+				resp["headers"] = map[string]interface{}{
+					"Location": map[string]interface{}{"description": "The new resource", "schema": map[string]interface{}{"type": "string"}},
+				}
+			}
+			responses[strconv.Itoa(code)] = resp
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"parameters":  params,
+			"responses":   responses,
+		}
+		if op.Body != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(schemas.schema(reflect.TypeOf(op.Body))),
+			}
+		}
+		if paths[route.Path] == nil {
+			paths[route.Path] = map[string]interface{}{}
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Transmission Web API",
+			"version":     Version,
+			"description": "The resource API for scripts and apps. Send an API token as a bearer token, or sign in when WEB_USER is set.",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer", "description": "An API token"},
+				"basicAuth":  map[string]interface{}{"type": "http", "scheme": "basic"},
+				"cookieAuth": map[string]interface{}{"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		},
+		// Without WEB_USER nothing is required, hence the empty alternative
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"basicAuth": []string{}},
+			map[string]interface{}{"cookieAuth": []string{}},
+			map[string]interface{}{},
+		},
+	}
+}
+
+// handleOpenAPI serves GET /api/openapi.json
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.api.openAPI())
+}
+
+// handleAPIDocsPage renders the API reference: Swagger UI from SWAGGER_UI_URL
+// over the generated document, with a plain route list when it can't load
+func (s *Server) handleAPIDocsPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, "apidocs.html", map[string]interface{}{
+		"Routes":    s.api.routes,
+		"SwaggerUI": strings.TrimSuffix(s.swaggerUI, "/"),
+		"Version":   Version,
+	})
+}
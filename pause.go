@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const automationPauseKey = "automation_pause"
+
+// PauseStatus describes the global automation pause
+type PauseStatus struct {
+	Paused bool       `json:"paused"`
+	Until  *time.Time `json:"until,omitempty"` // nil while paused means until resumed
+	Reason string     `json:"reason,omitempty"`
+}
+
+// AutomationPause is the global kill switch for RSS polling, IRC announce
+// adds and the watch folder, e.g. during a tracker outage. Manual adds are
+// unaffected.
+type AutomationPause struct {
+	db *sql.DB
+
+	mu     sync.Mutex
+	paused bool
+	until  time.Time // zero while paused means indefinitely
+	reason string
+}
+
+// NewAutomationPause loads the stored pause state
+func NewAutomationPause(db *sql.DB) (*AutomationPause, error) {
+	p := &AutomationPause{db: db}
+	value, err := getSetting(db, automationPauseKey)
+	if err != nil || value == "" {
+		return p, err
+	}
+	var stored struct {
+		Until  time.Time `json:"until"`
+		Reason string    `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, fmt.Errorf("invalid stored automation pause: %w", err)
+	}
+	p.paused, p.until, p.reason = true, stored.Until, stored.Reason
+	return p, nil
+}
+
+// Active reports whether automation is currently paused
+func (p *AutomationPause) Active() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused && (p.until.IsZero() || time.Now().Before(p.until))
+}
+
+// Pause silences automation until the given time, or until Resume if
+// until is zero
+func (p *AutomationPause) Pause(until time.Time, reason string) error {
+	value, _ := json.Marshal(map[string]interface{}{"until": until, "reason": reason})
+	if err := setSetting(p.db, automationPauseKey, string(value)); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.paused, p.until, p.reason = true, until, reason
+	p.mu.Unlock()
+	return nil
+}
+
+// Resume lifts the pause
+func (p *AutomationPause) Resume() error {
+	if err := setSetting(p.db, automationPauseKey, ""); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.paused, p.until, p.reason = false, time.Time{}, ""
+	p.mu.Unlock()
+	return nil
+}
+
+// Status returns the current pause state
+func (p *AutomationPause) Status() PauseStatus {
+	if !p.Active() {
+		return PauseStatus{}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	status := PauseStatus{Paused: true, Reason: p.reason}
+	if !p.until.IsZero() {
+		until := p.until
+		status.Until = &until
+	}
+	return status
+}
+
+// pauseRequest is the body of the pause endpoints. Until takes precedence
+// over Minutes; with neither a global pause lasts until resumed and a feed
+// pause is lifted.
+type pauseRequest struct {
+	ID      int        `json:"id,omitempty"`
+	Paused  bool       `json:"paused"`
+	Until   *time.Time `json:"until,omitempty"`
+	Minutes int        `json:"minutes,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+func (req *pauseRequest) deadline() time.Time {
+	switch {
+	case req.Until != nil:
+		return *req.Until
+	case req.Minutes > 0:
+		return time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+	}
+	return time.Time{}
+}
+
+func (s *Server) handleAutomationPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, s.pause.Status())
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+
+	var err error
+	if req.Paused {
+		until := req.deadline()
+		if !until.IsZero() && !until.After(time.Now()) {
+			writeJSONError(w, "until must be in the future")
+			return
+		}
+		err = s.pause.Pause(until, req.Reason)
+		log.Printf("Automation paused (until %v): %s", until, req.Reason)
+	} else {
+		err = s.pause.Resume()
+		log.Printf("Automation resumed")
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, s.pause.Status())
+}
+
+// handleFeedPause pauses one feed until a time, or resumes it
+func (s *Server) handleFeedPause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req pauseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == 0 {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	until := req.deadline()
+	if !until.IsZero() && !until.After(time.Now()) {
+		writeJSONError(w, "until must be in the future")
+		return
+	}
+	if err := s.feedManager.PauseFeed(req.ID, until); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultPeakInterval = 30 * time.Second
+
+// Peak rule orders: which of the waiting torrents keep downloading
+const (
+	PeakOrderOldest = "oldest"
+	PeakOrderNewest = "newest"
+)
+
+// PeakRule limits how many torrents download at once during a window,
+// tighter than Transmission's download-queue-size, e.g. weekday evenings
+// when the line is shared. Torrents carrying one of Labels go first, in
+// the order given, then the rest by age.
+type PeakRule struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Enabled      bool     `json:"enabled"`
+	Days         []string `json:"days"`  // weekdayNames; empty means every day
+	Start        string   `json:"start"` // "HH:MM"
+	End          string   `json:"end"`
+	MaxDownloads int      `json:"maxDownloads"` // 0 pauses every download
+	Order        string   `json:"order"`        // PeakOrderOldest or PeakOrderNewest
+	Labels       []string `json:"labels,omitempty"`
+}
+
+// Validate checks the rule and normalizes its days, times and labels
+func (r *PeakRule) Validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return err
+	}
+	if start == end {
+		return fmt.Errorf("start and end must differ")
+	}
+	mask, err := dayMask(r.Days)
+	if err != nil {
+		return err
+	}
+	if r.MaxDownloads < 0 {
+		return fmt.Errorf("maxDownloads must not be negative")
+	}
+	switch r.Order {
+	case "":
+		r.Order = PeakOrderOldest
+	case PeakOrderOldest, PeakOrderNewest:
+	default:
+		return fmt.Errorf("order must be %s or %s", PeakOrderOldest, PeakOrderNewest)
+	}
+	labels, err := normalizeLabels(r.Labels)
+	if err != nil {
+		return err
+	}
+	r.Start, r.End, r.Days, r.Labels = formatClock(start), formatClock(end), maskDays(mask), labels
+	return nil
+}
+
+// activeAt reports whether the rule's window covers t, in appLocation
+func (r *PeakRule) activeAt(t time.Time) bool {
+	if !r.Enabled {
+		return false
+	}
+	start, err1 := parseClock(r.Start)
+	end, err2 := parseClock(r.End)
+	mask, err3 := dayMask(r.Days)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	return windowActive(mask, start, end, t)
+}
+
+// rank orders torrents for the rule's slots: a lower label index first,
+// then by age, then by ID
+func (r *PeakRule) rank(torrents []*Torrent) {
+	labelRank := func(t *Torrent) int {
+		for i, l := range r.Labels {
+			if slices.Contains(t.Labels, l) {
+				return i
+			}
+		}
+		return len(r.Labels)
+	}
+	sort.SliceStable(torrents, func(i, j int) bool {
+		a, b := torrents[i], torrents[j]
+		if la, lb := labelRank(a), labelRank(b); la != lb {
+			return la < lb
+		}
+		if a.AddedDate != b.AddedDate {
+			if r.Order == PeakOrderNewest {
+				return a.AddedDate > b.AddedDate
+			}
+			return a.AddedDate < b.AddedDate
+		}
+		return a.ID < b.ID
+	})
+}
+
+// PeakLimiter applies the peak rules to the default daemon every
+// PEAK_LIMIT_INTERVAL. While a rule is active, downloading and queued
+// torrents past its limit are stopped, and started again as slots free up
+// or once the window ends. Only torrents it stopped itself are started, so
+// a torrent paused by hand stays paused; those are kept in SQLite so a
+// restart mid-window doesn't strand them.
+type PeakLimiter struct {
+	db       *sql.DB
+	client   *TransmissionClient
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	paused  map[string]bool // by hash
+	active  *PeakRule
+}
+
+// NewPeakLimiter creates the peak_rules table, and peak_paused for the
+// torrents it's holding back
+func NewPeakLimiter(db *sql.DB, client *TransmissionClient, interval time.Duration) (*PeakLimiter, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS peak_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		days INTEGER NOT NULL,
+		start_minute INTEGER NOT NULL,
+		end_minute INTEGER NOT NULL,
+		max_downloads INTEGER NOT NULL,
+		sort_order TEXT NOT NULL,
+		labels TEXT NOT NULL DEFAULT '[]'
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create peak_rules table: %w", err)
+	}
+	if _, err := db.Exec("CREATE TABLE IF NOT EXISTS peak_paused (hash TEXT PRIMARY KEY)"); err != nil {
+		return nil, fmt.Errorf("failed to create peak_paused table: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultPeakInterval
+	}
+	pl := &PeakLimiter{db: db, client: client, interval: interval, paused: map[string]bool{}}
+
+	rows, err := db.Query("SELECT hash FROM peak_paused")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		pl.paused[hash] = true
+	}
+	return pl, rows.Err()
+}
+
+// Rules returns every rule in ID order
+func (pl *PeakLimiter) Rules() ([]PeakRule, error) {
+	rows, err := pl.db.Query("SELECT id, name, enabled, days, start_minute, end_minute, max_downloads, sort_order, labels FROM peak_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []PeakRule{}
+	for rows.Next() {
+		var r PeakRule
+		var days, start, end int
+		var labels string
+		if err := rows.Scan(&r.ID, &r.Name, &r.Enabled, &days, &start, &end, &r.MaxDownloads, &r.Order, &labels); err != nil {
+			return nil, err
+		}
+		r.Days, r.Start, r.End = maskDays(days), formatClock(start), formatClock(end)
+		if err := json.Unmarshal([]byte(labels), &r.Labels); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// AddRule validates and stores a rule, setting its ID
+func (pl *PeakLimiter) AddRule(r *PeakRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	days, _ := dayMask(r.Days)
+	start, _ := parseClock(r.Start)
+	end, _ := parseClock(r.End)
+	labels, _ := json.Marshal(r.Labels)
+	res, err := pl.db.Exec("INSERT INTO peak_rules (name, enabled, days, start_minute, end_minute, max_downloads, sort_order, labels) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		r.Name, r.Enabled, days, start, end, r.MaxDownloads, r.Order, string(labels))
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	r.ID = int(id)
+	pl.recheck()
+	return nil
+}
+
+// UpdateRule replaces a stored rule
+func (pl *PeakLimiter) UpdateRule(r *PeakRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	days, _ := dayMask(r.Days)
+	start, _ := parseClock(r.Start)
+	end, _ := parseClock(r.End)
+	labels, _ := json.Marshal(r.Labels)
+	res, err := pl.db.Exec("UPDATE peak_rules SET name = ?, enabled = ?, days = ?, start_minute = ?, end_minute = ?, max_downloads = ?, sort_order = ?, labels = ? WHERE id = ?",
+		r.Name, r.Enabled, days, start, end, r.MaxDownloads, r.Order, string(labels), r.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("rule %d not found", r.ID)
+	}
+	pl.recheck()
+	return nil
+}
+
+// DeleteRule removes a rule; what it held back starts on the next poll
+func (pl *PeakLimiter) DeleteRule(id int) error {
+	if _, err := pl.db.Exec("DELETE FROM peak_rules WHERE id = ?", id); err != nil {
+		return err
+	}
+	pl.recheck()
+	return nil
+}
+
+// recheck makes the next poll apply the rules
+func (pl *PeakLimiter) recheck() {
+	pl.mu.Lock()
+	pl.lastRun = time.Time{}
+	pl.mu.Unlock()
+}
+
+// Active returns the rule in force at the last check, or nil
+func (pl *PeakLimiter) Active() *PeakRule {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.active
+}
+
+// Paused returns the hashes of the torrents being held back
+func (pl *PeakLimiter) Paused() []string {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	hashes := make([]string, 0, len(pl.paused))
+	for hash := range pl.paused {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// OnSnapshot applies the first active rule, in ID order, at most once per
+// interval
+func (pl *PeakLimiter) OnSnapshot(_, cur *Snapshot) {
+	pl.mu.Lock()
+	if cur.Time.Sub(pl.lastRun) < pl.interval {
+		pl.mu.Unlock()
+		return
+	}
+	pl.lastRun = cur.Time
+	paused := make(map[string]bool, len(pl.paused))
+	for hash := range pl.paused {
+		paused[hash] = true
+	}
+	pl.mu.Unlock()
+
+	rules, err := pl.Rules()
+	if err != nil {
+		log.Printf("Failed to load peak rules: %v", err)
+		return
+	}
+	var active *PeakRule
+	for i := range rules {
+		if rules[i].activeAt(cur.Time) {
+			active = &rules[i]
+			break
+		}
+	}
+
+	// Incomplete torrents downloading or waiting to, and the ones this
+	// stopped; any other stopped torrent was paused by someone else
+	var wanting []*Torrent
+	for i := range cur.Torrents {
+		t := &cur.Torrents[i]
+		if t.PercentDone >= 1 {
+			continue
+		}
+		if t.Status == 3 || t.Status == 4 || (t.Status == 0 && paused[t.HashString]) {
+			wanting = append(wanting, t)
+		}
+	}
+
+	held := map[string]bool{}
+	if active != nil {
+		active.rank(wanting)
+	}
+	for i, t := range wanting {
+		if active != nil && i >= active.MaxDownloads {
+			if t.Status != 0 {
+				if err := pl.client.StopTorrent(t.ID); err != nil {
+					log.Printf("Failed to hold back %s for %s: %v", t.Name, active.Name, err)
+					continue
+				}
+				log.Printf("Peak hours (%s): holding back %s", active.Name, t.Name)
+			}
+			held[t.HashString] = true
+			continue
+		}
+		if t.Status == 0 {
+			if err := pl.client.StartTorrent(t.ID); err != nil {
+				log.Printf("Failed to resume %s after peak hours: %v", t.Name, err)
+				held[t.HashString] = true
+				continue
+			}
+			log.Printf("Peak hours: resumed %s", t.Name)
+		}
+	}
+
+	pl.mu.Lock()
+	pl.active = active
+	pl.paused = held
+	pl.mu.Unlock()
+	for hash := range paused {
+		if !held[hash] {
+			if _, err := pl.db.Exec("DELETE FROM peak_paused WHERE hash = ?", hash); err != nil {
+				log.Printf("Failed to forget a held-back torrent: %v", err)
+			}
+		}
+	}
+	for hash := range held {
+		if !paused[hash] {
+			if _, err := pl.db.Exec("INSERT OR IGNORE INTO peak_paused (hash) VALUES (?)", hash); err != nil {
+				log.Printf("Failed to record a held-back torrent: %v", err)
+			}
+		}
+	}
+}
+
+func (s *Server) handleGetPeakRules(w http.ResponseWriter, _ *http.Request) {
+	rules, err := s.peak.Rules()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"rules": rules, "active": s.peak.Active(), "paused": s.peak.Paused()})
+}
+
+func (s *Server) handleAddPeakRule(w http.ResponseWriter, r *http.Request) {
+	rule := PeakRule{Enabled: true}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.peak.AddRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleUpdatePeakRule(w http.ResponseWriter, r *http.Request) {
+	var rule PeakRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.peak.UpdateRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleDeletePeakRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.peak.DeleteRule(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy actions
+const (
+	PolicyActionStop        = "stop"
+	PolicyActionRemove      = "remove"
+	PolicyActionRemoveData  = "remove-data"
+	PolicyActionReannounce  = "reannounce"
+	PolicyActionAddTrackers = "add-trackers"
+)
+
+// SourcePolicy attributes removals made by automation rules
+const SourcePolicy = "policy"
+
+// reannounceCooldown stops a matching rule from reannouncing every poll
+const reannounceCooldown = time.Hour
+
+// PolicyConditions are ANDed together; zero values match everything
+type PolicyConditions struct {
+	Private       *bool   `json:"private,omitempty"`
+	MinRatio      float64 `json:"minRatio,omitempty"`
+	MinSeedHours  float64 `json:"minSeedHours,omitempty"`
+	ErrorContains string  `json:"errorContains,omitempty"`
+	NamePattern   string  `json:"namePattern,omitempty"`
+	Status        []int   `json:"status,omitempty"`
+	ArrImported   *bool   `json:"arrImported,omitempty"` // Sonarr/Radarr imported the files
+	// The download's swarm class, held for at least MinSwarmDays
+	Swarm        string  `json:"swarm,omitempty"`
+	MinSwarmDays float64 `json:"minSwarmDays,omitempty"`
+
+	nameRe *regexp.Regexp // NamePattern, compiled once
+}
+
+// empty reports whether no condition is set, so every torrent matches
+func (c *PolicyConditions) empty() bool {
+	return c.Private == nil && c.MinRatio <= 0 && c.MinSeedHours <= 0 && c.ErrorContains == "" &&
+		c.NamePattern == "" && len(c.Status) == 0 && c.ArrImported == nil && c.Swarm == ""
+}
+
+// compile compiles NamePattern
+func (c *PolicyConditions) compile() error {
+	c.nameRe = nil
+	if c.NamePattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(c.NamePattern)
+	if err != nil {
+		return fmt.Errorf("invalid name pattern: %w", err)
+	}
+	c.nameRe = re
+	return nil
+}
+
+// destructive reports whether the action removes torrents
+func (r *PolicyRule) destructive() bool {
+	return r.Action == PolicyActionRemove || r.Action == PolicyActionRemoveData
+}
+
+// PolicyRule is an automation rule applied to every torrent on each run
+type PolicyRule struct {
+	ID         int              `json:"id"`
+	Name       string           `json:"name"`
+	Enabled    bool             `json:"enabled"`
+	Action     string           `json:"action"`
+	Conditions PolicyConditions `json:"conditions"`
+	Trackers   []string         `json:"trackers,omitempty"` // for add-trackers
+}
+
+// Validate checks the rule's action and pattern
+func (r *PolicyRule) Validate() error {
+	switch r.Action {
+	case PolicyActionStop, PolicyActionRemove, PolicyActionReannounce:
+	case PolicyActionRemoveData:
+		if !features.Enabled(FeatureRemoveData) {
+			return featureError(FeatureRemoveData)
+		}
+	case PolicyActionAddTrackers:
+		if len(r.Trackers) == 0 {
+			return fmt.Errorf("add-trackers requires at least one tracker")
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+	if r.destructive() && r.Conditions.empty() {
+		return fmt.Errorf("%s needs at least one condition; without one it matches every torrent", r.Action)
+	}
+	if err := r.Conditions.compile(); err != nil {
+		return err
+	}
+	switch r.Conditions.Swarm {
+	case "", SwarmHealthy, SwarmSlow, SwarmDead:
+	default:
+		return fmt.Errorf("swarm must be %s, %s or %s", SwarmHealthy, SwarmSlow, SwarmDead)
+	}
+	return nil
+}
+
+// Matches reports whether t satisfies every condition
+func (c *PolicyConditions) Matches(t *Torrent) bool {
+	if c.Private != nil && t.IsPrivate != *c.Private {
+		return false
+	}
+	if c.MinRatio > 0 && t.UploadRatio < c.MinRatio {
+		return false
+	}
+	if c.MinSeedHours > 0 && float64(t.SecondsSeeding)/3600 < c.MinSeedHours {
+		return false
+	}
+	if c.ErrorContains != "" && !strings.Contains(strings.ToLower(t.ErrorString), strings.ToLower(c.ErrorContains)) {
+		return false
+	}
+	if len(c.Status) > 0 {
+		found := false
+		for _, s := range c.Status {
+			if s == t.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.NamePattern != "" {
+		if c.nameRe == nil && c.compile() != nil {
+			return false
+		}
+		if !c.nameRe.MatchString(t.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// PolicyEngine evaluates automation rules against poller snapshots
+type PolicyEngine struct {
+	db       *sql.DB
+	client   *TransmissionClient
+	registry *TorrentRegistry
+	arr      *ArrTracker
+	seeding  *SeedRules // remove actions wait for a torrent's seed rule
+	swarm    *SwarmMonitor
+	interval time.Duration
+
+	mu      sync.Mutex
+	lastRun time.Time
+	applied map[string]time.Time // rule:hash → last time an action was taken
+}
+
+// NewPolicyEngine creates the engine and its policy_rules table
+func NewPolicyEngine(db *sql.DB, client *TransmissionClient, registry *TorrentRegistry, interval time.Duration) (*PolicyEngine, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS policy_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		action TEXT NOT NULL,
+		conditions TEXT NOT NULL,
+		trackers TEXT NOT NULL DEFAULT '[]'
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &PolicyEngine{
+		db:       db,
+		client:   client,
+		registry: registry,
+		interval: interval,
+		applied:  make(map[string]time.Time),
+	}, nil
+}
+
+// GetRules returns all rules
+func (pe *PolicyEngine) GetRules() ([]PolicyRule, error) {
+	rows, err := pe.db.Query("SELECT id, name, enabled, action, conditions, trackers FROM policy_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []PolicyRule
+	for rows.Next() {
+		var rule PolicyRule
+		var conditions, trackers string
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Enabled, &rule.Action, &conditions, &trackers); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(conditions), &rule.Conditions); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", rule.ID, err)
+		}
+		if err := json.Unmarshal([]byte(trackers), &rule.Trackers); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", rule.ID, err)
+		}
+		if err := rule.Conditions.compile(); err != nil {
+			log.Printf("Policy rule %d: %v", rule.ID, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// AddRule validates and stores a new rule
+func (pe *PolicyEngine) AddRule(rule *PolicyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	conditions, _ := json.Marshal(rule.Conditions)
+	trackers, _ := json.Marshal(rule.Trackers)
+	result, err := pe.db.Exec(
+		"INSERT INTO policy_rules (name, enabled, action, conditions, trackers) VALUES (?, ?, ?, ?, ?)",
+		rule.Name, rule.Enabled, rule.Action, string(conditions), string(trackers),
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	rule.ID = int(id)
+	return nil
+}
+
+// UpdateRule validates and replaces an existing rule
+func (pe *PolicyEngine) UpdateRule(rule *PolicyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	conditions, _ := json.Marshal(rule.Conditions)
+	trackers, _ := json.Marshal(rule.Trackers)
+	_, err := pe.db.Exec(
+		"UPDATE policy_rules SET name = ?, enabled = ?, action = ?, conditions = ?, trackers = ? WHERE id = ?",
+		rule.Name, rule.Enabled, rule.Action, string(conditions), string(trackers), rule.ID,
+	)
+	return err
+}
+
+// DeleteRule deletes a rule
+func (pe *PolicyEngine) DeleteRule(id int) error {
+	_, err := pe.db.Exec("DELETE FROM policy_rules WHERE id = ?", id)
+	return err
+}
+
+// OnSnapshot runs the enabled rules, at most once per interval
+func (pe *PolicyEngine) OnSnapshot(_, cur *Snapshot) {
+	pe.mu.Lock()
+	if time.Since(pe.lastRun) < pe.interval {
+		pe.mu.Unlock()
+		return
+	}
+	pe.lastRun = time.Now()
+	pe.mu.Unlock()
+
+	rules, err := pe.GetRules()
+	if err != nil {
+		log.Printf("Failed to load policy rules: %v", err)
+		return
+	}
+
+	removed := make(map[string]bool)
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Enabled {
+			continue
+		}
+		// Rules saved before DISABLE_FEATURES=remove-data are left alone
+		if rule.Action == PolicyActionRemoveData && !features.Enabled(FeatureRemoveData) {
+			continue
+		}
+		// and so are removals without conditions, saved before they were refused
+		if rule.destructive() && rule.Conditions.empty() {
+			continue
+		}
+		for j := range cur.Torrents {
+			t := &cur.Torrents[j]
+			if removed[t.HashString] || !pe.matches(rule, t) {
+				continue
+			}
+			acted, err := pe.apply(rule, t)
+			if err != nil {
+				log.Printf("Policy %q failed on %s: %v", rule.Name, t.Name, err)
+				continue
+			}
+			if !acted {
+				continue
+			}
+			log.Printf("Policy %q: %s %s", rule.Name, rule.Action, t.Name)
+			if rule.destructive() {
+				removed[t.HashString] = true
+			}
+		}
+	}
+}
+
+// matches checks rule's conditions, including those that need state beyond
+// the torrent itself
+func (pe *PolicyEngine) matches(rule *PolicyRule, t *Torrent) bool {
+	if c := rule.Conditions.ArrImported; c != nil && pe.arr.Imported(t.HashString) != *c {
+		return false
+	}
+	if c := rule.Conditions.Swarm; c != "" {
+		h := pe.swarm.Health(t.HashString)
+		if h == nil || h.Class != c || h.Days(time.Now()) < rule.Conditions.MinSwarmDays {
+			return false
+		}
+	}
+	return rule.Conditions.Matches(t)
+}
+
+// apply performs rule's action on t, returning false if there was nothing to do
+func (pe *PolicyEngine) apply(rule *PolicyRule, t *Torrent) (bool, error) {
+	switch rule.Action {
+	case PolicyActionStop:
+		if t.Status == 0 {
+			return false, nil
+		}
+		return true, pe.client.StopTorrent(t.ID)
+
+	case PolicyActionRemove, PolicyActionRemoveData:
+		deleteData := rule.Action == PolicyActionRemoveData
+		if pe.seeding.Blocks(t) {
+			return false, nil
+		}
+		if err := pe.client.RemoveTorrent(t.ID, deleteData); err != nil {
+			return false, err
+		}
+		pe.registry.RecordRemove(t, SourcePolicy, deleteData)
+		return true, nil
+
+	case PolicyActionReannounce:
+		key := fmt.Sprintf("%d:%s", rule.ID, t.HashString)
+		pe.mu.Lock()
+		last := pe.applied[key]
+		pe.mu.Unlock()
+		if time.Since(last) < reannounceCooldown {
+			return false, nil
+		}
+		if err := pe.client.ReannounceTorrent(t.ID); err != nil {
+			return false, err
+		}
+		pe.mu.Lock()
+		pe.applied[key] = time.Now()
+		pe.mu.Unlock()
+		return true, nil
+
+	case PolicyActionAddTrackers:
+		existing := make(map[string]bool, len(t.Trackers))
+		for _, tr := range t.Trackers {
+			existing[tr.Announce] = true
+		}
+		var missing []string
+		for _, tr := range rule.Trackers {
+			if !existing[tr] {
+				missing = append(missing, tr)
+			}
+		}
+		if len(missing) == 0 {
+			return false, nil
+		}
+		return true, pe.client.AddTrackers(t.ID, missing)
+	}
+	return false, nil
+}
+
+func (s *Server) handleGetPolicies(w http.ResponseWriter, _ *http.Request) {
+	rules, err := s.policy.GetRules()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"rules": rules})
+}
+
+func (s *Server) handleAddPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rule PolicyRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.policy.AddRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleUpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var rule PolicyRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.policy.UpdateRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleDeletePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.policy.DeleteRule(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostBackoffError is returned for requests to a host that asked us to back
+// off with Retry-After
+type HostBackoffError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *HostBackoffError) Error() string {
+	return fmt.Sprintf("%s asked us to back off until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// hostState tracks the requests in flight to one host
+type hostState struct {
+	slots        chan struct{}
+	nextAllowed  time.Time
+	blockedUntil time.Time
+}
+
+// politeTransport keeps feed fetches from hammering a tracker: it limits
+// concurrent requests per host, spaces consecutive requests out and honors
+// Retry-After on 429 and 503 responses
+type politeTransport struct {
+	Base    http.RoundTripper
+	perHost int
+	delay   time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+func newPoliteTransport(base http.RoundTripper) *politeTransport {
+	return &politeTransport{
+		Base:    base,
+		perHost: 1,
+		delay:   5 * time.Second,
+		hosts:   make(map[string]*hostState),
+	}
+}
+
+func (t *politeTransport) host(name string) *hostState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[name]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, max(t.perHost, 1))}
+		t.hosts[name] = h
+	}
+	return h
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name := strings.ToLower(req.URL.Hostname())
+	h := t.host(name)
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	defer func() { <-h.slots }()
+
+	t.mu.Lock()
+	now := time.Now()
+	if now.Before(h.blockedUntil) {
+		until := h.blockedUntil
+		t.mu.Unlock()
+		return nil, &HostBackoffError{Host: name, Until: until}
+	}
+	wait := h.nextAllowed.Sub(now)
+	t.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+
+	t.mu.Lock()
+	h.nextAllowed = time.Now().Add(t.delay)
+	if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			h.blockedUntil = time.Now().Add(d)
+			log.Printf("Feed host %s returned HTTP %d, backing off for %v", name, resp.StatusCode, d.Round(time.Second))
+		}
+	}
+	t.mu.Unlock()
+	return resp, err
+}
+
+// parseRetryAfter reads a Retry-After header in either of its forms: delay
+// seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, secs > 0
+	}
+	if at, err := http.ParseTime(v); err == nil && at.After(now) {
+		return at.Sub(now), true
+	}
+	return 0, false
+}
+
+// feedJitter is a stable per-feed delay of up to a tenth of its interval, so
+// feeds sharing an interval drift apart instead of firing together forever
+func feedJitter(feed *Feed) time.Duration {
+	interval := time.Duration(feed.CheckInterval) * time.Minute
+	spread := int64(interval / 10)
+	if spread <= 0 {
+		return 0
+	}
+	// Knuth's multiplicative hash spreads consecutive IDs out
+	return time.Duration(int64(uint32(feed.ID)*2654435761) % spread)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default adaptive polling intervals
+const (
+	defaultPollActive  = 2 * time.Second
+	defaultPollIdle    = 30 * time.Second
+	defaultPollIdleMax = 60 * time.Second
+)
+
+// PollSchedule sets how often the poller runs. It polls every Active while
+// torrents are downloading or someone is watching, and otherwise backs off
+// from Idle, doubling after each quiet poll up to IdleMax.
+type PollSchedule struct {
+	Active  time.Duration
+	Idle    time.Duration
+	IdleMax time.Duration
+}
+
+// FixedSchedule polls at a constant interval
+func FixedSchedule(interval time.Duration) PollSchedule {
+	return PollSchedule{Active: interval, Idle: interval, IdleMax: interval}
+}
+
+// Snapshot is the daemon state captured by a single poll
+type Snapshot struct {
+	Time     time.Time
+	Torrents []Torrent
+	Stats    *SessionStats
+}
+
+// SnapshotListener is called after every successful poll. prev is nil on the
+// first poll after startup.
+type SnapshotListener func(prev, cur *Snapshot)
+
+// Poller periodically fetches torrents and session stats from Transmission
+// and hands each snapshot to the registered listeners
+type Poller struct {
+	client    *TransmissionClient
+	schedule  PollSchedule
+	mu        sync.RWMutex
+	listeners []SnapshotListener
+	watchers  []func() int
+	latest    *Snapshot
+	interval  time.Duration // wait before the next poll
+	stopCh    chan struct{}
+	wakeCh    chan struct{}
+
+	lastRequest atomic.Int64 // unix nanos of the last API request that asked for fresh data
+}
+
+// NewPoller creates a poller running on the given schedule
+func NewPoller(client *TransmissionClient, schedule PollSchedule) *Poller {
+	if schedule.Active <= 0 {
+		schedule.Active = defaultPollActive
+	}
+	schedule.Idle = max(schedule.Idle, schedule.Active)
+	schedule.IdleMax = max(schedule.IdleMax, schedule.Idle)
+	return &Poller{
+		client:   client,
+		schedule: schedule,
+		interval: schedule.Active,
+		stopCh:   make(chan struct{}),
+		wakeCh:   make(chan struct{}, 1),
+	}
+}
+
+// Watch registers a count of live clients, such as the /ws hub's; while
+// any is non-zero the poller runs at the active interval
+func (p *Poller) Watch(fn func() int) {
+	p.mu.Lock()
+	p.watchers = append(p.watchers, fn)
+	p.mu.Unlock()
+}
+
+// Wake polls right away if the poller is backed off, e.g. when a browser
+// connects to an idle server
+func (p *Poller) Wake() {
+	if p.Interval() <= p.schedule.Active {
+		return
+	}
+	select {
+	case p.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// Touch counts an API request as a watching client for the next idle
+// interval, so browsers polling /api/torrents keep the poller active
+func (p *Poller) Touch() {
+	p.lastRequest.Store(time.Now().UnixNano())
+	p.Wake()
+}
+
+// Interval returns the current wait between polls
+func (p *Poller) Interval() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.interval
+}
+
+// watched reports whether any client is connected or has recently asked
+// for data
+func (p *Poller) watched() bool {
+	if time.Since(time.Unix(0, p.lastRequest.Load())) < p.schedule.Idle {
+		return true
+	}
+	p.mu.RLock()
+	watchers := p.watchers
+	p.mu.RUnlock()
+	for _, fn := range watchers {
+		if fn() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextInterval picks the wait after a poll of cur: active while something
+// is downloading or watched, otherwise backing off
+func (p *Poller) nextInterval(cur *Snapshot, prev time.Duration) time.Duration {
+	if p.watched() {
+		return p.schedule.Active
+	}
+	if cur != nil {
+		for _, t := range cur.Torrents {
+			if t.Status == 4 {
+				return p.schedule.Active
+			}
+		}
+	}
+	if prev < p.schedule.Idle {
+		return p.schedule.Idle
+	}
+	return min(prev*2, p.schedule.IdleMax)
+}
+
+// Subscribe registers a listener. Listeners run sequentially on the poll
+// goroutine and should not block for long.
+func (p *Poller) Subscribe(fn SnapshotListener) {
+	p.mu.Lock()
+	p.listeners = append(p.listeners, fn)
+	p.mu.Unlock()
+}
+
+// Latest returns the most recent snapshot, or nil before the first poll
+func (p *Poller) Latest() *Snapshot {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.latest
+}
+
+// Start begins background polling
+func (p *Poller) Start() {
+	go p.loop()
+}
+
+// Stop stops background polling
+func (p *Poller) Stop() {
+	close(p.stopCh)
+}
+
+func (p *Poller) loop() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+		case <-p.wakeCh:
+			timer.Stop()
+		case <-p.stopCh:
+			return
+		}
+		interval := p.Interval()
+		if cur := p.poll(); cur != nil {
+			interval = p.nextInterval(cur, interval)
+			p.mu.Lock()
+			p.interval = interval
+			p.mu.Unlock()
+		}
+		timer.Reset(interval)
+	}
+}
+
+// poll takes a snapshot and hands it to the listeners. It returns nil if
+// Transmission couldn't be reached.
+func (p *Poller) poll() *Snapshot {
+	torrents, err := p.client.GetTorrents()
+	if err != nil {
+		log.Printf("Poller: failed to get torrents: %v", err)
+		return nil
+	}
+
+	stats, err := p.client.GetSessionStats()
+	if err != nil {
+		log.Printf("Poller: failed to get session stats: %v", err)
+		return nil
+	}
+
+	cur := &Snapshot{
+		Time:     time.Now(),
+		Torrents: torrents,
+		Stats:    stats,
+	}
+
+	p.mu.Lock()
+	prev := p.latest
+	p.latest = cur
+	listeners := append([]SnapshotListener(nil), p.listeners...)
+	p.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(prev, cur)
+	}
+	return cur
+}
+
+// handlePollerStatus reports the polling schedule and the current interval
+func (s *Server) handlePollerStatus(w http.ResponseWriter, _ *http.Request) {
+	sched := s.poller.schedule
+	writeJSON(w, map[string]interface{}{
+		"active":   sched.Active.String(),
+		"idle":     sched.Idle.String(),
+		"idleMax":  sched.IdleMax.String(),
+		"interval": s.poller.Interval().String(),
+		"watched":  s.poller.watched(),
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPresenceTimeout   = 5 * time.Minute
+	defaultPortCheckInterval = 10 * time.Minute
+)
+
+// Presence tracks whether anyone is using the UI: a page load or API call
+// in the last timeout, or a live connection such as a /ws client. Optional
+// background work is suspended while nobody is present.
+type Presence struct {
+	timeout  time.Duration
+	lastSeen atomic.Int64 // unix nanos
+
+	mu       sync.RWMutex
+	watchers []func() int
+}
+
+// PresenceStatus is reported on /api/presence
+type PresenceStatus struct {
+	Present  bool       `json:"present"`
+	Clients  int        `json:"clients"` // live connections
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+	Timeout  string     `json:"timeout"`
+}
+
+// NewPresence creates a tracker that considers the UI abandoned after
+// timeout without requests or connections
+func NewPresence(timeout time.Duration) *Presence {
+	if timeout <= 0 {
+		timeout = defaultPresenceTimeout
+	}
+	return &Presence{timeout: timeout}
+}
+
+// Seen records UI activity
+func (p *Presence) Seen() {
+	p.lastSeen.Store(time.Now().UnixNano())
+}
+
+// Watch registers a count of live connections
+func (p *Presence) Watch(fn func() int) {
+	p.mu.Lock()
+	p.watchers = append(p.watchers, fn)
+	p.mu.Unlock()
+}
+
+func (p *Presence) clients() int {
+	p.mu.RLock()
+	watchers := p.watchers
+	p.mu.RUnlock()
+	n := 0
+	for _, fn := range watchers {
+		n += fn()
+	}
+	return n
+}
+
+// Present reports whether anyone has used the UI within the timeout or is
+// still connected
+func (p *Presence) Present() bool {
+	if last := p.lastSeen.Load(); last != 0 && time.Since(time.Unix(0, last)) < p.timeout {
+		return true
+	}
+	return p.clients() > 0
+}
+
+// Status describes the current presence
+func (p *Presence) Status() PresenceStatus {
+	status := PresenceStatus{Present: p.Present(), Clients: p.clients(), Timeout: p.timeout.String()}
+	if last := p.lastSeen.Load(); last != 0 {
+		t := time.Unix(0, last)
+		status.LastSeen = &t
+	}
+	return status
+}
+
+// Middleware counts page loads and API calls as activity. Health checks,
+// webhooks, the Prowlarr API, API tokens and presence checks themselves
+// are machines, not people, and don't count.
+func (p *Presence) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/healthz", strings.HasPrefix(path, "/api/webhooks/"), path == "/api/presence", strings.HasPrefix(path, "/api/v3/"), requestToken(r) != nil:
+		default:
+			p.Seen()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PortChecker caches Transmission's port test, which makes the daemon
+// contact an outside service. It's refreshed in the background only while
+// someone is present, and on demand when a page finds it stale.
+type PortChecker struct {
+	client   *TransmissionClient
+	presence *Presence
+	interval time.Duration
+
+	mu      sync.Mutex
+	open    bool
+	checked time.Time
+}
+
+// NewPortChecker creates a checker refreshing every interval while present
+func NewPortChecker(client *TransmissionClient, presence *Presence, interval time.Duration) *PortChecker {
+	if interval <= 0 {
+		interval = defaultPortCheckInterval
+	}
+	return &PortChecker{client: client, presence: presence, interval: interval}
+}
+
+// Start begins background refreshes
+func (pc *PortChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(pc.interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !pc.presence.Present() {
+				continue
+			}
+			if _, err := pc.check(); err != nil {
+				log.Printf("Port test failed: %v", err)
+			}
+		}
+	}()
+}
+
+func (pc *PortChecker) check() (bool, error) {
+	open, err := pc.client.TestPort()
+	if err != nil {
+		return false, err
+	}
+	pc.mu.Lock()
+	pc.open, pc.checked = open, time.Now()
+	pc.mu.Unlock()
+	return open, nil
+}
+
+// Open returns the cached result, testing first if it's older than the
+// interval
+func (pc *PortChecker) Open() (bool, error) {
+	pc.mu.Lock()
+	open, checked := pc.open, pc.checked
+	pc.mu.Unlock()
+	if !checked.IsZero() && time.Since(checked) < pc.interval {
+		return open, nil
+	}
+	return pc.check()
+}
+
+// LastChecked returns when the port was last tested, zero if never
+func (pc *PortChecker) LastChecked() time.Time {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.checked
+}
+
+func (s *Server) handlePresence(w http.ResponseWriter, _ *http.Request) {
+	resp := map[string]interface{}{"presence": s.presence.Status()}
+	if checked := s.ports.LastChecked(); !checked.IsZero() {
+		resp["portCheckedAt"] = checked
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Prowlarr pushes indexers to its "applications" through their v3 indexer
+// API. We implement just enough of Sonarr's flavour of it for Prowlarr to be
+// configured with transmission-web as a Sonarr application.
+
+// prowlarrVersion is reported by system/status; Prowlarr refuses to sync
+// to Sonarr versions older than v3
+const prowlarrVersion = "4.0.0.0"
+
+// arrField is one entry of an *arr provider's settings
+type arrField struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// arrIndexer is the v3 indexer resource
+type arrIndexer struct {
+	ID                      int        `json:"id"`
+	Name                    string     `json:"name"`
+	EnableRss               bool       `json:"enableRss"`
+	EnableAutomaticSearch   bool       `json:"enableAutomaticSearch"`
+	EnableInteractiveSearch bool       `json:"enableInteractiveSearch"`
+	Priority                int        `json:"priority"`
+	Implementation          string     `json:"implementation"`
+	ImplementationName      string     `json:"implementationName"`
+	ConfigContract          string     `json:"configContract"`
+	Protocol                string     `json:"protocol"`
+	Fields                  []arrField `json:"fields"`
+	Tags                    []int      `json:"tags"`
+}
+
+func toArrIndexer(ix *Indexer) arrIndexer {
+	// Only Prowlarr's own indexers carry their key back to it; manual
+	// ones' keys aren't shared with anything else
+	var apiKey interface{}
+	if ix.Source == IndexerSourceProwlarr && ix.APIKey != "" {
+		apiKey = ix.APIKey
+	}
+	return arrIndexer{
+		ID:                      ix.ID,
+		Name:                    ix.Name,
+		EnableRss:               ix.Enabled,
+		EnableAutomaticSearch:   ix.Enabled,
+		EnableInteractiveSearch: ix.Enabled,
+		Priority:                ix.Priority,
+		Implementation:          "Torznab",
+		ImplementationName:      "Torznab",
+		ConfigContract:          "TorznabSettings",
+		Protocol:                "torrent",
+		Fields: []arrField{
+			{Name: "baseUrl", Value: ix.BaseURL},
+			{Name: "apiPath", Value: ix.APIPath},
+			{Name: "apiKey", Value: apiKey},
+			{Name: "categories", Value: ix.Categories},
+		},
+		Tags: []int{},
+	}
+}
+
+// fromArrIndexer converts a pushed indexer, reading the settings we use
+// from its fields and ignoring the rest
+func fromArrIndexer(a *arrIndexer) *Indexer {
+	ix := &Indexer{
+		ID:         a.ID,
+		Name:       a.Name,
+		Enabled:    a.EnableRss || a.EnableAutomaticSearch || a.EnableInteractiveSearch,
+		Priority:   a.Priority,
+		Source:     IndexerSourceProwlarr,
+		Categories: []int{},
+	}
+	for _, f := range a.Fields {
+		switch f.Name {
+		case "baseUrl":
+			ix.BaseURL, _ = f.Value.(string)
+		case "apiPath":
+			ix.APIPath, _ = f.Value.(string)
+		case "apiKey":
+			ix.APIKey, _ = f.Value.(string)
+		case "categories":
+			values, _ := f.Value.([]interface{})
+			for _, v := range values {
+				if n, ok := v.(float64); ok {
+					ix.Categories = append(ix.Categories, int(n))
+				}
+			}
+		}
+	}
+	return ix
+}
+
+// prowlarrAuth wraps a handler with the X-Api-Key check. Sync is disabled
+// entirely until PROWLARR_API_KEY is set.
+func (s *Server) prowlarrAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("X-Api-Key")
+		if key == "" {
+			key = r.URL.Query().Get("apikey")
+		}
+		if s.prowlarrKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.prowlarrKey)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleArrSystemStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"appName":      "Sonarr",
+		"instanceName": "transmission-web",
+		"version":      prowlarrVersion,
+	})
+}
+
+func (s *Server) handleArrIndexerSchema(w http.ResponseWriter, _ *http.Request) {
+	schema := toArrIndexer(&Indexer{APIPath: "/api", Categories: []int{}})
+	writeJSON(w, []arrIndexer{schema})
+}
+
+func (s *Server) handleArrListIndexers(w http.ResponseWriter, _ *http.Request) {
+	indexers, err := s.indexers.List()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	list := make([]arrIndexer, 0, len(indexers))
+	for i := range indexers {
+		list = append(list, toArrIndexer(&indexers[i]))
+	}
+	writeJSON(w, list)
+}
+
+func (s *Server) handleArrGetIndexer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	ix, err := s.indexers.Get(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, toArrIndexer(ix))
+}
+
+func (s *Server) handleArrAddIndexer(w http.ResponseWriter, r *http.Request) {
+	var a arrIndexer
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	ix := fromArrIndexer(&a)
+	if err := s.indexers.Add(ix); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Prowlarr added indexer %s", ix.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, toArrIndexer(ix))
+}
+
+func (s *Server) handleArrUpdateIndexer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var a arrIndexer
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	a.ID = id
+	ix := fromArrIndexer(&a)
+	// A manual indexer was listed without its key, so keep the one it has
+	if cur, err := s.indexers.Get(id); err == nil && ix.APIKey == "" {
+		ix.APIKey = cur.APIKey
+	}
+	err := s.indexers.Update(ix)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Prowlarr updated indexer %s", ix.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, toArrIndexer(ix))
+}
+
+func (s *Server) handleArrDeleteIndexer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.indexers.Delete(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	log.Printf("Prowlarr deleted indexer %d", id)
+	writeJSON(w, map[string]string{})
+}
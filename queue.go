@@ -0,0 +1,49 @@
+package main
+
+// queueActions are the queue moves /api/action and the basic view accept
+var queueActions = map[string]func(c *TransmissionClient, id int) error{
+	"queue-top":    (*TransmissionClient).QueueMoveTop,
+	"queue-up":     (*TransmissionClient).QueueMoveUp,
+	"queue-down":   (*TransmissionClient).QueueMoveDown,
+	"queue-bottom": (*TransmissionClient).QueueMoveBottom,
+}
+
+func (c *TransmissionClient) moveQueue(method string, id int) error {
+	req := &RPCRequest{
+		Method:    method,
+		Arguments: map[string]interface{}{"ids": []int{id}},
+	}
+	_, err := c.doRequest(req)
+	return err
+}
+
+// QueueMoveTop puts a torrent first in its queue
+func (c *TransmissionClient) QueueMoveTop(id int) error {
+	return c.moveQueue("queue-move-top", id)
+}
+
+// QueueMoveUp moves a torrent one place earlier in its queue
+func (c *TransmissionClient) QueueMoveUp(id int) error {
+	return c.moveQueue("queue-move-up", id)
+}
+
+// QueueMoveDown moves a torrent one place later in its queue
+func (c *TransmissionClient) QueueMoveDown(id int) error {
+	return c.moveQueue("queue-move-down", id)
+}
+
+// QueueMoveBottom puts a torrent last in its queue
+func (c *TransmissionClient) QueueMoveBottom(id int) error {
+	return c.moveQueue("queue-move-bottom", id)
+}
+
+// Queued reports whether the torrent is waiting in the download or seed
+// queue, where its queue position decides when it starts
+func (t *Torrent) Queued() bool {
+	return t.Status == 3 || t.Status == 5
+}
+
+// QueueRank is the one-based queue position shown in the UI
+func (t *Torrent) QueueRank() int {
+	return t.QueuePosition + 1
+}
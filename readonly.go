@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const readOnlyKey = "read_only"
+
+// readOnlyMode is read by the templates to show the read-only banner
+var readOnlyMode *ReadOnlyMode
+
+// errReadOnlyLocked is returned when READ_ONLY pins read-only mode on
+var errReadOnlyLocked = errors.New("read-only mode is set by READ_ONLY and can't be turned off here")
+
+// ReadOnlyStatus describes read-only mode for the API and the banner
+type ReadOnlyStatus struct {
+	Enabled bool       `json:"enabled"`
+	Since   *time.Time `json:"since,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+	Locked  bool       `json:"locked,omitempty"` // set by READ_ONLY
+}
+
+// ReadOnlyMode is the admin switch that refuses every change made through
+// the UI and API, e.g. during daemon maintenance or while sharing access.
+// Background automation (RSS, IRC, policies) has its own pause.
+type ReadOnlyMode struct {
+	db     *sql.DB
+	locked bool
+
+	mu      sync.Mutex
+	enabled bool
+	since   time.Time
+	reason  string
+}
+
+// NewReadOnlyMode loads the stored switch; locked turns read-only mode on
+// for good, whatever was stored
+func NewReadOnlyMode(db *sql.DB, locked bool) (*ReadOnlyMode, error) {
+	m := &ReadOnlyMode{db: db, locked: locked}
+	if locked {
+		m.enabled, m.since = true, time.Now()
+		return m, nil
+	}
+	value, err := getSetting(db, readOnlyKey)
+	if err != nil || value == "" {
+		return m, err
+	}
+	var stored struct {
+		Since  time.Time `json:"since"`
+		Reason string    `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return nil, fmt.Errorf("invalid stored read-only mode: %w", err)
+	}
+	m.enabled, m.since, m.reason = true, stored.Since, stored.Reason
+	return m, nil
+}
+
+// Active reports whether changes are refused. A nil switch never is.
+func (m *ReadOnlyMode) Active() bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled
+}
+
+// Enable turns read-only mode on
+func (m *ReadOnlyMode) Enable(reason string) error {
+	now := time.Now()
+	if !m.locked {
+		value, _ := json.Marshal(map[string]interface{}{"since": now, "reason": reason})
+		if err := setSetting(m.db, readOnlyKey, string(value)); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.enabled {
+		m.since = now
+	}
+	m.enabled, m.reason = true, reason
+	return nil
+}
+
+// Disable turns read-only mode off unless READ_ONLY locked it on
+func (m *ReadOnlyMode) Disable() error {
+	if m.locked {
+		return errReadOnlyLocked
+	}
+	if err := setSetting(m.db, readOnlyKey, ""); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.enabled, m.since, m.reason = false, time.Time{}, ""
+	m.mu.Unlock()
+	return nil
+}
+
+// Status returns the current state
+func (m *ReadOnlyMode) Status() ReadOnlyStatus {
+	if m == nil {
+		return ReadOnlyStatus{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.enabled {
+		return ReadOnlyStatus{}
+	}
+	since := m.since
+	return ReadOnlyStatus{Enabled: true, Since: &since, Reason: m.reason, Locked: m.locked}
+}
+
+// readOnlyExempt lists the writes still allowed in read-only mode: signing
+// in and out, the switch itself, and dashboard layouts, which change
+// nothing but the page
+func readOnlyExempt(path string) bool {
+	switch path {
+	case "/login", "/logout", "/api/readonly", "/api/dashboard", "/api/dashboard/reset":
+		return true
+	}
+	return false
+}
+
+// Middleware refuses anything but GET, HEAD and OPTIONS while read-only
+// mode is on: API calls get a 503 with a JSON error and forms a plain one
+func (m *ReadOnlyMode) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !m.Active() || readOnlyExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		msg := "transmission-web is in read-only mode"
+		if reason := m.Status().Reason; reason != "" {
+			msg += ": " + reason
+		}
+		w.Header().Set("Retry-After", "300")
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			writeJSONError(w, msg)
+			return
+		}
+		http.Error(w, msg, http.StatusServiceUnavailable)
+	})
+}
+
+func (s *Server) handleReadOnly(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		writeJSON(w, s.readOnly.Status())
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+
+	var err error
+	if req.Enabled {
+		err = s.readOnly.Enable(strings.TrimSpace(req.Reason))
+		log.Printf("Read-only mode on: %s", req.Reason)
+	} else {
+		err = s.readOnly.Disable()
+		if err == nil {
+			log.Printf("Read-only mode off")
+		}
+	}
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, s.readOnly.Status())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ActionReannounceWhenAllowed waits for the trackers' minimum announce
+// interval instead of reannouncing straight away
+const ActionReannounceWhenAllowed = "reannounce-when-allowed"
+
+// errNotAnnouncing is returned for a torrent the daemon isn't announcing,
+// which no wait will change
+var errNotAnnouncing = errors.New("the torrent isn't running, so it can't announce; start it first")
+
+// ManualAnnounceTime returns when the daemon will next accept a manual
+// reannounce for a torrent. A daemon ignores one sent earlier. The zero
+// time means now, or a daemon that doesn't say.
+func (c *TransmissionClient) ManualAnnounceTime(id int) (time.Time, error) {
+	resp, err := c.doRequest(&RPCRequest{
+		Method:    "torrent-get",
+		Arguments: map[string]interface{}{"ids": []int{id}, "fields": []string{"id", "manualAnnounceTime"}},
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	var result struct {
+		Torrents []struct {
+			ManualAnnounceTime int64 `json:"manualAnnounceTime"`
+		} `json:"torrents"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return time.Time{}, err
+	}
+	if len(result.Torrents) == 0 {
+		return time.Time{}, &TorrentNotFoundError{ID: id}
+	}
+	return manualAnnounceAt(result.Torrents[0].ManualAnnounceTime)
+}
+
+// manualAnnounceAt reads manualAnnounceTime, which is -1 for a torrent
+// with no running tracker tier
+func manualAnnounceAt(sec int64) (time.Time, error) {
+	switch {
+	case sec < 0:
+		return time.Time{}, errNotAnnouncing
+	case sec == 0:
+		return time.Time{}, nil
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// ReannounceScheduler holds reannounces for the default daemon until its
+// trackers' minimum interval has passed, so asking early doesn't send an
+// announce the daemon drops or a tracker counts against the torrent.
+// Waiting reannounces are kept in SQLite and checked on every poll.
+type ReannounceScheduler struct {
+	db     *sql.DB
+	client *TransmissionClient
+
+	mu  sync.Mutex
+	due map[string]time.Time // by hash
+}
+
+// NewReannounceScheduler creates the scheduled_reannounces table
+func NewReannounceScheduler(db *sql.DB, client *TransmissionClient) (*ReannounceScheduler, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS scheduled_reannounces (
+		hash TEXT PRIMARY KEY,
+		due DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scheduled_reannounces table: %w", err)
+	}
+	rs := &ReannounceScheduler{db: db, client: client, due: map[string]time.Time{}}
+
+	rows, err := db.Query("SELECT hash, due FROM scheduled_reannounces")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var due time.Time
+		if err := rows.Scan(&hash, &due); err != nil {
+			return nil, err
+		}
+		rs.due[hash] = due
+	}
+	return rs, rows.Err()
+}
+
+// Schedule reannounces t as soon as the daemon allows: now if it already
+// does, returning the zero time, or else at the time returned
+func (rs *ReannounceScheduler) Schedule(t *Torrent) (time.Time, error) {
+	at, err := rs.client.ManualAnnounceTime(t.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !at.After(time.Now()) {
+		rs.forget(t.HashString)
+		return time.Time{}, rs.client.ReannounceTorrent(t.ID)
+	}
+	if _, err := rs.db.Exec("INSERT OR REPLACE INTO scheduled_reannounces (hash, due) VALUES (?, ?)", t.HashString, at.UTC()); err != nil {
+		return time.Time{}, err
+	}
+	rs.mu.Lock()
+	rs.due[t.HashString] = at
+	rs.mu.Unlock()
+	return at, nil
+}
+
+// Scheduled returns when a waiting reannounce for hash is due, or nil
+func (rs *ReannounceScheduler) Scheduled(hash string) *time.Time {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	due, ok := rs.due[hash]
+	if !ok {
+		return nil
+	}
+	return &due
+}
+
+func (rs *ReannounceScheduler) forget(hash string) {
+	rs.mu.Lock()
+	_, ok := rs.due[hash]
+	delete(rs.due, hash)
+	rs.mu.Unlock()
+	if !ok {
+		return
+	}
+	if _, err := rs.db.Exec("DELETE FROM scheduled_reannounces WHERE hash = ?", hash); err != nil {
+		log.Printf("Failed to forget a scheduled reannounce: %v", err)
+	}
+}
+
+// OnSnapshot sends the reannounces that have come due. The allowed time
+// is asked again first, since another announce may have pushed it back.
+func (rs *ReannounceScheduler) OnSnapshot(_, cur *Snapshot) {
+	rs.mu.Lock()
+	var ready []string
+	for hash, due := range rs.due {
+		if !due.After(cur.Time) {
+			ready = append(ready, hash)
+		}
+	}
+	rs.mu.Unlock()
+	if len(ready) == 0 {
+		return
+	}
+
+	byHash := make(map[string]*Torrent, len(cur.Torrents))
+	for i := range cur.Torrents {
+		byHash[cur.Torrents[i].HashString] = &cur.Torrents[i]
+	}
+	for _, hash := range ready {
+		t, ok := byHash[hash]
+		if !ok {
+			rs.forget(hash)
+			continue
+		}
+		at, err := rs.Schedule(t)
+		switch {
+		case errors.Is(err, errNotAnnouncing):
+			log.Printf("Dropped the scheduled reannounce of %s: it was stopped", t.Name)
+			rs.forget(hash)
+		case err != nil:
+			log.Printf("Failed to reannounce %s: %v", t.Name, err)
+		case at.IsZero():
+			log.Printf("Reannounced %s now its trackers allow it", t.Name)
+		}
+	}
+}
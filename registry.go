@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Torrent sources recorded in the registry and history
+const (
+	SourceUI       = "ui"
+	SourceAPI      = "api" // /api/v1 and API-token adds
+	SourceRSS      = "rss"
+	SourceWatch    = "watch"
+	SourceExternal = "external"
+)
+
+// TorrentOrigin records who added a torrent
+type TorrentOrigin struct {
+	Hash    string    `json:"hash"`
+	Name    string    `json:"name"`
+	Source  string    `json:"source"`
+	AddedAt time.Time `json:"addedAt"`
+}
+
+// TorrentRegistry keeps the local hash → origin mapping in sync with the
+// daemon. Adds and removals made through this app are recorded directly;
+// anything else the poller notices (Sonarr, transmission-remote, ...) is
+// attributed to SourceExternal.
+type TorrentRegistry struct {
+	db      *sql.DB
+	history *TorrentHistory
+	events  *EventBus
+
+	mu      sync.Mutex
+	origins map[string]*TorrentOrigin
+}
+
+// NewTorrentRegistry loads the origin mapping from the torrent_origins table
+func NewTorrentRegistry(db *sql.DB, history *TorrentHistory, events *EventBus) (*TorrentRegistry, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS torrent_origins (
+		hash TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		source TEXT NOT NULL,
+		added_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TorrentRegistry{
+		db:      db,
+		history: history,
+		events:  events,
+		origins: make(map[string]*TorrentOrigin),
+	}
+
+	rows, err := db.Query("SELECT hash, name, source, added_at FROM torrent_origins")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o TorrentOrigin
+		if err := rows.Scan(&o.Hash, &o.Name, &o.Source, &o.AddedAt); err != nil {
+			return nil, err
+		}
+		r.origins[o.Hash] = &o
+	}
+
+	return r, rows.Err()
+}
+
+// Origin returns who added the torrent with the given hash
+func (r *TorrentRegistry) Origin(hash string) (*TorrentOrigin, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, ok := r.origins[hash]
+	return o, ok
+}
+
+// RecordAdd records a torrent added through this app. Duplicates keep their
+// original attribution. A nil registry ignores the call.
+func (r *TorrentRegistry) RecordAdd(added *AddedTorrent, source string) {
+	if r == nil || added == nil || added.Duplicate {
+		return
+	}
+	if r.remember(added.HashString, added.Name, source) || r.reattribute(added.HashString, source) {
+		r.events.Publish(Event{Type: EventTorrentAdded, Hash: added.HashString, Name: added.Name, Source: source})
+	}
+}
+
+// reattribute claims a torrent the poller adopted as external in the moment
+// between the torrent-add call returning and RecordAdd running
+func (r *TorrentRegistry) reattribute(hash, source string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o, ok := r.origins[hash]
+	if !ok || o.Source != SourceExternal || time.Since(o.AddedAt) > time.Minute {
+		return false
+	}
+	o.Source = source
+	if _, err := r.db.Exec("UPDATE torrent_origins SET source = ? WHERE hash = ?", source, hash); err != nil {
+		log.Printf("Failed to update torrent origin: %v", err)
+	}
+	return true
+}
+
+// RecordRemove records a torrent removed through this app and archives it
+func (r *TorrentRegistry) RecordRemove(t *Torrent, source string, deletedData bool) {
+	if r == nil {
+		return
+	}
+	if err := r.history.Archive(t, source, deletedData); err != nil {
+		log.Printf("Failed to archive removed torrent %s: %v", t.Name, err)
+	}
+	r.forget(t.HashString)
+	r.events.Publish(Event{Type: EventTorrentRemoved, Hash: t.HashString, Name: t.Name, Source: source})
+}
+
+// remember stores an origin, returning false if the hash was already known
+func (r *TorrentRegistry) remember(hash, name, source string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.origins[hash]; ok {
+		return false
+	}
+	o := &TorrentOrigin{Hash: hash, Name: name, Source: source, AddedAt: time.Now().UTC()}
+	_, err := r.db.Exec(
+		"INSERT OR REPLACE INTO torrent_origins (hash, name, source, added_at) VALUES (?, ?, ?, ?)",
+		o.Hash, o.Name, o.Source, sqliteTime(o.AddedAt),
+	)
+	if err != nil {
+		log.Printf("Failed to record torrent origin: %v", err)
+	}
+	r.origins[hash] = o
+	return true
+}
+
+func (r *TorrentRegistry) forget(hash string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.origins, hash)
+	if _, err := r.db.Exec("DELETE FROM torrent_origins WHERE hash = ?", hash); err != nil {
+		log.Printf("Failed to forget torrent origin: %v", err)
+	}
+}
+
+// OnSnapshot reconciles the registry with the daemon's torrent list
+func (r *TorrentRegistry) OnSnapshot(prev, cur *Snapshot) {
+	present := make(map[string]*Torrent, len(cur.Torrents))
+	for i := range cur.Torrents {
+		present[cur.Torrents[i].HashString] = &cur.Torrents[i]
+	}
+
+	// On the very first run every existing torrent is simply adopted, rather
+	// than flooding the event stream with hundreds of "external" adds
+	r.mu.Lock()
+	seeding := prev == nil && len(r.origins) == 0
+	r.mu.Unlock()
+
+	for hash, t := range present {
+		if !r.remember(hash, t.Name, SourceExternal) || seeding {
+			continue
+		}
+		log.Printf("Detected externally added torrent: %s", t.Name)
+		r.events.Publish(Event{Type: EventTorrentAdded, Hash: hash, Name: t.Name, Source: SourceExternal})
+	}
+
+	// Anything we still have an origin for but the daemon doesn't was removed
+	// behind our back. Removals made through this app forget the origin first.
+	var prevByHash map[string]*Torrent
+	if prev != nil {
+		prevByHash = make(map[string]*Torrent, len(prev.Torrents))
+		for i := range prev.Torrents {
+			prevByHash[prev.Torrents[i].HashString] = &prev.Torrents[i]
+		}
+	}
+
+	r.mu.Lock()
+	var missing []*TorrentOrigin
+	for hash, o := range r.origins {
+		if _, ok := present[hash]; !ok {
+			missing = append(missing, o)
+		}
+	}
+	r.mu.Unlock()
+
+	for _, o := range missing {
+		// Final stats are only known if the torrent was in the previous poll
+		if t, ok := prevByHash[o.Hash]; ok {
+			if err := r.history.Archive(t, SourceExternal, false); err != nil {
+				log.Printf("Failed to archive removed torrent %s: %v", t.Name, err)
+			}
+		}
+		r.forget(o.Hash)
+		log.Printf("Detected externally removed torrent: %s", o.Name)
+		r.events.Publish(Event{Type: EventTorrentRemoved, Hash: o.Hash, Name: o.Name, Source: SourceExternal})
+	}
+}
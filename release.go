@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release is a release name broken into the fields scene naming encodes
+type Release struct {
+	Title      string `json:"title"`           // as written, separators replaced by spaces
+	Normalized string `json:"normalizedTitle"` // lowercase alphanumerics, for comparison
+	Year       int    `json:"year,omitempty"`
+	AirDate    string `json:"airDate,omitempty"` // YYYY-MM-DD of a daily show's episode
+	Season     int    `json:"season,omitempty"`
+	Episode    int    `json:"episode,omitempty"`
+	EpisodeEnd int    `json:"episodeEnd,omitempty"` // last episode of a multi-episode release
+	Version    int    `json:"version,omitempty"`    // v2 and up: a fixed re-release of an episode
+	Resolution string `json:"resolution,omitempty"` // 480p, 720p, 1080p, 2160p
+	Source     string `json:"source,omitempty"`     // web-dl, webrip, bluray, hdtv, ...
+	Codec      string `json:"codec,omitempty"`      // h264, h265, av1, xvid
+	Group      string `json:"group,omitempty"`
+	Proper     bool   `json:"proper,omitempty"` // PROPER or REPACK
+}
+
+var (
+	releaseExtension  = regexp.MustCompile(`(?i)\.(mkv|mp4|avi|m4v|ts|torrent)$`)
+	releaseBracketTag = regexp.MustCompile(`^\[([^\]]+)\]\s*`)
+	releaseGroup      = regexp.MustCompile(`-([A-Za-z0-9]+)(?:\[[^\]]*\])?$`)
+	releaseEpisode    = regexp.MustCompile(`(?i)\bS(\d{1,2})[ .]?E(\d{1,3})(?:[ .-]?E?(\d{1,3}))?\b`)
+	releaseCrossEp    = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	releaseSeasonOnly = regexp.MustCompile(`(?i)\b(?:S(\d{1,2})|Season (\d{1,2}))\b`)
+	releaseAbsoluteEp = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?\b`)
+	releaseVersion    = regexp.MustCompile(`(?i)\dv(\d)\b`)
+	releaseYear       = regexp.MustCompile(`\b(19\d{2}|20\d{2})\b`)
+	releaseAirDate    = regexp.MustCompile(`\b(19\d{2}|20\d{2})[ -](0[1-9]|1[0-2])[ -](0[1-9]|[12]\d|3[01])\b`)
+	releaseResolution = regexp.MustCompile(`(?i)\b(480p|576p|720p|1080[pi]|2160p|4k|uhd)\b`)
+	releaseSource     = regexp.MustCompile(`(?i)\b(web[ .-]?dl|webrip|web|blu[ .-]?ray|bdrip|brrip|remux|hdtv|dvdrip|hdrip)\b`)
+	releaseCodec      = regexp.MustCompile(`(?i)\b(x ?264|h ?264|avc|x ?265|h ?265|hevc|av1|xvid)\b`)
+	releaseProper     = regexp.MustCompile(`(?i)\b(proper|repack)\b`)
+	releaseNonAlnum   = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// ParseRelease parses a scene or anime style release name such as
+// "Show.Name.S01E02.1080p.WEB-DL.x264-GROUP" or
+// "[Group] Show Name - 05 (1080p).mkv"
+func ParseRelease(name string) Release {
+	var r Release
+	s := strings.TrimSpace(releaseExtension.ReplaceAllString(strings.TrimSpace(name), ""))
+
+	// Anime releases lead with the group in brackets; scene ones end in -GROUP
+	if m := releaseBracketTag.FindStringSubmatch(s); m != nil {
+		r.Group = m[1]
+		s = s[len(m[0]):]
+	} else if m := releaseGroup.FindStringSubmatchIndex(s); m != nil && hasReleaseMetadata(s[:m[0]]) {
+		r.Group = s[m[2]:m[3]]
+		s = s[:m[0]]
+	}
+
+	// Separators become spaces so word boundaries work throughout
+	s = strings.NewReplacer(".", " ", "_", " ", "(", " ", ")", " ", "[", " ", "]", " ").Replace(s)
+	s = strings.Join(strings.Fields(s), " ")
+
+	// The title runs up to the first piece of release metadata
+	titleEnd := len(s)
+	mark := func(loc []int) {
+		if loc != nil && loc[0] < titleEnd {
+			titleEnd = loc[0]
+		}
+	}
+
+	if m := releaseEpisode.FindStringSubmatchIndex(s); m != nil {
+		r.Season, _ = strconv.Atoi(s[m[2]:m[3]])
+		r.Episode, _ = strconv.Atoi(s[m[4]:m[5]])
+		if m[6] >= 0 {
+			r.EpisodeEnd, _ = strconv.Atoi(s[m[6]:m[7]])
+		}
+		mark(m)
+	} else if m := releaseCrossEp.FindStringSubmatchIndex(s); m != nil {
+		r.Season, _ = strconv.Atoi(s[m[2]:m[3]])
+		r.Episode, _ = strconv.Atoi(s[m[4]:m[5]])
+		mark(m)
+	} else if m := releaseSeasonOnly.FindStringSubmatchIndex(s); m != nil {
+		if m[2] >= 0 {
+			r.Season, _ = strconv.Atoi(s[m[2]:m[3]])
+		} else {
+			r.Season, _ = strconv.Atoi(s[m[4]:m[5]])
+		}
+		mark(m)
+	} else if m := releaseAbsoluteEp.FindStringSubmatchIndex(s); m != nil {
+		r.Episode, _ = strconv.Atoi(s[m[2]:m[3]])
+		mark(m)
+	}
+
+	if m := releaseVersion.FindStringSubmatch(s); m != nil && (r.Season > 0 || r.Episode > 0) {
+		r.Version, _ = strconv.Atoi(m[1])
+	}
+
+	// Daily shows are numbered by air date ("Show 2024 03 15")
+	if m := releaseAirDate.FindStringSubmatchIndex(s); m != nil && m[0] > 0 && r.Season == 0 && r.Episode == 0 {
+		r.AirDate = s[m[2]:m[3]] + "-" + s[m[4]:m[5]] + "-" + s[m[6]:m[7]]
+		mark(m)
+	}
+
+	if m := releaseResolution.FindStringSubmatchIndex(s); m != nil {
+		r.Resolution = normalizeResolution(s[m[2]:m[3]])
+		mark(m)
+	}
+	if m := releaseSource.FindStringSubmatchIndex(s); m != nil {
+		r.Source = normalizeSource(s[m[2]:m[3]])
+		mark(m)
+	}
+	if m := releaseCodec.FindStringSubmatchIndex(s); m != nil {
+		r.Codec = normalizeCodec(s[m[2]:m[3]])
+		mark(m)
+	}
+	if m := releaseProper.FindStringIndex(s); m != nil {
+		r.Proper = true
+		mark(m)
+	}
+
+	// The year is the last one before the rest of the metadata, so earlier
+	// ones stay in the title ("Blade Runner 2049 2017"), failing that the
+	// first after it. One at the very start is part of the title ("2001 A
+	// Space Odyssey").
+	var year []int
+	for _, m := range releaseYear.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > 0 && (m[0] < titleEnd || year == nil) {
+			year = m
+		}
+	}
+	if year != nil {
+		r.Year, _ = strconv.Atoi(s[year[2]:year[3]])
+		mark(year)
+	}
+
+	r.Title = strings.TrimRight(strings.TrimSpace(s[:titleEnd]), " -")
+	r.Normalized = normalizeTitle(r.Title)
+	return r
+}
+
+// hasReleaseMetadata tells a trailing -GROUP apart from a hyphenated title
+// ("Spider-Man") or the end of "WEB-DL"
+func hasReleaseMetadata(s string) bool {
+	if strings.HasSuffix(strings.ToLower(s), "web") {
+		return false
+	}
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	return releaseResolution.MatchString(s) || releaseSource.MatchString(s) ||
+		releaseCodec.MatchString(s) || releaseEpisode.MatchString(s)
+}
+
+// normalizeTitle reduces a title to lowercase words so naming variations
+// ("Show.Name", "Show_Name", "Show Name!") compare equal
+func normalizeTitle(title string) string {
+	t := strings.ToLower(strings.ReplaceAll(title, "&", " and "))
+	t = strings.ReplaceAll(t, "'", "")
+	return strings.TrimSpace(releaseNonAlnum.ReplaceAllString(t, " "))
+}
+
+func normalizeResolution(v string) string {
+	switch strings.ToLower(v) {
+	case "4k", "uhd":
+		return "2160p"
+	case "1080i":
+		return "1080p"
+	}
+	return strings.ToLower(v)
+}
+
+func normalizeSource(v string) string {
+	v = strings.ToLower(strings.NewReplacer(" ", "", ".", "", "-", "").Replace(v))
+	switch v {
+	case "webdl", "web":
+		return "web-dl"
+	case "bluray", "bdrip", "brrip":
+		return "bluray"
+	}
+	return v
+}
+
+func normalizeCodec(v string) string {
+	switch strings.ToLower(strings.ReplaceAll(v, " ", "")) {
+	case "x264", "h264", "avc":
+		return "h264"
+	case "x265", "h265", "hevc":
+		return "h265"
+	}
+	return strings.ToLower(v)
+}
+
+// ReleaseFilter matches parsed releases on their structured fields. Empty
+// fields match anything.
+type ReleaseFilter struct {
+	Title       string   `json:"title,omitempty"` // compared normalized
+	Year        int      `json:"year,omitempty"`
+	MinSeason   int      `json:"minSeason,omitempty"`
+	Resolutions []string `json:"resolutions,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	Codecs      []string `json:"codecs,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+	NoPacks     bool     `json:"noPacks,omitempty"` // skip whole-season releases
+}
+
+// Validate rejects filter values the parser can never produce
+func (f *ReleaseFilter) Validate() error {
+	for _, res := range f.Resolutions {
+		switch res {
+		case "480p", "576p", "720p", "1080p", "2160p":
+		default:
+			return fmt.Errorf("unknown resolution %q", res)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether r satisfies the filter
+func (f *ReleaseFilter) Matches(r *Release) bool {
+	return f.Mismatch(r) == ""
+}
+
+// Mismatch explains why r fails the filter, or returns "" if it matches
+func (f *ReleaseFilter) Mismatch(r *Release) string {
+	switch {
+	case f.Title != "" && normalizeTitle(f.Title) != r.Normalized:
+		return fmt.Sprintf("title %q is not %q", r.Title, f.Title)
+	case f.Year > 0 && r.Year != f.Year:
+		return fmt.Sprintf("year %d is not %d", r.Year, f.Year)
+	case f.MinSeason > 0 && r.Season < f.MinSeason:
+		return fmt.Sprintf("season %d is before %d", r.Season, f.MinSeason)
+	case f.NoPacks && r.Season > 0 && r.Episode == 0:
+		return "season packs are excluded"
+	case !matchesAny(f.Resolutions, r.Resolution):
+		return fmt.Sprintf("resolution %q not in %v", r.Resolution, f.Resolutions)
+	case !matchesAny(f.Sources, r.Source):
+		return fmt.Sprintf("source %q not in %v", r.Source, f.Sources)
+	case !matchesAny(f.Codecs, r.Codec):
+		return fmt.Sprintf("codec %q not in %v", r.Codec, f.Codecs)
+	case !matchesAny(f.Groups, r.Group):
+		return fmt.Sprintf("group %q not in %v", r.Group, f.Groups)
+	}
+	return ""
+}
+
+// matchesAny reports whether v is in list, case-insensitively; an empty
+// list matches anything
+func matchesAny(list []string, v string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestParseReleaseYear(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string
+		year  int
+	}{
+		{"Blade.Runner.2049.2017.1080p.BluRay.x264-GRP", "Blade Runner 2049", 2017},
+		{"Blade.Runner.1982.720p.BluRay.x264-GRP", "Blade Runner", 1982},
+		{"2001.A.Space.Odyssey.1968.1080p.BluRay.x264-GRP", "2001 A Space Odyssey", 1968},
+		{"1923.S01E01.1080p.WEB.h264-GRP", "1923", 0},
+		{"Show.2019.S01E01.720p.HDTV.x264-GRP", "Show", 2019},
+		{"Daily.Show.2024.03.14.720p.WEB.h264-GRP", "Daily Show", 2024},
+	}
+	for _, tt := range tests {
+		r := ParseRelease(tt.name)
+		if r.Title != tt.title || r.Year != tt.year {
+			t.Errorf("ParseRelease(%q) = %q, %d, want %q, %d", tt.name, r.Title, r.Year, tt.title, tt.year)
+		}
+	}
+}
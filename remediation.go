@@ -0,0 +1,125 @@
+package main
+
+import "strings"
+
+// Transmission's torrent error codes
+const (
+	TorrentErrorTrackerWarning = 1
+	TorrentErrorTracker        = 2
+	TorrentErrorLocal          = 3
+)
+
+// Remedy actions the UI offers as buttons. reannounce, verify and start are
+// /api/action actions; set-location is /api/torrent/{id}/move.
+const (
+	RemedyReannounce  = "reannounce"
+	RemedyVerify      = "verify"
+	RemedyStart       = "start"
+	RemedySetLocation = "set-location"
+)
+
+// Remediation explains a torrent error and suggests what to do about it
+type Remediation struct {
+	Problem     string   `json:"problem"`
+	Explanation string   `json:"explanation"`
+	Actions     []string `json:"actions"`
+}
+
+// errorRemedies are checked in order against the lowercased error string
+var errorRemedies = []struct {
+	matches []string
+	remedy  Remediation
+}{
+	{
+		[]string{"unregistered torrent", "torrent not registered", "not registered with this tracker", "torrent not found", "unknown torrent"},
+		Remediation{
+			Problem:     "Removed from the tracker",
+			Explanation: "The tracker no longer lists this torrent, usually because it was deleted or replaced by a new version (a PROPER or REPACK). Look for the replacement on the tracker; reannounce in case it was a tracker hiccup.",
+			Actions:     []string{RemedyReannounce},
+		},
+	},
+	{
+		[]string{"passkey", "unauthorized", "not authorized", "access denied"},
+		Remediation{
+			Problem:     "Not authorized by the tracker",
+			Explanation: "The tracker rejected the announce, usually an outdated passkey. Download the .torrent again from the tracker and re-add it, or fix the account.",
+			Actions:     []string{RemedyReannounce},
+		},
+	},
+	{
+		[]string{"no space left", "disk full", "not enough space"},
+		Remediation{
+			Problem:     "Disk full",
+			Explanation: "The download directory ran out of space. Free some up and start the torrent again, or move it to a disk with room.",
+			Actions:     []string{RemedySetLocation, RemedyStart},
+		},
+	},
+	{
+		[]string{"permission denied", "operation not permitted", "read-only file system"},
+		Remediation{
+			Problem:     "Can't write the files",
+			Explanation: "The daemon isn't allowed to write to the download directory. Give the user Transmission runs as access to it, or move the torrent somewhere it can write.",
+			Actions:     []string{RemedySetLocation, RemedyStart},
+		},
+	},
+	{
+		[]string{"no data found", "no such file or directory"},
+		Remediation{
+			Problem:     "Files missing",
+			Explanation: "The data isn't where Transmission expects it; it was probably moved or deleted outside the daemon. Point the torrent at its current location (with \"data is already there\"), or verify to download what's missing.",
+			Actions:     []string{RemedySetLocation, RemedyVerify},
+		},
+	},
+	{
+		[]string{"corrupt", "checksum", "hash check"},
+		Remediation{
+			Problem:     "Data doesn't match",
+			Explanation: "Some pieces on disk don't match the torrent. Verify to find the bad pieces and download them again.",
+			Actions:     []string{RemedyVerify},
+		},
+	},
+	{
+		[]string{"timed out", "timeout", "could not connect", "couldn't connect", "connection refused", "couldn't resolve", "could not resolve", "connection failed", "bad gateway", "service unavailable"},
+		Remediation{
+			Problem:     "Tracker unreachable",
+			Explanation: "The tracker didn't answer. This is usually temporary; the daemon retries on its own, or reannounce now. If it lasts, the tracker may be down for good.",
+			Actions:     []string{RemedyReannounce},
+		},
+	},
+}
+
+// remedyFor explains a torrent's error, or returns nil when it has none.
+// Unrecognized errors fall back to a generic remedy for their kind.
+func remedyFor(t *Torrent) *Remediation {
+	if t.Error == 0 {
+		return nil
+	}
+	msg := strings.ToLower(t.ErrorString)
+	for _, e := range errorRemedies {
+		for _, m := range e.matches {
+			if strings.Contains(msg, m) {
+				r := e.remedy
+				return &r
+			}
+		}
+	}
+	switch t.Error {
+	case TorrentErrorLocal:
+		return &Remediation{
+			Problem:     "Local error",
+			Explanation: "The daemon hit a problem with the files on disk. Check the directory is mounted and writable, then verify.",
+			Actions:     []string{RemedyVerify, RemedyStart},
+		}
+	case TorrentErrorTrackerWarning:
+		return &Remediation{
+			Problem:     "Tracker warning",
+			Explanation: "The tracker accepted the announce with a warning. It's usually informational.",
+			Actions:     []string{RemedyReannounce},
+		}
+	}
+	return &Remediation{
+		Problem:     "Tracker error",
+		Explanation: "The tracker refused the announce. Reannounce, and check the tracker's site if it persists.",
+		Actions:     []string{RemedyReannounce},
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRPCHealthRetention = 7 * 24 * time.Hour
+	defaultRPCSLO             = 0.99
+
+	// rpcLatencySamples caps the latencies kept per minute for percentiles
+	rpcLatencySamples = 2000
+)
+
+// rpcRecorder counts one client's RPC outcomes for the current minute. A
+// nil recorder ignores everything.
+type rpcRecorder struct {
+	mu         sync.Mutex
+	minute     time.Time
+	requests   int
+	errors     int
+	handshakes int
+	latencies  []time.Duration
+}
+
+// observe records a finished request; latency is zero when it never reached
+// the daemon (e.g. the request queue was full)
+func (r *rpcRecorder) observe(latency time.Duration, failed bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if failed {
+		r.errors++
+	}
+	if latency > 0 && len(r.latencies) < rpcLatencySamples {
+		r.latencies = append(r.latencies, latency)
+	}
+}
+
+func (r *rpcRecorder) handshake() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.handshakes++
+	r.mu.Unlock()
+}
+
+// take returns the minute collected so far and starts a new one
+func (r *rpcRecorder) take(now time.Time) RPCHealthMinute {
+	r.mu.Lock()
+	m := RPCHealthMinute{
+		Minute:     r.minute,
+		Requests:   r.requests,
+		Errors:     r.errors,
+		Handshakes: r.handshakes,
+	}
+	latencies := r.latencies
+	r.minute = now.Truncate(time.Minute)
+	r.requests, r.errors, r.handshakes, r.latencies = 0, 0, 0, nil
+	r.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	m.P50 = percentileMillis(latencies, 0.50)
+	m.P95 = percentileMillis(latencies, 0.95)
+	m.P99 = percentileMillis(latencies, 0.99)
+	if len(latencies) > 0 {
+		m.Max = float64(latencies[len(latencies)-1]) / float64(time.Millisecond)
+	}
+	return m
+}
+
+// percentileMillis picks the nearest-rank percentile of sorted latencies
+func percentileMillis(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// RPCHealthMinute is one minute of RPC traffic to a daemon. Latencies are
+// in milliseconds.
+type RPCHealthMinute struct {
+	Minute     time.Time `json:"minute"`
+	Requests   int       `json:"requests"`
+	Errors     int       `json:"errors"`
+	Handshakes int       `json:"handshakes"`
+	P50        float64   `json:"p50"`
+	P95        float64   `json:"p95"`
+	P99        float64   `json:"p99"`
+	Max        float64   `json:"max"`
+}
+
+// RPCHealthSummary totals a span of minutes against the SLO. The
+// percentiles are the worst minute's, since per-minute percentiles can't
+// be merged exactly.
+type RPCHealthSummary struct {
+	Requests    int     `json:"requests"`
+	Errors      int     `json:"errors"`
+	Handshakes  int     `json:"handshakes"`
+	SuccessRate float64 `json:"successRate"`
+	SLO         float64 `json:"slo"`
+	// BudgetLeft is the share of the allowed errors (1-SLO of requests)
+	// not yet used; negative once the budget is blown
+	BudgetLeft float64 `json:"budgetLeft"`
+	P50        float64 `json:"p50"`
+	P95        float64 `json:"p95"`
+	P99        float64 `json:"p99"`
+}
+
+// RPCHealth stores every client's per-minute RPC stats in SQLite so a
+// struggling daemon or flaky network shows up over time
+type RPCHealth struct {
+	db        *sql.DB
+	retention time.Duration
+	slo       float64
+
+	mu        sync.Mutex
+	recorders map[string]*rpcRecorder // by instance name
+}
+
+// NewRPCHealth creates the rpc_health table
+func NewRPCHealth(db *sql.DB, retention time.Duration, slo float64) (*RPCHealth, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS rpc_health (
+		instance TEXT NOT NULL,
+		minute INTEGER NOT NULL,
+		requests INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		handshakes INTEGER NOT NULL,
+		p50_ms REAL NOT NULL,
+		p95_ms REAL NOT NULL,
+		p99_ms REAL NOT NULL,
+		max_ms REAL NOT NULL,
+		PRIMARY KEY (instance, minute)
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if retention <= 0 {
+		retention = defaultRPCHealthRetention
+	}
+	if slo <= 0 || slo >= 1 {
+		slo = defaultRPCSLO
+	}
+	return &RPCHealth{db: db, retention: retention, slo: slo, recorders: make(map[string]*rpcRecorder)}, nil
+}
+
+// Track starts recording a client's requests under the instance name
+func (h *RPCHealth) Track(name string, c *TransmissionClient) {
+	rec := &rpcRecorder{minute: time.Now().Truncate(time.Minute)}
+	h.mu.Lock()
+	h.recorders[name] = rec
+	h.mu.Unlock()
+	c.mu.Lock()
+	c.recorder = rec
+	c.mu.Unlock()
+}
+
+// Start flushes every minute and prunes old rows hourly
+func (h *RPCHealth) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			h.flush(now)
+			if now.Minute() == 0 {
+				h.prune(now)
+			}
+		}
+	}()
+}
+
+func (h *RPCHealth) flush(now time.Time) {
+	h.mu.Lock()
+	recorders := make(map[string]*rpcRecorder, len(h.recorders))
+	for name, rec := range h.recorders {
+		recorders[name] = rec
+	}
+	h.mu.Unlock()
+
+	for name, rec := range recorders {
+		m := rec.take(now)
+		if m.Requests == 0 && m.Handshakes == 0 {
+			continue
+		}
+		_, err := h.db.Exec(`INSERT OR REPLACE INTO rpc_health
+			(instance, minute, requests, errors, handshakes, p50_ms, p95_ms, p99_ms, max_ms)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			name, m.Minute.Unix(), m.Requests, m.Errors, m.Handshakes, m.P50, m.P95, m.P99, m.Max)
+		if err != nil {
+			log.Printf("Failed to record RPC health: %v", err)
+		}
+	}
+}
+
+func (h *RPCHealth) prune(now time.Time) {
+	if _, err := h.db.Exec("DELETE FROM rpc_health WHERE minute < ?", now.Add(-h.retention).Unix()); err != nil {
+		log.Printf("Failed to prune RPC health: %v", err)
+	}
+}
+
+// Minutes returns an instance's stored minutes since a time, oldest first
+func (h *RPCHealth) Minutes(instance string, since time.Time) ([]RPCHealthMinute, error) {
+	rows, err := h.db.Query(`SELECT minute, requests, errors, handshakes, p50_ms, p95_ms, p99_ms, max_ms
+		FROM rpc_health WHERE instance = ? AND minute >= ? ORDER BY minute`, instance, since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	minutes := []RPCHealthMinute{}
+	for rows.Next() {
+		var m RPCHealthMinute
+		var minute int64
+		if err := rows.Scan(&minute, &m.Requests, &m.Errors, &m.Handshakes, &m.P50, &m.P95, &m.P99, &m.Max); err != nil {
+			return nil, err
+		}
+		m.Minute = time.Unix(minute, 0)
+		minutes = append(minutes, m)
+	}
+	return minutes, rows.Err()
+}
+
+// Summarize totals minutes against the SLO
+func (h *RPCHealth) Summarize(minutes []RPCHealthMinute) RPCHealthSummary {
+	sum := RPCHealthSummary{SLO: h.slo, SuccessRate: 1, BudgetLeft: 1}
+	for _, m := range minutes {
+		sum.Requests += m.Requests
+		sum.Errors += m.Errors
+		sum.Handshakes += m.Handshakes
+		sum.P50 = max(sum.P50, m.P50)
+		sum.P95 = max(sum.P95, m.P95)
+		sum.P99 = max(sum.P99, m.P99)
+	}
+	if sum.Requests > 0 {
+		sum.SuccessRate = 1 - float64(sum.Errors)/float64(sum.Requests)
+		allowed := float64(sum.Requests) * (1 - h.slo)
+		sum.BudgetLeft = 1 - float64(sum.Errors)/allowed
+	}
+	return sum
+}
+
+// rollup merges minutes into buckets of the given size for display
+func rollup(minutes []RPCHealthMinute, size time.Duration) []RPCHealthMinute {
+	var out []RPCHealthMinute
+	for _, m := range minutes {
+		start := m.Minute.Truncate(size)
+		if n := len(out); n > 0 && out[n-1].Minute.Equal(start) {
+			b := &out[n-1]
+			b.Requests += m.Requests
+			b.Errors += m.Errors
+			b.Handshakes += m.Handshakes
+			b.P50 = max(b.P50, m.P50)
+			b.P95 = max(b.P95, m.P95)
+			b.P99 = max(b.P99, m.P99)
+			b.Max = max(b.Max, m.Max)
+			continue
+		}
+		m.Minute = start
+		out = append(out, m)
+	}
+	return out
+}
+
+// rpcHealthQuery reads ?instance= and ?hours= (default 24, at most the
+// retention)
+func (s *Server) rpcHealthQuery(r *http.Request) (string, time.Duration) {
+	instance := r.URL.Query().Get("instance")
+	if instance == "" {
+		instance = DefaultInstance
+	}
+	span := 24 * time.Hour
+	if hours, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && hours > 0 {
+		span = min(time.Duration(hours)*time.Hour, s.rpcHealth.retention)
+	}
+	return instance, span
+}
+
+// handleRPCHealth serves the stored minutes and their summary
+func (s *Server) handleRPCHealth(w http.ResponseWriter, r *http.Request) {
+	instance, span := s.rpcHealthQuery(r)
+	minutes, err := s.rpcHealth.Minutes(instance, time.Now().Add(-span))
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"instance": instance,
+		"summary":  s.rpcHealth.Summarize(minutes),
+		"minutes":  minutes,
+	})
+}
+
+// handleRPCHealthPage renders the backend error budget dashboard
+func (s *Server) handleRPCHealthPage(w http.ResponseWriter, r *http.Request) {
+	instance, span := s.rpcHealthQuery(r)
+	minutes, err := s.rpcHealth.Minutes(instance, time.Now().Add(-span))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	buckets := rollup(minutes, time.Hour)
+	if span <= 6*time.Hour {
+		buckets = rollup(minutes, 5*time.Minute)
+	}
+	// The chart runs oldest to newest, scaled to the slowest bucket
+	maxP95 := 0.0
+	for _, b := range buckets {
+		maxP95 = max(maxP95, b.P95)
+	}
+	s.render(w, "rpc.html", map[string]interface{}{
+		"Instance":  instance,
+		"Instances": s.instanceNames(),
+		"Hours":     int(span / time.Hour),
+		"Summary":   s.rpcHealth.Summarize(minutes),
+		"Buckets":   buckets,
+		"MaxP95":    maxP95,
+		"Session":   s.clientSession(instance),
+		"Version":   Version,
+	})
+}
+
+// clientSession returns the handshake metrics of an instance, if it exists
+func (s *Server) clientSession(instance string) *SessionMetrics {
+	c, err := s.clientFor(instance)
+	if err != nil {
+		return nil
+	}
+	m := c.SessionMetrics()
+	return &m
+}
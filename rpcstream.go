@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// readerPool recycles the read buffers streamed torrent-get responses are
+// decoded through, so each poll doesn't allocate fresh ones
+var readerPool = sync.Pool{
+	New: func() interface{} { return bufio.NewReaderSize(nil, 64<<10) },
+}
+
+// torrentCountHint is the expected size of a torrent-get result
+func (c *TransmissionClient) torrentCountHint(ids []int) int {
+	if len(ids) > 0 {
+		return len(ids)
+	}
+	return int(c.lastTorrentCount.Load())
+}
+
+// streamTorrentGet runs torrent-get and calls fn with the decoder
+// positioned at each element of arguments.torrents, so the response is
+// decoded one torrent at a time rather than held whole in memory. On a
+// seedbox with tens of thousands of torrents that's the difference between a
+// few kilobytes and tens of megabytes per poll.
+func (c *TransmissionClient) streamTorrentGet(args map[string]interface{}, fn func(dec *json.Decoder) error) error {
+	resp, err := c.send(&RPCRequest{Method: "torrent-get", Arguments: args})
+	if err != nil {
+		return err
+	}
+	defer closeBody(resp)
+
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(resp.Body)
+	defer func() {
+		br.Reset(nil)
+		readerPool.Put(br)
+	}()
+
+	dec := json.NewDecoder(br)
+	var result string
+	err = decodeObject(dec, func(key string) error {
+		switch key {
+		case "result":
+			return dec.Decode(&result)
+		case "arguments":
+			return decodeObject(dec, func(key string) error {
+				if key != "torrents" {
+					return skipValue(dec)
+				}
+				return decodeArray(dec, func() error { return fn(dec) })
+			})
+		}
+		return skipValue(dec)
+	})
+	if err != nil {
+		return err
+	}
+	// Transmission writes "result" after "arguments", so a failure is
+	// only known once the body has been read
+	if result != "success" {
+		return &RPCResultError{Result: result}
+	}
+	return nil
+}
+
+// decodeObject calls fn for each key of the JSON object at the decoder,
+// with the decoder positioned at the key's value
+func decodeObject(dec *json.Decoder, fn func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %v in RPC response", tok)
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeArray calls fn for each element of the JSON array at the decoder.
+// A null array has no elements.
+func decodeArray(dec *json.Decoder, fn func() error) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return fmt.Errorf("unexpected %v in RPC response, want [", tok)
+	}
+	for dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != want {
+		return fmt.Errorf("unexpected %v in RPC response, want %v", tok, want)
+	}
+	return nil
+}
+
+// skipValue discards the next JSON value
+func skipValue(dec *json.Decoder) error {
+	var discard json.RawMessage
+	return dec.Decode(&discard)
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net"
 	"net/http"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
-	_ "modernc.org/sqlite"
 )
 
 // Feed represents an RSS feed configuration
@@ -27,9 +33,141 @@ type Feed struct {
 	LastChecked   time.Time `json:"lastChecked"`
 	LastError     string    `json:"lastError"`
 	MatchCount    int       `json:"matchCount"` // total matches found
+	// Filter additionally matches the parsed release name; nil matches all
+	Filter *ReleaseFilter `json:"filter,omitempty"`
+	// Patterns, when set, replace Pattern: they are tried in order and the
+	// first match decides the options the torrent is added with
+	Patterns []FeedPattern `json:"patterns,omitempty"`
+	// PausedUntil stops scheduled checks until then; set with PauseFeed
+	PausedUntil *time.Time `json:"pausedUntil,omitempty"`
+	// ItemKey picks what identifies an item in the feed history, for feeds
+	// whose GUIDs are missing or unstable; one of the ItemKey constants
+	ItemKey string `json:"itemKey,omitempty"`
+	// DownloadDir and Labels apply to everything the feed adds; a pattern's
+	// own directory wins, and its label is added to these
+	DownloadDir string   `json:"downloadDir,omitempty"`
+	Labels      []string `json:"labels,omitempty"`
+	// EpisodeDedup skips items for an episode that's already been added,
+	// whichever group released it; see trackedEpisode
+	EpisodeDedup bool `json:"episodeDedup,omitempty"`
 }
 
-// DownloadedItem tracks items that have been downloaded
+// Paused reports whether the feed is paused right now
+func (f *Feed) Paused() bool {
+	return f.PausedUntil != nil && time.Now().Before(*f.PausedUntil)
+}
+
+// Feed item identity strategies
+const (
+	ItemKeyGUID      = "guid"       // the GUID, else the link, else a title hash (default)
+	ItemKeyLink      = "link"       // the link, else a title hash
+	ItemKeyTitle     = "title"      // a hash of the title
+	ItemKeyTitleLink = "title+link" // a hash of the title and link
+)
+
+func validItemKey(key string) error {
+	switch key {
+	case "", ItemKeyGUID, ItemKeyLink, ItemKeyTitle, ItemKeyTitleLink:
+		return nil
+	}
+	return fmt.Errorf("unknown item key %q", key)
+}
+
+// itemKey returns the feed history key for item
+func (f *Feed) itemKey(item *gofeed.Item) string {
+	switch f.ItemKey {
+	case ItemKeyTitle:
+		return contentHash(item.Title)
+	case ItemKeyTitleLink:
+		return contentHash(item.Title + "\n" + item.Link)
+	case ItemKeyLink:
+		if item.Link != "" {
+			return item.Link
+		}
+		return contentHash(item.Title)
+	}
+	switch {
+	case item.GUID != "":
+		return item.GUID
+	case item.Link != "":
+		return item.Link
+	}
+	return contentHash(item.Title)
+}
+
+func contentHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
+
+// FeedPattern is one entry of a feed's ordered pattern list
+type FeedPattern struct {
+	Pattern string `json:"pattern"`
+	Dir     string `json:"dir,omitempty"`   // download directory; empty uses the daemon default
+	Label   string `json:"label,omitempty"` // label added to the torrent
+}
+
+// compilePatterns returns the feed's patterns in match order
+func (f *Feed) compilePatterns() ([]compiledPattern, error) {
+	patterns := f.Patterns
+	if len(patterns) == 0 {
+		patterns = []FeedPattern{{Pattern: f.Pattern}}
+	}
+	compiled := make([]compiledPattern, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", p.Pattern, err)
+		}
+		if strings.Contains(p.Label, ",") {
+			return nil, fmt.Errorf("label %q must not contain commas", p.Label)
+		}
+		compiled = append(compiled, compiledPattern{FeedPattern: p, re: re})
+	}
+	for _, l := range f.Labels {
+		if l == "" || strings.Contains(l, ",") {
+			return nil, fmt.Errorf("label %q must not be empty or contain commas", l)
+		}
+	}
+	return compiled, nil
+}
+
+// addOptions returns the directory and labels an item matched by p is
+// added with
+func (f *Feed) addOptions(p *compiledPattern) (string, []string) {
+	dir := p.Dir
+	if dir == "" {
+		dir = f.DownloadDir
+	}
+	labels := append([]string(nil), f.Labels...)
+	if p.Label != "" && !slices.Contains(labels, p.Label) {
+		labels = append(labels, p.Label)
+	}
+	return dir, labels
+}
+
+type compiledPattern struct {
+	FeedPattern
+	re *regexp.Regexp
+}
+
+// match returns the first pattern matching title
+func match(patterns []compiledPattern, title string) (*compiledPattern, bool) {
+	for i := range patterns {
+		if patterns[i].re.MatchString(title) {
+			return &patterns[i], true
+		}
+	}
+	return nil, false
+}
+
+// Feed history item statuses
+const (
+	ItemStatusAdded    = "added"
+	ItemStatusRejected = "rejected"
+)
+
+// DownloadedItem tracks items that have been downloaded, or rejected
 type DownloadedItem struct {
 	ID           int       `json:"id"`
 	FeedID       int       `json:"feedId"`
@@ -37,6 +175,8 @@ type DownloadedItem struct {
 	ItemTitle    string    `json:"itemTitle"`
 	ItemLink     string    `json:"itemLink"`
 	DownloadedAt time.Time `json:"downloadedAt"`
+	Status       string    `json:"status"`
+	Reason       string    `json:"reason,omitempty"`
 }
 
 // FeedCheckLog stores information about feed checks
@@ -47,25 +187,26 @@ type FeedCheckLog struct {
 	ItemsFound      int       `json:"itemsFound"`
 	ItemsMatched    int       `json:"itemsMatched"`
 	ItemsDownloaded int       `json:"itemsDownloaded"`
-	SampleTitles    string    `json:"sampleTitles"` // JSON array of first 10 titles
+	SampleTitles    string    `json:"sampleTitles"`    // JSON array of first 10 titles
+	Error           string    `json:"error,omitempty"` // why the feed couldn't be fetched
 }
 
 // FeedManager handles RSS feed polling and management
 type FeedManager struct {
 	db            *sql.DB
 	client        *TransmissionClient
+	adder         *Adder
 	parser        *gofeed.Parser
 	stopCh        chan struct{}
 	checkInterval time.Duration
+	concurrency   int // feeds checked at once
+	polite        *politeTransport
+	pause         *AutomationPause
+	dupes         *DuplicateGuard
 }
 
 // NewFeedManager creates a new feed manager
-func NewFeedManager(dbPath string, client *TransmissionClient) (*FeedManager, error) {
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
+func NewFeedManager(db *sql.DB, client *TransmissionClient) (*FeedManager, error) {
 	// Create tables
 	if err := createTables(db); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
@@ -92,11 +233,12 @@ func NewFeedManager(dbPath string, client *TransmissionClient) (*FeedManager, er
 		},
 	}
 
+	polite := newPoliteTransport(&customTransport{
+		Base: httpTransport,
+	})
 	httpClient := &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &customTransport{
-			Base: httpTransport,
-		},
+		Timeout:   60 * time.Second,
+		Transport: polite,
 	}
 
 	// Create parser with custom HTTP client
@@ -108,12 +250,22 @@ func NewFeedManager(dbPath string, client *TransmissionClient) (*FeedManager, er
 		client:        client,
 		parser:        parser,
 		stopCh:        make(chan struct{}),
-		checkInterval: 15 * time.Minute, // default check interval
+		checkInterval: time.Minute, // how often to look for due feeds
+		concurrency:   4,
+		polite:        polite,
 	}
 
 	return fm, nil
 }
 
+// SetPoliteness configures how many feeds are checked at once, how many
+// requests may be in flight to one host and the gap between requests to it
+func (fm *FeedManager) SetPoliteness(concurrency, perHost int, delay time.Duration) {
+	fm.concurrency = max(concurrency, 1)
+	fm.polite.perHost = max(perHost, 1)
+	fm.polite.delay = delay
+}
+
 // customTransport wraps the default transport to add headers
 type customTransport struct {
 	Base http.RoundTripper
@@ -174,7 +326,47 @@ func createTables(db *sql.DB) error {
 		}
 	}
 
-	return nil
+	// Items the adder refused are kept in the history with the reason, so
+	// they aren't retried on every check
+	if err := addColumn(db, "downloaded_items", "status", "TEXT NOT NULL DEFAULT 'added'"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "downloaded_items", "reason", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// The info hash of what an item added, to tell which feed a torrent
+	// came from
+	if err := addColumn(db, "downloaded_items", "hash", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "release_filter", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "patterns", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "paused_until", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "item_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "download_dir", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "labels", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumn(db, "feeds", "episode_dedup", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := createPendingTable(db); err != nil {
+		return err
+	}
+	if err := createEpisodesTable(db); err != nil {
+		return err
+	}
+	return createCheckItemsTable(db)
 }
 
 // Start begins background polling of RSS feeds
@@ -206,26 +398,40 @@ func (fm *FeedManager) pollLoop() {
 }
 
 func (fm *FeedManager) checkAllFeeds() {
+	if fm.pause.Active() {
+		return
+	}
+
 	feeds, err := fm.GetFeeds()
 	if err != nil {
 		log.Printf("Failed to get feeds: %v", err)
 		return
 	}
 
+	// Due feeds are checked in parallel; the transport keeps requests to
+	// any one host in line
+	sem := make(chan struct{}, fm.concurrency)
+	var wg sync.WaitGroup
 	for _, feed := range feeds {
-		if !feed.Enabled {
+		if !feed.Enabled || feed.Paused() {
 			continue
 		}
 
 		// Check if it's time to check this feed
-		if time.Since(feed.LastChecked) < time.Duration(feed.CheckInterval)*time.Minute {
+		if time.Since(feed.LastChecked) < time.Duration(feed.CheckInterval)*time.Minute+feedJitter(&feed) {
 			continue
 		}
 
-		if err := fm.CheckFeed(feed.ID); err != nil {
-			log.Printf("Error checking feed %s: %v", feed.Name, err)
-		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(feed Feed) {
+			defer func() { <-sem; wg.Done() }()
+			if err := fm.CheckFeed(feed.ID); err != nil {
+				log.Printf("Error checking feed %s: %v", feed.Name, err)
+			}
+		}(feed)
 	}
+	wg.Wait()
 }
 
 // CheckFeed checks a single feed for new items
@@ -252,6 +458,15 @@ func (fm *FeedManager) CheckFeed(feedID int) error {
 			break
 		}
 
+		// Retrying early would only anger a host that asked for a pause
+		var backoff *HostBackoffError
+		if errors.As(err, &backoff) {
+			fm.updateFeedError(feedID, backoff.Error())
+			fm.saveCheck(feedID, &checkRun{}, nil, backoff.Error())
+			log.Printf("⏸ Feed '%s' skipped: %v", feed.Name, backoff)
+			return nil
+		}
+
 		if attempt < maxRetries {
 			delay := baseDelay * time.Duration(attempt) // 10s, 20s
 			log.Printf("⚠️  Attempt %d/%d failed for feed '%s': %v. Retrying in %v...",
@@ -260,6 +475,7 @@ func (fm *FeedManager) CheckFeed(feedID int) error {
 		} else {
 			errorMsg := fmt.Sprintf("Failed after %d attempts: %v", maxRetries, err)
 			fm.updateFeedError(feedID, errorMsg)
+			fm.saveCheck(feedID, &checkRun{}, nil, errorMsg)
 			log.Printf("❌ Feed '%s' temporarily unavailable after %d attempts. Will retry on next check cycle.", feed.Name, maxRetries)
 			// Don't return error - just log it and let the next check cycle try again
 			// This way we don't spam the tracker
@@ -267,14 +483,18 @@ func (fm *FeedManager) CheckFeed(feedID int) error {
 		}
 	}
 
-	// Compile regex pattern
-	pattern, err := regexp.Compile(feed.Pattern)
+	// Compile regex patterns
+	patterns, err := feed.compilePatterns()
 	if err != nil {
 		fm.updateFeedError(feedID, fmt.Sprintf("invalid pattern: %v", err))
-		return fmt.Errorf("invalid regex pattern: %w", err)
+		return err
 	}
 
-	log.Printf("Checking RSS feed '%s' with pattern: %s", feed.Name, feed.Pattern)
+	if len(feed.Patterns) > 0 {
+		log.Printf("Checking RSS feed '%s' with %d patterns", feed.Name, len(feed.Patterns))
+	} else {
+		log.Printf("Checking RSS feed '%s' with pattern: %s", feed.Name, feed.Pattern)
+	}
 	log.Printf("Found %d items in feed", len(parsedFeed.Items))
 
 	// Collect sample titles for logging
@@ -285,22 +505,32 @@ func (fm *FeedManager) CheckFeed(feedID int) error {
 		}
 	}
 
-	matchCount := 0
-	downloadedCount := 0
-
+	run := &checkRun{}
+	dupIndex := fm.dupes.Index()
 	for _, item := range parsedFeed.Items {
-		// Check if item matches pattern
-		matched := pattern.MatchString(item.Title)
+		// The feed history is keyed on this rather than the GUID alone
+		key := feed.itemKey(item)
+
+		// Check if item matches a pattern, and the release filter if set
+		pattern, matched := match(patterns, item.Title)
 		if !matched {
+			run.record(item, key, CheckOutcomeNoMatch, "", "")
 			continue
 		}
+		if feed.Filter != nil {
+			release := ParseRelease(item.Title)
+			if reason := feed.Filter.Mismatch(&release); reason != "" {
+				run.record(item, key, CheckOutcomeFiltered, reason, pattern.Pattern)
+				continue
+			}
+		}
 
-		matchCount++
 		log.Printf("  ✓ Matched: %s", item.Title)
 
 		// Check if we've already downloaded this item
-		if fm.isDownloaded(feedID, item.GUID) {
+		if status := fm.itemStatus(feedID, key); status != "" {
 			log.Printf("  ⏭ Already downloaded: %s", item.Title)
+			run.record(item, key, CheckOutcomeSeen, seenReason(status), pattern.Pattern)
 			continue
 		}
 
@@ -308,39 +538,87 @@ func (fm *FeedManager) CheckFeed(feedID int) error {
 		torrentLink := fm.findTorrentLink(item)
 		if torrentLink == "" {
 			log.Printf("  ⚠ No torrent link found for: %s", item.Title)
+			run.record(item, key, CheckOutcomeNoLink, "", pattern.Pattern)
 			continue
 		}
 
 		// Add torrent to Transmission
-		if err := fm.client.AddTorrent(torrentLink, nil); err != nil {
+		req := AddRequest{URL: torrentLink, Source: SourceRSS}
+		req.Dir, req.Labels = feed.addOptions(pattern)
+
+		// Hold back items that repeat something we already have
+		size := itemSize(item)
+		if likely := dupIndex.lookup(item.Title, size); likely != nil {
+			log.Printf("  ⏭ %s: %s", likely, item.Title)
+			run.record(item, key, CheckOutcomeLikelyDuplicate, likely.String(), pattern.Pattern)
+			if err := fm.holdDuplicate(feedID, key, item, req, likely); err != nil {
+				log.Printf("  ⚠ Failed to record likely duplicate: %v", err)
+			}
+			continue
+		}
+
+		// Other releases of an episode the feed already has are skipped
+		// for good, whatever their GUID
+		if feed.EpisodeDedup {
+			if have := fm.trackedEpisode(item.Title); have != nil {
+				log.Printf("  ⏭ %s: %s", have, item.Title)
+				run.record(item, key, CheckOutcomeSameEpisode, have.String(), pattern.Pattern)
+				if err := fm.markItem(feedID, key, item, ItemStatusSkipped, have.String()); err != nil {
+					log.Printf("  ⚠ Failed to record skipped item: %v", err)
+				}
+				continue
+			}
+		}
+
+		added, err := fm.adder.Add(req)
+		var dup *DuplicateAddError
+		var excluded *ExcludedURLError
+		switch {
+		case errors.As(err, &excluded):
+			log.Printf("  🚫 Rejected %s: %v", item.Title, err)
+			run.record(item, key, CheckOutcomeRejected, err.Error(), pattern.Pattern)
+			if err := fm.markRejected(feedID, key, item, err.Error()); err != nil {
+				log.Printf("  ⚠ Failed to record rejected item: %v", err)
+			}
+			continue
+		case errors.As(err, &dup):
+			// Another source won the race; don't retry it on the next check
+			log.Printf("  ⏭ Already added by %s: %s", dup.WonBy, item.Title)
+			run.record(item, key, CheckOutcomeDuplicate, "already added by "+dup.WonBy, pattern.Pattern)
+			if err := fm.markDownloaded(feedID, key, item, ""); err != nil {
+				log.Printf("  ⚠ Failed to mark item as downloaded: %v", err)
+			}
+			continue
+		case err != nil:
 			log.Printf("  ❌ Failed to add torrent %s: %v", item.Title, err)
+			run.record(item, key, CheckOutcomeFailed, err.Error(), pattern.Pattern)
 			continue
 		}
 
+		run.record(item, key, CheckOutcomeAdded, "", pattern.Pattern)
+		if dupIndex != nil {
+			dupIndex.add(item.Title, size, "feed history")
+		}
+		if feed.EpisodeDedup {
+			if err := fm.trackEpisode(feedID, item.Title); err != nil {
+				log.Printf("  ⚠ Failed to track episode: %v", err)
+			}
+		}
+
 		// Mark as downloaded
-		if err := fm.markDownloaded(feedID, item); err != nil {
+		if err := fm.markDownloaded(feedID, key, item, added.HashString); err != nil {
 			log.Printf("  ⚠ Failed to mark item as downloaded: %v", err)
 			continue
 		}
 
-		downloadedCount++
 		log.Printf("  ✅ Added torrent from RSS feed %s: %s", feed.Name, item.Title)
 	}
 
-	// Log summary
+	downloadedCount := run.count(CheckOutcomeAdded)
 	log.Printf("Feed check complete: %d total items, %d matched pattern, %d downloaded",
-		len(parsedFeed.Items), matchCount, downloadedCount)
+		len(parsedFeed.Items), len(run.items)-run.count(CheckOutcomeNoMatch, CheckOutcomeFiltered), downloadedCount)
 
-	// Save check log
-	sampleJSON, _ := json.Marshal(sampleTitles)
-	_, err = fm.db.Exec(
-		`INSERT INTO feed_check_log (feed_id, checked_at, items_found, items_matched, items_downloaded, sample_titles)
-		 VALUES (?, datetime('now'), ?, ?, ?, ?)`,
-		feedID, len(parsedFeed.Items), matchCount, downloadedCount, string(sampleJSON),
-	)
-	if err != nil {
-		log.Printf("Failed to save check log: %v", err)
-	}
+	fm.saveCheck(feedID, run, sampleTitles, "")
 
 	// Update feed status
 	fm.updateFeedChecked(feedID, downloadedCount, "")
@@ -369,6 +647,36 @@ func (fm *FeedManager) findTorrentLink(item *gofeed.Item) string {
 		}
 	}
 
+	// Some feeds only link the torrent from the item's HTML body
+	for _, body := range []string{item.Description, item.Content} {
+		if link := extractTorrentLink(body); link != "" {
+			return link
+		}
+	}
+
+	return ""
+}
+
+var (
+	hrefPattern   = regexp.MustCompile(`(?i)href\s*=\s*(?:"([^"]+)"|'([^']+)'|([^\s>]+))`)
+	magnetPattern = regexp.MustCompile(`magnet:\?[^\s"'<>]+`)
+)
+
+// extractTorrentLink finds a magnet or .torrent link in an HTML fragment,
+// preferring anchors over bare magnets in the text
+func extractTorrentLink(body string) string {
+	if body == "" {
+		return ""
+	}
+	for _, m := range hrefPattern.FindAllStringSubmatch(body, -1) {
+		link := html.UnescapeString(m[1] + m[2] + m[3])
+		if isMagnetLink(link) || isTorrentFile(link) {
+			return link
+		}
+	}
+	if m := magnetPattern.FindString(body); m != "" {
+		return html.UnescapeString(m)
+	}
 	return ""
 }
 
@@ -380,20 +688,60 @@ func isTorrentFile(url string) bool {
 	return len(url) > 8 && url[len(url)-8:] == ".torrent"
 }
 
-func (fm *FeedManager) isDownloaded(feedID int, guid string) bool {
-	var count int
+// itemStatus returns the feed history status of an item, or "" if it
+// hasn't been seen
+func (fm *FeedManager) itemStatus(feedID int, guid string) string {
+	var status string
 	err := fm.db.QueryRow(
-		"SELECT COUNT(*) FROM downloaded_items WHERE feed_id = ? AND item_guid = ?",
+		"SELECT status FROM downloaded_items WHERE feed_id = ? AND item_guid = ? LIMIT 1",
 		feedID, guid,
-	).Scan(&count)
-	return err == nil && count > 0
+	).Scan(&status)
+	if err != nil {
+		return ""
+	}
+	return status
 }
 
-func (fm *FeedManager) markDownloaded(feedID int, item *gofeed.Item) error {
+func (fm *FeedManager) markDownloaded(feedID int, key string, item *gofeed.Item, hash string) error {
 	_, err := fm.db.Exec(
-		`INSERT INTO downloaded_items (feed_id, item_guid, item_title, item_link, downloaded_at)
-		 VALUES (?, ?, ?, ?, datetime('now'))`,
-		feedID, item.GUID, item.Title, item.Link,
+		`INSERT INTO downloaded_items (feed_id, item_guid, item_title, item_link, downloaded_at, hash)
+		 VALUES (?, ?, ?, ?, datetime('now'), ?)`,
+		feedID, key, item.Title, item.Link, strings.ToLower(hash),
+	)
+	return err
+}
+
+// FeedHashes maps the info hash of each torrent a feed added to the feed
+func (fm *FeedManager) FeedHashes() (map[string]int, error) {
+	rows, err := fm.db.Query("SELECT hash, feed_id FROM downloaded_items WHERE hash != ''")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	feeds := make(map[string]int)
+	for rows.Next() {
+		var hash string
+		var feedID int
+		if err := rows.Scan(&hash, &feedID); err != nil {
+			return nil, err
+		}
+		feeds[hash] = feedID
+	}
+	return feeds, rows.Err()
+}
+
+// markRejected records an item the adder refused, with the reason
+func (fm *FeedManager) markRejected(feedID int, key string, item *gofeed.Item, reason string) error {
+	return fm.markItem(feedID, key, item, ItemStatusRejected, reason)
+}
+
+// markItem records an item in the feed history under key, with a status
+// other than added, so later checks don't consider it again
+func (fm *FeedManager) markItem(feedID int, key string, item *gofeed.Item, status, reason string) error {
+	_, err := fm.db.Exec(
+		`INSERT INTO downloaded_items (feed_id, item_guid, item_title, item_link, downloaded_at, status, reason)
+		 VALUES (?, ?, ?, ?, datetime('now'), ?, ?)`,
+		feedID, key, item.Title, item.Link, status, reason,
 	)
 	return err
 }
@@ -421,11 +769,7 @@ func (fm *FeedManager) updateFeedError(feedID int, errorMsg string) {
 
 // GetFeeds returns all feeds
 func (fm *FeedManager) GetFeeds() ([]Feed, error) {
-	rows, err := fm.db.Query(`
-		SELECT id, name, url, pattern, enabled, check_interval, 
-		       COALESCE(last_checked, ''), COALESCE(last_error, ''), match_count
-		FROM feeds ORDER BY id
-	`)
+	rows, err := fm.db.Query("SELECT " + feedColumns + " FROM feeds ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -433,20 +777,11 @@ func (fm *FeedManager) GetFeeds() ([]Feed, error) {
 
 	var feeds []Feed
 	for rows.Next() {
-		var feed Feed
-		var lastChecked string
-		err := rows.Scan(
-			&feed.ID, &feed.Name, &feed.URL, &feed.Pattern, &feed.Enabled,
-			&feed.CheckInterval, &lastChecked, &feed.LastError, &feed.MatchCount,
-		)
+		feed, err := scanFeed(rows)
 		if err != nil {
 			return nil, err
 		}
-		if lastChecked != "" {
-			// Try multiple date formats
-			feed.LastChecked = parseSQLiteDate(lastChecked)
-		}
-		feeds = append(feeds, feed)
+		feeds = append(feeds, *feed)
 	}
 
 	return feeds, rows.Err()
@@ -454,25 +789,71 @@ func (fm *FeedManager) GetFeeds() ([]Feed, error) {
 
 // GetFeed returns a single feed by ID
 func (fm *FeedManager) GetFeed(id int) (*Feed, error) {
+	return scanFeed(fm.db.QueryRow("SELECT "+feedColumns+" FROM feeds WHERE id = ?", id))
+}
+
+const feedColumns = `id, name, url, pattern, enabled, check_interval,
+	COALESCE(last_checked, ''), COALESCE(last_error, ''), match_count, release_filter, patterns, paused_until, item_key,
+	download_dir, labels, episode_dedup`
+
+func scanFeed(row interface{ Scan(...interface{}) error }) (*Feed, error) {
 	var feed Feed
-	var lastChecked string
-	err := fm.db.QueryRow(`
-		SELECT id, name, url, pattern, enabled, check_interval,
-		       COALESCE(last_checked, ''), COALESCE(last_error, ''), match_count
-		FROM feeds WHERE id = ?
-	`, id).Scan(
+	var lastChecked, filter, patterns, pausedUntil, labels string
+	err := row.Scan(
 		&feed.ID, &feed.Name, &feed.URL, &feed.Pattern, &feed.Enabled,
-		&feed.CheckInterval, &lastChecked, &feed.LastError, &feed.MatchCount,
+		&feed.CheckInterval, &lastChecked, &feed.LastError, &feed.MatchCount, &filter, &patterns, &pausedUntil, &feed.ItemKey,
+		&feed.DownloadDir, &labels, &feed.EpisodeDedup,
 	)
 	if err != nil {
 		return nil, err
 	}
 	if lastChecked != "" {
+		// Try multiple date formats
 		feed.LastChecked = parseSQLiteDate(lastChecked)
 	}
+	if pausedUntil != "" {
+		t := parseSQLiteDate(pausedUntil)
+		feed.PausedUntil = &t
+	}
+	if filter != "" {
+		feed.Filter = &ReleaseFilter{}
+		if err := json.Unmarshal([]byte(filter), feed.Filter); err != nil {
+			return nil, fmt.Errorf("feed %d: invalid release filter: %w", feed.ID, err)
+		}
+	}
+	if patterns != "" {
+		if err := json.Unmarshal([]byte(patterns), &feed.Patterns); err != nil {
+			return nil, fmt.Errorf("feed %d: invalid patterns: %w", feed.ID, err)
+		}
+	}
+	if labels != "" {
+		feed.Labels = strings.Split(labels, ",")
+	}
 	return &feed, nil
 }
 
+// encodeFilter validates a feed's release filter and serializes it for the
+// release_filter column
+func encodeFilter(filter *ReleaseFilter) (string, error) {
+	if filter == nil {
+		return "", nil
+	}
+	if err := filter.Validate(); err != nil {
+		return "", fmt.Errorf("invalid release filter: %w", err)
+	}
+	data, err := json.Marshal(filter)
+	return string(data), err
+}
+
+// encodePatterns serializes a feed's pattern list for the patterns column
+func encodePatterns(patterns []FeedPattern) (string, error) {
+	if len(patterns) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(patterns)
+	return string(data), err
+}
+
 // parseSQLiteDate tries to parse dates in various SQLite formats
 func parseSQLiteDate(dateStr string) time.Time {
 	formats := []string{
@@ -495,9 +876,21 @@ func parseSQLiteDate(dateStr string) time.Time {
 
 // AddFeed adds a new feed
 func (fm *FeedManager) AddFeed(feed *Feed) error {
-	// Validate regex pattern
-	if _, err := regexp.Compile(feed.Pattern); err != nil {
-		return fmt.Errorf("invalid regex pattern: %w", err)
+	// Validate regex patterns
+	if _, err := feed.compilePatterns(); err != nil {
+		return err
+	}
+	if err := validItemKey(feed.ItemKey); err != nil {
+		return err
+	}
+
+	filter, err := encodeFilter(feed.Filter)
+	if err != nil {
+		return err
+	}
+	patterns, err := encodePatterns(feed.Patterns)
+	if err != nil {
+		return err
 	}
 
 	if feed.CheckInterval <= 0 {
@@ -505,9 +898,10 @@ func (fm *FeedManager) AddFeed(feed *Feed) error {
 	}
 
 	result, err := fm.db.Exec(
-		`INSERT INTO feeds (name, url, pattern, enabled, check_interval)
-		 VALUES (?, ?, ?, ?, ?)`,
-		feed.Name, feed.URL, feed.Pattern, feed.Enabled, feed.CheckInterval,
+		`INSERT INTO feeds (name, url, pattern, enabled, check_interval, release_filter, patterns, item_key, download_dir, labels, episode_dedup)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		feed.Name, feed.URL, feed.Pattern, feed.Enabled, feed.CheckInterval, filter, patterns, feed.ItemKey,
+		feed.DownloadDir, strings.Join(feed.Labels, ","), feed.EpisodeDedup,
 	)
 	if err != nil {
 		return err
@@ -520,19 +914,49 @@ func (fm *FeedManager) AddFeed(feed *Feed) error {
 
 // UpdateFeed updates an existing feed
 func (fm *FeedManager) UpdateFeed(feed *Feed) error {
-	// Validate regex pattern
-	if _, err := regexp.Compile(feed.Pattern); err != nil {
-		return fmt.Errorf("invalid regex pattern: %w", err)
+	// Validate regex patterns
+	if _, err := feed.compilePatterns(); err != nil {
+		return err
+	}
+	if err := validItemKey(feed.ItemKey); err != nil {
+		return err
 	}
 
-	_, err := fm.db.Exec(
-		`UPDATE feeds SET name = ?, url = ?, pattern = ?, enabled = ?, check_interval = ?
-		 WHERE id = ?`,
-		feed.Name, feed.URL, feed.Pattern, feed.Enabled, feed.CheckInterval, feed.ID,
+	filter, err := encodeFilter(feed.Filter)
+	if err != nil {
+		return err
+	}
+	patterns, err := encodePatterns(feed.Patterns)
+	if err != nil {
+		return err
+	}
+
+	_, err = fm.db.Exec(
+		`UPDATE feeds SET name = ?, url = ?, pattern = ?, enabled = ?, check_interval = ?, release_filter = ?, patterns = ?, item_key = ?,
+		 download_dir = ?, labels = ?, episode_dedup = ? WHERE id = ?`,
+		feed.Name, feed.URL, feed.Pattern, feed.Enabled, feed.CheckInterval, filter, patterns, feed.ItemKey,
+		feed.DownloadDir, strings.Join(feed.Labels, ","), feed.EpisodeDedup, feed.ID,
 	)
 	return err
 }
 
+// PauseFeed stops scheduled checks of a feed until the given time; a zero
+// time resumes it
+func (fm *FeedManager) PauseFeed(id int, until time.Time) error {
+	value := ""
+	if !until.IsZero() {
+		value = sqliteTime(until)
+	}
+	result, err := fm.db.Exec("UPDATE feeds SET paused_until = ? WHERE id = ?", value, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("feed %d not found", id)
+	}
+	return nil
+}
+
 // DeleteFeed deletes a feed
 func (fm *FeedManager) DeleteFeed(id int) error {
 	_, err := fm.db.Exec("DELETE FROM feeds WHERE id = ?", id)
@@ -546,7 +970,7 @@ func (fm *FeedManager) GetDownloadedItems(feedID int, limit int) ([]DownloadedIt
 	}
 
 	rows, err := fm.db.Query(`
-		SELECT id, feed_id, item_guid, item_title, item_link, downloaded_at
+		SELECT id, feed_id, item_guid, item_title, item_link, downloaded_at, status, reason
 		FROM downloaded_items
 		WHERE feed_id = ?
 		ORDER BY downloaded_at DESC
@@ -562,7 +986,7 @@ func (fm *FeedManager) GetDownloadedItems(feedID int, limit int) ([]DownloadedIt
 		var item DownloadedItem
 		err := rows.Scan(
 			&item.ID, &item.FeedID, &item.ItemGUID, &item.ItemTitle,
-			&item.ItemLink, &item.DownloadedAt,
+			&item.ItemLink, &item.DownloadedAt, &item.Status, &item.Reason,
 		)
 		if err != nil {
 			return nil, err
@@ -580,7 +1004,7 @@ func (fm *FeedManager) GetFeedCheckLogs(feedID int, limit int) ([]FeedCheckLog,
 	}
 
 	rows, err := fm.db.Query(`
-		SELECT id, feed_id, checked_at, items_found, items_matched, items_downloaded, sample_titles
+		SELECT id, feed_id, checked_at, items_found, items_matched, items_downloaded, sample_titles, error
 		FROM feed_check_log
 		WHERE feed_id = ?
 		ORDER BY checked_at DESC
@@ -596,7 +1020,7 @@ func (fm *FeedManager) GetFeedCheckLogs(feedID int, limit int) ([]FeedCheckLog,
 		var log FeedCheckLog
 		err := rows.Scan(
 			&log.ID, &log.FeedID, &log.CheckedAt, &log.ItemsFound,
-			&log.ItemsMatched, &log.ItemsDownloaded, &log.SampleTitles,
+			&log.ItemsMatched, &log.ItemsDownloaded, &log.SampleTitles, &log.Error,
 		)
 		if err != nil {
 			return nil, err
@@ -607,8 +1031,8 @@ func (fm *FeedManager) GetFeedCheckLogs(feedID int, limit int) ([]FeedCheckLog,
 	return logs, rows.Err()
 }
 
-// Close closes the database connection
+// Close stops background polling. The database itself is owned by main.
 func (fm *FeedManager) Close() error {
 	fm.Stop()
-	return fm.db.Close()
+	return nil
 }
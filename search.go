@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SourceSearch attributes torrents added from search results
+const SourceSearch = "search"
+
+// SearchResult is one torrent found in the self-hosted index
+type SearchResult struct {
+	Name        string    `json:"name"`
+	InfoHash    string    `json:"infoHash"`
+	Size        int64     `json:"size"`
+	Seeders     int       `json:"seeders"`
+	Leechers    int       `json:"leechers"`
+	MagnetURI   string    `json:"magnetUri"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// BitmagnetSearch queries a bitmagnet instance's GraphQL API
+type BitmagnetSearch struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewBitmagnetSearch creates a client for the bitmagnet at baseURL
+// (e.g. http://bitmagnet:3333)
+func NewBitmagnetSearch(baseURL string) *BitmagnetSearch {
+	return &BitmagnetSearch{
+		endpoint:   strings.TrimRight(baseURL, "/") + "/graphql",
+		httpClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+const bitmagnetSearchQuery = `query Search($input: TorrentContentSearchQueryInput!) {
+  torrentContent {
+    search(input: $input) {
+      items {
+        infoHash
+        title
+        seeders
+        leechers
+        publishedAt
+        torrent { name size magnetUri }
+      }
+    }
+  }
+}`
+
+// Search returns up to limit torrents matching query, best matches first
+func (b *BitmagnetSearch) Search(ctx context.Context, query string, limit int) ([]SearchResult, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": bitmagnetSearchQuery,
+		"variables": map[string]interface{}{
+			"input": map[string]interface{}{"queryString": query, "limit": limit},
+		},
+	})
+	req, err := http.NewRequestWithContext(ctx, "POST", b.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bitmagnet returned HTTP %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			TorrentContent struct {
+				Search struct {
+					Items []struct {
+						InfoHash    string    `json:"infoHash"`
+						Title       string    `json:"title"`
+						Seeders     *int      `json:"seeders"`
+						Leechers    *int      `json:"leechers"`
+						PublishedAt time.Time `json:"publishedAt"`
+						Torrent     struct {
+							Name      string `json:"name"`
+							Size      int64  `json:"size"`
+							MagnetURI string `json:"magnetUri"`
+						} `json:"torrent"`
+					} `json:"items"`
+				} `json:"search"`
+			} `json:"torrentContent"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Errors) > 0 {
+		return nil, errors.New(result.Errors[0].Message)
+	}
+
+	items := result.Data.TorrentContent.Search.Items
+	results := make([]SearchResult, 0, len(items))
+	for _, it := range items {
+		r := SearchResult{
+			Name:        it.Torrent.Name,
+			InfoHash:    it.InfoHash,
+			Size:        it.Torrent.Size,
+			MagnetURI:   it.Torrent.MagnetURI,
+			PublishedAt: it.PublishedAt,
+		}
+		if r.Name == "" {
+			r.Name = it.Title
+		}
+		if r.MagnetURI == "" {
+			r.MagnetURI = "magnet:?xt=urn:btih:" + it.InfoHash
+		}
+		// Swarm counts are null until bitmagnet has scraped the torrent
+		if it.Seeders != nil {
+			r.Seeders = *it.Seeders
+		}
+		if it.Leechers != nil {
+			r.Leechers = *it.Leechers
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if s.search == nil {
+		writeJSONError(w, "search is not configured")
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSONError(w, "missing q parameter")
+		return
+	}
+	limit := 50
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+
+	results, err := s.search.Search(r.Context(), query, limit)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"results": results})
+}
+
+func (s *Server) handleSearchAdd(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		MagnetURI string `json:"magnetUri"`
+		Result    string `json:"result"` // an indexer result's token
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	link := req.MagnetURI
+	if req.Result != "" {
+		var ok bool
+		if link, ok = s.torznab.Link(req.Result); !ok {
+			writeJSONError(w, "search result expired, search again")
+			return
+		}
+	} else if !isMagnetLink(link) {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	added, err := s.adder.Add(AddRequest{URL: link, Source: SourceSearch})
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(addErrorStatus(err))
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, added)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// SeedRule is a private tracker's hit-and-run rule: torrents announcing to
+// Tracker, or a subdomain of it, must be seeded for MinSeedHours or up to
+// MinRatio before they may be removed. When both are set, either one
+// satisfies the rule, the way most trackers word it.
+type SeedRule struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Enabled      bool    `json:"enabled"`
+	Tracker      string  `json:"tracker"` // host
+	MinSeedHours float64 `json:"minSeedHours,omitempty"`
+	MinRatio     float64 `json:"minRatio,omitempty"`
+}
+
+// Validate checks the rule names a tracker and at least one requirement
+func (r *SeedRule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Tracker = strings.ToLower(strings.TrimSpace(r.Tracker))
+	if u, err := url.Parse(r.Tracker); err == nil && u.Host != "" {
+		r.Tracker = u.Hostname() // an announce URL was pasted
+	}
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("name is required")
+	case r.Tracker == "":
+		return fmt.Errorf("tracker is required")
+	case r.MinSeedHours < 0 || r.MinRatio < 0:
+		return fmt.Errorf("minSeedHours and minRatio can't be negative")
+	case r.MinSeedHours == 0 && r.MinRatio == 0:
+		return fmt.Errorf("give minSeedHours, minRatio or both")
+	}
+	return nil
+}
+
+// covers reports whether the torrent announces to the rule's tracker
+func (r *SeedRule) covers(t *Torrent) bool {
+	for _, tr := range t.Trackers {
+		u, err := url.Parse(tr.Announce)
+		if err != nil {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if host == r.Tracker || strings.HasSuffix(host, "."+r.Tracker) {
+			return true
+		}
+	}
+	return false
+}
+
+// SeedObligation is where a torrent stands against its tracker's rule
+type SeedObligation struct {
+	Rule      string  `json:"rule"`
+	Met       bool    `json:"met"`
+	SeedLeft  int64   `json:"seedLeft,omitempty"`  // seconds of seeding still owed
+	RatioLeft float64 `json:"ratioLeft,omitempty"` // ratio still owed
+}
+
+// Owed describes what's left, for messages
+func (o *SeedObligation) Owed() string {
+	var parts []string
+	if o.SeedLeft > 0 {
+		parts = append(parts, fmt.Sprintf("%.1fh more seeding", float64(o.SeedLeft)/3600))
+	}
+	if o.RatioLeft > 0 {
+		parts = append(parts, fmt.Sprintf("%s more ratio", display.Ratio(o.RatioLeft)))
+	}
+	return strings.Join(parts, " or ")
+}
+
+// obligation checks t against one rule. An incomplete torrent hasn't
+// started seeding, so owes all of it.
+func (r *SeedRule) obligation(t *Torrent) *SeedObligation {
+	o := &SeedObligation{Rule: r.Name}
+	if r.MinSeedHours > 0 {
+		o.SeedLeft = max(int64(r.MinSeedHours*3600)-t.SecondsSeeding, 0)
+	}
+	if r.MinRatio > 0 {
+		o.RatioLeft = max(r.MinRatio-max(t.UploadRatio, 0), 0)
+	}
+	o.Met = t.PercentDone >= 1 &&
+		(r.MinSeedHours > 0 && o.SeedLeft == 0 || r.MinRatio > 0 && o.RatioLeft == 0)
+	if o.Met {
+		o.SeedLeft, o.RatioLeft = 0, 0
+	}
+	return o
+}
+
+// SeedObligationError is returned when removing a torrent that still owes
+// its tracker seeding
+type SeedObligationError struct {
+	Name       string
+	Obligation *SeedObligation
+}
+
+func (e *SeedObligationError) Error() string {
+	return fmt.Sprintf("%s hasn't met %q yet: it needs %s", e.Name, e.Obligation.Rule, e.Obligation.Owed())
+}
+
+// SeedRules keeps the per-tracker seeding rules, marks torrents with how
+// they stand, refuses to remove ones that still owe seeding unless forced,
+// and notifies once when a torrent on the default daemon has met its rule
+// and may be removed safely.
+type SeedRules struct {
+	db     *sql.DB
+	events *EventBus
+	block  bool
+
+	mu      sync.Mutex
+	rules   []SeedRule      // enabled ones, cached for listing
+	alerted map[string]bool // hashes already notified about
+}
+
+// NewSeedRules creates the seed_rules table, and seed_alerts for the
+// torrents already notified about, so a restart doesn't repeat them
+func NewSeedRules(db *sql.DB, events *EventBus, block bool) (*SeedRules, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS seed_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		tracker TEXT NOT NULL,
+		min_seed_hours REAL NOT NULL DEFAULT 0,
+		min_ratio REAL NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seed_rules table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS seed_alerts (
+		hash TEXT PRIMARY KEY,
+		alerted_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create seed_alerts table: %w", err)
+	}
+	sr := &SeedRules{db: db, events: events, block: block, alerted: map[string]bool{}}
+	if err := sr.reload(); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query("SELECT hash FROM seed_alerts")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		sr.alerted[hash] = true
+	}
+	return sr, rows.Err()
+}
+
+// GetRules returns all rules
+func (sr *SeedRules) GetRules() ([]SeedRule, error) {
+	rows, err := sr.db.Query("SELECT id, name, enabled, tracker, min_seed_hours, min_ratio FROM seed_rules ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []SeedRule{}
+	for rows.Next() {
+		var r SeedRule
+		if err := rows.Scan(&r.ID, &r.Name, &r.Enabled, &r.Tracker, &r.MinSeedHours, &r.MinRatio); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// reload refreshes the cached enabled rules
+func (sr *SeedRules) reload() error {
+	rules, err := sr.GetRules()
+	if err != nil {
+		return err
+	}
+	enabled := rules[:0]
+	for _, r := range rules {
+		if r.Enabled {
+			enabled = append(enabled, r)
+		}
+	}
+	sr.mu.Lock()
+	sr.rules = enabled
+	sr.mu.Unlock()
+	return nil
+}
+
+// AddRule validates and stores a new rule
+func (sr *SeedRules) AddRule(r *SeedRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	result, err := sr.db.Exec("INSERT INTO seed_rules (name, enabled, tracker, min_seed_hours, min_ratio) VALUES (?, ?, ?, ?, ?)",
+		r.Name, r.Enabled, r.Tracker, r.MinSeedHours, r.MinRatio)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	r.ID = int(id)
+	return sr.reload()
+}
+
+// UpdateRule validates and replaces an existing rule
+func (sr *SeedRules) UpdateRule(r *SeedRule) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	_, err := sr.db.Exec("UPDATE seed_rules SET name = ?, enabled = ?, tracker = ?, min_seed_hours = ?, min_ratio = ? WHERE id = ?",
+		r.Name, r.Enabled, r.Tracker, r.MinSeedHours, r.MinRatio, r.ID)
+	if err != nil {
+		return err
+	}
+	return sr.reload()
+}
+
+// DeleteRule deletes a rule
+func (sr *SeedRules) DeleteRule(id int) error {
+	if _, err := sr.db.Exec("DELETE FROM seed_rules WHERE id = ?", id); err != nil {
+		return err
+	}
+	return sr.reload()
+}
+
+// Obligation returns how t stands against the rules covering it: the
+// first one it still owes, or the first one when it has met them all. A
+// torrent no rule covers, or a nil SeedRules, gives nil.
+func (sr *SeedRules) Obligation(t *Torrent) *SeedObligation {
+	if sr == nil {
+		return nil
+	}
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	var first *SeedObligation
+	for i := range sr.rules {
+		if !sr.rules[i].covers(t) {
+			continue
+		}
+		o := sr.rules[i].obligation(t)
+		if !o.Met {
+			return o
+		}
+		if first == nil {
+			first = o
+		}
+	}
+	return first
+}
+
+// Mark sets Obligation on the torrents a rule covers
+func (sr *SeedRules) Mark(torrents []Torrent) {
+	for i := range torrents {
+		torrents[i].Obligation = sr.Obligation(&torrents[i])
+	}
+}
+
+// CheckRemove returns a SeedObligationError if t still owes seeding and
+// removal isn't forced. Nothing is blocked with SEED_RULES_BLOCK_REMOVAL
+// off.
+func (sr *SeedRules) CheckRemove(t *Torrent, force bool) error {
+	if sr == nil || !sr.block || force {
+		return nil
+	}
+	if o := sr.Obligation(t); o != nil && !o.Met {
+		return &SeedObligationError{Name: t.Name, Obligation: o}
+	}
+	return nil
+}
+
+// Blocks reports whether removing t would need forcing, for the basic
+// view's confirmation page
+func (sr *SeedRules) Blocks(t *Torrent) bool {
+	return sr.CheckRemove(t, false) != nil
+}
+
+// OnSnapshot notifies about torrents that have met their rule since the
+// last poll, and forgets the ones that went away or owe seeding again
+// after a rule changed
+func (sr *SeedRules) OnSnapshot(_, cur *Snapshot) {
+	sr.mu.Lock()
+	if len(sr.rules) == 0 && len(sr.alerted) == 0 {
+		sr.mu.Unlock()
+		return
+	}
+	sr.mu.Unlock()
+
+	met := make(map[string]bool)
+	for i := range cur.Torrents {
+		t := &cur.Torrents[i]
+		o := sr.Obligation(t)
+		if o == nil || !o.Met {
+			continue
+		}
+		met[t.HashString] = true
+		sr.mu.Lock()
+		done := sr.alerted[t.HashString]
+		sr.mu.Unlock()
+		if done {
+			continue
+		}
+		if _, err := sr.db.Exec("INSERT OR IGNORE INTO seed_alerts (hash, alerted_at) VALUES (?, ?)", t.HashString, cur.Time); err != nil {
+			log.Printf("Failed to record seed alert: %v", err)
+			continue
+		}
+		sr.mu.Lock()
+		sr.alerted[t.HashString] = true
+		sr.mu.Unlock()
+		sr.events.Publish(Event{
+			Type:    EventTorrentSeeded,
+			Hash:    t.HashString,
+			Name:    t.Name,
+			Message: fmt.Sprintf("met %q at ratio %s", o.Rule, display.Ratio(t.UploadRatio)),
+		})
+	}
+
+	sr.mu.Lock()
+	var stale []string
+	for hash := range sr.alerted {
+		if !met[hash] {
+			stale = append(stale, hash)
+			delete(sr.alerted, hash)
+		}
+	}
+	sr.mu.Unlock()
+	for _, hash := range stale {
+		if _, err := sr.db.Exec("DELETE FROM seed_alerts WHERE hash = ?", hash); err != nil {
+			log.Printf("Failed to clear seed alert: %v", err)
+		}
+	}
+}
+
+func (s *Server) handleGetSeedRules(w http.ResponseWriter, _ *http.Request) {
+	rules, err := s.seedRules.GetRules()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"rules": rules})
+}
+
+func (s *Server) handleAddSeedRule(w http.ResponseWriter, r *http.Request) {
+	var rule SeedRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.seedRules.AddRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, rule)
+}
+
+func (s *Server) handleUpdateSeedRule(w http.ResponseWriter, r *http.Request) {
+	var rule SeedRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.seedRules.UpdateRule(&rule); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleDeleteSeedRule(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.seedRules.DeleteRule(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The install and uninstall subcommands register the binary with the
+// platform's service manager, a Windows service or a macOS launchd agent,
+// so it starts at boot (or login) without Docker or systemd:
+//
+//	transmission-web install -config C:\transmission-web\config.yaml
+//
+// Services don't inherit the shell's environment, so settings come from
+// the -config file.
+
+// defaultServiceName names the service, or the launchd agent's label
+const defaultServiceName = "transmission-web"
+
+// serviceConfig is what a service is registered to run
+type serviceConfig struct {
+	name   string
+	exe    string // the binary, with symlinks resolved
+	config string // absolute path of the settings file, if any
+}
+
+// startService is replaced on Windows, where a process started by the
+// service manager has to report to it
+var startService = func() {}
+
+func uninstallFlags(fs *flag.FlagSet) (name *string) {
+	return fs.String("name", defaultServiceName, "service name, or launchd label on macOS")
+}
+
+func installFlags(fs *flag.FlagSet) (name, config *string) {
+	name = uninstallFlags(fs)
+	config = fs.String("config", os.Getenv("CONFIG_FILE"), "YAML settings file the service reads (CONFIG_FILE)")
+	return name, config
+}
+
+// runInstall is the install subcommand
+func runInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	name, config := installFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: transmission-web install [-name NAME] [-config FILE]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "install: %v\n", err)
+		return 1
+	}
+	c := serviceConfig{name: *name, exe: exe}
+	if *config != "" {
+		if c.config, err = filepath.Abs(*config); err != nil {
+			fmt.Fprintf(os.Stderr, "install: %v\n", err)
+			return 1
+		}
+		if _, err := os.Stat(c.config); err != nil {
+			fmt.Fprintf(os.Stderr, "install: %v\n", err)
+			return 1
+		}
+	}
+
+	logPath, err := installService(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "install: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Installed and started %s running %s\nIts log is %s\n", c.name, c.exe, logPath)
+	if c.config == "" {
+		fmt.Println("No -config was given, so it runs with the default settings")
+	}
+	return 0
+}
+
+// runUninstall is the uninstall subcommand. The service's log and
+// database are left where they are.
+func runUninstall(args []string) int {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	name := uninstallFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: transmission-web uninstall [-name NAME]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := uninstallService(*name); err != nil {
+		fmt.Fprintf(os.Stderr, "uninstall: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Stopped and removed %s\n", *name)
+	return 0
+}
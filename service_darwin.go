@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// launchdPlist runs the server at login and keeps it running, from its
+// own data directory so the database lands there
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		{{range .Args}}<string>{{xml .}}</string>
+		{{end}}</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .Dir}}</string>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+</dict>
+</plist>
+`))
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	template.HTMLEscape(&b, []byte(s))
+	return b.String()
+}
+
+// launchdPaths returns the agent's plist, data directory and log file
+func launchdPaths(name string) (plist, dir, logPath string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", "", err
+	}
+	plist = filepath.Join(home, "Library", "LaunchAgents", name+".plist")
+	dir = filepath.Join(home, "Library", "Application Support", "transmission-web")
+	logPath = filepath.Join(home, "Library", "Logs", "transmission-web", name+".log")
+	return plist, dir, logPath, nil
+}
+
+// launchctl runs launchctl against the user's GUI session
+func launchctl(verb, plist string) error {
+	out, err := exec.Command("launchctl", verb, fmt.Sprintf("gui/%d", os.Getuid()), plist).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", verb, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// installService writes a launchd agent for the current user and loads
+// it, which starts the server
+func installService(c serviceConfig) (string, error) {
+	plist, dir, logPath, err := launchdPaths(c.name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(plist); err == nil {
+		return "", fmt.Errorf("%s already exists; uninstall it first", plist)
+	}
+	for _, d := range []string{filepath.Dir(plist), dir, filepath.Dir(logPath)} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return "", err
+		}
+	}
+	args := []string{c.exe}
+	if c.config != "" {
+		args = append(args, "-config", c.config)
+	}
+	var buf bytes.Buffer
+	err = launchdPlist.Execute(&buf, map[string]interface{}{"Label": c.name, "Args": args, "Dir": dir, "Log": logPath})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(plist, buf.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+	if err := launchctl("bootstrap", plist); err != nil {
+		os.Remove(plist)
+		return "", err
+	}
+	return logPath, nil
+}
+
+// uninstallService unloads the agent, stopping the server, and deletes
+// its plist
+func uninstallService(name string) error {
+	plist, _, _, err := launchdPaths(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(plist); err != nil {
+		return fmt.Errorf("%s is not installed", name)
+	}
+	if err := launchctl("bootout", plist); err != nil {
+		return err
+	}
+	return os.Remove(plist)
+}
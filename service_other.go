@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package main
+
+import "errors"
+
+var errNoServiceManager = errors.New("install supports Windows services and macOS launchd; on Linux use the systemd unit in the README or Docker")
+
+func installService(serviceConfig) (string, error) {
+	return "", errNoServiceManager
+}
+
+func uninstallService(string) error {
+	return errNoServiceManager
+}
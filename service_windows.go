@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+func init() {
+	startService = startWindowsService
+}
+
+// serviceDataDir holds the service's database and log. A service starts
+// in System32, so it moves here and relative paths such as DB_PATH's
+// default land here too.
+func serviceDataDir() string {
+	return filepath.Join(os.Getenv("ProgramData"), "transmission-web")
+}
+
+// windowsService answers the service manager: it reports the server as
+// running until the service is stopped
+type windowsService struct{}
+
+func (windowsService) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for req := range requests {
+		switch req.Cmd {
+		case svc.Interrogate:
+			status <- req.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// startWindowsService hands the process to the service manager when it
+// was started as a service, and does nothing otherwise
+func startWindowsService() {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return
+	}
+	if err := os.Chdir(serviceDataDir()); err != nil {
+		log.Printf("Failed to move to %s: %v", serviceDataDir(), err)
+	}
+	go func() {
+		if err := svc.Run(defaultServiceName, windowsService{}); err != nil {
+			log.Fatalf("Service failed: %v", err)
+		}
+		// Run has reported the service stopped, so exiting now isn't taken
+		// for a crash and restarted
+		log.Printf("Stopped by the service manager")
+		os.Exit(0)
+	}()
+}
+
+// installService registers c as an automatic-start service running as
+// LocalSystem, restarted when it fails, and starts it. It needs an
+// elevated prompt.
+func installService(c serviceConfig) (string, error) {
+	dir := serviceDataDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	logPath := filepath.Join(dir, "transmission-web.log")
+	args := []string{"-log-file", logPath}
+	if c.config != "" {
+		args = append(args, "-config", c.config)
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("connecting to the service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(c.name); err == nil {
+		s.Close()
+		return "", fmt.Errorf("service %s already exists; uninstall it first", c.name)
+	}
+	s, err := m.CreateService(c.name, c.exe, mgr.Config{
+		DisplayName: "Transmission Web",
+		Description: "Web interface for the Transmission BitTorrent daemon",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	restart := []mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}
+	if err := s.SetRecoveryActions(restart, uint32((24 * time.Hour).Seconds())); err != nil {
+		log.Printf("Failed to set the service's restart policy: %v", err)
+	}
+	if err := s.Start(); err != nil {
+		return "", fmt.Errorf("service installed but failed to start: %w", err)
+	}
+	return logPath, nil
+}
+
+// uninstallService stops and deletes the service
+func uninstallService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("connecting to the service manager (run as administrator): %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	if _, err := s.Control(svc.Stop); err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return err
+	}
+	return s.Delete()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// rpcSessionKeyPrefix is followed by the RPC URL, so each instance keeps
+// its own session
+const rpcSessionKeyPrefix = "rpc_session:"
+
+// rpcSessionSecretKey holds the random per-install key stored sessions'
+// credential fingerprints are made with
+const rpcSessionSecretKey = "rpc_session_secret"
+
+// storedSession is the last session ID a daemon issued that a request then
+// succeeded with, so it's known to work with the configured credentials
+type storedSession struct {
+	ID          string    `json:"id"`
+	Credentials string    `json:"credentials"` // fingerprint of user and pass
+	Verified    time.Time `json:"verified"`
+}
+
+// SessionMetrics describes a client's X-Transmission-Session-Id handshakes
+type SessionMetrics struct {
+	Restored      bool       `json:"restored"`   // started with a stored session ID
+	Handshakes    int64      `json:"handshakes"` // 409s that issued a new ID
+	AuthFailures  int64      `json:"authFailures"`
+	LastHandshake *time.Time `json:"lastHandshake,omitempty"`
+	Verified      *time.Time `json:"verified,omitempty"` // last new ID a request succeeded with
+}
+
+// credentialsFingerprint identifies the credentials a session was issued
+// under without storing the password. It's keyed, so a copy of the
+// database can't be used to guess the password offline.
+func credentialsFingerprint(key []byte, user, pass string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(user + "\x00" + pass))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sessionSecret loads the install's fingerprint key, creating it on first
+// use
+func sessionSecret(db *sql.DB) ([]byte, error) {
+	value, err := getSetting(db, rpcSessionSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := hex.DecodeString(value); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := setSetting(db, rpcSessionSecretKey, hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PersistSession stores the client's session ID in db from now on, and
+// picks up the stored one if it was verified with the same credentials, so
+// a restart doesn't start with a 409 round trip
+func (c *TransmissionClient) PersistSession(db *sql.DB) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, err := sessionSecret(db)
+	if err != nil {
+		return err
+	}
+	c.sessionDB, c.sessionKey = db, key
+
+	value, err := getSetting(db, rpcSessionKeyPrefix+c.url)
+	if err != nil || value == "" {
+		return err
+	}
+	var stored storedSession
+	if err := json.Unmarshal([]byte(value), &stored); err != nil {
+		return fmt.Errorf("invalid stored RPC session: %w", err)
+	}
+	if stored.Credentials != credentialsFingerprint(c.sessionKey, c.user, c.pass) {
+		return nil // credentials changed since; handshake afresh
+	}
+	c.sessionID, c.savedSession = stored.ID, stored.ID
+	c.metrics.Restored = true
+	c.metrics.Verified = &stored.Verified
+	return nil
+}
+
+// recordHandshake notes a 409 that issued a new session ID
+func (c *TransmissionClient) recordHandshake(id string) {
+	now := time.Now()
+	c.mu.Lock()
+	c.sessionID = id
+	c.metrics.Handshakes++
+	c.metrics.LastHandshake = &now
+	c.mu.Unlock()
+}
+
+// recordAuthFailure notes a 401 and forgets the stored session, since the
+// credentials it was verified with no longer work
+func (c *TransmissionClient) recordAuthFailure() {
+	c.mu.Lock()
+	c.metrics.AuthFailures++
+	db, saved := c.sessionDB, c.savedSession
+	c.savedSession = ""
+	c.mu.Unlock()
+	if db != nil && saved != "" {
+		if err := setSetting(db, rpcSessionKeyPrefix+c.url, ""); err != nil {
+			log.Printf("Failed to clear stored RPC session: %v", err)
+		}
+	}
+}
+
+// recordSuccess stores a session ID the first time a request succeeds with
+// it. Later requests with the same ID don't touch the database.
+func (c *TransmissionClient) recordSuccess(id string) {
+	c.mu.Lock()
+	if c.sessionDB == nil || id == "" || id == c.savedSession {
+		c.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	c.savedSession = id
+	c.metrics.Verified = &now
+	db, key := c.sessionDB, c.sessionKey
+	c.mu.Unlock()
+
+	value, _ := json.Marshal(storedSession{
+		ID:          id,
+		Credentials: credentialsFingerprint(key, c.user, c.pass),
+		Verified:    now,
+	})
+	if err := setSetting(db, rpcSessionKeyPrefix+c.url, string(value)); err != nil {
+		log.Printf("Failed to store RPC session: %v", err)
+	}
+}
+
+// SessionMetrics returns the client's handshake counters
+func (c *TransmissionClient) SessionMetrics() SessionMetrics {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.metrics
+}
+
+// handleRPCSession reports session handshake metrics for every instance
+func (s *Server) handleRPCSession(w http.ResponseWriter, _ *http.Request) {
+	metrics := make(map[string]SessionMetrics)
+	for _, name := range s.instanceNames() {
+		client, _ := s.clientFor(name)
+		metrics[name] = client.SessionMetrics()
+	}
+	writeJSON(w, map[string]interface{}{"instances": metrics})
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// SessionConfig is the daemon configuration exposed by session-get. Fields
+// keep Transmission's own names so the struct maps straight onto
+// session-set; nil fields are left unchanged by SetSession.
+type SessionConfig struct {
+	// Speed limits, in KB/s
+	SpeedLimitDown        *int  `json:"speed-limit-down,omitempty"`
+	SpeedLimitDownEnabled *bool `json:"speed-limit-down-enabled,omitempty"`
+	SpeedLimitUp          *int  `json:"speed-limit-up,omitempty"`
+	SpeedLimitUpEnabled   *bool `json:"speed-limit-up-enabled,omitempty"`
+	AltSpeedDown          *int  `json:"alt-speed-down,omitempty"`
+	AltSpeedUp            *int  `json:"alt-speed-up,omitempty"`
+	AltSpeedEnabled       *bool `json:"alt-speed-enabled,omitempty"`
+	AltSpeedTimeEnabled   *bool `json:"alt-speed-time-enabled,omitempty"`
+	AltSpeedTimeBegin     *int  `json:"alt-speed-time-begin,omitempty"` // minutes after midnight
+	AltSpeedTimeEnd       *int  `json:"alt-speed-time-end,omitempty"`
+	AltSpeedTimeDay       *int  `json:"alt-speed-time-day,omitempty"` // bitmask, Sunday = 1
+
+	// Peers and network
+	Encryption             *string  `json:"encryption,omitempty"`
+	PeerLimitGlobal        *int     `json:"peer-limit-global,omitempty"`
+	PeerLimitPerTorrent    *int     `json:"peer-limit-per-torrent,omitempty"`
+	PeerPort               *int     `json:"peer-port,omitempty"`
+	PeerPortRandomOnStart  *bool    `json:"peer-port-random-on-start,omitempty"`
+	PortForwardingEnabled  *bool    `json:"port-forwarding-enabled,omitempty"`
+	DHTEnabled             *bool    `json:"dht-enabled,omitempty"`
+	PEXEnabled             *bool    `json:"pex-enabled,omitempty"`
+	LPDEnabled             *bool    `json:"lpd-enabled,omitempty"`
+	UTPEnabled             *bool    `json:"utp-enabled,omitempty"`
+	BlocklistEnabled       *bool    `json:"blocklist-enabled,omitempty"`
+	BlocklistURL           *string  `json:"blocklist-url,omitempty"`
+	CacheSizeMB            *int     `json:"cache-size-mb,omitempty"`
+	StartAddedTorrents     *bool    `json:"start-added-torrents,omitempty"`
+	RenamePartialFiles     *bool    `json:"rename-partial-files,omitempty"`
+	TrashOriginalTorrent   *bool    `json:"trash-original-torrent-files,omitempty"`
+	DownloadDir            *string  `json:"download-dir,omitempty"`
+	IncompleteDir          *string  `json:"incomplete-dir,omitempty"`
+	IncompleteDirEnabled   *bool    `json:"incomplete-dir-enabled,omitempty"`
+	SeedRatioLimit         *float64 `json:"seedRatioLimit,omitempty"`
+	SeedRatioLimited       *bool    `json:"seedRatioLimited,omitempty"`
+	IdleSeedingLimit       *int     `json:"idle-seeding-limit,omitempty"` // minutes
+	IdleSeedingLimitEnable *bool    `json:"idle-seeding-limit-enabled,omitempty"`
+
+	// Queueing
+	DownloadQueueSize    *int  `json:"download-queue-size,omitempty"`
+	DownloadQueueEnabled *bool `json:"download-queue-enabled,omitempty"`
+	SeedQueueSize        *int  `json:"seed-queue-size,omitempty"`
+	SeedQueueEnabled     *bool `json:"seed-queue-enabled,omitempty"`
+	QueueStalledMinutes  *int  `json:"queue-stalled-minutes,omitempty"`
+	QueueStalledEnabled  *bool `json:"queue-stalled-enabled,omitempty"`
+
+	// Read-only; ignored by SetSession
+	Version    string `json:"version,omitempty"`
+	RPCVersion int    `json:"rpc-version,omitempty"`
+}
+
+// Encryption modes accepted by session-set
+var encryptionModes = map[string]bool{"required": true, "preferred": true, "tolerated": true}
+
+// Validate checks the set fields are within the ranges session-set accepts
+func (c *SessionConfig) Validate() error {
+	nonNegative := map[string]*int{
+		"speed-limit-down":       c.SpeedLimitDown,
+		"speed-limit-up":         c.SpeedLimitUp,
+		"alt-speed-down":         c.AltSpeedDown,
+		"alt-speed-up":           c.AltSpeedUp,
+		"cache-size-mb":          c.CacheSizeMB,
+		"idle-seeding-limit":     c.IdleSeedingLimit,
+		"download-queue-size":    c.DownloadQueueSize,
+		"seed-queue-size":        c.SeedQueueSize,
+		"queue-stalled-minutes":  c.QueueStalledMinutes,
+		"peer-limit-global":      c.PeerLimitGlobal,
+		"peer-limit-per-torrent": c.PeerLimitPerTorrent,
+	}
+	for name, v := range nonNegative {
+		if v != nil && *v < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	for name, v := range map[string]*int{"alt-speed-time-begin": c.AltSpeedTimeBegin, "alt-speed-time-end": c.AltSpeedTimeEnd} {
+		if v != nil && (*v < 0 || *v >= 24*60) {
+			return fmt.Errorf("%s must be between 0 and 1439 minutes", name)
+		}
+	}
+	if c.AltSpeedTimeDay != nil && (*c.AltSpeedTimeDay < 0 || *c.AltSpeedTimeDay > 127) {
+		return fmt.Errorf("alt-speed-time-day must be a day bitmask between 0 and 127")
+	}
+	if c.PeerPort != nil && (*c.PeerPort < 1 || *c.PeerPort > 65535) {
+		return fmt.Errorf("peer-port must be between 1 and 65535")
+	}
+	if c.Encryption != nil && !encryptionModes[*c.Encryption] {
+		return fmt.Errorf("encryption must be required, preferred or tolerated")
+	}
+	if c.SeedRatioLimit != nil && *c.SeedRatioLimit < 0 {
+		return fmt.Errorf("seedRatioLimit must not be negative")
+	}
+	if c.DownloadDir != nil && *c.DownloadDir == "" {
+		return fmt.Errorf("download-dir must not be empty")
+	}
+	return nil
+}
+
+// GetSession returns the daemon's configuration
+func (c *TransmissionClient) GetSession() (*SessionConfig, error) {
+	resp, err := c.doRequest(&RPCRequest{Method: "session-get"})
+	if err != nil {
+		return nil, err
+	}
+	var cfg SessionConfig
+	if err := json.Unmarshal(resp.Arguments, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// SetSession applies the non-nil fields of cfg with session-set
+func (c *TransmissionClient) SetSession(cfg *SessionConfig) error {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	delete(args, "version")
+	delete(args, "rpc-version")
+	if len(args) == 0 {
+		return nil
+	}
+	_, err = c.doRequest(&RPCRequest{Method: "session-set", Arguments: args})
+	return err
+}
+
+// sessionRevision is the ETag of a configuration. The daemon keeps no
+// revision of its own, so it's a hash of the settings: any change, from
+// another tab or another client entirely, gives a new one.
+func sessionRevision(cfg *SessionConfig) string {
+	raw, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(raw)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// sessionWriteMu keeps a conditional update's check and session-set together
+var sessionWriteMu sync.Mutex
+
+// handleSession serves the daemon configuration on GET and applies a partial
+// update on POST, returning the configuration as it is afterwards. Both
+// send the revision as an ETag; a POST with If-Match is refused with a 412
+// and the current configuration if the settings have changed since.
+func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	if r.Method == "POST" {
+		if !features.Enabled(FeatureSettings) {
+			writeFeatureDisabled(w, r, FeatureSettings)
+			return
+		}
+		var cfg SessionConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			writeJSONError(w, "invalid request")
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+		if !setSessionIfMatch(w, client, &cfg, r.Header.Get("If-Match")) {
+			return
+		}
+	}
+
+	cfg, err := client.GetSession()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	w.Header().Set("ETag", sessionRevision(cfg))
+	writeJSON(w, cfg)
+}
+
+// setSessionIfMatch applies cfg unless ifMatch is given and no longer the
+// current revision, writing the error response itself when it fails
+func setSessionIfMatch(w http.ResponseWriter, client *TransmissionClient, cfg *SessionConfig, ifMatch string) bool {
+	if ifMatch == "" || ifMatch == "*" {
+		if err := client.SetSession(cfg); err != nil {
+			writeJSONError(w, err.Error())
+			return false
+		}
+		return true
+	}
+	sessionWriteMu.Lock()
+	defer sessionWriteMu.Unlock()
+	current, err := client.GetSession()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return false
+	}
+	if rev := sessionRevision(current); rev != ifMatch {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", rev)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		writeJSON(w, map[string]interface{}{
+			"error":    "the settings were changed elsewhere since they were loaded",
+			"revision": rev,
+			"current":  current,
+		})
+		return false
+	}
+	if err := client.SetSession(cfg); err != nil {
+		writeJSONError(w, err.Error())
+		return false
+	}
+	return true
+}
+
+// settingField is one input on the settings page
+type settingField struct {
+	Key     string // session-get name
+	Label   string
+	Kind    string // "number", "decimal", "bool", "text" or "select"
+	Options []string
+	Value   interface{}
+}
+
+type settingSection struct {
+	Title  string
+	Fields []settingField
+}
+
+// settingsLayout orders the settings page. Values are filled in from
+// session-get, and fields the daemon doesn't report are left out.
+var settingsLayout = []settingSection{
+	{Title: "Speed Limits (KB/s)", Fields: []settingField{
+		{Key: "speed-limit-down-enabled", Label: "Limit download", Kind: "bool"},
+		{Key: "speed-limit-down", Label: "Download limit", Kind: "number"},
+		{Key: "speed-limit-up-enabled", Label: "Limit upload", Kind: "bool"},
+		{Key: "speed-limit-up", Label: "Upload limit", Kind: "number"},
+		{Key: "alt-speed-enabled", Label: "Alternative limits on", Kind: "bool"},
+		{Key: "alt-speed-down", Label: "Alternative download limit", Kind: "number"},
+		{Key: "alt-speed-up", Label: "Alternative upload limit", Kind: "number"},
+		{Key: "alt-speed-time-enabled", Label: "Schedule alternative limits", Kind: "bool"},
+		{Key: "alt-speed-time-begin", Label: "Schedule start (minutes after midnight)", Kind: "number"},
+		{Key: "alt-speed-time-end", Label: "Schedule end (minutes after midnight)", Kind: "number"},
+		{Key: "alt-speed-time-day", Label: "Schedule days (bitmask, Sunday = 1)", Kind: "number"},
+	}},
+	{Title: "Peers", Fields: []settingField{
+		{Key: "encryption", Label: "Encryption", Kind: "select", Options: []string{"required", "preferred", "tolerated"}},
+		{Key: "peer-limit-global", Label: "Global peer limit", Kind: "number"},
+		{Key: "peer-limit-per-torrent", Label: "Peers per torrent", Kind: "number"},
+		{Key: "peer-port", Label: "Peer port", Kind: "number"},
+		{Key: "peer-port-random-on-start", Label: "Random port on start", Kind: "bool"},
+		{Key: "port-forwarding-enabled", Label: "UPnP/NAT-PMP port forwarding", Kind: "bool"},
+		{Key: "dht-enabled", Label: "DHT", Kind: "bool"},
+		{Key: "pex-enabled", Label: "Peer exchange", Kind: "bool"},
+		{Key: "lpd-enabled", Label: "Local peer discovery", Kind: "bool"},
+		{Key: "utp-enabled", Label: "µTP", Kind: "bool"},
+		{Key: "blocklist-enabled", Label: "Blocklist", Kind: "bool"},
+		{Key: "blocklist-url", Label: "Blocklist URL", Kind: "text"},
+	}},
+	{Title: "Downloading", Fields: []settingField{
+		{Key: "download-dir", Label: "Download directory", Kind: "text"},
+		{Key: "incomplete-dir-enabled", Label: "Keep incomplete torrents elsewhere", Kind: "bool"},
+		{Key: "incomplete-dir", Label: "Incomplete directory", Kind: "text"},
+		{Key: "rename-partial-files", Label: "Append .part to incomplete files", Kind: "bool"},
+		{Key: "start-added-torrents", Label: "Start added torrents", Kind: "bool"},
+		{Key: "trash-original-torrent-files", Label: "Delete .torrent files after adding", Kind: "bool"},
+		{Key: "cache-size-mb", Label: "Disk cache (MB)", Kind: "number"},
+	}},
+	{Title: "Seeding", Fields: []settingField{
+		{Key: "seedRatioLimited", Label: "Stop at ratio", Kind: "bool"},
+		{Key: "seedRatioLimit", Label: "Ratio", Kind: "decimal"},
+		{Key: "idle-seeding-limit-enabled", Label: "Stop when idle", Kind: "bool"},
+		{Key: "idle-seeding-limit", Label: "Idle minutes", Kind: "number"},
+	}},
+	{Title: "Queue", Fields: []settingField{
+		{Key: "download-queue-enabled", Label: "Limit active downloads", Kind: "bool"},
+		{Key: "download-queue-size", Label: "Active downloads", Kind: "number"},
+		{Key: "seed-queue-enabled", Label: "Limit active seeds", Kind: "bool"},
+		{Key: "seed-queue-size", Label: "Active seeds", Kind: "number"},
+		{Key: "queue-stalled-enabled", Label: "Skip stalled torrents", Kind: "bool"},
+		{Key: "queue-stalled-minutes", Label: "Stalled after minutes", Kind: "number"},
+	}},
+}
+
+// settingsForm fills settingsLayout with the daemon's values
+func settingsForm(cfg *SessionConfig) ([]settingSection, error) {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, err
+	}
+	var sections []settingSection
+	for _, section := range settingsLayout {
+		filled := settingSection{Title: section.Title}
+		for _, f := range section.Fields {
+			if v, ok := values[f.Key]; ok {
+				f.Value = v
+				filled.Fields = append(filled.Fields, f)
+			}
+		}
+		if len(filled.Fields) > 0 {
+			sections = append(sections, filled)
+		}
+	}
+	return sections, nil
+}
+
+// handleSettingsPage renders the daemon configuration editor
+func (s *Server) handleSettingsPage(w http.ResponseWriter, r *http.Request) {
+	instance := r.URL.Query().Get("instance")
+	if instance == "" {
+		instance = DefaultInstance
+	}
+	client, err := s.clientFor(instance)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	cfg, err := client.GetSession()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sections, err := settingsForm(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The schedule, label caps and peak rules drive the default daemon only
+	var rules []AltSpeedRule
+	var caps []LabelCap
+	var peak []PeakRule
+	if instance == DefaultInstance {
+		if rules, err = s.altSpeed.Rules(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if caps, err = s.labelCaps.GetCaps(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if peak, err = s.peak.Rules(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	s.render(w, "settings.html", map[string]interface{}{
+		"AltRules":    rules,
+		"AltActive":   s.altSpeed.Active(),
+		"LabelCaps":   caps,
+		"LabelShares": s.labelCaps.Shares(),
+		"PeakRules":   peak,
+		"PeakActive":  s.peak.Active(),
+		"PeakPaused":  len(s.peak.Paused()),
+		"AddDefaults": s.addDefaults.All(),
+		"Weekdays":    weekdayNames,
+		"Config":      cfg,
+		"Revision":    sessionRevision(cfg),
+		"Sections":    sections,
+		"Instance":    instance,
+		"Instances":   s.instanceNames(),
+		"Version":     Version,
+	})
+}
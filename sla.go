@@ -0,0 +1,323 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slaCheckInterval is how often deadlines are recomputed from the SLAs
+const slaCheckInterval = time.Minute
+
+// CompletionSLA expects torrents carrying Label, or added by the feed
+// FeedID, to reach 100% within Hours of being added
+type CompletionSLA struct {
+	ID      int     `json:"id"`
+	Name    string  `json:"name"`
+	Enabled bool    `json:"enabled"`
+	Label   string  `json:"label,omitempty"`
+	FeedID  int     `json:"feedId,omitempty"`
+	Hours   float64 `json:"hours"`
+}
+
+// Validate checks the SLA names exactly one label or feed and a deadline
+func (c *CompletionSLA) Validate() error {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Label = strings.TrimSpace(c.Label)
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("name is required")
+	case (c.Label == "") == (c.FeedID == 0):
+		return fmt.Errorf("give either a label or a feedId")
+	case c.Hours <= 0:
+		return fmt.Errorf("hours must be positive")
+	}
+	return nil
+}
+
+// SLALate is how far a torrent is behind its SLA
+type SLALate struct {
+	SLA      string    `json:"sla"`
+	Deadline time.Time `json:"deadline"`
+	Behind   int64     `json:"behind"` // seconds past the deadline
+}
+
+// slaDeadline is when an incomplete torrent is due, under its strictest SLA
+type slaDeadline struct {
+	sla      string
+	hours    float64
+	deadline time.Time
+}
+
+// SLAMonitor works out when each incomplete torrent on the default daemon
+// is due and notifies once when one runs late. Deadlines are recomputed
+// once a minute; torrents are marked late against them whenever they're
+// listed.
+type SLAMonitor struct {
+	db     *sql.DB
+	feeds  *FeedManager
+	events *EventBus
+
+	mu        sync.Mutex
+	lastRun   time.Time
+	deadlines map[string]slaDeadline // by hash
+}
+
+// NewSLAMonitor creates the completion_slas table, and sla_alerts for the
+// torrents already notified about, so a restart doesn't repeat them
+func NewSLAMonitor(db *sql.DB, feeds *FeedManager, events *EventBus) (*SLAMonitor, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS completion_slas (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		enabled INTEGER NOT NULL DEFAULT 1,
+		label TEXT NOT NULL DEFAULT '',
+		feed_id INTEGER NOT NULL DEFAULT 0,
+		hours REAL NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create completion_slas table: %w", err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sla_alerts (
+		hash TEXT PRIMARY KEY,
+		alerted_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sla_alerts table: %w", err)
+	}
+	return &SLAMonitor{db: db, feeds: feeds, events: events, deadlines: map[string]slaDeadline{}}, nil
+}
+
+// GetSLAs returns all SLAs
+func (m *SLAMonitor) GetSLAs() ([]CompletionSLA, error) {
+	rows, err := m.db.Query("SELECT id, name, enabled, label, feed_id, hours FROM completion_slas ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	slas := []CompletionSLA{}
+	for rows.Next() {
+		var c CompletionSLA
+		if err := rows.Scan(&c.ID, &c.Name, &c.Enabled, &c.Label, &c.FeedID, &c.Hours); err != nil {
+			return nil, err
+		}
+		slas = append(slas, c)
+	}
+	return slas, rows.Err()
+}
+
+// AddSLA validates and stores a new SLA
+func (m *SLAMonitor) AddSLA(c *CompletionSLA) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	result, err := m.db.Exec("INSERT INTO completion_slas (name, enabled, label, feed_id, hours) VALUES (?, ?, ?, ?, ?)",
+		c.Name, c.Enabled, c.Label, c.FeedID, c.Hours)
+	if err != nil {
+		return err
+	}
+	id, _ := result.LastInsertId()
+	c.ID = int(id)
+	m.recheck()
+	return nil
+}
+
+// UpdateSLA validates and replaces an existing SLA
+func (m *SLAMonitor) UpdateSLA(c *CompletionSLA) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	_, err := m.db.Exec("UPDATE completion_slas SET name = ?, enabled = ?, label = ?, feed_id = ?, hours = ? WHERE id = ?",
+		c.Name, c.Enabled, c.Label, c.FeedID, c.Hours, c.ID)
+	if err != nil {
+		return err
+	}
+	m.recheck()
+	return nil
+}
+
+// DeleteSLA deletes an SLA
+func (m *SLAMonitor) DeleteSLA(id int) error {
+	if _, err := m.db.Exec("DELETE FROM completion_slas WHERE id = ?", id); err != nil {
+		return err
+	}
+	m.recheck()
+	return nil
+}
+
+// recheck makes the next poll recompute the deadlines
+func (m *SLAMonitor) recheck() {
+	m.mu.Lock()
+	m.lastRun = time.Time{}
+	m.mu.Unlock()
+}
+
+// OnSnapshot recomputes the deadlines, at most once a minute, and
+// notifies about torrents that have run late since the last check
+func (m *SLAMonitor) OnSnapshot(_, cur *Snapshot) {
+	m.mu.Lock()
+	if cur.Time.Sub(m.lastRun) < slaCheckInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastRun = cur.Time
+	m.mu.Unlock()
+
+	slas, err := m.GetSLAs()
+	if err != nil {
+		log.Printf("Failed to load completion SLAs: %v", err)
+		return
+	}
+	var feedOf map[string]int
+	if slices.ContainsFunc(slas, func(c CompletionSLA) bool { return c.Enabled && c.FeedID != 0 }) {
+		if feedOf, err = m.feeds.FeedHashes(); err != nil {
+			log.Printf("Failed to load feed torrents for SLAs: %v", err)
+			return
+		}
+	}
+
+	deadlines := make(map[string]slaDeadline)
+	for _, t := range cur.Torrents {
+		if t.PercentDone >= 1 || t.AddedDate == 0 {
+			continue
+		}
+		for _, c := range slas {
+			if !c.Enabled {
+				continue
+			}
+			if c.Label != "" && !slices.Contains(t.Labels, c.Label) {
+				continue
+			}
+			if c.FeedID != 0 && feedOf[t.HashString] != c.FeedID {
+				continue
+			}
+			due := time.Unix(t.AddedDate, 0).Add(time.Duration(c.Hours * float64(time.Hour)))
+			if d, ok := deadlines[t.HashString]; !ok || due.Before(d.deadline) {
+				deadlines[t.HashString] = slaDeadline{sla: c.Name, hours: c.Hours, deadline: due}
+			}
+		}
+	}
+	m.mu.Lock()
+	m.deadlines = deadlines
+	m.mu.Unlock()
+
+	m.alert(cur, deadlines)
+}
+
+// alert notifies about each late torrent not notified about yet, and
+// forgets the ones that finished, went away or are no longer late
+func (m *SLAMonitor) alert(cur *Snapshot, deadlines map[string]slaDeadline) {
+	alerted := map[string]bool{}
+	rows, err := m.db.Query("SELECT hash FROM sla_alerts")
+	if err != nil {
+		log.Printf("Failed to load SLA alerts: %v", err)
+		return
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err == nil {
+			alerted[hash] = true
+		}
+	}
+	rows.Close()
+
+	for _, t := range cur.Torrents {
+		d, ok := deadlines[t.HashString]
+		if !ok || !cur.Time.After(d.deadline) {
+			continue
+		}
+		if alerted[t.HashString] {
+			delete(alerted, t.HashString)
+			continue
+		}
+		if _, err := m.db.Exec("INSERT OR IGNORE INTO sla_alerts (hash, alerted_at) VALUES (?, ?)", t.HashString, cur.Time); err != nil {
+			log.Printf("Failed to record SLA alert: %v", err)
+			continue
+		}
+		m.events.Publish(Event{
+			Type:    EventTorrentLate,
+			Hash:    t.HashString,
+			Name:    t.Name,
+			Message: fmt.Sprintf("%s done, %q expected it within %gh", display.Percent(t.PercentDone), d.sla, d.hours),
+		})
+	}
+	for hash := range alerted {
+		if _, err := m.db.Exec("DELETE FROM sla_alerts WHERE hash = ?", hash); err != nil {
+			log.Printf("Failed to clear SLA alert: %v", err)
+		}
+	}
+}
+
+// Mark sets Late on the default daemon's torrents that are past their
+// deadline. A nil monitor marks nothing.
+func (m *SLAMonitor) Mark(torrents []Torrent, now time.Time) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.deadlines) == 0 {
+		return
+	}
+	for i := range torrents {
+		t := &torrents[i]
+		if t.Instance != "" || t.PercentDone >= 1 {
+			continue
+		}
+		if d, ok := m.deadlines[t.HashString]; ok && now.After(d.deadline) {
+			t.Late = &SLALate{SLA: d.sla, Deadline: d.deadline, Behind: int64(now.Sub(d.deadline).Seconds())}
+		}
+	}
+}
+
+func (s *Server) handleGetSLAs(w http.ResponseWriter, _ *http.Request) {
+	slas, err := s.sla.GetSLAs()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"slas": slas})
+}
+
+func (s *Server) handleAddSLA(w http.ResponseWriter, r *http.Request) {
+	var c CompletionSLA
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.sla.AddSLA(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, c)
+}
+
+func (s *Server) handleUpdateSLA(w http.ResponseWriter, r *http.Request) {
+	var c CompletionSLA
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := s.sla.UpdateSLA(&c); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleDeleteSLA(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.sla.DeleteSLA(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,60 @@
+// Swipe gestures for the dashboard's cards view (?view=cards): swipe a
+// torrent left to stop it and right to start it. A card follows the finger
+// and snaps back if the swipe is too short or turns into a scroll.
+(function () {
+    const list = document.getElementById('torrent-list');
+    if (!list || !list.classList.contains('cards-view')) return;
+
+    const threshold = 80; // px to count as a swipe
+    const STOPPED = '0';
+    let card = null, startX = 0, startY = 0, dx = 0, horizontal = null;
+
+    function reset() {
+        if (card) {
+            card.style.transform = '';
+            card.classList.remove('swipe-start', 'swipe-stop');
+        }
+        card = null;
+        horizontal = null;
+        dx = 0;
+    }
+
+    list.addEventListener('touchstart', e => {
+        const target = e.target.closest('.torrent-card');
+        // The aggregated view's cards have no actions, and buttons keep their taps
+        if (!target || target.dataset.id.includes(':') || e.target.closest('button, a') || e.touches.length !== 1) return;
+        card = target;
+        startX = e.touches[0].clientX;
+        startY = e.touches[0].clientY;
+    }, {passive: true});
+
+    list.addEventListener('touchmove', e => {
+        if (!card) return;
+        const x = e.touches[0].clientX - startX;
+        const y = e.touches[0].clientY - startY;
+        if (horizontal === null && (Math.abs(x) > 10 || Math.abs(y) > 10)) {
+            horizontal = Math.abs(x) > Math.abs(y);
+        }
+        if (!horizontal) {
+            if (horizontal === false) reset();
+            return;
+        }
+        dx = x;
+        card.style.transform = `translateX(${dx}px)`;
+        card.classList.toggle('swipe-start', dx > threshold && card.dataset.status === STOPPED);
+        card.classList.toggle('swipe-stop', dx < -threshold && card.dataset.status !== STOPPED);
+    }, {passive: true});
+
+    list.addEventListener('touchend', () => {
+        if (!card) return;
+        const id = parseInt(card.dataset.id, 10);
+        if (dx > threshold && card.dataset.status === STOPPED) {
+            torrentAction(id, 'start');
+        } else if (dx < -threshold && card.dataset.status !== STOPPED) {
+            torrentAction(id, 'stop');
+        }
+        reset();
+    });
+
+    list.addEventListener('touchcancel', reset);
+})();
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ndjsonFlushEvery is how many lines are buffered before flushing to the
+// client
+const ndjsonFlushEvery = 100
+
+// ndjsonWriter streams newline-delimited JSON, one value per line, so
+// clients can process huge lists before the whole response exists
+type ndjsonWriter struct {
+	enc     *json.Encoder
+	flusher http.Flusher
+	n       int
+}
+
+func newNDJSONWriter(w http.ResponseWriter) *ndjsonWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+	return &ndjsonWriter{enc: json.NewEncoder(w), flusher: flusher}
+}
+
+// Write sends one line
+func (nw *ndjsonWriter) Write(v interface{}) error {
+	if err := nw.enc.Encode(v); err != nil {
+		return err
+	}
+	nw.n++
+	if nw.n%ndjsonFlushEvery == 0 {
+		nw.Flush()
+	}
+	return nil
+}
+
+// Flush pushes buffered lines to the client
+func (nw *ndjsonWriter) Flush() {
+	if nw.flusher != nil {
+		nw.flusher.Flush()
+	}
+}
+
+// TorrentFile is one file of a torrent with its download state
+type TorrentFile struct {
+	TorrentID      int    `json:"torrentId"`
+	Index          int    `json:"index"`
+	Name           string `json:"name"`
+	Length         int64  `json:"length"`
+	BytesCompleted int64  `json:"bytesCompleted"`
+	Wanted         bool   `json:"wanted"`
+	Priority       int    `json:"priority"`
+}
+
+// torrentFiles is the torrent-get shape of the files and fileStats fields
+type torrentFiles struct {
+	ID    int `json:"id"`
+	Files []struct {
+		Name           string `json:"name"`
+		Length         int64  `json:"length"`
+		BytesCompleted int64  `json:"bytesCompleted"`
+	} `json:"files"`
+	FileStats []struct {
+		Wanted   bool `json:"wanted"`
+		Priority int  `json:"priority"`
+	} `json:"fileStats"`
+}
+
+// each calls fn for every file, stopping at the first error
+func (tf *torrentFiles) each(fn func(TorrentFile) error) error {
+	for i, f := range tf.Files {
+		file := TorrentFile{
+			TorrentID:      tf.ID,
+			Index:          i,
+			Name:           f.Name,
+			Length:         f.Length,
+			BytesCompleted: f.BytesCompleted,
+		}
+		if i < len(tf.FileStats) {
+			file.Wanted, file.Priority = tf.FileStats[i].Wanted, tf.FileStats[i].Priority
+		}
+		if err := fn(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachFile streams the files of the given torrents, or of every torrent
+// when ids is empty, to fn one at a time
+func (c *TransmissionClient) EachFile(ids []int, fn func(TorrentFile) error) error {
+	args := map[string]interface{}{
+		"fields": []string{"id", "files", "fileStats"},
+	}
+	if len(ids) > 0 {
+		args["ids"] = ids
+	}
+	return c.streamTorrentGet(args, func(dec *json.Decoder) error {
+		var tf torrentFiles
+		if err := dec.Decode(&tf); err != nil {
+			return err
+		}
+		return tf.each(fn)
+	})
+}
+
+// handleFilesStream streams the file index as NDJSON, one file per line,
+// for every torrent or just ?id=. Files are passed through as they're
+// decoded from Transmission's response.
+func (s *Server) handleFilesStream(w http.ResponseWriter, r *http.Request) {
+	var ids []int
+	if v := r.URL.Query().Get("id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, "invalid id")
+			return
+		}
+		ids = []int{id}
+	}
+
+	var nw *ndjsonWriter
+	err := s.client.EachFile(ids, func(f TorrentFile) error {
+		if err := r.Context().Err(); err != nil {
+			return err
+		}
+		if nw == nil {
+			nw = newNDJSONWriter(w)
+		}
+		return nw.Write(f)
+	})
+	switch {
+	case err != nil && nw == nil:
+		writeJSONError(w, err.Error())
+	case err != nil:
+		if r.Context().Err() == nil {
+			log.Printf("Failed to stream files: %v", err)
+		}
+	case nw == nil:
+		newNDJSONWriter(w) // no files: an empty stream
+	default:
+		nw.Flush()
+	}
+}
+
+// handlePeersStream streams the peers of every torrent as NDJSON, one peer
+// per line tagged with its torrentId
+func (s *Server) handlePeersStream(w http.ResponseWriter, r *http.Request) {
+	all, err := s.client.GetAllPeers()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+
+	nw := newNDJSONWriter(w)
+	for _, t := range all.Torrents {
+		for _, p := range t.Peers {
+			if err := r.Context().Err(); err != nil {
+				return
+			}
+			line := struct {
+				TorrentID int `json:"torrentId"`
+				Peer
+			}{t.ID, p}
+			if err := nw.Write(line); err != nil {
+				log.Printf("Failed to stream peers: %v", err)
+				return
+			}
+		}
+	}
+	nw.Flush()
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// supportLogLines is how many recent log entries a support bundle carries
+const supportLogLines = 2000
+
+// sensitiveKeyParts mark settings (and JSON fields) whose values are never
+// put in a support bundle
+var sensitiveKeyParts = []string{"PASS", "TOKEN", "SECRET", "KEY", "WEBHOOK", "COOKIE", "AUTH"}
+
+// secretParamPattern finds credentials in URLs written out in log lines:
+// tracker passkeys, API keys and userinfo
+var secretParamPattern = regexp.MustCompile(`(?i)((?:passkey|apikey|api_key|token|auth|key|password|pass|secret)=)[^&\s"'\\]+|(://)[^/\s@:]+:[^/\s@]+@`)
+
+// announcePattern finds tracker announce URLs in log lines; private
+// trackers often put the passkey in the path
+var announcePattern = regexp.MustCompile(`(?i)\b((?:https?|udp)://[^\s/"'<>]+)/[^\s"'<>]*announce[^\s"'<>]*`)
+
+const redacted = "[redacted]"
+
+func sensitiveKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactText strips URL credentials from free text
+func redactText(s string) string {
+	return secretParamPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if strings.HasPrefix(m, "://") {
+			return "://" + redacted + "@"
+		}
+		return m[:strings.Index(m, "=")+1] + redacted
+	})
+}
+
+// redactValue sanitizes a setting's value: URLs lose their userinfo and
+// query values, and JSON settings such as TRANSMISSION_INSTANCES lose their
+// sensitive fields
+func redactValue(val string) string {
+	if strings.HasPrefix(val, "[") || strings.HasPrefix(val, "{") {
+		var doc interface{}
+		if json.Unmarshal([]byte(val), &doc) == nil {
+			data, err := json.Marshal(redactJSON(doc))
+			if err == nil {
+				return string(data)
+			}
+		}
+	}
+	if u, err := url.Parse(val); err == nil && u.Scheme != "" && u.Host != "" {
+		hadUser := u.User != nil
+		u.User = nil
+		var params []string
+		for k := range u.Query() {
+			params = append(params, url.QueryEscape(k)+"="+redacted)
+		}
+		sort.Strings(params)
+		u.RawQuery = strings.Join(params, "&")
+		if hadUser {
+			return strings.Replace(u.String(), "://", "://"+redacted+"@", 1)
+		}
+		return u.String()
+	}
+	return redactText(val)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if s, ok := child.(string); ok && s != "" && sensitiveKey(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactJSON(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactJSON(child)
+		}
+	case string:
+		return redactValue(v)
+	}
+	return v
+}
+
+// supportSetting is one setting in use, without anything secret
+type supportSetting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"` // "env" or "file"
+}
+
+// supportSettings lists the settings read since startup that have a value.
+// Secret ones are shown only as set, and any secret value is also returned
+// so it can be scrubbed from the rest of the bundle.
+func supportSettings() (map[string]supportSetting, []string) {
+	settings := map[string]supportSetting{}
+	var secrets []string
+	settingsRead.Range(func(k, _ interface{}) bool {
+		key := k.(string)
+		val := lookupEnv(key)
+		if val == "" {
+			return true
+		}
+		setting := supportSetting{Value: redactValue(val), Source: "file"}
+		if os.Getenv(key) != "" {
+			setting.Source = "env"
+		}
+		if sensitiveKey(key) {
+			setting.Value = redacted
+			secrets = append(secrets, val)
+		}
+		settings[key] = setting
+		return true
+	})
+	return settings, secrets
+}
+
+// supportHealth gathers what the status endpoints report
+func (s *Server) supportHealth() map[string]interface{} {
+	health := map[string]interface{}{
+		"generatedAt":      time.Now().UTC(),
+		"disabledFeatures": append([]string{}, features.Disabled()...),
+		"readOnly":         s.readOnly.Status(),
+	}
+
+	daemons := map[string]interface{}{}
+	since := time.Now().Add(-24 * time.Hour)
+	for _, name := range s.instanceNames() {
+		daemon := map[string]interface{}{"session": s.clientSession(name)}
+		if c, err := s.clientFor(name); err == nil {
+			if _, err := c.GetSession(); err != nil {
+				daemon["error"] = redactText(err.Error())
+			}
+		}
+		if s.rpcHealth != nil {
+			if minutes, err := s.rpcHealth.Minutes(name, since); err == nil {
+				daemon["rpc24h"] = s.rpcHealth.Summarize(minutes)
+			}
+		}
+		daemons[name] = daemon
+	}
+	health["daemons"] = daemons
+
+	if s.poller != nil {
+		health["poller"] = map[string]interface{}{
+			"interval": s.poller.Interval().String(),
+			"watched":  s.poller.watched(),
+		}
+	}
+	if s.presence != nil {
+		health["presence"] = s.presence.Status()
+	}
+	if s.ports != nil {
+		if checked := s.ports.LastChecked(); !checked.IsZero() {
+			health["portCheckedAt"] = checked
+		}
+	}
+	if s.egress != nil {
+		health["egress"] = s.egress.Status()
+	}
+	if s.trackerCheck != nil {
+		checkedAt, report := s.trackerCheck.Report()
+		trackers := make([]TrackerReport, len(report))
+		for i, r := range report {
+			r.Announce, r.LastError = announceOrigin(r.Announce), redactText(r.LastError)
+			trackers[i] = r
+		}
+		health["trackers"] = map[string]interface{}{"checkedAt": checkedAt, "trackers": trackers}
+	}
+	if s.irc != nil {
+		health["irc"] = s.irc.Status()
+	}
+	if configFile != nil {
+		health["configFile"] = configFile.Status()
+	}
+	return health
+}
+
+// announceOrigin reduces an announce URL to its scheme and host, since the
+// path can hold a private tracker's passkey
+func announceOrigin(announce string) string {
+	if u, err := url.Parse(announce); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return redacted
+}
+
+// supportSchema returns the database's CREATE statements and the row count
+// of each table
+func (s *Server) supportSchema() (string, map[string]interface{}, error) {
+	db := s.feedManager.db
+	rows, err := db.Query("SELECT type, name, sql FROM sqlite_master WHERE sql IS NOT NULL ORDER BY type DESC, name")
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+	var schema strings.Builder
+	var tables []string
+	for rows.Next() {
+		var kind, name, stmt string
+		if err := rows.Scan(&kind, &name, &stmt); err != nil {
+			return "", nil, err
+		}
+		if kind == "table" && !strings.HasPrefix(name, "sqlite_") {
+			tables = append(tables, name)
+		}
+		fmt.Fprintf(&schema, "%s;\n\n", stmt)
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, table := range tables {
+		var n int64
+		if err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %q", table)).Scan(&n); err == nil {
+			counts[table] = n
+		}
+	}
+	info := map[string]interface{}{"rowCounts": counts}
+	var version string
+	if db.QueryRow("SELECT sqlite_version()").Scan(&version) == nil {
+		info["sqliteVersion"] = version
+	}
+	if fi, err := os.Stat(getEnv("DB_PATH", "./feeds.db")); err == nil {
+		info["sizeBytes"] = fi.Size()
+	}
+	return schema.String(), info, nil
+}
+
+// supportVersions reports the app, Go and daemon versions
+func (s *Server) supportVersions() map[string]interface{} {
+	versions := map[string]interface{}{
+		"app":  Version,
+		"go":   runtime.Version(),
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+	daemons := map[string]interface{}{}
+	for _, name := range s.instanceNames() {
+		c, err := s.clientFor(name)
+		if err != nil {
+			continue
+		}
+		if session, err := c.GetSession(); err == nil {
+			daemons[name] = map[string]interface{}{"version": session.Version, "rpcVersion": session.RPCVersion}
+		}
+	}
+	versions["daemons"] = daemons
+	return versions
+}
+
+// writeSupportBundle writes the ZIP: versions, sanitized settings, recent
+// logs, a health report and the database schema
+func (s *Server) writeSupportBundle(w *bytes.Buffer) error {
+	settings, secrets := supportSettings()
+	scrub := func(text string) string {
+		for _, secret := range secrets {
+			if len(secret) >= 4 {
+				text = strings.ReplaceAll(text, secret, redacted)
+			}
+		}
+		return redactText(announcePattern.ReplaceAllString(text, "$1"))
+	}
+
+	var logs strings.Builder
+	entries, err := s.logEntries(LogFilter{Limit: supportLogLines})
+	for _, e := range entries {
+		fmt.Fprintln(&logs, scrub(e.String()))
+	}
+	if err != nil {
+		fmt.Fprintf(&logs, "# daemon log unavailable: %v\n", err)
+	}
+	schema, tables, err := s.supportSchema()
+	if err != nil {
+		schema = "-- failed to read the schema: " + err.Error() + "\n"
+	}
+
+	files := []struct {
+		name string
+		body interface{}
+	}{
+		{"versions.json", s.supportVersions()},
+		{"settings.json", settings},
+		{"health.json", s.supportHealth()},
+		{"tables.json", tables},
+		{"logs.txt", logs.String()},
+		{"schema.sql", schema},
+	}
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if text, ok := file.body.(string); ok {
+			if _, err := f.Write([]byte(text)); err != nil {
+				return err
+			}
+			continue
+		}
+		var data bytes.Buffer
+		enc := json.NewEncoder(&data)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.body); err != nil {
+			return err
+		}
+		if _, err := f.Write([]byte(scrub(data.String()))); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// handleSupportBundle serves a ZIP to attach to bug reports. Passwords,
+// tokens and API keys are left out, and URL credentials are stripped from
+// the settings and logs.
+func (s *Server) handleSupportBundle(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	if err := s.writeSupportBundle(&buf); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	name := "transmission-web-support-" + time.Now().Format("20060102-150405")
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	w.Write(buf.Bytes())
+}
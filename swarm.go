@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSwarmInterval = 5 * time.Minute
+	defaultSwarmWindow   = 6 * time.Hour
+	defaultSwarmSlowKBps = 50
+	// swarmMinSamples is how many samples a torrent needs, spanning at
+	// least half the window, before it's classified
+	swarmMinSamples = 3
+)
+
+// Swarm classes
+const (
+	SwarmHealthy = "healthy"
+	SwarmSlow    = "slow" // averaging under SWARM_SLOW_KBPS
+	SwarmDead    = "dead" // nothing received and trackers reporting no seeders all window
+)
+
+// SwarmHealth is what the samples say about a downloading torrent's swarm
+type SwarmHealth struct {
+	Class   string    `json:"class"`
+	Since   time.Time `json:"since"`   // when it entered Class
+	Rate    int64     `json:"rate"`    // average download rate over the window, bytes/s
+	Peers   int       `json:"peers"`   // most peers connected at a sample
+	Seeders int       `json:"seeders"` // most seeders a tracker reported; -1 unknown
+}
+
+// Days is how long the torrent has been in its class
+func (h *SwarmHealth) Days(now time.Time) float64 {
+	return now.Sub(h.Since).Hours() / 24
+}
+
+// swarmSample is a torrent's swarm at one sample
+type swarmSample struct {
+	time    time.Time
+	rate    int64
+	peers   int
+	seeders int
+}
+
+// SwarmMonitor samples the default daemon's downloading torrents every
+// SWARM_SAMPLE_INTERVAL and classes each swarm over the last SWARM_WINDOW:
+// dead when nothing arrived and no tracker reported a seeder, slow when it
+// averaged under SWARM_SLOW_KBPS, healthy otherwise. A stalled download
+// is otherwise only an ETA of "Unknown", whether the swarm is gone or
+// just thin. Classes and when they began are kept in SQLite, so
+// automation rules can act on a torrent dead for days; samples aren't.
+type SwarmMonitor struct {
+	db       *sql.DB
+	client   *TransmissionClient
+	interval time.Duration
+	window   time.Duration
+	slow     int64 // bytes/s
+
+	mu      sync.Mutex
+	lastRun time.Time
+	samples map[string][]swarmSample // by hash, oldest first
+	health  map[string]*SwarmHealth  // by hash
+}
+
+// NewSwarmMonitor creates the swarm_health table
+func NewSwarmMonitor(db *sql.DB, client *TransmissionClient, interval, window time.Duration, slowKBps int) (*SwarmMonitor, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS swarm_health (
+		hash TEXT PRIMARY KEY,
+		class TEXT NOT NULL,
+		since DATETIME NOT NULL,
+		rate INTEGER NOT NULL,
+		peers INTEGER NOT NULL,
+		seeders INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create swarm_health table: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultSwarmInterval
+	}
+	if window <= 0 {
+		window = defaultSwarmWindow
+	}
+	if slowKBps <= 0 {
+		slowKBps = defaultSwarmSlowKBps
+	}
+	sm := &SwarmMonitor{
+		db: db, client: client, interval: interval, window: window, slow: int64(slowKBps) * 1024,
+		samples: map[string][]swarmSample{}, health: map[string]*SwarmHealth{},
+	}
+
+	rows, err := db.Query("SELECT hash, class, since, rate, peers, seeders FROM swarm_health")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var h SwarmHealth
+		if err := rows.Scan(&hash, &h.Class, &h.Since, &h.Rate, &h.Peers, &h.Seeders); err != nil {
+			return nil, err
+		}
+		sm.health[hash] = &h
+	}
+	return sm, rows.Err()
+}
+
+// Health returns a torrent's swarm class, or nil before it has one
+func (sm *SwarmMonitor) Health(hash string) *SwarmHealth {
+	if sm == nil {
+		return nil
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if h, ok := sm.health[hash]; ok {
+		c := *h
+		return &c
+	}
+	return nil
+}
+
+// Mark sets Swarm on the default daemon's incomplete torrents
+func (sm *SwarmMonitor) Mark(torrents []Torrent) {
+	if sm == nil {
+		return
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if len(sm.health) == 0 {
+		return
+	}
+	for i := range torrents {
+		t := &torrents[i]
+		if t.Instance != "" || t.PercentDone >= 1 {
+			continue
+		}
+		if h, ok := sm.health[t.HashString]; ok {
+			c := *h
+			t.Swarm = &c
+		}
+	}
+}
+
+// classify reads a class from a torrent's samples, or "" while there are
+// too few of them
+func (sm *SwarmMonitor) classify(samples []swarmSample) (string, int64, int, int) {
+	if len(samples) < swarmMinSamples || samples[len(samples)-1].time.Sub(samples[0].time) < sm.window/2 {
+		return "", 0, 0, 0
+	}
+	var total int64
+	peers, seeders := 0, -1
+	received := false
+	for _, s := range samples {
+		total += s.rate
+		peers = max(peers, s.peers)
+		seeders = max(seeders, s.seeders)
+		received = received || s.rate > 0
+	}
+	rate := total / int64(len(samples))
+	switch {
+	// Dead needs a tracker to have said there are no seeders; with no
+	// count (-1) a torrent receiving nothing is only slow
+	case !received && seeders == 0:
+		return SwarmDead, rate, peers, seeders
+	case rate < sm.slow:
+		return SwarmSlow, rate, peers, seeders
+	}
+	return SwarmHealthy, rate, peers, seeders
+}
+
+// OnSnapshot samples the downloading torrents, at most once per interval.
+// Stopped and queued torrents keep their class without being sampled;
+// finished and removed ones lose it.
+func (sm *SwarmMonitor) OnSnapshot(_, cur *Snapshot) {
+	sm.mu.Lock()
+	if cur.Time.Sub(sm.lastRun) < sm.interval {
+		sm.mu.Unlock()
+		return
+	}
+	sm.lastRun = cur.Time
+	sm.mu.Unlock()
+
+	var downloading []*Torrent
+	for i := range cur.Torrents {
+		if t := &cur.Torrents[i]; t.Status == 4 && t.PercentDone < 1 {
+			downloading = append(downloading, t)
+		}
+	}
+	var trackers map[int][]TrackerStats
+	if len(downloading) > 0 {
+		var err error
+		if trackers, err = sm.client.GetAllTrackerStats(); err != nil {
+			log.Printf("Failed to read tracker stats for swarm sampling: %v", err)
+		}
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	since := cur.Time.Add(-sm.window)
+	for _, t := range downloading {
+		seeders := -1
+		for _, ts := range trackers[t.ID] {
+			seeders = max(seeders, ts.SeederCount)
+		}
+		samples := append(sm.samples[t.HashString], swarmSample{time: cur.Time, rate: t.RateDownload, peers: t.PeersConnected, seeders: seeders})
+		for len(samples) > 0 && samples[0].time.Before(since) {
+			samples = samples[1:]
+		}
+		sm.samples[t.HashString] = samples
+
+		class, rate, peers, seeders := sm.classify(samples)
+		if class == "" {
+			continue
+		}
+		h, ok := sm.health[t.HashString]
+		if !ok || h.Class != class {
+			if ok {
+				log.Printf("Swarm of %s is now %s (was %s)", t.Name, class, h.Class)
+			}
+			h = &SwarmHealth{Class: class, Since: cur.Time}
+			sm.health[t.HashString] = h
+		}
+		h.Rate, h.Peers, h.Seeders = rate, peers, seeders
+		_, err := sm.db.Exec("INSERT OR REPLACE INTO swarm_health (hash, class, since, rate, peers, seeders) VALUES (?, ?, ?, ?, ?, ?)",
+			t.HashString, h.Class, h.Since.UTC(), h.Rate, h.Peers, h.Seeders)
+		if err != nil {
+			log.Printf("Failed to record swarm health: %v", err)
+		}
+	}
+
+	incomplete := make(map[string]bool, len(cur.Torrents))
+	for i := range cur.Torrents {
+		if t := &cur.Torrents[i]; t.PercentDone < 1 {
+			incomplete[t.HashString] = true
+		}
+	}
+	for hash := range sm.samples {
+		if !incomplete[hash] {
+			delete(sm.samples, hash)
+		}
+	}
+	for hash := range sm.health {
+		if incomplete[hash] {
+			continue
+		}
+		delete(sm.health, hash)
+		if _, err := sm.db.Exec("DELETE FROM swarm_health WHERE hash = ?", hash); err != nil {
+			log.Printf("Failed to forget swarm health: %v", err)
+		}
+	}
+}
+
+// handleSwarmHealth serves GET /api/swarm: the classed torrents' swarm
+// health by hash
+func (s *Server) handleSwarmHealth(w http.ResponseWriter, _ *http.Request) {
+	s.swarm.mu.Lock()
+	health := make(map[string]SwarmHealth, len(s.swarm.health))
+	for hash, h := range s.swarm.health {
+		health[hash] = *h
+	}
+	s.swarm.mu.Unlock()
+	writeJSON(w, map[string]interface{}{
+		"window":  s.swarm.window.String(),
+		"slowBps": s.swarm.slow,
+		"health":  health,
+	})
+}
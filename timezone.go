@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// appLocation is the timezone for day, week and billing boundaries,
+// schedules and displayed timestamps. It's set from TIMEZONE at startup and
+// defaults to the server's local time.
+var appLocation = time.Local
+
+// loadTimezone resolves an IANA timezone name such as "Europe/London"; an
+// empty name keeps the server's local time
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIMEZONE %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// localTime converts t to the configured timezone
+func localTime(t time.Time) time.Time {
+	return t.In(appLocation)
+}
+
+// timezoneName is the configured zone's IANA name for the browser's Intl
+// APIs, or "" when unset so pages keep the browser's own timezone
+func timezoneName() string {
+	if appLocation == time.Local {
+		return ""
+	}
+	return appLocation.String()
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// torznabTimeout bounds each indexer's query, so a slow one only drops its
+// own results
+const torznabTimeout = 20 * time.Second
+
+// maxResultLinks caps how many result links are kept for one-click adds
+const maxResultLinks = 5000
+
+// IndexerResult is a search result from a Torznab indexer. Its download
+// link can carry the indexer's API key, so it stays server-side and the
+// result is added by Token.
+type IndexerResult struct {
+	SearchResult
+	Indexer   string `json:"indexer"`
+	IndexerID int    `json:"indexerId"`
+	Token     string `json:"token"`
+	link      string
+}
+
+// IndexerError is an indexer whose query failed during a search
+type IndexerError struct {
+	Indexer string `json:"indexer"`
+	Error   string `json:"error"`
+}
+
+// TorznabSearch queries every enabled indexer (Jackett, Prowlarr or any
+// other Torznab endpoint) and merges what they return
+type TorznabSearch struct {
+	indexers   *IndexerStore
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	links map[string]string // result token -> download link
+}
+
+// NewTorznabSearch creates a search over the indexers in store
+func NewTorznabSearch(store *IndexerStore) *TorznabSearch {
+	return &TorznabSearch{
+		indexers:   store,
+		httpClient: &http.Client{Timeout: torznabTimeout},
+		links:      map[string]string{},
+	}
+}
+
+// Search queries the enabled indexers in parallel and returns up to limit
+// results, most seeded first, along with the indexers that failed. A
+// torrent found by several indexers is listed once.
+func (t *TorznabSearch) Search(ctx context.Context, query string, limit int) ([]IndexerResult, []IndexerError, error) {
+	all, err := t.indexers.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	var enabled []Indexer
+	for _, ix := range all {
+		if ix.Enabled {
+			enabled = append(enabled, ix)
+		}
+	}
+	if len(enabled) == 0 {
+		return nil, nil, fmt.Errorf("no indexers are enabled")
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results []IndexerResult
+		failed  = []IndexerError{}
+	)
+	for i := range enabled {
+		wg.Add(1)
+		go func(ix *Indexer) {
+			defer wg.Done()
+			found, err := t.queryIndexer(ctx, ix, query, limit)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Indexer %s search failed: %v", ix.Name, err)
+				failed = append(failed, IndexerError{Indexer: ix.Name, Error: err.Error()})
+				return
+			}
+			results = append(results, found...)
+		}(&enabled[i])
+	}
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Seeders > results[j].Seeders })
+	merged := []IndexerResult{}
+	seen := map[string]bool{}
+	for _, r := range results {
+		if r.InfoHash != "" {
+			hash := strings.ToLower(r.InfoHash)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+		}
+		merged = append(merged, r)
+		if len(merged) == limit {
+			break
+		}
+	}
+	t.remember(merged)
+	return merged, failed, nil
+}
+
+// queryIndexer runs a t=search query against one indexer
+func (t *TorznabSearch) queryIndexer(ctx context.Context, ix *Indexer, query string, limit int) ([]IndexerResult, error) {
+	u, err := url.Parse(strings.TrimRight(ix.BaseURL, "/") + ix.APIPath)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("t", "search")
+	q.Set("q", query)
+	q.Set("limit", strconv.Itoa(limit))
+	if ix.APIKey != "" {
+		q.Set("apikey", ix.APIKey)
+	}
+	if len(ix.Categories) > 0 {
+		cats := make([]string, len(ix.Categories))
+		for i, c := range ix.Categories {
+			cats[i] = strconv.Itoa(c)
+		}
+		q.Set("cat", strings.Join(cats, ","))
+	}
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := context.WithTimeout(ctx, torznabTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		// url.Error quotes the URL, API key and all
+		if uerr, ok := err.(*url.Error); ok {
+			err = uerr.Err
+		}
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("indexer returned HTTP %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	// Torznab reports a bad key or query as <error code="..." description="..."/>
+	var torznabErr struct {
+		XMLName     xml.Name
+		Code        string `xml:"code,attr"`
+		Description string `xml:"description,attr"`
+	}
+	if xml.Unmarshal(body, &torznabErr) == nil && torznabErr.XMLName.Local == "error" {
+		return nil, fmt.Errorf("indexer error %s: %s", torznabErr.Code, torznabErr.Description)
+	}
+	feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Torznab response: %w", err)
+	}
+
+	results := make([]IndexerResult, 0, len(feed.Items))
+	for _, item := range feed.Items {
+		if r, ok := torznabResult(ix, item); ok {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// torznabResult reads a result from a Torznab item and its torznab:attr
+// elements; items without a download link are dropped
+func torznabResult(ix *Indexer, item *gofeed.Item) (IndexerResult, bool) {
+	attrs := map[string]string{}
+	for _, ext := range item.Extensions["torznab"]["attr"] {
+		attrs[ext.Attrs["name"]] = ext.Attrs["value"]
+	}
+	r := IndexerResult{
+		SearchResult: SearchResult{
+			Name:     item.Title,
+			InfoHash: strings.ToLower(attrs["infohash"]),
+			Size:     itemSize(item),
+		},
+		Indexer:   ix.Name,
+		IndexerID: ix.ID,
+	}
+	r.Seeders, _ = strconv.Atoi(attrs["seeders"])
+	// Torznab's peers counts the seeders too
+	if peers, err := strconv.Atoi(attrs["peers"]); err == nil {
+		r.Leechers = max(peers-r.Seeders, 0)
+	}
+	if item.PublishedParsed != nil {
+		r.PublishedAt = *item.PublishedParsed
+	}
+
+	r.link = attrs["magneturl"]
+	for _, enc := range item.Enclosures {
+		if r.link == "" && enc.URL != "" {
+			r.link = enc.URL
+		}
+	}
+	if r.link == "" {
+		r.link = item.Link
+	}
+	if r.link == "" {
+		return r, false
+	}
+	if r.InfoHash == "" {
+		r.InfoHash = magnetInfoHash(r.link)
+	}
+	sum := sha1.Sum([]byte(ix.Name + "\n" + r.link))
+	r.Token = hex.EncodeToString(sum[:12])
+	return r, true
+}
+
+// remember keeps the results' links so they can be added by token
+func (t *TorznabSearch) remember(results []IndexerResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.links)+len(results) > maxResultLinks {
+		t.links = map[string]string{}
+	}
+	for _, r := range results {
+		t.links[r.Token] = r.link
+	}
+}
+
+// Link returns the download link of a result from a recent search
+func (t *TorznabSearch) Link(token string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	link, ok := t.links[token]
+	return link, ok
+}
+
+// handleIndexerSearch serves GET /api/indexers/search?q=&limit=
+func (s *Server) handleIndexerSearch(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSONError(w, "missing q parameter")
+		return
+	}
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+	results, failed, err := s.torznab.Search(r.Context(), query, limit)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{"results": results, "errors": failed})
+}
+
+// handleAddIndexer serves POST /api/indexers/add for a hand-configured
+// Jackett or Torznab endpoint
+func (s *Server) handleAddIndexer(w http.ResponseWriter, r *http.Request) {
+	var ix Indexer
+	if err := json.NewDecoder(r.Body).Decode(&ix); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	ix.ID = 0
+	ix.Source = IndexerSourceManual
+	if ix.Categories == nil {
+		ix.Categories = []int{}
+	}
+	if err := s.indexers.Add(&ix); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	log.Printf("Added indexer %s", ix.Name)
+	ix.APIKey = ""
+	writeJSON(w, ix)
+}
+
+// handleDeleteIndexer serves POST /api/indexers/delete?id=
+func (s *Server) handleDeleteIndexer(w http.ResponseWriter, r *http.Request) {
+	id, ok := queryID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.indexers.Delete(id); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	log.Printf("Deleted indexer %d", id)
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+// handleSearchPage serves /search; with ?q= it searches the indexers and
+// lists the results
+func (s *Server) handleSearchPage(w http.ResponseWriter, r *http.Request) {
+	indexers, err := s.indexers.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for i := range indexers {
+		indexers[i].APIKey = ""
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	data := map[string]interface{}{
+		"Indexers": indexers,
+		"Query":    query,
+		"Version":  Version,
+	}
+	if query != "" {
+		results, failed, err := s.torznab.Search(r.Context(), query, 100)
+		data["Results"], data["Failed"], data["Error"] = results, failed, err
+	}
+	s.render(w, "search.html", data)
+}
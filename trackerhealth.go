@@ -0,0 +1,429 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTrackerCheckInterval = time.Hour
+	defaultTrackerDeadAfter     = 7 * 24 * time.Hour
+)
+
+// TrackerHealth re-tests trackers across the library on a schedule and
+// flags the ones that keep failing. A tracker is failing while none of the
+// torrents using it had a successful last announce; one success anywhere
+// clears it. Once it's been failing for deadAfter it's dead, and can be
+// removed from the public torrents using it: never from private torrents,
+// whose tracker is the point, and never a torrent's last tracker. With
+// autoRemove that happens on the check after a tracker was first reported
+// dead, so the log always has the report before anything changes. Only the
+// default instance is checked.
+type TrackerHealth struct {
+	db         *sql.DB
+	client     *TransmissionClient
+	poller     *Poller
+	interval   time.Duration
+	deadAfter  time.Duration
+	autoRemove bool
+
+	checking  sync.Mutex // one check at a time
+	mu        sync.Mutex
+	checkedAt time.Time
+	report    []TrackerReport
+	trackers  map[int]int // tracker count by torrent ID at the last check
+}
+
+// TrackerReport is one failing tracker and the torrents using it
+type TrackerReport struct {
+	Announce     string                 `json:"announce"`
+	Host         string                 `json:"host"`
+	FailingSince time.Time              `json:"failingSince"`
+	LastError    string                 `json:"lastError"`
+	Dead         bool                   `json:"dead"`
+	Torrents     []TrackerReportTorrent `json:"torrents"`
+	Removable    int                    `json:"removable"`
+}
+
+// TrackerReportTorrent is a torrent using a failing tracker
+type TrackerReportTorrent struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	TrackerID int    `json:"trackerId"`
+	Private   bool   `json:"private"`
+	Removable bool   `json:"removable"`
+	Reason    string `json:"reason,omitempty"` // why it isn't removable
+}
+
+// TrackerRemoval is a tracker removed (or, in a dry run, to be removed)
+// from a torrent
+type TrackerRemoval struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	Announce string `json:"announce"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewTrackerHealth creates the tracker_health table, which remembers since
+// when each failing tracker has been failing across restarts
+func NewTrackerHealth(db *sql.DB, client *TransmissionClient, poller *Poller, interval, deadAfter time.Duration, autoRemove bool) (*TrackerHealth, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tracker_health (
+		announce TEXT PRIMARY KEY,
+		failing_since INTEGER NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		reported_at INTEGER NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultTrackerCheckInterval
+	}
+	if deadAfter <= 0 {
+		deadAfter = defaultTrackerDeadAfter
+	}
+	return &TrackerHealth{
+		db:         db,
+		client:     client,
+		poller:     poller,
+		interval:   interval,
+		deadAfter:  deadAfter,
+		autoRemove: autoRemove,
+	}, nil
+}
+
+// Start checks now and then every interval
+func (th *TrackerHealth) Start() {
+	go func() {
+		ticker := time.NewTicker(th.interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			if err := th.Check(); err != nil {
+				log.Printf("Tracker health check failed: %v", err)
+			}
+		}
+	}()
+}
+
+// trackerUse collects one tracker's results across the library
+type trackerUse struct {
+	ok, failed bool
+	lastError  string
+	torrents   []TrackerReportTorrent
+	retest     int // a torrent to reannounce so the tracker is tried again
+}
+
+// Check reads every torrent's tracker stats, updates since when each
+// tracker has been failing and rebuilds the report
+func (th *TrackerHealth) Check() error {
+	th.checking.Lock()
+	defer th.checking.Unlock()
+
+	var torrents []Torrent
+	if snap := th.poller.Latest(); snap != nil {
+		torrents = snap.Torrents
+	} else {
+		var err error
+		if torrents, err = th.client.GetTorrents(); err != nil {
+			return err
+		}
+	}
+	stats, err := th.client.GetAllTrackerStats()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	uses := map[string]*trackerUse{}
+	trackers := make(map[int]int, len(torrents))
+	for _, t := range torrents {
+		list := stats[t.ID]
+		trackers[t.ID] = len(list)
+		for _, ts := range list {
+			u := uses[ts.Announce]
+			if u == nil {
+				u = &trackerUse{}
+				uses[ts.Announce] = u
+			}
+			entry := TrackerReportTorrent{ID: t.ID, Name: t.Name, TrackerID: ts.ID, Private: t.IsPrivate}
+			switch {
+			case t.IsPrivate:
+				entry.Reason = "private torrent"
+			case len(list) < 2:
+				entry.Reason = "only tracker"
+			default:
+				entry.Removable = true
+			}
+			u.torrents = append(u.torrents, entry)
+
+			switch {
+			case ts.LastAnnounceSucceeded:
+				u.ok = true
+			case ts.HasAnnounced:
+				u.failed, u.lastError = true, ts.LastAnnounceResult
+				// Transmission backs off from failing trackers; make sure
+				// each one is tried again at least once per check
+				if u.retest == 0 && time.Unix(ts.NextAnnounceTime, 0).After(now.Add(th.interval)) {
+					u.retest = t.ID
+				}
+			}
+		}
+	}
+
+	failing, err := th.load()
+	if err != nil {
+		return err
+	}
+	for announce := range failing {
+		if u := uses[announce]; u == nil || u.ok {
+			if _, err := th.db.Exec("DELETE FROM tracker_health WHERE announce = ?", announce); err != nil {
+				return err
+			}
+			delete(failing, announce)
+		}
+	}
+
+	var report []TrackerReport
+	var dead []string
+	retest := map[int]bool{}
+	for announce, u := range uses {
+		if u.ok || !u.failed {
+			continue
+		}
+		row, known := failing[announce]
+		if !known {
+			row = trackerHealthRow{since: now}
+			_, err = th.db.Exec("INSERT INTO tracker_health (announce, failing_since, last_error) VALUES (?, ?, ?)", announce, now.Unix(), u.lastError)
+		} else {
+			_, err = th.db.Exec("UPDATE tracker_health SET last_error = ? WHERE announce = ?", u.lastError, announce)
+		}
+		if err != nil {
+			return err
+		}
+		r := TrackerReport{
+			Announce:     announce,
+			Host:         trackerHost(announce),
+			FailingSince: row.since,
+			LastError:    u.lastError,
+			Dead:         now.Sub(row.since) >= th.deadAfter,
+			Torrents:     u.torrents,
+		}
+		for _, t := range u.torrents {
+			if t.Removable {
+				r.Removable++
+			}
+		}
+		report = append(report, r)
+		if u.retest != 0 {
+			retest[u.retest] = true
+		}
+		if r.Dead {
+			if row.reported.IsZero() {
+				log.Printf("⚠️ Tracker %s has been failing since %s (%s) on %d torrents; %d public torrents can drop it",
+					announce, row.since.Format(time.DateOnly), u.lastError, len(u.torrents), r.Removable)
+				if _, err := th.db.Exec("UPDATE tracker_health SET reported_at = ? WHERE announce = ?", now.Unix(), announce); err != nil {
+					return err
+				}
+			} else if th.autoRemove {
+				dead = append(dead, announce)
+			}
+		}
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].FailingSince.Before(report[j].FailingSince) })
+
+	for id := range retest {
+		if err := th.client.ReannounceTorrent(id); err != nil {
+			log.Printf("Failed to re-test trackers of torrent %d: %v", id, err)
+		}
+	}
+
+	th.mu.Lock()
+	th.checkedAt, th.report, th.trackers = now, report, trackers
+	th.mu.Unlock()
+
+	if len(dead) > 0 {
+		for _, rm := range th.Remove(dead, false) {
+			if rm.Error != "" {
+				log.Printf("Failed to remove dead tracker %s from %s: %s", rm.Announce, rm.Name, rm.Error)
+			} else {
+				log.Printf("Removed dead tracker %s from %s", rm.Announce, rm.Name)
+			}
+		}
+	}
+	return nil
+}
+
+type trackerHealthRow struct {
+	since, reported time.Time
+}
+
+func (th *TrackerHealth) load() (map[string]trackerHealthRow, error) {
+	rows, err := th.db.Query("SELECT announce, failing_since, reported_at FROM tracker_health")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	failing := map[string]trackerHealthRow{}
+	for rows.Next() {
+		var announce string
+		var since, reported int64
+		if err := rows.Scan(&announce, &since, &reported); err != nil {
+			return nil, err
+		}
+		row := trackerHealthRow{since: time.Unix(since, 0)}
+		if reported != 0 {
+			row.reported = time.Unix(reported, 0)
+		}
+		failing[announce] = row
+	}
+	return failing, rows.Err()
+}
+
+// Report returns the failing trackers from the last check, longest failing
+// first
+func (th *TrackerHealth) Report() (time.Time, []TrackerReport) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	return th.checkedAt, th.report
+}
+
+// Remove takes dead trackers off the public torrents using them, all of
+// them or only those in announces. A torrent with several dead trackers
+// keeps its last one. A dry run only lists what would go.
+func (th *TrackerHealth) Remove(announces []string, dryRun bool) []TrackerRemoval {
+	th.mu.Lock()
+	report := th.report
+	left := make(map[int]int, len(th.trackers))
+	for id, n := range th.trackers {
+		left[id] = n
+	}
+	th.mu.Unlock()
+	removals := []TrackerRemoval{}
+	removed := map[string]map[int]bool{}
+	for _, r := range report {
+		if !r.Dead || (len(announces) > 0 && !slices.Contains(announces, r.Announce)) {
+			continue
+		}
+		for _, t := range r.Torrents {
+			if !t.Removable || left[t.ID] < 2 {
+				continue
+			}
+			rm := TrackerRemoval{ID: t.ID, Name: t.Name, Announce: r.Announce}
+			if dryRun {
+				left[t.ID]--
+			} else {
+				if err := th.client.RemoveTrackers(t.ID, []int{t.TrackerID}); err != nil {
+					rm.Error = err.Error()
+				} else {
+					left[t.ID]--
+					if removed[r.Announce] == nil {
+						removed[r.Announce] = map[int]bool{}
+					}
+					removed[r.Announce][t.ID] = true
+				}
+			}
+			removals = append(removals, rm)
+		}
+	}
+	if len(removed) == 0 {
+		return removals
+	}
+
+	// Keep the report current until the next check
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	updated := make([]TrackerReport, 0, len(th.report))
+	for _, r := range th.report {
+		if gone := removed[r.Announce]; gone != nil {
+			for id := range gone {
+				th.trackers[id]--
+			}
+			kept := []TrackerReportTorrent{}
+			for _, t := range r.Torrents {
+				if !gone[t.ID] {
+					kept = append(kept, t)
+				}
+			}
+			r.Torrents, r.Removable = kept, r.Removable-len(gone)
+			if len(kept) == 0 {
+				continue
+			}
+		}
+		updated = append(updated, r)
+	}
+	th.report = updated
+	return removals
+}
+
+// trackerHost is an announce URL's host, or the URL if it doesn't parse
+func trackerHost(announce string) string {
+	if u, err := url.Parse(announce); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return announce
+}
+
+func (s *Server) writeTrackerHealth(w http.ResponseWriter) {
+	checkedAt, report := s.trackerCheck.Report()
+	if report == nil {
+		report = []TrackerReport{}
+	}
+	resp := map[string]interface{}{
+		"trackers":   report,
+		"deadAfter":  s.trackerCheck.deadAfter.String(),
+		"interval":   s.trackerCheck.interval.String(),
+		"autoRemove": s.trackerCheck.autoRemove,
+	}
+	if !checkedAt.IsZero() {
+		resp["checkedAt"] = checkedAt
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleTrackerHealth(w http.ResponseWriter, _ *http.Request) {
+	s.writeTrackerHealth(w)
+}
+
+// handleTrackerHealthCheck checks now instead of waiting for the schedule
+func (s *Server) handleTrackerHealthCheck(w http.ResponseWriter, _ *http.Request) {
+	if err := s.trackerCheck.Check(); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	s.writeTrackerHealth(w)
+}
+
+// handleRemoveDeadTrackers removes dead trackers, optionally only those in
+// {"announce": [...]}, or with ?dry_run=true lists what would be removed
+func (s *Server) handleRemoveDeadTrackers(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Announce []string `json:"announce"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	dryRun, _ := parseBoolParam(r.URL.Query().Get("dry_run"))
+	removals := s.trackerCheck.Remove(req.Announce, dryRun)
+	failed := 0
+	for _, rm := range removals {
+		if rm.Error != "" {
+			failed++
+		}
+	}
+	resp := map[string]interface{}{"dryRun": dryRun, "removed": removals}
+	if failed > 0 {
+		resp["error"] = fmt.Sprintf("%d of %d removals failed", failed, len(removals))
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"strings"
+)
+
+// TrackerAugmenter appends a configured list of public trackers to torrents
+// that arrive without any, and keeps those trackers off private torrents
+type TrackerAugmenter struct {
+	client   *TransmissionClient
+	trackers []string
+	sources  map[string]bool
+}
+
+// NewTrackerAugmenter creates an augmenter applying trackers to adds from
+// the given sources. It returns nil when no trackers are configured.
+func NewTrackerAugmenter(client *TransmissionClient, trackers, sources []string) *TrackerAugmenter {
+	if len(trackers) == 0 {
+		return nil
+	}
+	enabled := make(map[string]bool, len(sources))
+	for _, s := range sources {
+		enabled[s] = true
+	}
+	return &TrackerAugmenter{client: client, trackers: trackers, sources: enabled}
+}
+
+// List returns the configured public trackers
+func (t *TrackerAugmenter) List() []string {
+	if t == nil {
+		return nil
+	}
+	return t.trackers
+}
+
+// Prepare rewrites a trackerless magnet in req to include the public trackers
+func (t *TrackerAugmenter) Prepare(req *AddRequest) {
+	if t == nil || !t.sources[req.Source] || !isMagnetLink(req.URL) {
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+	if len(q["tr"]) > 0 {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(req.URL)
+	for _, tr := range t.trackers {
+		b.WriteString("&tr=")
+		b.WriteString(url.QueryEscape(tr))
+	}
+	req.URL = b.String()
+	log.Printf("Added %d public trackers to trackerless magnet", len(t.trackers))
+}
+
+// AfterAdd adds the public trackers to a trackerless, non-private .torrent
+// file once Transmission has accepted it
+func (t *TrackerAugmenter) AfterAdd(req *AddRequest, added *AddedTorrent) {
+	if t == nil || !t.sources[req.Source] || len(req.Data) == 0 || added.Duplicate {
+		return
+	}
+	meta, err := parseTorrentFile(req.Data)
+	if err != nil || meta.Private || len(meta.Trackers) > 0 {
+		return
+	}
+	if err := t.client.AddTrackers(added.ID, t.trackers); err != nil {
+		log.Printf("Failed to add public trackers to %s: %v", added.Name, err)
+	}
+}
+
+// OnSnapshot strips public trackers from torrents that turned out to be
+// private once their metadata arrived (e.g. magnets we augmented)
+func (t *TrackerAugmenter) OnSnapshot(_, cur *Snapshot) {
+	public := make(map[string]bool, len(t.trackers))
+	for _, tr := range t.trackers {
+		public[tr] = true
+	}
+
+	for _, torrent := range cur.Torrents {
+		if !torrent.IsPrivate {
+			continue
+		}
+		var ids []int
+		for _, tr := range torrent.Trackers {
+			if public[tr.Announce] {
+				ids = append(ids, tr.ID)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		if err := t.client.RemoveTrackers(torrent.ID, ids); err != nil {
+			log.Printf("Failed to strip public trackers from private torrent %s: %v", torrent.Name, err)
+			continue
+		}
+		log.Printf("Stripped %d public trackers from private torrent %s", len(ids), torrent.Name)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTrafficInterval  = 5 * time.Minute
+	defaultTrafficRetention = 30 * 24 * time.Hour
+	trafficPruneInterval    = time.Hour
+	trafficGraphPoints      = 300
+)
+
+// TrafficPoint is a torrent's counters at a sample, with its average
+// rates since the previous point
+type TrafficPoint struct {
+	Time       time.Time `json:"time"`
+	Downloaded int64     `json:"downloaded"` // downloadedEver
+	Uploaded   int64     `json:"uploaded"`   // uploadedEver
+	Ratio      float64   `json:"ratio"`
+	Download   int64     `json:"download"` // bytes/s
+	Upload     int64     `json:"upload"`
+}
+
+// TrafficReport is a torrent's recorded transfer history
+type TrafficReport struct {
+	Points []TrafficPoint `json:"points"`
+	// What moved in the last 24 hours, or since Since when recording
+	// started later than that
+	Downloaded24h int64     `json:"downloaded24h"`
+	Uploaded24h   int64     `json:"uploaded24h"`
+	Since         time.Time `json:"since"`
+}
+
+// trafficState is the last sample recorded for a torrent
+type trafficState struct {
+	down, up int64
+	ratio    float64
+	moving   bool      // whether the counters changed at that sample
+	seen     time.Time // the last poll they were seen unchanged at
+}
+
+// TorrentTraffic samples the default daemon's downloadedEver and
+// uploadedEver for each torrent every TORRENT_TRAFFIC_INTERVAL, which
+// Transmission keeps only as running totals. A sample is only stored when
+// the counters moved, plus one either side of each quiet spell so the
+// rates between samples stay true.
+type TorrentTraffic struct {
+	db        *sql.DB
+	interval  time.Duration
+	retention time.Duration
+
+	mu        sync.Mutex
+	lastRun   time.Time
+	lastPrune time.Time
+	last      map[string]trafficState // by hash
+}
+
+// NewTorrentTraffic creates the torrent_traffic table
+func NewTorrentTraffic(db *sql.DB, interval, retention time.Duration) (*TorrentTraffic, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS torrent_traffic (
+		hash TEXT NOT NULL,
+		time DATETIME NOT NULL,
+		downloaded INTEGER NOT NULL,
+		uploaded INTEGER NOT NULL,
+		ratio REAL NOT NULL,
+		PRIMARY KEY (hash, time)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create torrent_traffic table: %w", err)
+	}
+	if interval <= 0 {
+		interval = defaultTrafficInterval
+	}
+	if retention <= 0 {
+		retention = defaultTrafficRetention
+	}
+	return &TorrentTraffic{db: db, interval: interval, retention: retention, last: map[string]trafficState{}}, nil
+}
+
+func (tt *TorrentTraffic) record(hash string, at time.Time, down, up int64, ratio float64) {
+	_, err := tt.db.Exec("INSERT OR REPLACE INTO torrent_traffic (hash, time, downloaded, uploaded, ratio) VALUES (?, ?, ?, ?, ?)",
+		hash, at.UTC(), down, up, ratio)
+	if err != nil {
+		log.Printf("Failed to record torrent traffic: %v", err)
+	}
+}
+
+// OnSnapshot samples the counters, at most once per interval
+func (tt *TorrentTraffic) OnSnapshot(_, cur *Snapshot) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if cur.Time.Sub(tt.lastRun) < tt.interval {
+		return
+	}
+	tt.lastRun = cur.Time
+
+	present := make(map[string]bool, len(cur.Torrents))
+	for _, t := range cur.Torrents {
+		present[t.HashString] = true
+		st, ok := tt.last[t.HashString]
+		changed := !ok || t.DownloadedEver != st.down || t.UploadedEver != st.up
+		switch {
+		case changed:
+			// Close the quiet spell at the last poll it was seen in
+			if ok && !st.moving && !st.seen.IsZero() {
+				tt.record(t.HashString, st.seen, st.down, st.up, st.ratio)
+			}
+			tt.record(t.HashString, cur.Time, t.DownloadedEver, t.UploadedEver, t.UploadRatio)
+			st = trafficState{down: t.DownloadedEver, up: t.UploadedEver, ratio: t.UploadRatio, moving: ok}
+		case st.moving:
+			tt.record(t.HashString, cur.Time, t.DownloadedEver, t.UploadedEver, t.UploadRatio)
+			st.moving, st.seen = false, time.Time{}
+		default:
+			st.seen = cur.Time
+		}
+		tt.last[t.HashString] = st
+	}
+	for hash := range tt.last {
+		if !present[hash] {
+			delete(tt.last, hash)
+		}
+	}
+
+	if cur.Time.Sub(tt.lastPrune) >= trafficPruneInterval {
+		tt.lastPrune = cur.Time
+		if _, err := tt.db.Exec("DELETE FROM torrent_traffic WHERE time < ?", cur.Time.UTC().Add(-tt.retention)); err != nil {
+			log.Printf("Failed to prune torrent traffic: %v", err)
+		}
+	}
+}
+
+// Report returns a torrent's history as of now, thinned to at most
+// maxPoints by keeping the last sample in each equal slice of time, and
+// what it moved in the last day against the counters now
+func (tt *TorrentTraffic) Report(hash string, t *Torrent, now time.Time, maxPoints int) (*TrafficReport, error) {
+	rows, err := tt.db.Query("SELECT time, downloaded, uploaded, ratio FROM torrent_traffic WHERE hash = ? ORDER BY time", hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var all []TrafficPoint
+	for rows.Next() {
+		var p TrafficPoint
+		if err := rows.Scan(&p.Time, &p.Downloaded, &p.Uploaded, &p.Ratio); err != nil {
+			return nil, err
+		}
+		all = append(all, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &TrafficReport{Points: []TrafficPoint{}, Since: now.Add(-24 * time.Hour)}
+	if len(all) == 0 {
+		return report, nil
+	}
+
+	// The day's totals count from the last sample before it began, or the
+	// first one when recording started within it
+	base := all[0]
+	for _, p := range all {
+		if p.Time.After(report.Since) {
+			break
+		}
+		base = p
+	}
+	if base.Time.After(report.Since) {
+		report.Since = base.Time
+	}
+	report.Downloaded24h = max(t.DownloadedEver-base.Downloaded, 0)
+	report.Uploaded24h = max(t.UploadedEver-base.Uploaded, 0)
+
+	kept := all
+	if maxPoints > 0 && len(all) > maxPoints {
+		first, span := all[0].Time, all[len(all)-1].Time.Sub(all[0].Time)
+		kept = make([]TrafficPoint, 0, maxPoints)
+		slot := -1
+		for _, p := range all {
+			s := int(float64(p.Time.Sub(first)) / float64(span) * float64(maxPoints-1))
+			if s == slot {
+				kept[len(kept)-1] = p
+				continue
+			}
+			slot = s
+			kept = append(kept, p)
+		}
+	}
+	for i, p := range kept {
+		if i > 0 {
+			prev := kept[i-1]
+			if secs := p.Time.Sub(prev.Time).Seconds(); secs > 0 {
+				p.Download = max(int64(float64(p.Downloaded-prev.Downloaded)/secs), 0)
+				p.Upload = max(int64(float64(p.Uploaded-prev.Uploaded)/secs), 0)
+			}
+		}
+		report.Points = append(report.Points, p)
+	}
+	return report, nil
+}
+
+// TrafficGraph is a torrent's rates and ratio scaled to the detail page's
+// SVGs, which share the speed graph's size
+type TrafficGraph struct {
+	Down, Up, Ratio string
+	Peak            int64
+	MaxRatio        float64
+	Since           time.Time
+}
+
+// trafficGraph draws the report's points; nil before there are two
+func trafficGraph(points []TrafficPoint) *TrafficGraph {
+	if len(points) < 2 {
+		return nil
+	}
+	g := &TrafficGraph{Peak: 1, MaxRatio: 1, Since: points[0].Time}
+	for _, p := range points {
+		g.Peak = max(g.Peak, p.Download, p.Upload)
+		g.MaxRatio = max(g.MaxRatio, p.Ratio)
+	}
+	start, span := points[0].Time, points[len(points)-1].Time.Sub(points[0].Time)
+	point := func(t time.Time, v float64) string {
+		x := float64(t.Sub(start)) / float64(span) * speedGraphWidth
+		y := speedGraphHeight - v*speedGraphHeight
+		return strconv.FormatFloat(x, 'f', 1, 64) + "," + strconv.FormatFloat(y, 'f', 1, 64)
+	}
+	down := make([]string, len(points))
+	up := make([]string, len(points))
+	ratio := make([]string, len(points))
+	for i, p := range points {
+		down[i] = point(p.Time, float64(p.Download)/float64(g.Peak))
+		up[i] = point(p.Time, float64(p.Upload)/float64(g.Peak))
+		ratio[i] = point(p.Time, max(p.Ratio, 0)/g.MaxRatio)
+	}
+	g.Down, g.Up, g.Ratio = strings.Join(down, " "), strings.Join(up, " "), strings.Join(ratio, " ")
+	return g
+}
+
+// apiTorrentTraffic serves GET /api/v1/torrents/{id}/traffic. Only the
+// default daemon is sampled, so other instances' torrents have none.
+func (s *Server) apiTorrentTraffic(w http.ResponseWriter, r *http.Request) error {
+	c, t, err := s.apiTorrent(r)
+	if err != nil {
+		return err
+	}
+	if c != s.client {
+		writeJSON(w, TrafficReport{Points: []TrafficPoint{}, Since: time.Now().Add(-24 * time.Hour)})
+		return nil
+	}
+	maxPoints, _ := strconv.Atoi(r.URL.Query().Get("points"))
+	report, err := s.traffic.Report(t.HashString, t, time.Now(), maxPoints)
+	if err != nil {
+		return err
+	}
+	writeJSON(w, report)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TransferTotals is the data moved during a span of time
+type TransferTotals struct {
+	Uploaded   int64 `json:"uploaded"`
+	Downloaded int64 `json:"downloaded"`
+}
+
+// PeriodComparison compares the current period so far with the same
+// elapsed span of the previous one. Changes are percentages, nil when the
+// previous period moved nothing.
+type PeriodComparison struct {
+	Period           string         `json:"period"`
+	CurrentStart     time.Time      `json:"currentStart"`
+	PreviousStart    time.Time      `json:"previousStart"`
+	Current          TransferTotals `json:"current"`
+	Previous         TransferTotals `json:"previous"`
+	UploadedChange   *float64       `json:"uploadedChange"`
+	DownloadedChange *float64       `json:"downloadedChange"`
+}
+
+// TransferHistory records upload/download per hour from session-stats
+// deltas, so totals can be compared across days and weeks
+type TransferHistory struct {
+	db *sql.DB
+}
+
+// NewTransferHistory creates the history and its transfer_history table
+func NewTransferHistory(db *sql.DB) (*TransferHistory, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS transfer_history (
+		hour TEXT PRIMARY KEY,
+		uploaded INTEGER NOT NULL DEFAULT 0,
+		downloaded INTEGER NOT NULL DEFAULT 0
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	return &TransferHistory{db: db}, nil
+}
+
+// OnSnapshot adds the transfer since the previous poll to the current hour
+func (h *TransferHistory) OnSnapshot(prev, cur *Snapshot) {
+	if prev == nil || prev.Stats == nil || cur.Stats == nil {
+		return
+	}
+	up := cur.Stats.CumulativeStats.UploadedBytes - prev.Stats.CumulativeStats.UploadedBytes
+	down := cur.Stats.CumulativeStats.DownloadedBytes - prev.Stats.CumulativeStats.DownloadedBytes
+	if up <= 0 && down <= 0 {
+		// Nothing moved, or the daemon's counters were reset
+		return
+	}
+
+	_, err := h.db.Exec(
+		`INSERT INTO transfer_history (hour, uploaded, downloaded) VALUES (?, ?, ?)
+		 ON CONFLICT(hour) DO UPDATE SET
+		   uploaded = uploaded + excluded.uploaded,
+		   downloaded = downloaded + excluded.downloaded`,
+		sqliteTime(cur.Time.Truncate(time.Hour)), max(up, 0), max(down, 0),
+	)
+	if err != nil {
+		log.Printf("Failed to record transfer history: %v", err)
+	}
+}
+
+// Totals sums the transfer recorded for hours in [from, to)
+func (h *TransferHistory) Totals(from, to time.Time) (TransferTotals, error) {
+	var t TransferTotals
+	err := h.db.QueryRow(
+		"SELECT COALESCE(SUM(uploaded), 0), COALESCE(SUM(downloaded), 0) FROM transfer_history WHERE hour >= ? AND hour < ?",
+		sqliteTime(from.Truncate(time.Hour)), sqliteTime(to),
+	).Scan(&t.Uploaded, &t.Downloaded)
+	return t, err
+}
+
+// Compare returns today vs yesterday and this week vs last week as of now,
+// in the configured timezone. Weeks start on Monday.
+func (h *TransferHistory) Compare(now time.Time) ([]PeriodComparison, error) {
+	now = localTime(now)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+
+	periods := []struct {
+		name     string
+		start    time.Time
+		previous time.Time
+	}{
+		{"day", today, today.AddDate(0, 0, -1)},
+		{"week", week, week.AddDate(0, 0, -7)},
+	}
+
+	comparisons := make([]PeriodComparison, 0, len(periods))
+	for _, p := range periods {
+		elapsed := now.Sub(p.start)
+		current, err := h.Totals(p.start, now)
+		if err != nil {
+			return nil, err
+		}
+		previous, err := h.Totals(p.previous, p.previous.Add(elapsed))
+		if err != nil {
+			return nil, err
+		}
+		comparisons = append(comparisons, PeriodComparison{
+			Period:           p.name,
+			CurrentStart:     p.start,
+			PreviousStart:    p.previous,
+			Current:          current,
+			Previous:         previous,
+			UploadedChange:   percentChange(current.Uploaded, previous.Uploaded),
+			DownloadedChange: percentChange(current.Downloaded, previous.Downloaded),
+		})
+	}
+	return comparisons, nil
+}
+
+func percentChange(cur, prev int64) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	change := float64(cur-prev) / float64(prev) * 100
+	return &change
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, _ *http.Request) {
+	stats, err := s.client.GetSessionStats()
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	comparisons, err := s.transfers.Compare(time.Now())
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"stats":       stats,
+		"comparisons": comparisons,
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// defaultRPCConns is how many connections to the daemon are kept open.
+// Transmission serves RPC over HTTP/1.1, so this is also how many requests
+// can be in flight at once.
+const defaultRPCConns = 4
+
+// newRPCTransport builds the transport used to reach the daemon: a small,
+// persistent pool of keep-alive connections rather than one per request.
+// http2 is for daemons behind a TLS proxy that speaks it, where a single
+// connection carries every request.
+func newRPCTransport(maxConns int, http2 bool) *http.Transport {
+	if maxConns <= 0 {
+		maxConns = defaultRPCConns
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:   http2,
+		MaxIdleConns:        maxConns,
+		MaxIdleConnsPerHost: maxConns,
+		MaxConnsPerHost:     maxConns,
+		IdleConnTimeout:     5 * time.Minute,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+}
+
+// ConfigureTransport sets the size of the daemon connection pool and
+// whether to try HTTP/2
+func (c *TransmissionClient) ConfigureTransport(maxConns int, http2 bool) {
+	c.client.Transport = newRPCTransport(maxConns, http2)
+}
+
+// closeBody drains what's left of a response before closing it; a body
+// closed unread can't hand its connection back to the pool
+func closeBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+// defaultRPCQueueTimeout is how long a request waits for a free slot
+// before giving up
+const defaultRPCQueueTimeout = 10 * time.Second
+
+// RPCBusyError means a request queued longer than the limit allows
+// because the daemon already had its maximum of requests in flight
+type RPCBusyError struct {
+	InFlight int
+	Waited   time.Duration
+}
+
+func (e *RPCBusyError) Error() string {
+	return fmt.Sprintf("transmission is busy: %d requests in flight, gave up after %v", e.InFlight, e.Waited)
+}
+
+// rpcLimiter caps concurrent RPC requests so a burst of UI activity queues
+// here instead of piling onto a small daemon. A nil limiter is unlimited.
+type rpcLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newRPCLimiter(n int, timeout time.Duration) *rpcLimiter {
+	if n <= 0 {
+		return nil
+	}
+	return &rpcLimiter{slots: make(chan struct{}, n), timeout: timeout}
+}
+
+// acquire waits for a slot, up to the queue timeout
+func (l *rpcLimiter) acquire() error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return &RPCBusyError{InFlight: cap(l.slots), Waited: l.timeout}
+	}
+}
+
+func (l *rpcLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// limitedBody frees the request's slot once its response has been read
+type limitedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// SetRequestLimit caps concurrent RPC requests at n, queueing the rest for
+// up to queueTimeout; n <= 0 removes the cap
+func (c *TransmissionClient) SetRequestLimit(n int, queueTimeout time.Duration) {
+	c.limiter = newRPCLimiter(n, queueTimeout)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The tui subcommand is a full-screen torrent list for a terminal. It drives
+// the terminal with ANSI escapes and stty rather than a curses library, so
+// it runs over SSH on anything with a POSIX shell.
+
+// tuiBackend is what the TUI needs: the running server's API, or the daemon
+// itself through TransmissionClient
+type tuiBackend interface {
+	Torrents() ([]Torrent, error)
+	Action(id int, action string) error
+	Add(link string) error
+}
+
+type remoteTUIBackend struct{ *RemoteClient }
+
+func (b remoteTUIBackend) Torrents() ([]Torrent, error) {
+	return b.RemoteClient.Torrents(url.Values{"sort": {"name"}})
+}
+
+func (b remoteTUIBackend) Action(id int, action string) error {
+	_, err := b.Run("torrent."+action, map[string]interface{}{"id": id})
+	return err
+}
+
+func (b remoteTUIBackend) Add(link string) error {
+	_, err := b.Run("torrent.add", map[string]interface{}{"url": link})
+	return err
+}
+
+// daemonTUIBackend can't remove torrents: without the server's database
+// there are no seeding rules to check or removal history to record
+type daemonTUIBackend struct{ *TransmissionClient }
+
+func (b daemonTUIBackend) Torrents() ([]Torrent, error) {
+	torrents, err := b.GetTorrents()
+	if err != nil {
+		return nil, err
+	}
+	return newTorrentIndex(torrents).query(TorrentQuery{Sort: "name"}).Torrents, nil
+}
+
+func (b daemonTUIBackend) Action(id int, action string) error {
+	switch action {
+	case "start":
+		return b.StartTorrent(id)
+	case "stop":
+		return b.StopTorrent(id)
+	case "remove":
+		return errors.New("removing needs the server, so seeding rules apply; run tui without -daemon")
+	}
+	return errUnknownAction
+}
+
+func (b daemonTUIBackend) Add(link string) error {
+	if isInfoHash(link) {
+		link, _ = magnetFromHash(link, "", nil)
+	}
+	_, err := b.AddTorrent(link, nil, AddOptions{})
+	return err
+}
+
+// tuiMode is what keys currently do
+type tuiMode int
+
+const (
+	tuiBrowse   tuiMode = iota
+	tuiAdding           // typing a magnet link
+	tuiRemoving         // waiting for y/n
+)
+
+type tui struct {
+	backend  tuiBackend
+	torrents []Torrent
+	selected int
+	offset   int // first visible row
+	mode     tuiMode
+	input    string
+	message  string
+	rows     int
+	cols     int
+}
+
+// tuiOptions are the tui subcommand's flags
+type tuiOptions struct {
+	remote   func() *RemoteClient
+	daemon   *bool
+	interval *time.Duration
+}
+
+func tuiFlags(fs *flag.FlagSet) *tuiOptions {
+	return &tuiOptions{
+		remote:   remoteFlags(fs),
+		daemon:   fs.Bool("daemon", false, "talk to the daemon at TRANSMISSION_URL instead of a transmission-web server"),
+		interval: fs.Duration("interval", 2*time.Second, "refresh interval"),
+	}
+}
+
+func runTUI(args []string) int {
+	fs := flag.NewFlagSet("tui", flag.ExitOnError)
+	opts := tuiFlags(fs)
+	fs.Parse(args)
+
+	ui := &tui{}
+	if *opts.daemon {
+		ui.backend = daemonTUIBackend{NewTransmissionClient(
+			getEnv("TRANSMISSION_URL", defaultTransmissionURL),
+			getEnv("TRANSMISSION_USER", "transmission"),
+			getEnv("TRANSMISSION_PASS", ""),
+		)}
+	} else {
+		ui.backend = remoteTUIBackend{opts.remote()}
+	}
+	if err := ui.refresh(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tui needs an interactive terminal: %v\n", err)
+		return 1
+	}
+	defer restore()
+	fmt.Print("\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readKeys(keys)
+	ticker := time.NewTicker(*opts.interval)
+	defer ticker.Stop()
+	for {
+		ui.rows, ui.cols = terminalSize()
+		ui.draw()
+		select {
+		case key, ok := <-keys:
+			if !ok || !ui.handleKey(key) {
+				return 0
+			}
+		case <-ticker.C:
+			if err := ui.refresh(); err != nil {
+				ui.message = err.Error()
+			}
+		}
+	}
+}
+
+// rawTerminal puts stdin into raw mode and returns a function restoring it
+func rawTerminal() (func(), error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	return func() { stty(strings.TrimSpace(saved)) }, nil
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// terminalSize returns rows and columns, or 24x80 if stty can't tell
+func terminalSize() (int, int) {
+	out, err := stty("size")
+	if err == nil {
+		var rows, cols int
+		if _, err := fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
+
+// readKeys sends each key press, with arrow keys as "up" and "down"
+func readKeys(keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		switch s := string(buf[:n]); s {
+		case "\x1b[A", "\x1bOA":
+			keys <- "up"
+		case "\x1b[B", "\x1bOB":
+			keys <- "down"
+		case "\x1b[5~":
+			keys <- "pgup"
+		case "\x1b[6~":
+			keys <- "pgdn"
+		default:
+			keys <- s
+		}
+	}
+}
+
+func (ui *tui) refresh() error {
+	torrents, err := ui.backend.Torrents()
+	if err != nil {
+		return err
+	}
+	ui.torrents = torrents
+	ui.selected = max(0, min(ui.selected, len(torrents)-1))
+	return nil
+}
+
+func (ui *tui) current() *Torrent {
+	if ui.selected < len(ui.torrents) {
+		return &ui.torrents[ui.selected]
+	}
+	return nil
+}
+
+// handleKey acts on a key press and returns false to quit
+func (ui *tui) handleKey(key string) bool {
+	switch ui.mode {
+	case tuiAdding:
+		switch key {
+		case "\r", "\n":
+			ui.mode = tuiBrowse
+			if link := strings.TrimSpace(ui.input); link != "" {
+				ui.act("Added", func() error { return ui.backend.Add(link) })
+			}
+		case "\x1b", "\x03":
+			ui.mode, ui.message = tuiBrowse, ""
+		case "\x7f", "\b":
+			if ui.input != "" {
+				ui.input = ui.input[:len(ui.input)-1]
+			}
+		default:
+			if !strings.ContainsAny(key, "\x1b\r\n") {
+				ui.input += key // pastes arrive as one read
+			}
+		}
+		return true
+
+	case tuiRemoving:
+		ui.mode = tuiBrowse
+		if t := ui.current(); t != nil && (key == "y" || key == "Y") {
+			id := t.ID
+			ui.act("Removed "+t.Name, func() error { return ui.backend.Action(id, "remove") })
+		} else {
+			ui.message = ""
+		}
+		return true
+	}
+
+	page := max(1, ui.rows-4)
+	switch key {
+	case "q", "\x03":
+		return false
+	case "up", "k":
+		ui.selected = max(0, ui.selected-1)
+	case "down", "j":
+		ui.selected = min(len(ui.torrents)-1, ui.selected+1)
+	case "pgup":
+		ui.selected = max(0, ui.selected-page)
+	case "pgdn":
+		ui.selected = min(len(ui.torrents)-1, ui.selected+page)
+	case "r":
+		ui.act("Refreshed", func() error { return nil })
+	case "a":
+		ui.mode, ui.input = tuiAdding, ""
+	case "s", " ":
+		if t := ui.current(); t != nil {
+			id := t.ID
+			if t.Status == 0 {
+				ui.act("Started "+t.Name, func() error { return ui.backend.Action(id, "start") })
+			} else {
+				ui.act("Stopped "+t.Name, func() error { return ui.backend.Action(id, "stop") })
+			}
+		}
+	case "d":
+		if t := ui.current(); t != nil {
+			ui.mode = tuiRemoving
+			ui.message = "Remove " + t.Name + " (files are kept)? y/n"
+		}
+	}
+	return true
+}
+
+// act runs fn, reports how it went and refreshes the list
+func (ui *tui) act(done string, fn func() error) {
+	if err := fn(); err != nil {
+		ui.message = err.Error()
+		return
+	}
+	ui.message = done
+	if err := ui.refresh(); err != nil {
+		ui.message = err.Error()
+	}
+}
+
+func (ui *tui) draw() {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	var down, up int64
+	for _, t := range ui.torrents {
+		down += t.RateDownload
+		up += t.RateUpload
+	}
+	line := func(s string) {
+		if r := []rune(s); len(r) > ui.cols {
+			s = string(r[:ui.cols])
+		}
+		b.WriteString(s + "\x1b[K\r\n")
+	}
+	line(fmt.Sprintf("\x1b[1mtransmission-web\x1b[0m  %d torrents  down %s  up %s",
+		len(ui.torrents), display.Speed(down), display.Speed(up)))
+	header := fmt.Sprintf("  %-14s %7s %11s %11s %6s  %s", "Status", "Done", "Down", "Up", "Ratio", "Name")
+	line("\x1b[7m" + header + strings.Repeat(" ", max(0, ui.cols-len(header))) + "\x1b[0m")
+
+	visible := max(1, ui.rows-4)
+	if ui.selected < ui.offset {
+		ui.offset = ui.selected
+	}
+	if ui.selected >= ui.offset+visible {
+		ui.offset = ui.selected - visible + 1
+	}
+	for i := ui.offset; i < len(ui.torrents) && i < ui.offset+visible; i++ {
+		t := &ui.torrents[i]
+		status := statusText(t.Status)
+		if t.Error != 0 {
+			status = "Error"
+		}
+		row := fmt.Sprintf("  %-14s %7s %11s %11s %6s  %s", status, display.Percent(t.PercentDone),
+			display.Speed(t.RateDownload), display.Speed(t.RateUpload), display.Ratio(t.UploadRatio), t.Name)
+		if i == ui.selected {
+			row = "\x1b[1m>" + row[1:] + "\x1b[0m"
+		}
+		line(row)
+	}
+	for i := len(ui.torrents) - ui.offset; i < visible; i++ {
+		line("")
+	}
+
+	switch ui.mode {
+	case tuiAdding:
+		// Long magnet links scroll so the end being typed stays in view
+		prompt := "Magnet link, URL or info-hash (Enter to add, Esc to cancel): "
+		input := []rune(ui.input)
+		if room := max(10, ui.cols-len(prompt)-1); len(input) > room {
+			input = input[len(input)-room:]
+		}
+		line(prompt + string(input))
+	default:
+		line(ui.message)
+	}
+	b.WriteString("\x1b[2m↑/↓ select  s start/stop  a add  d remove  r refresh  q quit\x1b[0m\x1b[K")
+	os.Stdout.WriteString(b.String())
+}
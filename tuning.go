@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Bandwidth priorities accepted by torrent-set
+const (
+	BandwidthPriorityLow    = -1
+	BandwidthPriorityNormal = 0
+	BandwidthPriorityHigh   = 1
+)
+
+// TorrentTuning holds the per-torrent distribution settings Transmission
+// exposes over RPC. Transmission has no super-seeding mode, so an initial
+// seeder tunes distribution through the peer limit and bandwidth priority.
+// Nil fields are left unchanged by SetTorrentTuning.
+type TorrentTuning struct {
+	PeerLimit           *int  `json:"peerLimit,omitempty"`
+	BandwidthPriority   *int  `json:"bandwidthPriority,omitempty"`
+	HonorsSessionLimits *bool `json:"honorsSessionLimits,omitempty"`
+}
+
+// Validate checks the tuning values are within the RPC's accepted ranges
+func (t *TorrentTuning) Validate() error {
+	if t.PeerLimit != nil && (*t.PeerLimit < 1 || *t.PeerLimit > 65535) {
+		return fmt.Errorf("peer limit must be between 1 and 65535")
+	}
+	if t.BandwidthPriority != nil && (*t.BandwidthPriority < BandwidthPriorityLow || *t.BandwidthPriority > BandwidthPriorityHigh) {
+		return fmt.Errorf("bandwidth priority must be -1, 0 or 1")
+	}
+	return nil
+}
+
+// GetTorrentTuning returns the current tuning settings of a torrent
+func (c *TransmissionClient) GetTorrentTuning(id int) (*TorrentTuning, error) {
+	req := &RPCRequest{
+		Method: "torrent-get",
+		Arguments: map[string]interface{}{
+			"ids":    []int{id},
+			"fields": []string{"id", "peer-limit", "bandwidthPriority", "honorsSessionLimits"},
+		},
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Torrents []struct {
+			PeerLimit           int  `json:"peer-limit"`
+			BandwidthPriority   int  `json:"bandwidthPriority"`
+			HonorsSessionLimits bool `json:"honorsSessionLimits"`
+		} `json:"torrents"`
+	}
+	if err := json.Unmarshal(resp.Arguments, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Torrents) == 0 {
+		return nil, fmt.Errorf("torrent %d not found", id)
+	}
+	t := result.Torrents[0]
+	return &TorrentTuning{
+		PeerLimit:           &t.PeerLimit,
+		BandwidthPriority:   &t.BandwidthPriority,
+		HonorsSessionLimits: &t.HonorsSessionLimits,
+	}, nil
+}
+
+// SetTorrentTuning applies the non-nil tuning settings to a torrent
+func (c *TransmissionClient) SetTorrentTuning(id int, tuning *TorrentTuning) error {
+	args := make(map[string]interface{})
+	if tuning.PeerLimit != nil {
+		args["peer-limit"] = *tuning.PeerLimit
+	}
+	if tuning.BandwidthPriority != nil {
+		args["bandwidthPriority"] = *tuning.BandwidthPriority
+	}
+	if tuning.HonorsSessionLimits != nil {
+		args["honorsSessionLimits"] = *tuning.HonorsSessionLimits
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return c.setTorrent(id, args)
+}
+
+func (s *Server) handleGetTuning(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	tuning, err := client.GetTorrentTuning(id)
+	if err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, tuning)
+}
+
+func (s *Server) handleSetTuning(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var tuning TorrentTuning
+	if err := json.NewDecoder(r.Body).Decode(&tuning); err != nil {
+		writeJSONError(w, "invalid request")
+		return
+	}
+	if err := tuning.Validate(); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	client, ok := s.requestClient(w, r)
+	if !ok {
+		return
+	}
+	if err := client.SetTorrentTuning(id, &tuning); err != nil {
+		writeJSONError(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUpdateURL           = "https://api.github.com/repos/james-gonzalez/transmission-web/releases/latest"
+	defaultUpdateCheckInterval = 24 * time.Hour
+	updateChecksumsAsset       = "checksums.txt"
+	maxUpdateArchive           = 256 << 20
+)
+
+// updateChecker is read by the templates to show the new version notice
+var updateChecker *UpdateChecker
+
+// releasePublicKey is the base64 ed25519 key release checksums are signed
+// with, set during release builds via ldflags. UPDATE_PUBLIC_KEY overrides
+// it, e.g. for a fork's releases.
+var releasePublicKey = ""
+
+// githubRelease is the part of a GitHub release the updater reads
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *githubRelease) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// fetchRelease reads the release JSON at url
+func fetchRelease(ctx context.Context, client *http.Client, url string) (*githubRelease, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "transmission-web/"+Version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release check: %s", resp.Status)
+	}
+	var release githubRelease
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return nil, fmt.Errorf("release check: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, errors.New("release check: no tag in the response")
+	}
+	return &release, nil
+}
+
+// parseVersion reads "v1.2.3" or "1.2.3"; builds without a release version
+// ("dev") don't parse
+func parseVersion(v string) ([3]int, bool) {
+	var parts [3]int
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+	fields := strings.Split(v, ".")
+	if len(fields) != 3 {
+		return parts, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// newerVersion reports whether latest is a later release than current
+func newerVersion(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	c, cok := parseVersion(current)
+	if !ok || !cok {
+		return false
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// UpdateStatus is what the last release check found
+type UpdateStatus struct {
+	Current   string     `json:"current"`
+	Latest    string     `json:"latest,omitempty"`
+	Available bool       `json:"available"`
+	URL       string     `json:"url,omitempty"` // the release page
+	CheckedAt *time.Time `json:"checkedAt,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// UpdateChecker looks for a newer release in the background, for the
+// notice in the UI. Installing it is left to the update subcommand.
+type UpdateChecker struct {
+	client   *http.Client
+	url      string
+	interval time.Duration
+
+	mu     sync.Mutex
+	status UpdateStatus
+}
+
+// NewUpdateChecker checks url every interval
+func NewUpdateChecker(url string, interval time.Duration) *UpdateChecker {
+	if interval <= 0 {
+		interval = defaultUpdateCheckInterval
+	}
+	return &UpdateChecker{
+		client:   &http.Client{Timeout: 30 * time.Second},
+		url:      url,
+		interval: interval,
+		status:   UpdateStatus{Current: Version},
+	}
+}
+
+// Start checks now and then every interval
+func (u *UpdateChecker) Start() {
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if err := u.Check(ctx); err != nil {
+				log.Printf("⚠️ Update check failed: %v", err)
+			}
+			cancel()
+			time.Sleep(u.interval)
+		}
+	}()
+}
+
+// Check fetches the latest release
+func (u *UpdateChecker) Check(ctx context.Context) error {
+	release, err := fetchRelease(ctx, u.client, u.url)
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.status.CheckedAt = &now
+	if err != nil {
+		u.status.Error = err.Error()
+		return err
+	}
+	wasAvailable := u.status.Available
+	u.status = UpdateStatus{
+		Current:   Version,
+		Latest:    release.TagName,
+		Available: newerVersion(release.TagName, Version),
+		URL:       release.HTMLURL,
+		CheckedAt: &now,
+	}
+	if u.status.Available && !wasAvailable {
+		log.Printf("transmission-web %s is available (running %s); see %s", release.TagName, Version, release.HTMLURL)
+	}
+	return nil
+}
+
+// Status returns what the last check found; a nil checker has found nothing
+func (u *UpdateChecker) Status() UpdateStatus {
+	if u == nil {
+		return UpdateStatus{Current: Version}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
+
+func (s *Server) handleUpdateStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, updateChecker.Status())
+}
+
+// releaseArchive is the goreleaser archive for this platform
+func releaseArchive() string {
+	arch := runtime.GOARCH
+	if arch == "amd64" {
+		arch = "x86_64"
+	}
+	goos := runtime.GOOS
+	return fmt.Sprintf("transmission-web_%s_%s.tar.gz", strings.ToUpper(goos[:1])+goos[1:], arch)
+}
+
+type updateOptions struct {
+	apply *bool
+	force *bool
+	url   *string
+}
+
+func updateFlags(fs *flag.FlagSet) *updateOptions {
+	return &updateOptions{
+		apply: fs.Bool("apply", false, "download, verify and install the release; without it the command only checks"),
+		force: fs.Bool("force", false, "install even when the release isn't newer, e.g. over a dev build"),
+		url:   fs.String("url", getEnv("UPDATE_URL", defaultUpdateURL), "release to check, as GitHub API JSON (UPDATE_URL)"),
+	}
+}
+
+// runUpdate is the update subcommand. It only replaces the binary with
+// -apply, after checking checksums.txt's ed25519 signature and the archive
+// against checksums.txt.
+func runUpdate(args []string) int {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	opts := updateFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: transmission-web update [-apply] [-force]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	client := &http.Client{Timeout: 5 * time.Minute}
+	release, err := fetchRelease(ctx, client, *opts.url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Running %s; the latest release is %s\n", Version, release.TagName)
+	if !newerVersion(release.TagName, Version) && !*opts.force {
+		if _, ok := parseVersion(Version); !ok {
+			fmt.Println("This build has no release version; use -force to install the release over it")
+		} else {
+			fmt.Println("Already up to date")
+		}
+		return 0
+	}
+	if !*opts.apply {
+		fmt.Printf("Release notes: %s\nRun transmission-web update -apply to install it\n", release.HTMLURL)
+		return 0
+	}
+
+	path, err := installRelease(ctx, client, release)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "update: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Installed %s to %s; restart transmission-web to run it\n", release.TagName, path)
+	return 0
+}
+
+// installRelease downloads this platform's archive, verifies it and
+// replaces the running executable with the binary inside
+func installRelease(ctx context.Context, client *http.Client, release *githubRelease) (string, error) {
+	archive := releaseArchive()
+	archiveURL, checksumsURL := release.asset(archive), release.asset(updateChecksumsAsset)
+	if archiveURL == "" {
+		return "", fmt.Errorf("release %s has no %s", release.TagName, archive)
+	}
+	if checksumsURL == "" {
+		return "", fmt.Errorf("release %s has no %s to verify against", release.TagName, updateChecksumsAsset)
+	}
+	// A checksum alone only proves what the release page says, so nothing
+	// is installed without a signature from the release key
+	key := getEnv("UPDATE_PUBLIC_KEY", releasePublicKey)
+	if key == "" {
+		return "", errors.New("this build has no release signing key; set UPDATE_PUBLIC_KEY to install releases")
+	}
+	sigURL := release.asset(updateChecksumsAsset + ".sig")
+	if sigURL == "" {
+		return "", fmt.Errorf("release %s has no %s.sig; not installing an unsigned release", release.TagName, updateChecksumsAsset)
+	}
+
+	checksums, err := download(ctx, client, checksumsURL, 1<<20)
+	if err != nil {
+		return "", err
+	}
+	sig, err := download(ctx, client, sigURL, 4096)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(key, checksums, sig); err != nil {
+		return "", err
+	}
+	fmt.Println("Signature verified")
+	want, err := checksumFor(checksums, archive)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Printf("Downloading %s\n", archiveURL)
+	data, err := download(ctx, client, archiveURL, maxUpdateArchive)
+	if err != nil {
+		return "", err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+		return "", fmt.Errorf("%s doesn't match its checksum; not installing it", archive)
+	}
+	fmt.Println("Checksum verified")
+	binary, err := extractBinary(data)
+	if err != nil {
+		return "", err
+	}
+	return replaceExecutable(binary)
+}
+
+func download(ctx context.Context, client *http.Client, url string, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "transmission-web/"+Version)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s: too large", url)
+	}
+	return data, nil
+}
+
+// verifySignature checks an ed25519 signature of data, either raw or base64,
+// against a base64 public key
+func verifySignature(publicKey string, data, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("the release signing key must be a base64 ed25519 public key")
+	}
+	if len(sig) != ed25519.SignatureSize {
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+			sig = decoded
+		}
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), data, sig) {
+		return errors.New("checksums.txt signature doesn't verify; not installing the release")
+	}
+	return nil
+}
+
+// checksumFor finds name in a sha256sum style checksums file
+func checksumFor(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", updateChecksumsAsset, name)
+}
+
+// extractBinary pulls the executable out of a release archive
+func extractBinary(archive []byte) ([]byte, error) {
+	name := "transmission-web"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the archive has no %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == name {
+			return io.ReadAll(io.LimitReader(tr, maxUpdateArchive))
+		}
+	}
+}
+
+// replaceExecutable writes binary next to the running executable and
+// renames it into place, so a failed write leaves the old one alone
+func replaceExecutable(binary []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".transmission-web-update-*")
+	if err != nil {
+		return "", fmt.Errorf("can't write next to %s: %w", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(binary); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return "", err
+	}
+	// Windows won't replace a running executable, but will rename it
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return "", err
+	}
+	return exe, nil
+}
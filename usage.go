@@ -0,0 +1,315 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Data cap actions taken when monthly usage approaches the cap
+const (
+	DataCapActionNone     = "none"
+	DataCapActionAltSpeed = "alt-speed"
+	DataCapActionPause    = "pause"
+)
+
+// DataCapConfig configures monthly transfer accounting
+type DataCapConfig struct {
+	CapBytes    int64   // 0 disables cap enforcement (usage is still tracked)
+	BillingDay  int     // day of month the billing period resets (1-28)
+	WarnPercent float64 // percentage of the cap at which to warn and act
+	Action      string  // one of the DataCapAction constants
+}
+
+// MonthlyUsage reports transfer totals for a billing period
+type MonthlyUsage struct {
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Uploaded    int64     `json:"uploaded"`
+	Downloaded  int64     `json:"downloaded"`
+	Total       int64     `json:"total"`
+	CapBytes    int64     `json:"capBytes"`
+	Percent     float64   `json:"percent"`
+	Warning     bool      `json:"warning"`
+	Action      string    `json:"action"`
+	Enforced    bool      `json:"enforced"`
+}
+
+// UsageTracker accumulates upload/download totals per billing period from
+// session-stats deltas and enforces an optional ISP data cap
+type UsageTracker struct {
+	db     *sql.DB
+	client *TransmissionClient
+	config DataCapConfig
+
+	mu       sync.Mutex
+	period   time.Time // start of the period the enforcement state belongs to
+	warned   bool
+	enforced bool
+	paused   []int // torrents stopped by the pause action, resumed on reset
+}
+
+// periodKey is how a billing period is stored
+func periodKey(start time.Time) string {
+	return start.Format("2006-01-02")
+}
+
+func loadDataCapConfig() DataCapConfig {
+	return DataCapConfig{
+		CapBytes:    int64(getEnvFloat("DATA_CAP_GB", 0) * 1024 * 1024 * 1024),
+		BillingDay:  getEnvInt("DATA_CAP_BILLING_DAY", 1),
+		WarnPercent: getEnvFloat("DATA_CAP_WARN_PERCENT", 90),
+		Action:      getEnv("DATA_CAP_ACTION", DataCapActionNone),
+	}
+}
+
+// NewUsageTracker creates a usage tracker backed by the monthly_usage table
+func NewUsageTracker(db *sql.DB, client *TransmissionClient, config DataCapConfig) (*UsageTracker, error) {
+	if config.BillingDay < 1 || config.BillingDay > 28 {
+		config.BillingDay = 1
+	}
+	switch config.Action {
+	case DataCapActionNone, DataCapActionAltSpeed, DataCapActionPause:
+	default:
+		log.Printf("Unknown DATA_CAP_ACTION %q, disabling cap action", config.Action)
+		config.Action = DataCapActionNone
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS monthly_usage (
+		period_start TEXT PRIMARY KEY,
+		uploaded INTEGER NOT NULL DEFAULT 0,
+		downloaded INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	// The pause action's torrents, so a restart can still resume them
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS data_cap_paused (
+		torrent_id INTEGER PRIMARY KEY,
+		period_start TEXT NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &UsageTracker{db: db, client: client, config: config}
+	rows, err := db.Query("SELECT torrent_id, period_start FROM data_cap_paused")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var period string
+		if err := rows.Scan(&id, &period); err != nil {
+			return nil, err
+		}
+		start, err := time.ParseInLocation("2006-01-02", period, localTime(time.Now()).Location())
+		if err != nil {
+			return nil, err
+		}
+		u.period, u.enforced = start, true
+		u.paused = append(u.paused, id)
+	}
+	return u, rows.Err()
+}
+
+// billingPeriod returns the start and end of the billing period containing
+// t; periods turn over at midnight in the configured timezone
+func billingPeriod(t time.Time, billingDay int) (time.Time, time.Time) {
+	t = localTime(t)
+	start := time.Date(t.Year(), t.Month(), billingDay, 0, 0, 0, 0, t.Location())
+	if t.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start, start.AddDate(0, 1, 0)
+}
+
+// OnSnapshot records the transfer delta between two polls
+func (u *UsageTracker) OnSnapshot(prev, cur *Snapshot) {
+	if prev == nil || prev.Stats == nil || cur.Stats == nil {
+		return
+	}
+
+	up := cur.Stats.CumulativeStats.UploadedBytes - prev.Stats.CumulativeStats.UploadedBytes
+	down := cur.Stats.CumulativeStats.DownloadedBytes - prev.Stats.CumulativeStats.DownloadedBytes
+	// Counters go backwards when the daemon's stats are reset
+	if up < 0 {
+		up = 0
+	}
+	if down < 0 {
+		down = 0
+	}
+
+	start, _ := billingPeriod(cur.Time, u.config.BillingDay)
+	if up > 0 || down > 0 {
+		_, err := u.db.Exec(
+			`INSERT INTO monthly_usage (period_start, uploaded, downloaded, updated_at)
+			 VALUES (?, ?, ?, datetime('now'))
+			 ON CONFLICT(period_start) DO UPDATE SET
+			   uploaded = uploaded + excluded.uploaded,
+			   downloaded = downloaded + excluded.downloaded,
+			   updated_at = excluded.updated_at`,
+			periodKey(start), up, down,
+		)
+		if err != nil {
+			log.Printf("Failed to record usage: %v", err)
+			return
+		}
+	}
+
+	u.evaluate(cur)
+}
+
+func (u *UsageTracker) evaluate(cur *Snapshot) {
+	usage := u.usageAt(cur.Time)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !usage.PeriodStart.Equal(u.period) {
+		if !u.period.IsZero() {
+			log.Printf("Billing period reset, usage counters start at zero")
+			u.release()
+		}
+		u.period = usage.PeriodStart
+		u.warned = false
+		u.enforced = false
+	}
+
+	if !usage.Warning {
+		return
+	}
+
+	if !u.warned {
+		log.Printf("⚠️  Data cap warning: %.1f%% of monthly cap used (%d of %d bytes)",
+			usage.Percent, usage.Total, usage.CapBytes)
+		u.warned = true
+	}
+
+	// The pause action runs on every poll while over the cap, so torrents
+	// resumed or added since are stopped too
+	if !u.enforced || u.config.Action == DataCapActionPause {
+		u.enforced = u.enforce(cur) || u.enforced
+	}
+}
+
+// enforce applies the configured cap action, reporting whether it fully
+// succeeded; otherwise it's tried again on the next poll. Callers must
+// hold u.mu.
+func (u *UsageTracker) enforce(cur *Snapshot) bool {
+	switch u.config.Action {
+	case DataCapActionAltSpeed:
+		if err := u.client.SetAltSpeedEnabled(true); err != nil {
+			log.Printf("Failed to enable alt-speed for data cap: %v", err)
+			return false
+		}
+		log.Printf("Enabled alternative speed limits to stay under the data cap")
+	case DataCapActionPause:
+		ok := true
+		paused := 0
+		for _, t := range cur.Torrents {
+			if t.Status != 3 && t.Status != 4 {
+				continue
+			}
+			if err := u.client.StopTorrent(t.ID); err != nil {
+				log.Printf("Failed to pause %s for data cap: %v", t.Name, err)
+				ok = false
+				continue
+			}
+			if !slices.Contains(u.paused, t.ID) {
+				u.paused = append(u.paused, t.ID)
+			}
+			paused++
+			_, err := u.db.Exec("INSERT OR REPLACE INTO data_cap_paused (torrent_id, period_start) VALUES (?, ?)", t.ID, periodKey(u.period))
+			if err != nil {
+				log.Printf("Failed to record a torrent paused for the data cap: %v", err)
+			}
+		}
+		if paused > 0 {
+			log.Printf("Paused %d downloading torrents to stay under the data cap", paused)
+		}
+		return ok
+	}
+	return true
+}
+
+// release undoes the cap action at the start of a new period. Callers must
+// hold u.mu.
+func (u *UsageTracker) release() {
+	if !u.enforced && len(u.paused) == 0 {
+		return
+	}
+	switch u.config.Action {
+	case DataCapActionAltSpeed:
+		if err := u.client.SetAltSpeedEnabled(false); err != nil {
+			log.Printf("Failed to disable alt-speed after data cap reset: %v", err)
+		}
+	case DataCapActionPause:
+		for _, id := range u.paused {
+			if err := u.client.StartTorrent(id); err != nil {
+				log.Printf("Failed to resume torrent %d after data cap reset: %v", id, err)
+			}
+		}
+		u.paused = nil
+		if _, err := u.db.Exec("DELETE FROM data_cap_paused"); err != nil {
+			log.Printf("Failed to forget the torrents paused for the data cap: %v", err)
+		}
+	}
+}
+
+// HoldsAltSpeed reports whether the data cap has turned on the alternative
+// speed limits, so schedules leave them on
+func (u *UsageTracker) HoldsAltSpeed() bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.enforced && u.config.Action == DataCapActionAltSpeed
+}
+
+// Current returns usage for the billing period containing now
+func (u *UsageTracker) Current() *MonthlyUsage {
+	if u == nil {
+		return nil
+	}
+	usage := u.usageAt(time.Now())
+	u.mu.Lock()
+	usage.Enforced = u.enforced && usage.PeriodStart.Equal(u.period)
+	u.mu.Unlock()
+	return usage
+}
+
+func (u *UsageTracker) usageAt(t time.Time) *MonthlyUsage {
+	start, end := billingPeriod(t, u.config.BillingDay)
+	usage := &MonthlyUsage{
+		PeriodStart: start,
+		PeriodEnd:   end,
+		CapBytes:    u.config.CapBytes,
+		Action:      u.config.Action,
+	}
+
+	err := u.db.QueryRow(
+		"SELECT uploaded, downloaded FROM monthly_usage WHERE period_start = ?",
+		periodKey(start),
+	).Scan(&usage.Uploaded, &usage.Downloaded)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to read usage: %v", err)
+	}
+
+	usage.Total = usage.Uploaded + usage.Downloaded
+	if usage.CapBytes > 0 {
+		usage.Percent = float64(usage.Total) / float64(usage.CapBytes) * 100
+		usage.Warning = usage.Percent >= u.config.WarnPercent
+	}
+	return usage
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, s.usage.Current())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Torrent list views: the default list, or cards sized for phones with
+// swipe gestures (static/cards.js)
+const (
+	ViewList  = "list"
+	ViewCards = "cards"
+)
+
+const viewCookieName = "tw_view"
+
+// listView picks the dashboard's view: ?view= chooses one and remembers it
+// in a cookie, which is used otherwise
+func listView(w http.ResponseWriter, r *http.Request) string {
+	if view := r.URL.Query().Get("view"); view == ViewList || view == ViewCards {
+		http.SetCookie(w, &http.Cookie{
+			Name:     viewCookieName,
+			Value:    view,
+			Path:     "/",
+			Expires:  time.Now().AddDate(1, 0, 0),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return view
+	}
+	if cookie, err := r.Cookie(viewCookieName); err == nil && cookie.Value == ViewCards {
+		return ViewCards
+	}
+	return ViewList
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultWatchInterval = 10 * time.Second
+
+// Suffixes appended to a watched file once it's been dealt with
+const (
+	watchDoneSuffix   = ".added"
+	watchFailedSuffix = ".failed"
+)
+
+// errWatchFile marks a watched file that can never be added as it is
+var errWatchFile = errors.New("invalid watch file")
+
+// WatchFolder adds the .torrent files, and .magnet files holding a magnet
+// link, dropped into WATCH_DIR. Unlike the daemon's own watch-dir, adds go
+// through the adder with the "watch" source, so they're deduplicated,
+// checked for disk space and get that source's add defaults. An added
+// file is renamed with an .added suffix, and one that can't ever be added
+// (not a torrent, excluded, rejected by the daemon) with .failed. Other
+// failures, such as the daemon being unreachable or the disk full, are
+// retried on the next scan. Files modified within the last interval are
+// left for the next scan, since they may still be being written, and
+// nothing is added while automation is paused.
+type WatchFolder struct {
+	dir      string
+	adder    *Adder
+	pause    *AutomationPause
+	interval time.Duration
+	failed   map[string]string // last error by file, so it's logged once
+}
+
+// NewWatchFolder watches dir, "" meaning no watch folder
+func NewWatchFolder(dir string, adder *Adder, pause *AutomationPause) *WatchFolder {
+	if dir == "" {
+		return nil
+	}
+	return &WatchFolder{dir: dir, adder: adder, pause: pause, failed: map[string]string{}}
+}
+
+// Start scans the folder every interval
+func (wf *WatchFolder) Start(interval time.Duration) {
+	if wf == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	wf.interval = interval
+	log.Printf("Watching %s for torrents every %v", wf.dir, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			wf.safeScan()
+			<-ticker.C
+		}
+	}()
+}
+
+// safeScan scans unless automation is paused, and keeps a panic in one
+// scan from ending the watch
+func (wf *WatchFolder) safeScan() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Watch folder scan failed: %v", r)
+		}
+	}()
+	if wf.pause.Active() {
+		return
+	}
+	wf.scan()
+}
+
+func (wf *WatchFolder) scan() {
+	entries, err := os.ReadDir(wf.dir)
+	if err != nil {
+		if wf.failed[wf.dir] != err.Error() {
+			log.Printf("Failed to read watch folder: %v", err)
+		}
+		wf.failed[wf.dir] = err.Error()
+		return
+	}
+	delete(wf.failed, wf.dir)
+
+	settled := time.Now().Add(-wf.interval)
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".torrent" && ext != ".magnet") {
+			continue
+		}
+		path := filepath.Join(wf.dir, e.Name())
+		seen[path] = true
+		if info, err := e.Info(); err != nil || info.ModTime().After(settled) {
+			continue // gone, or maybe still being written
+		}
+		err := wf.add(path, ext)
+		if err != nil && !permanentWatchError(err) {
+			if wf.failed[path] != err.Error() {
+				log.Printf("Failed to add %s from the watch folder, will retry: %v", e.Name(), err)
+			}
+			wf.failed[path] = err.Error()
+			continue
+		}
+		delete(wf.failed, path)
+		suffix := watchDoneSuffix
+		if err != nil {
+			log.Printf("Failed to add %s from the watch folder: %v", e.Name(), err)
+			suffix = watchFailedSuffix
+		}
+		if err := os.Rename(path, path+suffix); err != nil {
+			log.Printf("Failed to mark %s as done: %v", e.Name(), err)
+		}
+	}
+	for path := range wf.failed {
+		if path != wf.dir && !seen[path] {
+			delete(wf.failed, path)
+		}
+	}
+}
+
+// permanentWatchError reports whether err means the file will never be
+// added, rather than that the daemon or disk isn't ready for it yet
+func permanentWatchError(err error) bool {
+	var excluded *ExcludedURLError
+	var rejected *RPCResultError
+	return errors.Is(err, errWatchFile) || errors.As(err, &excluded) || errors.As(err, &rejected)
+}
+
+// add adds one file; a release that's already been added counts as done
+func (wf *WatchFolder) add(path, ext string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	req := AddRequest{Source: SourceWatch}
+	if ext == ".magnet" {
+		req.URL = strings.TrimSpace(string(data))
+		if isInfoHash(req.URL) {
+			req.URL, _ = magnetFromHash(req.URL, "", nil)
+		}
+		if !isMagnetLink(req.URL) {
+			return fmt.Errorf("%w: no magnet link in the file", errWatchFile)
+		}
+	} else {
+		if _, err := parseTorrentFile(data); err != nil {
+			return fmt.Errorf("%w: %v", errWatchFile, err)
+		}
+		req.Data = data
+	}
+	added, err := wf.adder.Add(req)
+	var dup *DuplicateAddError
+	if errors.As(err, &dup) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Added %s from the watch folder", added.Name)
+	return nil
+}
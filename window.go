@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultWindowLimit = 100
+	maxWindowLimit     = 1000
+)
+
+// torrentSortKeys are the sort orders /api/torrents/window accepts. Every
+// order breaks ties by ID so pages don't shift between requests.
+var torrentSortKeys = map[string]func(a, b *Torrent) int{
+	"id": func(a, b *Torrent) int { return 0 },
+	"name": func(a, b *Torrent) int {
+		return strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	},
+	"added":        func(a, b *Torrent) int { return compareInt(a.AddedDate, b.AddedDate) },
+	"size":         func(a, b *Torrent) int { return compareInt(a.SizeWhenDone, b.SizeWhenDone) },
+	"progress":     func(a, b *Torrent) int { return compareFloat(a.PercentDone, b.PercentDone) },
+	"ratio":        func(a, b *Torrent) int { return compareFloat(a.UploadRatio, b.UploadRatio) },
+	"status":       func(a, b *Torrent) int { return compareInt(int64(a.Status), int64(b.Status)) },
+	"rateDownload": func(a, b *Torrent) int { return compareInt(a.RateDownload, b.RateDownload) },
+	"rateUpload":   func(a, b *Torrent) int { return compareInt(a.RateUpload, b.RateUpload) },
+	"eta":          func(a, b *Torrent) int { return compareInt(etaKey(a.ETA), etaKey(b.ETA)) },
+	"peers":        func(a, b *Torrent) int { return compareInt(int64(a.PeersConnected), int64(b.PeersConnected)) },
+	"queue":        func(a, b *Torrent) int { return compareInt(int64(a.QueuePosition), int64(b.QueuePosition)) },
+}
+
+// etaKey sorts unknown ETAs (negative) after every known one
+func etaKey(eta int) int64 {
+	if eta < 0 {
+		return math.MaxInt64
+	}
+	return int64(eta)
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// TorrentWindow is one page of the sorted, filtered torrent list
+type TorrentWindow struct {
+	Torrents []Torrent `json:"torrents"`
+	Total    int       `json:"total"` // matching torrents across all pages
+	Offset   int       `json:"offset"`
+	Limit    int       `json:"limit"`
+}
+
+// windowTorrents sorts torrents by the sort and order parameters and cuts
+// out the offset/limit page. It sorts torrents in place.
+func windowTorrents(torrents []Torrent, q url.Values) (*TorrentWindow, error) {
+	key := q.Get("sort")
+	if key == "" {
+		key = "id"
+	}
+	cmp, ok := torrentSortKeys[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %q", key)
+	}
+	desc := q.Get("order") == "desc"
+
+	offset, limit := 0, defaultWindowLimit
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid offset %q", v)
+		}
+		offset = n
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit %q", v)
+		}
+		limit = min(n, maxWindowLimit)
+	}
+
+	sort.Slice(torrents, func(i, j int) bool {
+		a, b := &torrents[i], &torrents[j]
+		c := cmp(a, b)
+		if c == 0 {
+			c = compareInt(int64(a.ID), int64(b.ID))
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	start := min(offset, len(torrents))
+	end := min(start+limit, len(torrents))
+	return &TorrentWindow{
+		Torrents: torrents[start:end],
+		Total:    len(torrents),
+		Offset:   offset,
+		Limit:    limit,
+	}, nil
+}
+
+// handleTorrentWindow serves one page of the torrent list for infinite
+// scrolling. It reads the poller's latest snapshot rather than asking
+// Transmission on every scroll, and accepts the same filters as
+// /api/torrents plus:
+//
+//	offset=N            zero-based index of the first torrent (default 0)
+//	limit=N             page size (default 100, at most 1000)
+//	sort=KEY            id, name, added, size, progress, ratio, status,
+//	                    rateDownload, rateUpload, eta or peers (default id)
+//	order=asc|desc      sort direction (default asc)
+func (s *Server) handleTorrentWindow(w http.ResponseWriter, r *http.Request) {
+	var torrents []Torrent
+	if snap := s.poller.Latest(); snap != nil {
+		// Copy so sorting doesn't reorder the shared snapshot
+		torrents = append([]Torrent(nil), snap.Torrents...)
+	} else {
+		var err error
+		if torrents, err = s.client.GetTorrents(); err != nil {
+			writeJSONError(w, err.Error())
+			return
+		}
+	}
+	torrents = filterTorrents(torrents, r.URL.Query())
+
+	window, err := windowTorrents(torrents, r.URL.Query())
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSONError(w, err.Error())
+		return
+	}
+	addDisplay(window.Torrents, nil)
+	writeJSON(w, window)
+}